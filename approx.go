@@ -0,0 +1,296 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/internal/sketch"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+// KLLAccuracy is the accuracy parameter of the KLL sketches used by
+// ApproxQuantiles.
+const kllAccuracy = 200
+
+var (
+	typeOfFloat64s    = reflect.TypeOf([]float64(nil))
+	typeOfInt64s      = reflect.TypeOf([]int64(nil))
+	typeOfHLLSketch   = reflect.TypeOf((*sketch.HLL)(nil))
+	typeOfKLLSketch   = reflect.TypeOf((*sketch.KLL)(nil))
+	typeOfTopKSketch  = reflect.TypeOf((*sketch.TopK)(nil))
+	hllSketchCombiner = reflect.ValueOf(func(s, o *sketch.HLL) *sketch.HLL {
+		s.Merge(o)
+		return s
+	})
+	kllSketchCombiner = reflect.ValueOf(func(s, o *sketch.KLL) *sketch.KLL {
+		s.Merge(o)
+		return s
+	})
+	topKSketchCombiner = reflect.ValueOf(func(s, o *sketch.TopK) *sketch.TopK {
+		s.Merge(o)
+		return s
+	})
+)
+
+// ApproxCountDistinct returns a slice that estimates, for each
+// distinct value of the provided slice's prefix columns, the number of
+// distinct values in its residual column. The slice must have exactly
+// one residual column, which must be hashable, and its prefix columns
+// must be partitionable. Estimates are computed with HyperLogLog
+// sketches, with a typical relative error of about 1.6%. Sketches are
+// combined before they are shuffled, so that ApproxCountDistinct
+// shuffles at most one sketch per key per shard. Schematically:
+//
+//	ApproxCountDistinct(Slice<k1, ..., kp, v>) Slice<k1, ..., kp, int64>
+func ApproxCountDistinct(slice Slice) Slice {
+	if err := checkSketchable(slice, "approxcountdistinct"); err != nil {
+		typecheck.Panic(1, err.Error())
+	}
+	if vtyp := slice.Out(slice.NumOut() - 1); !frame.CanHash(vtyp) {
+		typecheck.Panicf(1, "approxcountdistinct: cannot hash values of type %s", vtyp)
+	}
+	sketches := &sketchSlice{makeName("approxcountdistinctsketch"), slice, typeOfHLLSketch, func(vals frame.Frame, i int) reflect.Value {
+		s := new(sketch.HLL)
+		s.Add(hashValue(vals, i))
+		return reflect.ValueOf(s)
+	}}
+	return &sketchResultSlice{
+		name:  makeName("approxcountdistinct"),
+		Slice: &reduceSlice{sketches, makeName("approxcountdistinctcombine"), hllSketchCombiner},
+		out:   []reflect.Type{reflect.TypeOf(int64(0))},
+		result: func(s reflect.Value, out []reflect.Value) {
+			out[0].SetInt(int64(s.Interface().(*sketch.HLL).Estimate()))
+		},
+	}
+}
+
+// ApproxQuantiles returns a slice that estimates, for each distinct
+// value of the provided slice's prefix columns, the provided
+// quantiles of the values in its residual column. The slice must have
+// exactly one residual column, of numeric type, and its prefix columns
+// must be partitionable. Quantiles must be in [0, 1]; the estimates
+// are returned as a []float64, in the order of the provided quantiles.
+// Estimates are computed with KLL sketches, whose rank error is
+// typically within 1%. Sketches are combined before they are
+// shuffled. Schematically:
+//
+//	ApproxQuantiles(Slice<k1, ..., kp, v>, []float64) Slice<k1, ..., kp, []float64>
+func ApproxQuantiles(slice Slice, quantiles []float64) Slice {
+	if err := checkSketchable(slice, "approxquantiles"); err != nil {
+		typecheck.Panic(1, err.Error())
+	}
+	vtyp := slice.Out(slice.NumOut() - 1)
+	switch vtyp.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+	default:
+		typecheck.Panicf(1, "approxquantiles: values of type %s are not numeric", vtyp)
+	}
+	for _, q := range quantiles {
+		if q < 0 || q > 1 {
+			typecheck.Panicf(1, "approxquantiles: quantile %v is not in [0, 1]", q)
+		}
+	}
+	quantiles = append([]float64(nil), quantiles...)
+	sketches := &sketchSlice{makeName("approxquantilessketch"), slice, typeOfKLLSketch, func(vals frame.Frame, i int) reflect.Value {
+		s := sketch.NewKLL(kllAccuracy)
+		v := vals.Index(0, i)
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			s.Add(float64(v.Int()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			s.Add(float64(v.Uint()))
+		default:
+			s.Add(v.Float())
+		}
+		return reflect.ValueOf(s)
+	}}
+	return &sketchResultSlice{
+		name:  makeName("approxquantiles"),
+		Slice: &reduceSlice{sketches, makeName("approxquantilescombine"), kllSketchCombiner},
+		out:   []reflect.Type{typeOfFloat64s},
+		result: func(s reflect.Value, out []reflect.Value) {
+			out[0].Set(reflect.ValueOf(s.Interface().(*sketch.KLL).Quantiles(quantiles)))
+		},
+	}
+}
+
+// TopKFrequent returns a slice that estimates, for each distinct value
+// of the provided slice's prefix columns, the k most frequent values
+// in its residual column, together with their frequencies. The slice
+// must have exactly one residual column, whose values must be hashable
+// and comparable, and its prefix columns must be partitionable. The
+// values are returned in order of decreasing frequency. Frequencies
+// are counted exactly while a key has few distinct values; beyond
+// this, they are estimated with a Count-Min sketch, which never
+// underestimates frequencies. Sketches are combined before they are
+// shuffled. Schematically:
+//
+//	TopKFrequent(Slice<k1, ..., kp, v>, int) Slice<k1, ..., kp, []v, []int64>
+func TopKFrequent(slice Slice, k int) Slice {
+	if err := checkSketchable(slice, "topkfrequent"); err != nil {
+		typecheck.Panic(1, err.Error())
+	}
+	if k < 1 {
+		typecheck.Panicf(1, "topkfrequent: k must be positive; got %d", k)
+	}
+	vtyp := slice.Out(slice.NumOut() - 1)
+	if !frame.CanHash(vtyp) || !vtyp.Comparable() {
+		typecheck.Panicf(1, "topkfrequent: values of type %s are not hashable and comparable", vtyp)
+	}
+	// Sketches store values as empty interfaces.
+	gob.Register(reflect.Zero(vtyp).Interface())
+	sketches := &sketchSlice{makeName("topkfrequentsketch"), slice, typeOfTopKSketch, func(vals frame.Frame, i int) reflect.Value {
+		s := sketch.NewTopK(k)
+		s.Add(vals.Index(0, i).Interface(), hashValue(vals, i), 1)
+		return reflect.ValueOf(s)
+	}}
+	return &sketchResultSlice{
+		name:  makeName("topkfrequent"),
+		Slice: &reduceSlice{sketches, makeName("topkfrequentcombine"), topKSketchCombiner},
+		out:   []reflect.Type{reflect.SliceOf(vtyp), typeOfInt64s},
+		result: func(s reflect.Value, out []reflect.Value) {
+			items, freqs := s.Interface().(*sketch.TopK).Top()
+			values := reflect.MakeSlice(reflect.SliceOf(vtyp), len(items), len(items))
+			counts := make([]int64, len(freqs))
+			for i := range items {
+				values.Index(i).Set(reflect.ValueOf(items[i]))
+				counts[i] = int64(freqs[i])
+			}
+			out[0].Set(values)
+			out[1].Set(reflect.ValueOf(counts))
+		},
+	}
+}
+
+// CheckSketchable returns an error if the provided slice cannot be
+// aggregated by sketches.
+func checkSketchable(slice Slice, op string) error {
+	if res := slice.NumOut() - slice.Prefix(); res != 1 {
+		return fmt.Errorf("%s: the slice must have exactly one residual column; has %d", op, res)
+	}
+	return canMakeCombiningFrame(slice)
+}
+
+// HashValue returns a 64-bit hash of the prefix columns of the ith
+// row of frame vals.
+func hashValue(vals frame.Frame, i int) uint64 {
+	return uint64(vals.HashWithSeed(i, 0x9747b28c))<<32 | uint64(vals.HashWithSeed(i, 0x5bd1e995))
+}
+
+// SketchSlice replaces the residual column of its underlying slice
+// with a sketch summarizing the column's value. Sketches are combined
+// by a downstream reduceSlice.
+type sketchSlice struct {
+	name Name
+	Slice
+	typ    reflect.Type
+	sketch func(vals frame.Frame, i int) reflect.Value
+}
+
+func (s *sketchSlice) Name() Name { return s.name }
+func (s *sketchSlice) Out(c int) reflect.Type {
+	if c == s.Slice.NumOut()-1 {
+		return s.typ
+	}
+	return s.Slice.Out(c)
+}
+func (*sketchSlice) ShardType() ShardType     { return HashShard }
+func (*sketchSlice) NumDep() int              { return 1 }
+func (s *sketchSlice) Dep(i int) Dep          { return singleDep(i, s.Slice, false) }
+func (*sketchSlice) Combiner() *reflect.Value { return nil }
+
+func (s *sketchSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &sketchReader{op: s, reader: deps[0]}
+}
+
+type sketchReader struct {
+	op     *sketchSlice
+	reader sliceio.Reader
+	in     frame.Frame
+}
+
+func (s *sketchReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if !slicetype.Assignable(out, s.op) {
+		return 0, errTypeError
+	}
+	n := out.Len()
+	if s.in.IsZero() {
+		s.in = frame.Make(s.op.Slice, n, n)
+	} else {
+		s.in = s.in.Ensure(n)
+	}
+	n, err := s.reader.Read(ctx, s.in.Slice(0, n))
+	vcol := s.op.NumOut() - 1
+	frame.Copy(frame.Values(out.Values()[:vcol]), frame.Values(s.in.Slice(0, n).Values()[:vcol]))
+	vals := frame.Values([]reflect.Value{s.in.Value(vcol)})
+	for i := 0; i < n; i++ {
+		out.Index(vcol, i).Set(s.op.sketch(vals, i))
+	}
+	return n, err
+}
+
+// SketchResultSlice replaces the sketch column of its underlying
+// slice with the result columns computed from each sketch.
+type sketchResultSlice struct {
+	name Name
+	Slice
+	out    []reflect.Type
+	result func(sketch reflect.Value, out []reflect.Value)
+}
+
+func (s *sketchResultSlice) Name() Name  { return s.name }
+func (s *sketchResultSlice) NumOut() int { return s.Slice.NumOut() - 1 + len(s.out) }
+func (s *sketchResultSlice) Out(c int) reflect.Type {
+	if vcol := s.Slice.NumOut() - 1; c >= vcol {
+		return s.out[c-vcol]
+	}
+	return s.Slice.Out(c)
+}
+func (*sketchResultSlice) ShardType() ShardType     { return HashShard }
+func (*sketchResultSlice) NumDep() int              { return 1 }
+func (s *sketchResultSlice) Dep(i int) Dep          { return singleDep(i, s.Slice, false) }
+func (*sketchResultSlice) Combiner() *reflect.Value { return nil }
+
+func (s *sketchResultSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &sketchResultReader{op: s, reader: deps[0]}
+}
+
+type sketchResultReader struct {
+	op     *sketchResultSlice
+	reader sliceio.Reader
+	in     frame.Frame
+}
+
+func (s *sketchResultReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if !slicetype.Assignable(out, s.op) {
+		return 0, errTypeError
+	}
+	n := out.Len()
+	if s.in.IsZero() {
+		s.in = frame.Make(s.op.Slice, n, n)
+	} else {
+		s.in = s.in.Ensure(n)
+	}
+	n, err := s.reader.Read(ctx, s.in.Slice(0, n))
+	vcol := s.op.Slice.NumOut() - 1
+	frame.Copy(frame.Values(out.Values()[:vcol]), frame.Values(s.in.Slice(0, n).Values()[:vcol]))
+	results := make([]reflect.Value, len(s.op.out))
+	for i := 0; i < n; i++ {
+		for j := range results {
+			results[j] = out.Index(vcol+j, i)
+		}
+		s.op.result(s.in.Index(vcol, i), results)
+	}
+	return n, err
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice_test
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/slicetest"
+)
+
+func TestApproxCountDistinct(t *testing.T) {
+	const N = 100000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	for m := 1; m < 10; m += 4 {
+		slice := bigslice.Const(m, ints)
+		// Key "a" has N/10 distinct values; key "b" has 3.
+		slice = bigslice.Map(slice, func(x int) (string, int) {
+			if x%2 == 0 {
+				return "a", x / 2 % (N / 10)
+			}
+			return "b", x % 3
+		})
+		slice = bigslice.ApproxCountDistinct(slice)
+		for _, s := range run(context.Background(), t, slice) {
+			var (
+				keys   []string
+				counts []int64
+			)
+			slicetest.ScanAll(t, s, &keys, &counts)
+			if got, want := len(keys), 2; got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			for i, key := range keys {
+				want := float64(N / 10)
+				if key == "b" {
+					want = 3
+				}
+				if got := float64(counts[i]); math.Abs(got-want) > 0.05*want {
+					t.Errorf("key %s: got %v, want %v", key, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestApproxQuantiles(t *testing.T) {
+	const N = 100000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	qs := []float64{0, 0.1, 0.5, 0.9, 1}
+	for m := 1; m < 10; m += 4 {
+		slice := bigslice.Const(m, ints)
+		slice = bigslice.Map(slice, func(x int) (bool, int) { return x%2 == 0, x })
+		slice = bigslice.ApproxQuantiles(slice, qs)
+		for _, s := range run(context.Background(), t, slice) {
+			var (
+				keys      []bool
+				quantiles [][]float64
+			)
+			slicetest.ScanAll(t, s, &keys, &quantiles)
+			if got, want := len(keys), 2; got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			for i := range keys {
+				if got, want := len(quantiles[i]), len(qs); got != want {
+					t.Fatalf("got %v, want %v", got, want)
+				}
+				for j, q := range qs {
+					if got, want := quantiles[i][j], q*N; math.Abs(got-want) > 0.02*N {
+						t.Errorf("key %v: quantile %v: got %v, want %v", keys[i], q, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTopKFrequent(t *testing.T) {
+	const N = 10000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	for m := 1; m < 10; m += 4 {
+		slice := bigslice.Const(m, ints)
+		// Value 0 occurs most frequently, then 1, then 2; all other
+		// values occur once.
+		slice = bigslice.Map(slice, func(x int) (string, int) {
+			switch {
+			case x%10 == 0:
+				return "x", 0
+			case x%10 < 6:
+				return "x", 1
+			case x%10 < 8:
+				return "x", 2
+			}
+			return "x", x
+		})
+		slice = bigslice.TopKFrequent(slice, 3)
+		for _, s := range run(context.Background(), t, slice) {
+			var (
+				keys   []string
+				values [][]int
+				counts [][]int64
+			)
+			slicetest.ScanAll(t, s, &keys, &values, &counts)
+			if got, want := len(keys), 1; got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			if got, want := values[0], []int{1, 2, 0}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			for i, want := range []int64{N / 2, N / 5, N / 10} {
+				if got := counts[0][i]; got < want || float64(got) > 1.05*float64(want) {
+					t.Errorf("value %d: got %v, want %v", values[0][i], got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestApproxNames(t *testing.T) {
+	slice := bigslice.Const(1, []string{"a"}, []int{1})
+	// Each stage has its own name, so that their status and counters
+	// are kept apart.
+	for _, c := range []struct {
+		slice bigslice.Slice
+		op    string
+	}{
+		{bigslice.ApproxCountDistinct(slice), "approxcountdistinct"},
+		{bigslice.ApproxQuantiles(slice, []float64{0.5}), "approxquantiles"},
+		{bigslice.TopKFrequent(slice, 1), "topkfrequent"},
+	} {
+		combine := c.slice.Dep(0).Slice
+		sketch := combine.Dep(0).Slice
+		var ops []string
+		for _, s := range []bigslice.Slice{sketch, combine, c.slice} {
+			ops = append(ops, s.Name().Op)
+		}
+		if got, want := ops, []string{c.op + "sketch", c.op + "combine", c.op}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestApproxTypeError(t *testing.T) {
+	expectTypeError(t, "approxcountdistinct: the slice must have exactly one residual column; has 2", func() {
+		bigslice.ApproxCountDistinct(bigslice.Const(1, []string{"a"}, []int{1}, []int{2}))
+	})
+	expectTypeError(t, "approxquantiles: values of type string are not numeric", func() {
+		bigslice.ApproxQuantiles(bigslice.Const(1, []int{1}, []string{"a"}), []float64{0.5})
+	})
+	expectTypeError(t, "approxquantiles: quantile 2 is not in [0, 1]", func() {
+		bigslice.ApproxQuantiles(bigslice.Const(1, []int{1}, []int{1}), []float64{2})
+	})
+	expectTypeError(t, "topkfrequent: values of type []int are not hashable and comparable", func() {
+		bigslice.TopKFrequent(bigslice.Const(1, []int{1}, [][]int{{1}}), 1)
+	})
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package zipslice implements bigslice operations for reading and
+// writing zip archives.
+package zipslice
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/sliceio"
+)
+
+// Entry describes a single zip file entry, including its full contents.
+type Entry struct {
+	// FileHeader is the full zip file header.
+	zip.FileHeader
+	// Body is the (uncompressed) file contents.
+	Body []byte
+}
+
+// ReadAtCloser groups the io.ReaderAt and io.Closer interfaces.
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Reader returns a slice of Entry records representing the zip
+// archive returned by the archive func, together with its size in
+// bytes. Slices are sharded nshard ways, striped across entries.
+// Shards are assigned using the archive's central directory, so that
+// each shard decompresses only the entries that belong to it.
+func Reader(nshard int, archive func() (ReadAtCloser, int64, error)) bigslice.Slice {
+	bigslice.Helper()
+	type state struct {
+		files []*zip.File
+		io.Closer
+	}
+	return bigslice.ReaderFunc(nshard, func(shard int, state *state, entries []Entry) (n int, err error) {
+		defer func() {
+			if err != nil && state.Closer != nil {
+				state.Close()
+				state.Closer = nil
+			}
+		}()
+		if state.files == nil {
+			rc, size, err := archive()
+			if err != nil {
+				return 0, err
+			}
+			state.Closer = rc
+			r, err := zip.NewReader(rc, size)
+			if err != nil {
+				return 0, err
+			}
+			state.files = make([]*zip.File, 0, len(r.File)/nshard+1)
+			for i := shard; i < len(r.File); i += nshard {
+				state.files = append(state.files, r.File[i])
+			}
+		}
+		for n < len(entries) {
+			if len(state.files) == 0 {
+				return n, sliceio.EOF
+			}
+			var file *zip.File
+			file, state.files = state.files[0], state.files[1:]
+			entries[n].FileHeader = file.FileHeader
+			entries[n].Body, err = readFile(file)
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(state.files) == 0 {
+			return n, sliceio.EOF
+		}
+		return n, nil
+	})
+}
+
+func readFile(file *zip.File) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	p, err := ioutil.ReadAll(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return p, rc.Close()
+}
+
+// Writer returns a slice that writes each shard of the provided
+// slice of Entry records into a separate zip archive. The archive for
+// a shard is created by invoking the create func with the shard
+// number; it is finalized and closed once the shard has been fully
+// written. The returned slice is functionally equivalent to the input
+// slice: entries are passed through as they are written.
+//
+// Entries are compressed according to the Method field of their
+// headers.
+func Writer(slice bigslice.Slice, create func(shard int) (io.WriteCloser, error)) bigslice.Slice {
+	bigslice.Helper()
+	type state struct {
+		*zip.Writer
+		io.Closer
+	}
+	return bigslice.WriterFunc(slice, func(shard int, state *state, err error, entries []Entry) error {
+		if state.Writer == nil {
+			if err != nil && err != sliceio.EOF {
+				return nil
+			}
+			wc, err := create(shard)
+			if err != nil {
+				return err
+			}
+			state.Writer = zip.NewWriter(wc)
+			state.Closer = wc
+		}
+		for i := range entries {
+			header := entries[i].FileHeader
+			w, err := state.CreateHeader(&header)
+			if err != nil {
+				state.Closer.Close()
+				return err
+			}
+			if _, err := w.Write(entries[i].Body); err != nil {
+				state.Closer.Close()
+				return err
+			}
+		}
+		switch {
+		case err == sliceio.EOF:
+			if err := state.Writer.Close(); err != nil {
+				state.Closer.Close()
+				return err
+			}
+			return state.Closer.Close()
+		case err != nil:
+			// The archive is incomplete; release the underlying writer
+			// without finalizing it.
+			return state.Closer.Close()
+		}
+		return nil
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package zipslice_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/grailbio/base/must"
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/archive/zipslice"
+	"github.com/grailbio/bigslice/slicetest"
+)
+
+type nopCloser struct{ io.ReaderAt }
+
+func (nopCloser) Close() error { return nil }
+
+type bufferCloser struct{ bytes.Buffer }
+
+func (*bufferCloser) Close() error { return nil }
+
+func makeArchive(t *testing.T, n int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	rnd := rand.New(rand.NewSource(1))
+	p := make([]byte, 256)
+	for i := 0; i < n; i++ {
+		m := rnd.Intn(256)
+		fw, err := w.CreateHeader(&zip.FileHeader{
+			Name:   fmt.Sprintf("%03d", i),
+			Method: zip.Deflate,
+		})
+		must.Nil(err)
+		for j := 0; j < m; j++ {
+			p[j] = byte(m)
+		}
+		_, err = fw.Write(p[:m])
+		must.Nil(err)
+	}
+	must.Nil(w.Close())
+	return buf.Bytes()
+}
+
+func checkEntries(t *testing.T, entries []zipslice.Entry, n int) {
+	t.Helper()
+	if got, want := len(entries), n; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	for i, entry := range entries {
+		if got, want := entry.Name, fmt.Sprintf("%03d", i); got != want {
+			t.Errorf("entry %d: got %v, want %v", i, got, want)
+		}
+		n := len(entry.Body)
+		for _, b := range entry.Body {
+			if got, want := b, byte(n); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	const N = 1000
+	p := makeArchive(t, N)
+	slice := zipslice.Reader(10, func() (zipslice.ReadAtCloser, int64, error) {
+		return nopCloser{bytes.NewReader(p)}, int64(len(p)), nil
+	})
+	var entries []zipslice.Entry
+	slicetest.RunAndScan(t, slice, &entries)
+	checkEntries(t, entries, N)
+}
+
+func TestWriter(t *testing.T) {
+	const (
+		N      = 100
+		nshard = 4
+	)
+	p := makeArchive(t, N)
+	var (
+		mu       sync.Mutex
+		archives = make(map[int]*bufferCloser)
+	)
+	slice := zipslice.Reader(nshard, func() (zipslice.ReadAtCloser, int64, error) {
+		return nopCloser{bytes.NewReader(p)}, int64(len(p)), nil
+	})
+	slice = zipslice.Writer(slice, func(shard int) (io.WriteCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		archives[shard] = new(bufferCloser)
+		return archives[shard], nil
+	})
+	if err := slicetest.RunErr(slice); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(archives), nshard; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var entries []zipslice.Entry
+	for _, archive := range archives {
+		r, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range r.File {
+			rc, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(rc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rc.Close()
+			entries = append(entries, zipslice.Entry{FileHeader: file.FileHeader, Body: body})
+		}
+	}
+	checkEntries(t, entries, N)
+}
+
+func TestWriterType(t *testing.T) {
+	defer func() {
+		if e := recover(); e == nil {
+			t.Error("expected type error")
+		}
+	}()
+	zipslice.Writer(bigslice.Const(1, []int{1, 2, 3}), func(int) (io.WriteCloser, error) {
+		panic("not reached")
+	})
+}
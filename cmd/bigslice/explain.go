@@ -0,0 +1,44 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/grailbio/base/must"
+)
+
+func explainCmdUsage(flags *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: bigslice explain [-format format] [input] [flags]
+
+Command explain builds the provided package or files, and then
+prints the physical plan of the program's first bigslice computation
+instead of running it. The plan comprises the pipelined stages of
+tasks, their shard counts, shuffle boundaries, combiners, cache hits,
+and pragmas. See "bigslice build -help" for more details on building.
+
+The flags are:
+`)
+	flags.PrintDefaults()
+	os.Exit(2)
+}
+
+func explainCmd(args []string) {
+	var (
+		flags  = flag.NewFlagSet("bigslice explain", flag.ExitOnError)
+		format = flags.String("format", "text", "plan format: text, json, or dot")
+	)
+	flags.Usage = func() { explainCmdUsage(flags) }
+	must.Nil(flags.Parse(args))
+	switch *format {
+	case "text", "json", "dot":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown plan format %q\n", *format)
+		flags.Usage()
+	}
+	buildAndRun(flags.Args(), "-explain="+*format)
+}
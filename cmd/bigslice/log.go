@@ -0,0 +1,168 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/base/must"
+	"github.com/grailbio/bigslice/exec"
+)
+
+func logCmdUsage(flags *flag.FlagSet) {
+	fmt.Fprint(os.Stderr, `usage: bigslice log [-n N] eventlog
+
+Command log summarizes an event log written by a bigslice session
+(see exec.EventLog). It reports the outcome of each invocation, the
+tasks that failed, the tasks that were retried, lost machines, and
+the slowest successful task runs.
+
+The flags are:
+`)
+	flags.PrintDefaults()
+	os.Exit(2)
+}
+
+func logCmd(args []string) {
+	var (
+		flags = flag.NewFlagSet("bigslice log", flag.ExitOnError)
+		n     = flags.Int("n", 10, "number of slowest tasks to report")
+	)
+	flags.Usage = func() { logCmdUsage(flags) }
+	must.Nil(flags.Parse(args))
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	events, err := exec.ReadEvents(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", flags.Arg(0), err)
+	}
+	summarizeEvents(os.Stdout, events, *n)
+}
+
+// SummarizeEvents writes a summary of the provided events to w,
+// reporting at most n of the slowest tasks.
+func summarizeEvents(w io.Writer, events []exec.Event, n int) {
+	var (
+		invocations []exec.Event
+		failures    []exec.Event
+		lost        []exec.Event
+		slowest     []exec.Event
+		attempts    = make(map[string]int)
+		taskLost    = make(map[string]int)
+		ntask       = make(map[string]bool)
+	)
+	for _, event := range events {
+		switch event.Type {
+		case exec.EventInvocation:
+			if event.State != "start" {
+				invocations = append(invocations, event)
+			}
+		case exec.EventMachine:
+			if event.State == "lost" {
+				lost = append(lost, event)
+			}
+		case exec.EventTask:
+			ntask[event.Task] = true
+			if event.Attempt > attempts[event.Task] {
+				attempts[event.Task] = event.Attempt
+			}
+			switch event.State {
+			case exec.TaskErr.String():
+				failures = append(failures, event)
+			case exec.TaskLost.String():
+				taskLost[event.Task]++
+			case exec.TaskOk.String():
+				slowest = append(slowest, event)
+			}
+		}
+	}
+	if len(events) > 0 {
+		fmt.Fprintf(w, "%d events from %s to %s (%s); %d tasks\n",
+			len(events), events[0].Time.Format(time.RFC3339), events[len(events)-1].Time.Format(time.RFC3339),
+			events[len(events)-1].Time.Sub(events[0].Time).Round(time.Second), len(ntask))
+	}
+
+	fmt.Fprintln(w, "\ninvocations:")
+	tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	for _, event := range invocations {
+		fmt.Fprintf(tw, "\t%d\t%s\t%s\t%s\n", event.Invocation, event.Location, event.State, firstLine(event.Error))
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nfailed tasks (%d):\n", len(failures))
+	tw = tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	for _, event := range failures {
+		fmt.Fprintf(tw, "\t%s\t%s\tattempt %d\t%s\n", event.Task, machine(event), event.Attempt, firstLine(event.Error))
+	}
+	tw.Flush()
+
+	var retried []string
+	for task, attempt := range attempts {
+		if attempt > 1 {
+			retried = append(retried, task)
+		}
+	}
+	sort.Slice(retried, func(i, j int) bool {
+		if attempts[retried[i]] != attempts[retried[j]] {
+			return attempts[retried[i]] > attempts[retried[j]]
+		}
+		return retried[i] < retried[j]
+	})
+	fmt.Fprintf(w, "\nretried tasks (%d):\n", len(retried))
+	tw = tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	for _, task := range retried {
+		fmt.Fprintf(tw, "\t%s\t%d attempts\t%d lost\n", task, attempts[task], taskLost[task])
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nlost machines (%d):\n", len(lost))
+	tw = tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	for _, event := range lost {
+		fmt.Fprintf(tw, "\t%s\t%s\t%s\n", event.Machine, event.Time.Format(time.RFC3339), firstLine(event.Error))
+	}
+	tw.Flush()
+
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].Duration > slowest[j].Duration
+	})
+	if len(slowest) > n {
+		slowest = slowest[:n]
+	}
+	fmt.Fprintf(w, "\nslowest tasks:\n")
+	tw = tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	for _, event := range slowest {
+		fmt.Fprintf(tw, "\t%s\t%s\t%s\tin:%d\tout:%d\n",
+			event.Task, event.Duration.Round(time.Millisecond), machine(event), event.RecordsIn, event.RecordsOut)
+	}
+	tw.Flush()
+}
+
+func machine(event exec.Event) string {
+	if event.Machine == "" {
+		return "local"
+	}
+	return event.Machine
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i] + " ..."
+	}
+	return s
+}
@@ -34,6 +34,8 @@ The commands are:
 	setup-ec2   configure EC2 for use with Bigslice
 	build       build a bigslice program
 	run         run a bigslice program or source files
+	explain     print the physical plan of a bigslice program
+	log         summarize the event log of a bigslice session
 `)
 	// TODO(marius): this command pulls in way too many global flags
 	// from other modules, including Vanadium; these dependencies
@@ -67,6 +69,10 @@ func main() {
 		runCmd(args)
 	case "build":
 		buildCmd(args)
+	case "explain":
+		explainCmd(args)
+	case "log":
+		logCmd(args)
 	case "setup-ec2":
 		setupEc2Cmd(args)
 	}
@@ -23,6 +23,13 @@ Command run builds and then runs the provided package or files. See
 }
 
 func runCmd(args []string) {
+	buildAndRun(args)
+}
+
+// buildAndRun builds the package or files given by the leading
+// non-flag arguments in args, and then runs the resulting binary with
+// the provided binary flags, followed by the remaining arguments.
+func buildAndRun(args []string, binaryFlags ...string) {
 	var buildIndex int
 	for _, arg := range args {
 		if arg == "-help" || arg == "--help" {
@@ -40,7 +47,7 @@ func runCmd(args []string) {
 		binary = build(args[:buildIndex], "")
 	}
 
-	cmd := exec.Command(binary, args[buildIndex:]...)
+	cmd := exec.Command(binary, append(binaryFlags, args[buildIndex:]...)...)
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 	cmd.Stdin = os.Stdin
@@ -22,6 +22,10 @@ type cogroupSlice struct {
 	out      []reflect.Type
 	prefix   int
 	numShard int
+	// Copartitioned is true when the cogroup's slices are partitioned
+	// by their keys with the same partitioning, in which case no
+	// shuffle is required.
+	copartitioned bool
 }
 
 // Cogroup returns a slice that, for each key in any slice, contains
@@ -35,6 +39,10 @@ type cogroupSlice struct {
 // Cogroup uses the prefix columns of each slice as its key; keys must be
 // partitionable.
 //
+// If all of the slices are partitioned by their keys with the same
+// Partitioning value (see PartitionWith), Cogroup does not shuffle its
+// input.
+//
 // TODO(marius): don't require spilling to disk when the input data
 // set is small enough.
 //
@@ -43,13 +51,21 @@ type cogroupSlice struct {
 // require some changes downstream, however, so that buffering and
 // encoding functionality also know how to read scanner values.
 func Cogroup(slices ...Slice) Slice {
+	return makeCogroup(makeName("cogroup"), slices)
+}
+
+// MakeCogroup returns a cogroupSlice with the provided name for the
+// provided slices. It panics with a type error (attributed to the
+// caller of its caller, and prefixed by the name's op) if the slices
+// cannot be cogrouped.
+func makeCogroup(name Name, slices []Slice) *cogroupSlice {
 	if len(slices) == 0 {
-		typecheck.Panic(1, "cogroup: expected at least one slice")
+		typecheck.Panicf(2, "%s: expected at least one slice", name.Op)
 	}
 	var keyTypes []reflect.Type
 	for i, slice := range slices {
 		if slice.NumOut() == 0 {
-			typecheck.Panicf(1, "cogroup: slice %d has no columns", i)
+			typecheck.Panicf(2, "%s: slice %d has no columns", name.Op, i)
 		}
 		if i == 0 {
 			keyTypes = make([]reflect.Type, slice.Prefix())
@@ -58,21 +74,21 @@ func Cogroup(slices ...Slice) Slice {
 			}
 		} else {
 			if got, want := slice.Prefix(), len(keyTypes); got != want {
-				typecheck.Panicf(1, "cogroup: prefix mismatch: expected %d but got %d", want, got)
+				typecheck.Panicf(2, "%s: prefix mismatch: expected %d but got %d", name.Op, want, got)
 			}
 			for j := range keyTypes {
 				if got, want := slice.Out(j), keyTypes[j]; got != want {
-					typecheck.Panicf(1, "cogroup: key column type mismatch: expected %s but got %s", want, got)
+					typecheck.Panicf(2, "%s: key column type mismatch: expected %s but got %s", name.Op, want, got)
 				}
 			}
 		}
 	}
 	for i := range keyTypes {
 		if !frame.CanHash(keyTypes[i]) {
-			typecheck.Panicf(1, "cogroup: key column(%d) type %s cannot be hashed", i, keyTypes[i])
+			typecheck.Panicf(2, "%s: key column(%d) type %s cannot be hashed", name.Op, i, keyTypes[i])
 		}
 		if !frame.CanCompare(keyTypes[i]) {
-			typecheck.Panicf(1, "cogroup: key column(%d) type %s cannot be sorted", i, keyTypes[i])
+			typecheck.Panicf(2, "%s: key column(%d) type %s cannot be sorted", name.Op, i, keyTypes[i])
 		}
 	}
 	out := keyTypes
@@ -92,11 +108,12 @@ func Cogroup(slices ...Slice) Slice {
 	}
 
 	return &cogroupSlice{
-		name:     makeName("cogroup"),
-		numShard: numShard,
-		slices:   slices,
-		out:      out,
-		prefix:   len(keyTypes),
+		name:          name,
+		numShard:      numShard,
+		slices:        slices,
+		out:           out,
+		prefix:        len(keyTypes),
+		copartitioned: copartitioned(slices),
 	}
 }
 
@@ -107,7 +124,7 @@ func (c *cogroupSlice) NumOut() int            { return len(c.out) }
 func (c *cogroupSlice) Out(i int) reflect.Type { return c.out[i] }
 func (c *cogroupSlice) Prefix() int            { return c.prefix }
 func (c *cogroupSlice) NumDep() int            { return len(c.slices) }
-func (c *cogroupSlice) Dep(i int) Dep          { return Dep{c.slices[i], true, false} }
+func (c *cogroupSlice) Dep(i int) Dep          { return Dep{c.slices[i], !c.copartitioned, false} }
 func (*cogroupSlice) Combiner() *reflect.Value { return nil }
 
 type cogroupReader struct {
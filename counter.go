@@ -0,0 +1,135 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/grailbio/bigslice/stats"
+)
+
+// Counters collects the user-defined counters that are incremented
+// by user functions while evaluating a task. Counters are kept per
+// slice: each counter belongs to the slice whose user function
+// incremented it. Executors provide a Counters to the readers of a
+// task through the task's context; see WithCounters.
+type Counters struct {
+	mu     sync.Mutex
+	slices map[Name]*stats.Map
+}
+
+// Counter returns the named counter of the provided slice. The
+// counter is created if it does not already exist.
+func (c *Counters) Counter(slice Name, name string) *stats.Int {
+	c.mu.Lock()
+	if c.slices == nil {
+		c.slices = make(map[Name]*stats.Map)
+	}
+	m := c.slices[slice]
+	if m == nil {
+		m = stats.NewMap()
+		c.slices[slice] = m
+	}
+	c.mu.Unlock()
+	return m.Int(name)
+}
+
+// Values returns a snapshot of the counters' values, keyed by slice.
+func (c *Counters) Values() map[Name]stats.Values {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	vals := make(map[Name]stats.Values, len(c.slices))
+	for slice, m := range c.slices {
+		vals[slice] = make(stats.Values)
+		m.AddAll(vals[slice])
+	}
+	return vals
+}
+
+type countersKey struct{}
+
+// WithCounters returns a context that carries the provided Counters.
+// Executors use this to collect the user-defined counters of the
+// tasks that they run.
+func WithCounters(ctx context.Context, c *Counters) context.Context {
+	return context.WithValue(ctx, countersKey{}, c)
+}
+
+type counterSliceKey struct{}
+
+// WithCounterSlice returns a context that attributes the counters
+// incremented through it to the named slice.
+func withCounterSlice(ctx context.Context, slice Name) context.Context {
+	return context.WithValue(ctx, counterSliceKey{}, slice)
+}
+
+// Counter returns the user-defined counter with the provided name.
+// User functions that accept a context (see Map, Filter, and
+// Flatmap) may use counters to count events of interest, for
+// example:
+//
+//	slice = bigslice.Filter(slice, func(ctx context.Context, line string) bool {
+//		if !valid(line) {
+//			bigslice.Counter(ctx, "malformed").Add(1)
+//			return false
+//		}
+//		return true
+//	})
+//
+// Counters are aggregated across the tasks that compute each slice:
+// they are displayed in the status of the slice while it is being
+// computed, and are reported by the computation's result. Only the
+// counters of successful task runs are retained, so that rows are
+// counted once even if their tasks are retried.
+//
+// Counters incremented through the context of a reader that is not
+// associated with a particular slice are attributed to the slice
+// computed by the task. If ctx is not a task's context, Counter
+// returns a nil counter, which ignores updates.
+func Counter(ctx context.Context, name string) *stats.Int {
+	c, _ := ctx.Value(countersKey{}).(*Counters)
+	if c == nil {
+		return nil
+	}
+	slice, _ := ctx.Value(counterSliceKey{}).(Name)
+	return c.Counter(slice, name)
+}
+
+type funcTimerKey struct{}
+
+// WithFuncTimer returns a context that carries the provided timer, to
+// which the latency of calls to the user functions of Map, Filter,
+// Flatmap, and ReaderFunc is recorded. Executors use this to report
+// the distribution of the latencies of user functions. The calls that
+// Map, Filter, and Flatmap make for a batch of rows are timed
+// together, and recorded at their mean latency, so that timing adds
+// no per-row cost. The timer should be local to the task whose
+// context is ctx, and merged into shared stats when the task
+// completes.
+func WithFuncTimer(ctx context.Context, timer *stats.Timer) context.Context {
+	return context.WithValue(ctx, funcTimerKey{}, timer)
+}
+
+// FuncTimer returns the timer carried by ctx, or nil if ctx does not
+// carry one.
+func funcTimer(ctx context.Context) *stats.Timer {
+	timer, _ := ctx.Value(funcTimerKey{}).(*stats.Timer)
+	return timer
+}
+
+// CallTimed calls the user function fn with the provided arguments,
+// recording the latency of the call to timer, if it is not nil.
+func callTimed(timer *stats.Timer, fn reflect.Value, args []reflect.Value) []reflect.Value {
+	if timer == nil {
+		return fn.Call(args)
+	}
+	start := time.Now()
+	result := fn.Call(args)
+	timer.Observe(time.Since(start))
+	return result
+}
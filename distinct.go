@@ -0,0 +1,157 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/sortio"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+var typeOfBool = reflect.TypeOf(false)
+
+// Distinct returns a slice that contains each distinct row of the
+// provided slice exactly once. All of the slice's columns must be
+// partitionable. Distinct performs map-side "combining", so that
+// duplicate rows are dropped before they are shuffled; the combine
+// buffers spill to disk when the set of rows is large. Rows are not
+// sorted within a shard. Schematically:
+//
+//	Distinct(Slice<t1, t2, ..., tn>) Slice<t1, t2, ..., tn>
+func Distinct(slice Slice) Slice {
+	if slice.NumOut() == 0 {
+		typecheck.Panic(1, "distinct: slice has no columns")
+	}
+	key := &distinctKeySlice{name: makeName("distinctkey"), Slice: slice}
+	if err := canMakeCombiningFrame(key); err != nil {
+		typecheck.Panic(1, err.Error())
+	}
+	d := &distinctSlice{Slice: slice, key: key, combiner: firstCombiner(typeOfBool)}
+	d.name = makeName("distinct")
+	return d
+}
+
+// DistinctBy returns a slice that contains exactly one row for each
+// distinct value of the provided slice's prefix columns. The slice
+// must have exactly one residual column (as in Reduce), and its
+// prefix columns must be partitionable. For each key, the value of
+// the first row encountered is retained; since the order in which
+// rows are combined is not defined across shards, the value kept for
+// keys that appear in multiple shards is arbitrary. Like Distinct,
+// DistinctBy drops duplicates before they are shuffled. Schematically:
+//
+//	DistinctBy(Slice<k1, ..., kp, v>) Slice<k1, ..., kp, v>
+func DistinctBy(slice Slice) Slice {
+	if res := slice.NumOut() - slice.Prefix(); res != 1 {
+		typecheck.Panicf(1, "distinctby: the slice must have exactly one residual column; has %d", res)
+	}
+	if err := canMakeCombiningFrame(slice); err != nil {
+		typecheck.Panic(1, err.Error())
+	}
+	return &reduceSlice{slice, makeName("distinctby"), firstCombiner(slice.Out(slice.NumOut() - 1))}
+}
+
+// FirstCombiner returns a combiner for values of type typ that
+// retains the first of the two values it is presented.
+func firstCombiner(typ reflect.Type) reflect.Value {
+	fn := reflect.FuncOf([]reflect.Type{typ, typ}, []reflect.Type{typ}, false)
+	return reflect.MakeFunc(fn, func(args []reflect.Value) []reflect.Value {
+		return args[:1]
+	})
+}
+
+// DistinctKeySlice amends its underlying slice with a (boolean)
+// value column, making the underlying slice's columns the prefix. This
+// permits full rows to be deduplicated by the combining machinery.
+type distinctKeySlice struct {
+	name Name
+	Slice
+}
+
+func (d *distinctKeySlice) Name() Name    { return d.name }
+func (d *distinctKeySlice) NumOut() int   { return d.Slice.NumOut() + 1 }
+func (d *distinctKeySlice) Prefix() int   { return d.Slice.NumOut() }
+func (*distinctKeySlice) NumDep() int     { return 1 }
+func (d *distinctKeySlice) Dep(i int) Dep { return singleDep(i, d.Slice, false) }
+func (d *distinctKeySlice) Out(c int) reflect.Type {
+	if c == d.Slice.NumOut() {
+		return typeOfBool
+	}
+	return d.Slice.Out(c)
+}
+func (*distinctKeySlice) ShardType() ShardType     { return HashShard }
+func (*distinctKeySlice) Combiner() *reflect.Value { return nil }
+
+type distinctKeyReader struct {
+	op     *distinctKeySlice
+	reader sliceio.Reader
+}
+
+func (d *distinctKeyReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if !slicetype.Assignable(out, d.op) {
+		return 0, errTypeError
+	}
+	// Read directly into the key columns of out.
+	vcol := d.op.Slice.NumOut()
+	n, err := d.reader.Read(ctx, frame.Values(out.Values()[:vcol]))
+	for i := 0; i < n; i++ {
+		out.Index(vcol, i).SetBool(true)
+	}
+	return n, err
+}
+
+func (d *distinctKeySlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &distinctKeyReader{d, deps[0]}
+}
+
+// DistinctSlice deduplicates the rows of its (keyed) dependency,
+// and strips the value column from its output.
+type distinctSlice struct {
+	name Name
+	Slice
+	key      *distinctKeySlice
+	combiner reflect.Value
+}
+
+func (d *distinctSlice) Name() Name               { return d.name }
+func (*distinctSlice) ShardType() ShardType       { return HashShard }
+func (*distinctSlice) NumDep() int                { return 1 }
+func (d *distinctSlice) Dep(i int) Dep            { return Dep{d.key, true, true} }
+func (d *distinctSlice) Combiner() *reflect.Value { return &d.combiner }
+
+func (d *distinctSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	reader := deps[0]
+	if len(deps) > 1 {
+		reader = sortio.Reduce(d.key, fmt.Sprintf("distinct-%d", shard), deps, d.combiner)
+	}
+	return &distinctReader{op: d, reader: reader}
+}
+
+type distinctReader struct {
+	op     *distinctSlice
+	reader sliceio.Reader
+	in     frame.Frame
+}
+
+func (d *distinctReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if !slicetype.Assignable(out, d.op) {
+		return 0, errTypeError
+	}
+	n := out.Len()
+	if d.in.IsZero() {
+		d.in = frame.Make(d.op.key, n, n)
+	} else {
+		d.in = d.in.Ensure(n)
+	}
+	n, err := d.reader.Read(ctx, d.in.Slice(0, n))
+	frame.Copy(out, frame.Values(d.in.Slice(0, n).Values()[:d.op.Slice.NumOut()]))
+	return n, err
+}
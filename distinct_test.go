@@ -0,0 +1,95 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/slicetest"
+)
+
+func TestDistinct(t *testing.T) {
+	const N = 1000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	for m := 1; m < 5; m++ {
+		slice := bigslice.Const(m, ints)
+		slice = bigslice.Map(slice, func(x int) (string, int) {
+			return fmt.Sprintf("%dx%d", x%3, x%2), x % 2
+		})
+		slice = bigslice.Distinct(slice)
+		assertEqual(t, slice, true,
+			[]string{"0x0", "0x1", "1x0", "1x1", "2x0", "2x1"},
+			[]int{0, 1, 0, 1, 0, 1})
+	}
+}
+
+func TestDistinctName(t *testing.T) {
+	slice := bigslice.Distinct(bigslice.Const(1, []int{1}))
+	// The map-side stage has its own name, so that its status and
+	// counters are kept apart from the distinct's.
+	if got, want := slice.Dep(0).Slice.Name().Op, "distinctkey"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := slice.Name().Op, "distinct"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDistinctBy(t *testing.T) {
+	const N = 1000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	for m := 1; m < 5; m++ {
+		slice := bigslice.Const(m, ints)
+		slice = bigslice.Map(slice, func(x int) (string, int, int) {
+			return "x", x % 3, x
+		})
+		slice = bigslice.Prefixed(slice, 2)
+		slice = bigslice.DistinctBy(slice)
+		// The value retained for each key is arbitrary, but it must be
+		// one of the key's values.
+		slice = bigslice.Map(slice, func(k1 string, k2, v int) (string, int, bool) {
+			return k1 + fmt.Sprint(k2), v, v%3 == k2
+		})
+		var (
+			keys  []string
+			vals  []int
+			valid []bool
+		)
+		for _, s := range run(context.Background(), t, slice) {
+			slicetest.ScanAll(t, s, &keys, &vals, &valid)
+			if got, want := len(keys), 3; got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			seen := make(map[string]bool)
+			for i := range keys {
+				if seen[keys[i]] {
+					t.Errorf("duplicate key %s", keys[i])
+				}
+				seen[keys[i]] = true
+				if !valid[i] {
+					t.Errorf("key %s: invalid value %d", keys[i], vals[i])
+				}
+			}
+		}
+	}
+}
+
+func TestDistinctTypeError(t *testing.T) {
+	expectTypeError(t, "cannot combine values for keys of type: []int", func() {
+		bigslice.Distinct(bigslice.Const(1, []string{"a"}, [][]int{{1}}))
+	})
+	expectTypeError(t, "distinctby: the slice must have exactly one residual column; has 2", func() {
+		bigslice.DistinctBy(bigslice.Const(1, []string{"a"}, []int{1}, []int{2}))
+	})
+}
@@ -15,6 +15,7 @@ import (
 	"net/http"
 	"runtime"
 	"runtime/debug"
+	"sort"
 	"sync"
 	"time"
 
@@ -113,6 +114,13 @@ type bigmachineExecutor struct {
 	managers []*machineManager
 }
 
+// NewBigmachineExecutor returns a new executor that runs tasks on
+// machines of the provided bigmachine system, as configured by the
+// Bigmachine option.
+func NewBigmachineExecutor(system bigmachine.System, params ...bigmachine.Param) Executor {
+	return newBigmachineExecutor(system, params...)
+}
+
 func newBigmachineExecutor(system bigmachine.System, params ...bigmachine.Param) *bigmachineExecutor {
 	return &bigmachineExecutor{system: system, params: params}
 }
@@ -133,6 +141,8 @@ func (b *bigmachineExecutor) Start(sess *Session) (shutdown func()) {
 	b.invocationDeps = make(map[uint64]map[uint64]bool)
 	b.worker = &worker{
 		MachineCombiners: sess.machineCombiners,
+		CaptureDir:       sess.captureDir,
+		CaptureRecords:   sess.captureRecords,
 	}
 
 	return b.b.Shutdown
@@ -152,6 +162,7 @@ func (b *bigmachineExecutor) manager(i int) *machineManager {
 			maxLoad = 0
 		}
 		b.managers[i] = newMachineManager(b.b, b.params, b.status, b.sess.Parallelism(), maxLoad, b.worker)
+		b.managers[i].eventLog = b.sess.eventLog
 		go b.managers[i].Do(backgroundcontext.Get())
 	}
 	return b.managers[i]
@@ -298,8 +309,9 @@ compile:
 	// Populate the run request. Include the locations of all dependent
 	// outputs so that the receiving worker can read from them.
 	req := taskRunRequest{
-		Name:       task.Name,
-		Invocation: task.Invocation.Index,
+		Name:        task.Name,
+		Invocation:  task.Invocation.Index,
+		ErrorPolicy: b.sess.errorPolicy,
 	}
 	machineIndices := make(map[string]int)
 	g, _ := errgroup.WithContext(ctx)
@@ -342,6 +354,7 @@ compile:
 	defer ctxcancel()
 
 	b.sess.tracer.Event(m, task, "B")
+	b.sess.observer.assign(task, m.Addr)
 	task.Set(TaskRunning)
 	var reply taskRunReply
 	err := m.RetryCall(ctx, "Worker.Run", req, &reply)
@@ -349,6 +362,13 @@ compile:
 	switch {
 	case err == nil:
 		b.sess.tracer.Event(m, task, "E")
+		task.setRowErrorList(reply.Skipped, reply.DeadLetters, reply.DeadLetterSlices)
+		task.setCounterList(reply.Counters)
+		b.sess.ReportRun(task, TaskRunStats{
+			RecordsIn:    reply.RecordsIn,
+			RecordsOut:   reply.RecordsOut,
+			ShuffleBytes: reply.ShuffleBytes,
+		})
 		b.setLocation(task, m)
 		task.Set(TaskOk)
 		m.Assign(task)
@@ -382,7 +402,9 @@ func (b *bigmachineExecutor) Reader(ctx context.Context, task *Task, partition i
 	if m == nil {
 		return sliceio.ErrReader(errors.E(errors.NotExist, fmt.Sprintf("task %s", task.Name)))
 	}
-	if task.CombineKey != "" {
+	// Tasks with combine keys store only their dead letters under their
+	// own names.
+	if task.CombineKey != "" && partition < task.NumPartition {
 		return sliceio.ErrReader(fmt.Errorf("read %s: cannot read tasks with combine keys", task.Name))
 	}
 	// TODO(marius): access the store here, too, in case it's a shared one (e.g., s3)
@@ -396,6 +418,94 @@ func (b *bigmachineExecutor) HandleDebug(handler *http.ServeMux) {
 	b.b.HandleDebug(handler)
 }
 
+// addMetrics implements metricsExecutor.
+func (b *bigmachineExecutor) addMetrics(m *metrics) {
+	b.mu.Lock()
+	managers := append([]*machineManager(nil), b.managers...)
+	b.mu.Unlock()
+	var (
+		machines, lost int
+		spillBytes     int64
+		dists          = make(stats.Distributions)
+	)
+	for _, manager := range managers {
+		if manager == nil {
+			continue
+		}
+		for _, mach := range manager.Machines() {
+			machines++
+			mach.mu.Lock()
+			var (
+				addr   = mach.Addr
+				isLost = mach.lost
+				load   = mach.load
+				mem    = mach.mem
+				disk   = mach.disk
+				vals   = mach.vals.Copy()
+			)
+			// The distributions and bytes spilled of lost machines remain
+			// in the totals, so that these do not decrease.
+			dists.Merge(mach.dists)
+			mach.mu.Unlock()
+			spillBytes += vals["spillbytes"]
+			if isLost {
+				lost++
+				continue
+			}
+			m.Add("bigslice_machine_load1", "gauge", "One-minute load average of the machine.",
+				load.Averages.Load1, "machine", addr)
+			m.Add("bigslice_machine_memory_used_bytes", "gauge", "Memory used on the machine.",
+				float64(mem.System.Used), "machine", addr)
+			m.Add("bigslice_machine_disk_used_bytes", "gauge", "Disk space used on the machine.",
+				float64(disk.Usage.Used), "machine", addr)
+			names := make([]string, 0, len(vals))
+			for name := range vals {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				m.Add("bigslice_machine_counter", "counter", "Worker counters of the machine, e.g., records read (read) and written (write), and bytes shuffled (shufflebytes) and spilled (spillbytes).",
+					float64(vals[name]), "machine", addr, "name", name)
+			}
+		}
+	}
+	addDistributions(m, dists)
+	m.Add("bigslice_spill_bytes_total", "counter", spillBytesHelp, float64(spillBytes))
+	m.Add("bigslice_machines", "gauge", "Number of machines that are live.", float64(machines-lost))
+	m.Add("bigslice_machines_lost_total", "counter", "Number of machines that have been lost.", float64(lost))
+}
+
+// machineStatus implements machineStatusExecutor.
+func (b *bigmachineExecutor) machineStatus() []machineStatus {
+	b.mu.Lock()
+	managers := append([]*machineManager(nil), b.managers...)
+	b.mu.Unlock()
+	var statuses []machineStatus
+	for _, manager := range managers {
+		if manager == nil {
+			continue
+		}
+		for _, mach := range manager.Machines() {
+			mach.mu.Lock()
+			statuses = append(statuses, machineStatus{
+				Addr:      mach.Addr,
+				Lost:      mach.lost,
+				Tasks:     mach.Stats.Int("tasks").Get(),
+				Maxprocs:  mach.Maxprocs,
+				Load1:     mach.load.Averages.Load1,
+				MemUsed:   mach.mem.System.Used,
+				MemTotal:  mach.mem.System.Total,
+				DiskUsed:  mach.disk.Usage.Used,
+				DiskTotal: mach.disk.Usage.Total,
+				Counters:  mach.vals.String(),
+			})
+			mach.mu.Unlock()
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Addr < statuses[j].Addr })
+	return statuses
+}
+
 // Location returns the machine on which the results of the provided
 // task resides.
 func (b *bigmachineExecutor) location(task *Task) *sliceMachine {
@@ -428,6 +538,10 @@ type worker struct {
 	// MachineCombiners determines whether to use the MachineCombiners
 	// compilation option.
 	MachineCombiners bool
+	// CaptureDir and CaptureRecords configure the capture of failed
+	// tasks; see CaptureFailures.
+	CaptureDir     string
+	CaptureRecords int
 
 	b     *bigmachine.B
 	store Store
@@ -438,10 +552,18 @@ type worker struct {
 	tasks    map[uint64]map[TaskName]*Task
 	slices   map[uint64]bigslice.Slice
 	stats    *stats.Map
-
-	// CombinerStates and combiners are used to manage shared combine
-	// buffers.
+	// runStats holds the statistics of the last successful run of
+	// each task, which are returned also to repeated requests to run
+	// the task.
+	runStats map[*Task]TaskRunStats
+	// invocations holds the compiled invocations, as they were
+	// received from the driver.
+	invocations map[uint64]bigslice.Invocation
+
+	// CombinerStates, combinerErrs, and combiners are used to manage
+	// shared combine buffers.
 	combinerStates map[TaskName]combinerState
+	combinerErrs   map[TaskName]error
 	combiners      map[TaskName][]chan *combiner
 
 	commitLimiter *limiter.Limiter
@@ -451,8 +573,11 @@ func (w *worker) Init(b *bigmachine.B) error {
 	w.cond = ctxsync.NewCond(&w.mu)
 	w.tasks = make(map[uint64]map[TaskName]*Task)
 	w.slices = make(map[uint64]bigslice.Slice)
+	w.runStats = make(map[*Task]TaskRunStats)
+	w.invocations = make(map[uint64]bigslice.Invocation)
 	w.combiners = make(map[TaskName][]chan *combiner)
 	w.combinerStates = make(map[TaskName]combinerState)
+	w.combinerErrs = make(map[TaskName]error)
 	w.b = b
 	dir, err := ioutil.TempDir("", "bigslice")
 	if err != nil {
@@ -493,6 +618,8 @@ func (w *worker) Compile(ctx context.Context, inv bigslice.Invocation, _ *struct
 		}
 	}()
 	return w.compiles.Do(inv.Index, func() error {
+		orig := inv
+		orig.Args = append([]interface{}(nil), inv.Args...)
 		// Substitute invocation refs for the results of the invocation.
 		// The executor must ensure that all references have been compiled.
 		for i, arg := range inv.Args {
@@ -523,6 +650,7 @@ func (w *worker) Compile(ctx context.Context, inv bigslice.Invocation, _ *struct
 		w.mu.Lock()
 		w.tasks[inv.Index] = named
 		w.slices[inv.Index] = &Result{Slice: slice, tasks: tasks}
+		w.invocations[inv.Index] = orig
 		w.mu.Unlock()
 		return nil
 	})
@@ -544,19 +672,45 @@ type taskRunRequest struct {
 	// fact that the task graph is identical to all viewers: locations
 	// are stored in the order of task dependencies.
 	Locations []int
+
+	// ErrorPolicy is the session's policy for errors returned by user
+	// functions.
+	ErrorPolicy bigslice.ErrorPolicy
 }
 
 func (r *taskRunRequest) location(taskIndex int) string {
 	return r.Machines[r.Locations[taskIndex]]
 }
 
-type taskRunReply struct{} // nothing here yet
+// TaskRunReply contains the results of a successful task run.
+type taskRunReply struct {
+	// Skipped is the number of rows that were skipped because of errors
+	// returned by user functions.
+	Skipped int64
+	// DeadLetters describes the dead letters that were stored with the
+	// task's output, if the task was run with the DeadLetterOnError
+	// policy. DeadLetterSlices contains the position, in the task's
+	// slices, of the slice of each of the dead letters.
+	DeadLetters      []DeadLetterOutput
+	DeadLetterSlices []int
+	// Counters contains the values of the task's user-defined
+	// counters, indexed by the position of their slice in the task's
+	// slices.
+	Counters []stats.Values
+	// RecordsIn and RecordsOut are the number of records read and
+	// written by the task.
+	RecordsIn, RecordsOut int64
+	// ShuffleBytes is the encoded size of the dependency outputs read
+	// by the task.
+	ShuffleBytes int64
+}
 
 // Run runs an individual task as described in the request. Run
 // returns a nil error when the task was successfully run and its
 // output deposited in a local buffer.
 func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunReply) (err error) {
 	recordsOut := w.stats.Int("write")
+	taskTime := w.stats.Timer("tasktime")
 	w.mu.Lock()
 	named := w.tasks[req.Invocation]
 	w.mu.Unlock()
@@ -587,21 +741,80 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 		if e := task.Err(); e != nil {
 			err = e
 		}
+		if err == nil {
+			reply.Skipped, reply.DeadLetters, reply.DeadLetterSlices = task.rowErrorList()
+			reply.Counters = task.counterList()
+			w.mu.Lock()
+			stats := w.runStats[task]
+			w.mu.Unlock()
+			reply.RecordsIn, reply.RecordsOut, reply.ShuffleBytes = stats.RecordsIn, stats.RecordsOut, stats.ShuffleBytes
+		}
 		return err
 	}
 	task.state = TaskRunning
 	task.Unlock()
+	start := time.Now()
+	// Dead letters are written to the store, in partitions that follow
+	// the task's output partitions.
+	deadLetters := newDeadLetterWriter(ctx, w.store, task)
+	rowErrors := &bigslice.RowErrors{
+		Policy:           req.ErrorPolicy,
+		WriteDeadLetters: deadLetters.Write,
+	}
+	ctx = bigslice.WithRowErrors(ctx, rowErrors)
+	counters := new(bigslice.Counters)
+	ctx = bigslice.WithCounters(ctx, counters)
+	// As in the local executor, function latencies are recorded by a
+	// timer that is local to the task.
+	funcTime := stats.NewTimer()
+	defer w.stats.Timer("functime").Merge(funcTime)
+	ctx = bigslice.WithFuncTimer(ctx, funcTime)
+	ctx = bigslice.WithTask(ctx, task.Name.String(), task.Name.Shard, task.Invocation.Index)
+	// Release the resources held by the task's readers, including
+	// those that were abandoned, once the task has completed. This is
+	// deferred first so that failed tasks are captured beforehand.
+	ctx, cleanup := bigslice.WithTaskCleanup(ctx)
+	defer cleanup()
+	var (
+		taskRecordsIn, taskRecordsOut stats.Int
+		// ShuffleBytes is the encoded size of the dependency outputs
+		// read by the task, where known.
+		shuffleBytes int64
+	)
 	defer func() {
 		if e := recover(); e != nil {
 			stack := debug.Stack()
 			err = fmt.Errorf("panic while evaluating slice: %v\n%s", e, string(stack))
 			err = errors.E(err, errors.Fatal)
 		}
+		var outputs []DeadLetterOutput
+		if err == nil {
+			err = rowErrors.Flush()
+		}
+		if err == nil {
+			outputs, err = deadLetters.Commit(ctx)
+		}
 		if err != nil {
+			deadLetters.Discard(ctx)
 			log.Printf("task %s error: %v", req.Name, err)
+			w.capture(ctx, req, task, err)
 			task.Error(errors.Recover(err))
 		} else {
+			task.SetRowErrors(rowErrors.Skipped(), outputs)
+			task.SetCounters(counters.Values())
+			stats := TaskRunStats{
+				RecordsIn:    taskRecordsIn.Get(),
+				RecordsOut:   taskRecordsOut.Get(),
+				ShuffleBytes: shuffleBytes,
+			}
+			w.mu.Lock()
+			w.runStats[task] = stats
+			w.mu.Unlock()
+			reply.Skipped, reply.DeadLetters, reply.DeadLetterSlices = task.rowErrorList()
+			reply.Counters = task.counterList()
+			reply.RecordsIn, reply.RecordsOut, reply.ShuffleBytes = stats.RecordsIn, stats.RecordsOut, stats.ShuffleBytes
 			task.Set(TaskOk)
+			taskTime.Observe(time.Since(start))
 		}
 	}()
 
@@ -610,10 +823,12 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 	var (
 		totalRecordsIn *stats.Int
 		recordsIn      *stats.Int
+		readBatches    *stats.Histogram
 	)
 	if len(task.Deps) > 0 {
 		totalRecordsIn = w.stats.Int("inrecords")
 		recordsIn = w.stats.Int("read")
+		readBatches = w.stats.Histogram("readbatch")
 	}
 	var (
 		in        = make([]sliceio.Reader, 0, len(task.Deps))
@@ -653,7 +868,7 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 					Machine:       machine,
 					TaskPartition: taskPartition{TaskName{Op: dep.CombineKey}, dep.Partition},
 				}
-				in = append(in, &statsReader{r, recordsIn})
+				in = append(in, &statsReader{r, recordsIn, readBatches})
 				defer r.Close()
 			}
 		} else {
@@ -671,6 +886,9 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 						defer rc.Close()
 						reader.q[j] = sliceio.NewDecodingReader(rc)
 						totalRecordsIn.Add(info.Records)
+						if info.Size > 0 {
+							shuffleBytes += info.Size
+						}
 						taskIndex++
 						continue Tasks
 					}
@@ -690,8 +908,11 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 					Machine:       machine,
 					TaskPartition: tp,
 				}
-				reader.q[j] = &statsReader{r, recordsIn}
+				reader.q[j] = &statsReader{r, recordsIn, readBatches}
 				totalRecordsIn.Add(info.Records)
+				if info.Size > 0 {
+					shuffleBytes += info.Size
+				}
 				defer r.Close()
 			}
 			// We shuffle the tasks here so that we don't encounter
@@ -711,10 +932,17 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 		}
 	}
 
+	// Count the task's own records, regardless of where they are
+	// read from.
+	for i := range in {
+		in[i] = &statsReader{in[i], &taskRecordsIn, nil}
+	}
+	out := sliceio.Reader(&statsReader{task.Do(in), &taskRecordsOut, nil})
+
 	// If we have a combiner, then we partition globally for the machine
 	// into common combiners.
 	if task.Combiner != nil {
-		return w.runCombine(ctx, task, task.Do(in))
+		return w.runCombine(ctx, task, out)
 	}
 
 	// Stream partition output directly to the underlying store, but
@@ -750,7 +978,6 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 			part.wc.Discard(ctx)
 		}
 	}()
-	out := task.Do(in)
 	count := make([]int64, task.NumPartition)
 	switch {
 	case task.NumOut() == 0:
@@ -769,21 +996,27 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 		for i := range partitionv {
 			partitionv[i] = frame.Make(task, psize, psize)
 		}
-		in := frame.Make(task, *defaultChunksize, *defaultChunksize)
+		var (
+			in        = frame.Make(task, *defaultChunksize, *defaultChunksize)
+			partition = task.NewPartitioner()
+		)
 		for {
 			n, err := out.Read(ctx, in)
 			if err != nil && err != sliceio.EOF {
 				return err
 			}
 			for i := 0; i < n; i++ {
-				p := int(in.Hash(i)) % task.NumPartition
+				p, err := partition(in, i)
+				if err != nil {
+					return err
+				}
 				j := lens[p]
 				frame.Copy(partitionv[p].Slice(j, j+1), in.Slice(i, i+1))
 				lens[p]++
 				count[p]++
 				// Flush when we fill up.
 				if lens[p] == psize {
-					if err := partitions[p].Encode(partitionv[p]); err != nil {
+					if err := partitions[p].Encode(task.ProjectPartition(partitionv[p], p)); err != nil {
 						return err
 					}
 					lens[p] = 0
@@ -799,7 +1032,7 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 			if n == 0 {
 				continue
 			}
-			if err := partitions[p].Encode(partitionv[p].Slice(0, n)); err != nil {
+			if err := partitions[p].Encode(task.ProjectPartition(partitionv[p].Slice(0, n), p)); err != nil {
 				return err
 			}
 		}
@@ -810,7 +1043,7 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 			if err != nil && err != sliceio.EOF {
 				return err
 			}
-			if err := partitions[0].Encode(in.Slice(0, n)); err != nil {
+			if err := partitions[0].Encode(task.ProjectPartition(in.Slice(0, n), 0)); err != nil {
 				return err
 			}
 			recordsOut.Add(int64(n))
@@ -821,6 +1054,7 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 		}
 	}
 
+	partitionRows := w.stats.Histogram("partitionrows")
 	for i, part := range partitions {
 		if err := part.buf.Flush(); err != nil {
 			return err
@@ -829,11 +1063,86 @@ func (w *worker) Run(ctx context.Context, req taskRunRequest, reply *taskRunRepl
 		if err := part.wc.Commit(ctx, count[i]); err != nil {
 			return err
 		}
+		partitionRows.Observe(count[i])
 	}
 	partitions = nil
 	return nil
 }
 
+// Capture captures the inputs of the provided failed task, if the
+// worker is configured to capture failures. Tasks that failed
+// because of unavailable machines or cancellation are not captured:
+// they are not the fault of the task.
+func (w *worker) capture(ctx context.Context, req taskRunRequest, task *Task, taskErr error) {
+	if w.CaptureDir == "" || ctx.Err() != nil ||
+		errors.Is(errors.Unavailable, taskErr) || errors.Is(errors.Net, taskErr) {
+		return
+	}
+	chain, err := invocationChain(task.Invocation, func(index uint64) (bigslice.Invocation, bool) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		inv, ok := w.invocations[index]
+		return inv, ok
+	})
+	if err != nil {
+		log.Error.Printf("task %s: capture: %v", task.Name, err)
+		return
+	}
+	// Open the task's inputs anew, from the same locations from which
+	// they were read by the task.
+	var (
+		deps      = make([][]sliceio.Reader, len(task.Deps))
+		taskIndex int
+	)
+	for i, dep := range task.Deps {
+		if dep.CombineKey != "" {
+			locations := make(map[string]bool)
+			for j := 0; j < dep.NumTask(); j++ {
+				addr := req.location(taskIndex)
+				taskIndex++
+				if locations[addr] {
+					continue
+				}
+				locations[addr] = true
+				machine, err := w.b.Dial(ctx, addr)
+				if err != nil {
+					log.Error.Printf("task %s: capture: %v", task.Name, err)
+					return
+				}
+				r := &machineReader{
+					Machine:       machine,
+					TaskPartition: taskPartition{TaskName{Op: dep.CombineKey}, dep.Partition},
+				}
+				defer r.Close()
+				deps[i] = append(deps[i], r)
+			}
+			continue
+		}
+		for j := 0; j < dep.NumTask(); j++ {
+			deptask := dep.Task(j)
+			addr := req.location(taskIndex)
+			taskIndex++
+			if rc, err := w.store.Open(ctx, deptask.Name, dep.Partition, 0); err == nil {
+				defer rc.Close()
+				deps[i] = append(deps[i], sliceio.NewDecodingReader(rc))
+				continue
+			}
+			machine, err := w.b.Dial(ctx, addr)
+			if err != nil {
+				log.Error.Printf("task %s: capture: %v", task.Name, err)
+				return
+			}
+			r := &machineReader{
+				Machine:       machine,
+				TaskPartition: taskPartition{deptask.Name, dep.Partition},
+			}
+			defer r.Close()
+			deps[i] = append(deps[i], r)
+		}
+	}
+	captureTask(ctx, w.CaptureDir, w.CaptureRecords, chain, w.MachineCombiners, task, taskErr, deps)
+}
+
 func (w *worker) runCombine(ctx context.Context, task *Task, in sliceio.Reader) (err error) {
 	combineKey := task.Name
 	if task.CombineKey != "" {
@@ -893,13 +1202,17 @@ func (w *worker) runCombine(ctx context.Context, task *Task, in sliceio.Reader)
 	for i := range partitionCombiner {
 		partitionCombiner[i] = makeCombiningFrame(task, *task.Combiner, 8, 1)
 	}
+	partition := task.NewPartitioner()
 	for {
 		n, err := in.Read(ctx, out)
 		if err != nil && err != sliceio.EOF {
 			return err
 		}
 		for i := 0; i < n; i++ {
-			p := int(out.Hash(i)) % task.NumPartition
+			p, perr := partition(out, i)
+			if perr != nil {
+				return perr
+			}
 			pcomb := partitionCombiner[p]
 			pcomb.Combine(out.Slice(i, i+1))
 
@@ -942,8 +1255,18 @@ func (w *worker) runCombine(ctx context.Context, task *Task, in sliceio.Reader)
 	return nil
 }
 
+// Stats returns the worker's counters, together with the
+// process-wide count of bytes spilled by combiners.
 func (w *worker) Stats(ctx context.Context, _ struct{}, values *stats.Values) error {
 	w.stats.AddAll(*values)
+	(*values)["spillbytes"] += combineSpillBytes.Value()
+	return nil
+}
+
+// Distributions returns the distributions recorded by the worker's
+// histograms.
+func (w *worker) Distributions(ctx context.Context, _ struct{}, dists *stats.Distributions) error {
+	w.stats.AddDistributions(*dists)
 	return nil
 }
 
@@ -978,7 +1301,7 @@ func (w *worker) CommitCombiner(ctx context.Context, key TaskName, _ *struct{})
 		case combinerCommitted:
 			return nil
 		case combinerError:
-			return errors.E("error while writing combiner")
+			return errors.E("error while writing combiner", w.combinerErrs[key])
 		case combinerIdle:
 			w.combinerStates[key] = combinerWriting
 			go w.writeCombiner(key)
@@ -1024,6 +1347,7 @@ func (w *worker) writeCombiner(key TaskName) {
 	} else {
 		log.Error.Printf("failed to write combine buffer %s: %v", key, err)
 		w.combinerStates[key] = combinerError
+		w.combinerErrs[key] = err
 	}
 	w.cond.Broadcast()
 }
@@ -1033,9 +1357,25 @@ func (w *worker) writeCombiner(key TaskName) {
 // TODO(marius): should we flush combined outputs explicitly?
 func (w *worker) Read(ctx context.Context, req readRequest, rc *io.ReadCloser) (err error) {
 	*rc, err = w.store.Open(ctx, req.Name, req.Partition, req.Offset)
+	if err == nil {
+		*rc = &countingReadCloser{*rc, w.stats.Int("shufflebytes")}
+	}
 	return
 }
 
+// countingReadCloser is an io.ReadCloser that counts the bytes read
+// from it.
+type countingReadCloser struct {
+	io.ReadCloser
+	n *stats.Int
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
 // readRequest is the request payload for Worker.Run
 type readRequest struct {
 	// Name is the name of the task whose output is to be read.
@@ -1187,11 +1527,15 @@ func (m *machineReader) Close() error {
 type statsReader struct {
 	reader  sliceio.Reader
 	numRead *stats.Int
+	batches *stats.Histogram
 }
 
 func (s *statsReader) Read(ctx context.Context, f frame.Frame) (n int, err error) {
 	n, err = s.reader.Read(ctx, f)
 	s.numRead.Add(int64(n))
+	if n > 0 {
+		s.batches.Observe(int64(n))
+	}
 	return
 }
 
@@ -0,0 +1,282 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+)
+
+// captureMetadata is the name of the file, in a capture directory,
+// that contains the capture's metadata. The captured inputs are
+// stored in a file store under the "inputs" prefix.
+const captureMetadata = "task.gob"
+
+// A taskCapture describes a failed task whose inputs were captured
+// so that the task can be replayed (see ReplayTask).
+type taskCapture struct {
+	// Invocations is the chain of invocations from which the task
+	// was compiled: the task's own invocation is last, and each
+	// invocation follows the invocations on whose results it depends.
+	// Results are represented by invocationRefs.
+	Invocations []bigslice.Invocation
+	// FuncLocations contains the location of the Func of each
+	// invocation, so that replays can verify that they are run by a
+	// binary with the same Funcs.
+	FuncLocations []string
+	// MachineCombiners is the session's MachineCombiners option,
+	// which affects compilation.
+	MachineCombiners bool
+	// Task is the name of the captured task.
+	Task TaskName
+	// Error is the text of the error with which the task failed.
+	Error string
+	// MaxRecords is the maximum number of records that were captured
+	// from each input; zero indicates no limit.
+	MaxRecords int
+	// Inputs holds the number of captured readers for each of the
+	// task's dependencies.
+	Inputs []int
+}
+
+// captureInput returns the name under which the j'th reader of the
+// i'th dependency is captured.
+func captureInput(i, j int) TaskName {
+	return TaskName{Op: fmt.Sprintf("dep%d", i), Shard: j, NumShard: j + 1}
+}
+
+// InvocationChain returns the chain of invocations on which the
+// provided invocation depends: the invocation itself is last, and
+// each invocation follows the invocations on whose results it
+// depends. Results in the returned invocations' arguments are
+// replaced by invocationRefs. Invocations that are referred to by
+// invocationRefs are resolved by lookup.
+func invocationChain(inv bigslice.Invocation, lookup func(index uint64) (bigslice.Invocation, bool)) ([]bigslice.Invocation, error) {
+	var (
+		chain []bigslice.Invocation
+		seen  = make(map[uint64]bool)
+		visit func(inv bigslice.Invocation) error
+	)
+	visit = func(inv bigslice.Invocation) error {
+		if seen[inv.Index] {
+			return nil
+		}
+		seen[inv.Index] = true
+		args := make([]interface{}, len(inv.Args))
+		for i, arg := range inv.Args {
+			var dep bigslice.Invocation
+			switch arg := arg.(type) {
+			case *Result:
+				dep = arg.inv
+			case invocationRef:
+				var ok bool
+				if lookup != nil {
+					dep, ok = lookup(arg.Index)
+				}
+				if !ok {
+					return fmt.Errorf("invocation %d not found", arg.Index)
+				}
+			default:
+				args[i] = arg
+				continue
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+			args[i] = invocationRef{dep.Index}
+		}
+		inv.Args = args
+		chain = append(chain, inv)
+		return nil
+	}
+	err := visit(inv)
+	return chain, err
+}
+
+// CaptureTask captures the failed task, which was compiled from the
+// provided invocation chain and failed with the provided error, to
+// the directory dir/{task name}. Deps contains readers of the
+// task's inputs, as in taskInputs; at most maxRecords records are
+// captured from each reader, or all of them if maxRecords is zero.
+// Failures to capture are logged.
+func captureTask(ctx context.Context, dir string, maxRecords int, chain []bigslice.Invocation, machineCombiners bool, task *Task, taskErr error, deps [][]sliceio.Reader) {
+	path := file.Join(dir, task.Name.String())
+	if err := writeCapture(ctx, path, maxRecords, chain, machineCombiners, task, taskErr, deps); err != nil {
+		log.Error.Printf("task %s: failed to capture inputs to %s: %v", task.Name, path, err)
+		return
+	}
+	log.Printf("task %s: captured failed task to %s; replay it with slicetest.ReplayTask", task.Name, path)
+}
+
+func writeCapture(ctx context.Context, path string, maxRecords int, chain []bigslice.Invocation, machineCombiners bool, task *Task, taskErr error, deps [][]sliceio.Reader) error {
+	capture := taskCapture{
+		Invocations:      chain,
+		MachineCombiners: machineCombiners,
+		Task:             task.Name,
+		Error:            taskErr.Error(),
+		MaxRecords:       maxRecords,
+		Inputs:           make([]int, len(deps)),
+	}
+	locs := bigslice.FuncLocations()
+	for _, inv := range chain {
+		capture.FuncLocations = append(capture.FuncLocations, locs[inv.Func])
+	}
+	store := &fileStore{Prefix: file.Join(path, "inputs")}
+	for i, readers := range deps {
+		capture.Inputs[i] = len(readers)
+		for j, r := range readers {
+			if err := captureReader(ctx, store, captureInput(i, j), task.Deps[i].Head.PartitionType(task.Deps[i].Partition), r, maxRecords); err != nil {
+				return err
+			}
+		}
+	}
+	f, err := file.Create(ctx, file.Join(path, captureMetadata))
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f.Writer(ctx)).Encode(capture); err != nil {
+		f.Discard(ctx)
+		return err
+	}
+	return f.Close(ctx)
+}
+
+// CaptureReader writes at most maxRecords records (or all of them,
+// if maxRecords is zero) of type typ from r to the provided store.
+func captureReader(ctx context.Context, store Store, name TaskName, typ slicetype.Type, r sliceio.Reader, maxRecords int) error {
+	wc, err := store.Create(ctx, name, 0)
+	if err != nil {
+		return err
+	}
+	var (
+		enc   = sliceio.NewEncoder(wc)
+		buf   = frame.Make(typ, *defaultChunksize, *defaultChunksize)
+		count int64
+	)
+	for maxRecords == 0 || count < int64(maxRecords) {
+		f := buf
+		if maxRecords > 0 && int64(f.Len()) > int64(maxRecords)-count {
+			f = f.Slice(0, int(int64(maxRecords)-count))
+		}
+		n, err := r.Read(ctx, f)
+		if err != nil && err != sliceio.EOF {
+			wc.Discard(ctx)
+			return err
+		}
+		if n > 0 {
+			if err := enc.Encode(f.Slice(0, n)); err != nil {
+				wc.Discard(ctx)
+				return err
+			}
+			count += int64(n)
+		}
+		if err == sliceio.EOF {
+			break
+		}
+	}
+	return wc.Commit(ctx, count)
+}
+
+// ReplayTask replays a failed task that was captured by a session
+// configured with CaptureFailures. The task is recompiled from its
+// captured invocations, and its Do function is run in-process, with
+// its captured inputs, until its output is exhausted. ReplayTask
+// returns the error, if any, with which the replayed task fails.
+// Panics are not recovered, so that they may be inspected by a
+// debugger.
+//
+// The captured invocations must be compiled by the same binary that
+// captured the task (or, for example, a test binary that defines the
+// same Funcs in the same order). If the task's inputs were captured
+// only in part, the replay sees only the captured prefix of each
+// input.
+func ReplayTask(ctx context.Context, path string) error {
+	f, err := file.Open(ctx, file.Join(path, captureMetadata))
+	if err != nil {
+		return err
+	}
+	var capture taskCapture
+	err = gob.NewDecoder(f.Reader(ctx)).Decode(&capture)
+	f.Close(ctx)
+	if err != nil {
+		return errors.E(errors.Invalid, fmt.Sprintf("replay %s: decode capture", path), err)
+	}
+	locs := bigslice.FuncLocations()
+	for i, inv := range capture.Invocations {
+		if int(inv.Func) >= len(locs) || locs[inv.Func] != capture.FuncLocations[i] {
+			return errors.E(errors.Precondition, fmt.Sprintf(
+				"replay %s: func %d was defined at %s by the capturing binary; the task must be replayed by a binary with the same funcs",
+				path, inv.Func, capture.FuncLocations[i]))
+		}
+	}
+	w := &worker{
+		MachineCombiners: capture.MachineCombiners,
+		tasks:            make(map[uint64]map[TaskName]*Task),
+		slices:           make(map[uint64]bigslice.Slice),
+		invocations:      make(map[uint64]bigslice.Invocation),
+	}
+	for _, inv := range capture.Invocations {
+		if err := w.Compile(ctx, inv, nil); err != nil {
+			return err
+		}
+	}
+	inv := capture.Invocations[len(capture.Invocations)-1]
+	task := w.tasks[inv.Index][capture.Task]
+	if task == nil {
+		return errors.E(errors.NotExist, fmt.Sprintf("replay %s: task %s not found", path, capture.Task))
+	}
+	if len(capture.Inputs) != len(task.Deps) {
+		return errors.E(errors.Invalid, fmt.Sprintf("replay %s: captured %d inputs for task %s with %d dependencies",
+			path, len(capture.Inputs), task.Name, len(task.Deps)))
+	}
+	log.Printf("replaying task %s, which failed with: %s", task.Name, capture.Error)
+	store := &fileStore{Prefix: file.Join(path, "inputs")}
+	deps := make([][]sliceio.Reader, len(task.Deps))
+	for i := range deps {
+		deps[i] = make([]sliceio.Reader, capture.Inputs[i])
+		for j := range deps[i] {
+			rc, err := store.Open(ctx, captureInput(i, j), 0, 0)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			deps[i][j] = sliceio.NewDecodingReader(rc)
+		}
+	}
+	ctx = bigslice.WithTask(ctx, task.Name.String(), task.Name.Shard, task.Invocation.Index)
+	ctx, cleanup := bigslice.WithTaskCleanup(ctx)
+	defer cleanup()
+	in, err := taskInputs(ctx, task, deps)
+	if err != nil {
+		return err
+	}
+	out := task.Do(in)
+	if task.NumOut() == 0 {
+		_, err := out.Read(ctx, frame.Empty)
+		if err == sliceio.EOF {
+			err = nil
+		}
+		return err
+	}
+	buf := frame.Make(task, *defaultChunksize, *defaultChunksize)
+	for {
+		_, err := out.Read(ctx, buf)
+		if err == sliceio.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/bigslice"
+)
+
+var (
+	captureSource = bigslice.Func(func() bigslice.Slice {
+		return bigslice.Const(4, rangeSlice(0, 100), rangeSlice(0, 100))
+	})
+	captureFail = bigslice.Func(func(slice bigslice.Slice, bad int) bigslice.Slice {
+		slice = bigslice.Reduce(slice, func(a, e int) int { return a + e })
+		return bigslice.Map(slice, func(k, v int) int {
+			if k == bad {
+				panic(fmt.Sprintf("bad key %d", k))
+			}
+			return v
+		})
+	})
+)
+
+// runCapture runs a computation whose task fails on the provided key
+// in a session that captures failures to a temporary directory with
+// the provided bound. It returns the directory, which should be
+// removed by the caller, and the path of the single captured task.
+func runCapture(t *testing.T, executor Option, maxRecords int) (dir, path string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	sess := Start(executor, CaptureFailures(dir, maxRecords))
+	source, err := sess.Run(ctx, captureSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sess.Run(ctx, captureFail, source, 57); err == nil {
+		t.Fatal("expected error")
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*", captureMetadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(paths), 1; got != want {
+		t.Fatalf("got %v, want %v: %v", got, want, paths)
+	}
+	return dir, filepath.Dir(paths[0])
+}
+
+func TestCaptureReplay(t *testing.T) {
+	for name, executor := range executors {
+		t.Run(name, func(t *testing.T) {
+			dir, path := runCapture(t, executor, 0)
+			defer os.RemoveAll(dir)
+			defer func() {
+				e := recover()
+				if e == nil {
+					t.Fatal("expected replay to panic")
+				}
+				if got, want := fmt.Sprint(e), "bad key 57"; !strings.Contains(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			}()
+			err := ReplayTask(context.Background(), path)
+			t.Fatalf("replay returned %v", err)
+		})
+	}
+}
+
+func TestCaptureMaxRecords(t *testing.T) {
+	dir, path := runCapture(t, Local, 1)
+	defer os.RemoveAll(dir)
+	store := &fileStore{Prefix: file.Join(path, "inputs")}
+	// The failed task reads the 4 shards of the source.
+	for j := 0; j < 4; j++ {
+		info, err := store.Stat(context.Background(), captureInput(0, j), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Records > 1 {
+			t.Errorf("input %d: captured %d records", j, info.Records)
+		}
+	}
+	if _, err := store.Stat(context.Background(), captureInput(0, 4), 0); err == nil {
+		t.Error("unexpected input")
+	}
+}
+
+func TestReplayTaskMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ReplayTask(context.Background(), dir); err == nil {
+		t.Error("expected error")
+	}
+}
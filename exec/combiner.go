@@ -25,6 +25,7 @@ var (
 	combinerRecords      = expvar.NewInt("combinerrecords")
 	combinerTotalRecords = expvar.NewInt("combinertotalrecords")
 	combineDiskSpills    = expvar.NewInt("combinediskspills")
+	combineSpillBytes    = expvar.NewInt("combinespillbytes")
 )
 
 var (
@@ -260,6 +261,7 @@ func (c *combiner) spill(f frame.Frame) error {
 	sort.Sort(f)
 	n, err := c.spiller.Spill(f)
 	if err == nil {
+		combineSpillBytes.Add(int64(n))
 		combinerKeys.Add(-int64(f.Len()))
 		combinerRecords.Add(-int64(c.total))
 		c.total = 0
@@ -17,7 +17,9 @@ import (
 // Pipeline returns the sequence of slices that may be pipelined
 // starting from slice. Slices that do not have shuffle dependencies
 // may be pipelined together: slices[0] depends on slices[1], and so on.
-func pipeline(slice bigslice.Slice) (slices []bigslice.Slice) {
+// Pipelining stops at slices that are shared, so that these are
+// materialized and computed only once.
+func pipeline(slice bigslice.Slice, shared map[bigslice.Slice]bool) (slices []bigslice.Slice) {
 	for {
 		// Stop at *Results, so we can re-use previous tasks.
 		if _, ok := bigslice.Unwrap(slice).(*Result); ok {
@@ -27,6 +29,9 @@ func pipeline(slice bigslice.Slice) (slices []bigslice.Slice) {
 		if slice.NumDep() != 1 {
 			return
 		}
+		if _, ok := bigslice.Unwrap(slice).(bigslice.DepShardMapper); ok {
+			return
+		}
 		dep := slice.Dep(0)
 		if dep.Shuffle {
 			return
@@ -34,17 +39,121 @@ func pipeline(slice bigslice.Slice) (slices []bigslice.Slice) {
 		if pragma, ok := dep.Slice.(bigslice.Pragma); ok && pragma.Materialize() {
 			return
 		}
+		if shared[dep.Slice] && !recompute(dep.Slice) {
+			return
+		}
 		slice = dep.Slice
 	}
 }
 
+// Recompute returns whether v, a slice or a pragma, has the
+// Recompute pragma, which is an optional method of bigslice.Pragma.
+func recompute(v interface{}) bool {
+	p, ok := v.(interface{ Recompute() bool })
+	return ok && p.Recompute()
+}
+
+// SharedSlices returns the set of slices in the graph rooted at slice
+// on which multiple slices depend. Shared slices would otherwise be
+// pipelined into, and thus computed by, each of their dependents.
+func sharedSlices(slice bigslice.Slice) map[bigslice.Slice]bool {
+	var (
+		ndeps  = make(map[bigslice.Slice]int)
+		shared = make(map[bigslice.Slice]bool)
+		walk   func(bigslice.Slice)
+	)
+	walk = func(slice bigslice.Slice) {
+		for i := 0; i < slice.NumDep(); i++ {
+			dep := slice.Dep(i).Slice
+			ndeps[dep]++
+			if ndeps[dep] > 1 {
+				shared[dep] = true
+			}
+			// Results are not pipelined, so there is no need to
+			// traverse them.
+			if _, ok := bigslice.Unwrap(dep).(*Result); ok || ndeps[dep] > 1 {
+				continue
+			}
+			walk(dep)
+		}
+	}
+	walk(slice)
+	return shared
+}
+
+// A depLayout describes how a slice partitions the output of one of
+// its dependencies.
+type depLayout struct {
+	// Npartition is the number of partitions into which the output
+	// of a shuffle dependency is hashed, or 0 if the dependency is
+	// not shuffled.
+	npartition int
+	// DepPartitioned is set for the dependencies of DepPartitioners,
+	// which must agree on the partitioning of a shared dependency.
+	depPartitioned bool
+	// Owner is nonzero for shuffle dependencies whose partitioning is
+	// particular to the dependent slice: those with a custom
+	// partitioner or a combiner.
+	owner int
+}
+
+// RepartitionedSlices returns the set of slices in the graph rooted
+// at slice whose dependents partition their output in different
+// ways. The tasks of these slices are left unpartitioned, and each
+// dependent that partitions them does so through its own stage of
+// pass-thru tasks. The tasks of other slices are partitioned
+// directly, once for all of their dependents. This is decided ahead
+// of compilation since dependents that do not shuffle a slice read
+// the unpartitioned output of its individual tasks.
+func repartitionedSlices(slice bigslice.Slice) map[bigslice.Slice]bool {
+	var (
+		layouts       = make(map[bigslice.Slice]depLayout)
+		repartitioned = make(map[bigslice.Slice]bool)
+		visited       = make(map[bigslice.Slice]bool)
+		owners        int
+		walk          func(bigslice.Slice)
+	)
+	walk = func(slice bigslice.Slice) {
+		if visited[slice] {
+			return
+		}
+		visited[slice] = true
+		for i := 0; i < slice.NumDep(); i++ {
+			dep := slice.Dep(i)
+			var layout depLayout
+			if dep.Shuffle {
+				layout.npartition = slice.NumShard()
+				if _, ok := bigslice.Unwrap(slice).(bigslice.ShufflePartitioner); ok || slice.Combiner() != nil {
+					owners++
+					layout.owner = owners
+				}
+			} else {
+				_, layout.depPartitioned = bigslice.Unwrap(slice).(bigslice.DepPartitioner)
+			}
+			if prev, ok := layouts[dep.Slice]; ok && prev != layout {
+				repartitioned[dep.Slice] = true
+			}
+			layouts[dep.Slice] = layout
+			// Results are not pipelined, so there is no need to
+			// traverse them.
+			if _, ok := bigslice.Unwrap(dep.Slice).(*Result); !ok {
+				walk(dep.Slice)
+			}
+		}
+	}
+	walk(slice)
+	return repartitioned
+}
+
 // Compile compiles the provided slice into a set of task graphs,
 // each representing the computation for one shard of the slice. The
 // slice is produced by the provided invocation. Compile coalesces
 // slice operations that can be pipelined into single tasks, creating
 // wide dependencies only at shuffle boundaries. The provided namer
 // must mint names that are unique to the session. The order in which
-// the namer is invoked is guaranteed to be deterministic.
+// the namer is invoked is guaranteed to be deterministic. Slices on
+// which multiple slices depend are materialized, so that they are
+// computed once, unless they have the Recompute pragma.
 //
 // TODO(marius): we don't currently reuse tasks across compilations,
 // even though this could sometimes safely be done (when the number
@@ -57,7 +166,7 @@ func pipeline(slice bigslice.Slice) (slices []bigslice.Slice) {
 // all other slices must be derived. This simplifies the
 // implementation but may make the API a little confusing.
 func compile(slice bigslice.Slice, inv bigslice.Invocation, machineCombiners bool) (tasks []*Task, err error) {
-	c := compiler{make(taskNamer), inv, machineCombiners, make(map[bigslice.Slice][]*Task)}
+	c := compiler{make(taskNamer), inv, machineCombiners, make(map[bigslice.Slice][]*Task), sharedSlices(slice), repartitionedSlices(slice)}
 	tasks, _, err = c.compile(slice)
 	return
 }
@@ -67,6 +176,8 @@ type compiler struct {
 	inv              bigslice.Invocation
 	machineCombiners bool
 	memo             map[bigslice.Slice][]*Task
+	shared           map[bigslice.Slice]bool
+	repartitioned    map[bigslice.Slice]bool
 }
 
 // compile_ compiles the provided slice into a set of task graphs,
@@ -96,7 +207,7 @@ func (c *compiler) compile(slice bigslice.Slice) (tasks []*Task, reused bool, er
 	// Pipeline slices and create a task for each underlying shard,
 	// pipelining the eligible computations.
 	tasks = make([]*Task, slice.NumShard())
-	slices = pipeline(slice)
+	slices = pipeline(slice, c.shared)
 	ops := make([]string, 0, len(slices)+1)
 	ops = append(ops, fmt.Sprintf("inv%d", c.inv.Index))
 	var pragmas bigslice.Pragmas
@@ -125,54 +236,75 @@ func (c *compiler) compile(slice bigslice.Slice) (tasks []*Task, reused bool, er
 		if err != nil {
 			return nil, false, err
 		}
+		// The tasks of results from a previous invocation, and of
+		// slices that are partitioned differently by their dependents,
+		// are partitioned through a stage of pass-thru tasks, instead
+		// of directly; see repartitionedSlices.
+		_, isResult := bigslice.Unwrap(dep.Slice).(*Result)
+		repartition := isResult || c.repartitioned[dep.Slice]
 		// These needn't be shuffle deps, for example if we terminated
 		// pipelining early because we're reusing a result or because we're
 		// doing a shuffle-free join.
+		//
+		// Slices that implement DepShardMapper choose which shards of
+		// the dependency are read by each of their shards; slices that
+		// implement DepPartitioner also choose which partition is read.
+		if mapper, ok := bigslice.Unwrap(lastSlice).(bigslice.DepShardMapper); ok && !dep.Shuffle {
+			var partition int
+			if partitioner, ok := mapper.(bigslice.DepPartitioner); ok {
+				var (
+					fn         bigslice.Partitioner
+					npartition int
+				)
+				fn, npartition, partition = partitioner.DepPartition(i)
+				if repartition {
+					deptasks, err = c.passThru(fmt.Sprintf("%s_partition_%d", opName, i), dep.Slice, deptasks)
+					if err != nil {
+						return nil, false, err
+					}
+				}
+				var columns [][]int
+				if projector, ok := partitioner.(bigslice.DepPartitionProjector); ok {
+					columns = projector.DepPartitionColumns(i)
+				}
+				for _, task := range deptasks {
+					task.NumPartition = npartition
+					task.Partitioner = fn
+					task.PartitionColumns = columns
+				}
+			}
+			for shard := range tasks {
+				for _, depShard := range mapper.DepShards(shard, i) {
+					tasks[shard].Deps = append(tasks[shard].Deps,
+						TaskDep{deptasks[depShard], partition, false, dep.Expand, ""})
+				}
+			}
+			continue
+		}
 		if !dep.Shuffle {
 			if len(tasks) != len(deptasks) {
 				log.Panicf("tasks:%d deptasks:%d", len(tasks), len(deptasks))
 			}
 			for shard := range tasks {
 				tasks[shard].Deps = append(tasks[shard].Deps,
-					TaskDep{deptasks[shard], 0, dep.Expand, ""})
+					TaskDep{deptasks[shard], 0, false, dep.Expand, ""})
 			}
 			continue
 		}
 
-		// In the case where we are reusing slice results and require a
-		// shuffle, we have to insert an explicit shuffle stage. This is
-		// done by creating a pass-thru task for each dependency task.
-		// These tasks then receive a shuffle dependency from the task set
-		// we are compiling. This in turn will induce shuffling and local
-		// combining at runtime.
-		if reused {
-			for _, task := range deptasks {
-				if task.Combiner != nil {
-					// TODO(marius): we may consider supporting this, but it should
-					// be very rare, since it requires the user to explicitly reuse
-					// an intermediate slice, which is impossible via the current
-					// API.
-					return nil, false, fmt.Errorf("cannot reuse task %s with combine key %s", task, task.CombineKey)
-				}
+		// Slices that implement ShufflePartitioner provide their own
+		// partitioners. Their partitioning, like that of slices with
+		// combiners, is particular to the slice, and so the
+		// dependency's tasks are shuffled through a stage of pass-thru
+		// tasks also when they are shared by other compilations of the
+		// slice, as happens with the Recompute pragma.
+		partitioner, _ := bigslice.Unwrap(lastSlice).(bigslice.ShufflePartitioner)
+		owned := partitioner != nil || lastSlice.Combiner() != nil
+		if repartition || (reused && owned) {
+			deptasks, err = c.passThru(fmt.Sprintf("%s_shuffle_%d", opName, i), dep.Slice, deptasks)
+			if err != nil {
+				return nil, false, err
 			}
-			newDeps := make([]*Task, len(deptasks))
-			// We now insert a set of tasks whose only purpose is (re-)shuffling
-			// the output from the previously completed task.
-			for shard, task := range deptasks {
-				newDeps[shard] = &Task{
-					Type:       dep.Slice,
-					Invocation: c.inv,
-					Name: TaskName{
-						Op:       fmt.Sprintf("%s_shuffle_%d", opName, i),
-						Shard:    shard,
-						NumShard: len(deptasks),
-					},
-					Do:     func(readers []sliceio.Reader) sliceio.Reader { return readers[0] },
-					Deps:   []TaskDep{{task, 0, false, ""}},
-					Pragma: task.Pragma,
-				}
-			}
-			deptasks = newDeps
 		}
 
 		for _, task := range deptasks {
@@ -185,8 +317,14 @@ func (c *compiler) compile(slice bigslice.Slice) (tasks []*Task, reused bool, er
 		}
 		// Assign a partitioner and partition width our dependencies, so that
 		// these are properly partitioned at the time of computation.
-		for _, task := range deptasks {
+		for shard, task := range deptasks {
 			task.NumPartition = slice.NumShard()
+			if partitioner != nil {
+				dep, shard := i, shard
+				task.newPartitioner = func() bigslice.Partitioner {
+					return partitioner.Partitioner(dep, shard)
+				}
+			}
 			// Assign a combine key that's based on the root name of the task.
 			// This is a name that's unique in the task namespace and is used to
 			// coalesce combiners on a single machine.
@@ -197,7 +335,7 @@ func (c *compiler) compile(slice bigslice.Slice) (tasks []*Task, reused bool, er
 		// Each shard reads different partitions from all of the previous slice's shards.
 		for partition := range tasks {
 			tasks[partition].Deps = append(tasks[partition].Deps,
-				TaskDep{deptasks[0], partition, dep.Expand, combineKey})
+				TaskDep{deptasks[0], partition, true, dep.Expand, combineKey})
 		}
 	}
 	// Pipeline execution, folding multiple frame operations
@@ -242,6 +380,40 @@ func (c *compiler) compile(slice bigslice.Slice) (tasks []*Task, reused bool, er
 	return tasks, false, nil
 }
 
+// PassThru returns a stage of tasks, with the provided op name, that
+// pass through the unpartitioned output of the provided tasks of slice
+// dep, so that the pass-thru tasks may be partitioned by the dependent
+// being compiled. This in turn induces shuffling and local combining
+// at runtime, while leaving the original tasks to their other
+// dependents.
+func (c *compiler) passThru(op string, dep bigslice.Slice, deptasks []*Task) ([]*Task, error) {
+	for _, task := range deptasks {
+		if task.Combiner != nil {
+			// TODO(marius): we may consider supporting this, but it should
+			// be very rare, since it requires the user to explicitly reuse
+			// an intermediate slice, which is impossible via the current
+			// API.
+			return nil, fmt.Errorf("cannot reuse task %s with combine key %s", task, task.CombineKey)
+		}
+	}
+	tasks := make([]*Task, len(deptasks))
+	for shard, task := range deptasks {
+		tasks[shard] = &Task{
+			Type:       dep,
+			Invocation: c.inv,
+			Name: TaskName{
+				Op:       op,
+				Shard:    shard,
+				NumShard: len(deptasks),
+			},
+			Do:     func(readers []sliceio.Reader) sliceio.Reader { return readers[0] },
+			Deps:   []TaskDep{{task, 0, false, false, ""}},
+			Pragma: task.Pragma,
+		}
+	}
+	return tasks, nil
+}
+
 type taskNamer map[string]int
 
 func (n taskNamer) New(name string) string {
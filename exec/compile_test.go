@@ -5,9 +5,15 @@
 package exec
 
 import (
+	"context"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
 )
 
 // TestMaterialize verifies that the Materialize pragma interrupts pipeline,
@@ -53,15 +59,73 @@ func TestReuse(t *testing.T) {
 	iterTasks(tasks, func(task *Task) {
 		numTasks++
 	})
-	// Expect N*4 tasks:
+	// Expect N*5 tasks:
 	// N for the cogroup
 	// N for each of the two cogrouped slices
+	// N for the shared map, which is materialized automatically
 	// N for the reader
-	//
-	// Without the Materialize pragma, this would have N*3 tasks, as the two
-	// middle map slices would each be independently pipelined with the root
-	// reader.
-	if got, want := numTasks, N*4; got != want {
+	if got, want := numTasks, N*5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestMaterializeShared verifies that slices on which multiple slices
+// depend are materialized, and that the Recompute pragma overrides
+// this.
+func TestMaterializeShared(t *testing.T) {
+	const N = 100
+	diamond := func(prags ...bigslice.Pragma) *bigslice.FuncValue {
+		return bigslice.Func(func() (slice bigslice.Slice) {
+			slice = bigslice.ReaderFunc(N, func(shard int, x *int, xs []int) (int, error) {
+				return 0, sliceio.EOF
+			})
+			slice = bigslice.Map(slice, func(i int) int { return i }, prags...)
+			lhsSlice := bigslice.Map(slice, func(i int) int { return i })
+			rhsSlice := bigslice.Map(slice, func(i int) int { return i })
+			slice = bigslice.Cogroup(lhsSlice, rhsSlice)
+			return
+		})
+	}
+	// Expect N*4 tasks:
+	// N for the cogroup
+	// N for each of the two cogrouped slices
+	// N for the reader and the shared map, pipelined together
+	if got, want := countTasks(t, diamond()), N*4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// With the Recompute pragma, the shared map (and its reader) is
+	// pipelined into each of the cogrouped slices.
+	if got, want := countTasks(t, diamond(bigslice.Recompute)), N*3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Pragmas need not implement Recompute.
+	if got, want := countTasks(t, diamond(plainPragma{})), N*4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := countTasks(t, diamond(plainPragma{}, bigslice.Recompute)), N*3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// PlainPragma is a Pragma that does not implement its optional
+// methods.
+type plainPragma struct{}
+
+func (plainPragma) Exclusive() bool   { return false }
+func (plainPragma) Materialize() bool { return false }
+
+// TestMaterializeSharedConst verifies that shared Const slices are
+// recomputed rather than materialized.
+func TestMaterializeSharedConst(t *testing.T) {
+	const N = 10
+	f := bigslice.Func(func() (slice bigslice.Slice) {
+		slice = bigslice.Const(N, []int{1, 2, 3})
+		lhsSlice := bigslice.Map(slice, func(i int) int { return i })
+		rhsSlice := bigslice.Map(slice, func(i int) int { return i })
+		slice = bigslice.Cogroup(lhsSlice, rhsSlice)
+		return
+	})
+	if got, want := countTasks(t, f), N*3; got != want {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
@@ -105,6 +169,134 @@ var reuseWithShuffle = bigslice.Func(func() (slice bigslice.Slice) {
 	return
 })
 
+// TestSharedShuffle verifies that the tasks of a shared slice are
+// shuffled directly when all of its dependents partition them in the
+// same way, and through a stage of pass-thru tasks otherwise.
+func TestSharedShuffle(t *testing.T) {
+	const N = 10
+	shared := func() bigslice.Slice {
+		slice := bigslice.ReaderFunc(N, func(shard int, x *int, xs []int) (int, error) {
+			return 0, sliceio.EOF
+		})
+		return bigslice.Map(slice, func(i int) (int, int) { return i, i })
+	}
+	selfJoin := bigslice.Func(func() bigslice.Slice {
+		slice := shared()
+		return bigslice.Cogroup(slice, slice)
+	})
+	// N for the shared slice; N for the cogroup.
+	if got, want := countTasks(t, selfJoin), N*2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	mixed := bigslice.Func(func() bigslice.Slice {
+		slice := shared()
+		mapped := bigslice.Map(slice, func(k, v int) (int, int) { return k, v })
+		reduced := bigslice.Reduce(slice, func(a, e int) int { return a + e })
+		return bigslice.Cogroup(mapped, reduced)
+	})
+	// N for the shared slice; N each for the map, the reduce, the
+	// cogroup, and the pass-thru stage that shuffles the shared
+	// slice's output for the reduce.
+	if got, want := countTasks(t, mixed), N*5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestSharedDepPartition verifies that the tasks of a shared slice
+// that are partitioned differently by a DepPartitioner and another
+// dependent are partitioned through a stage of pass-thru tasks,
+// leaving the shared tasks unpartitioned.
+func TestSharedDepPartition(t *testing.T) {
+	const N = 10
+	var shared bigslice.Slice
+	f := bigslice.Func(func() bigslice.Slice {
+		shared = bigslice.ReaderFunc(N, func(shard int, x *int, xs []int) (int, error) {
+			return 0, sliceio.EOF
+		})
+		shared = bigslice.Map(shared, func(i int) int { return i })
+		mapped := bigslice.Map(shared, func(i int) int { return i })
+		partitioned := &depPartitionSlice{bigslice.Name{Op: "deppartition"}, shared}
+		return bigslice.Cogroup(mapped, partitioned)
+	})
+	inv := f.Invocation("<unknown>")
+	slice := inv.Invoke()
+	tasks, err := compile(slice, inv, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var npassThru int
+	iterTasks(tasks, func(task *Task) {
+		if strings.Contains(task.Name.Op, "_partition_") {
+			npassThru++
+			if got, want := task.NumPartition, 2; got != want {
+				t.Errorf("%v: got %v, want %v", task, got, want)
+			}
+			return
+		}
+		if task.Type == shared {
+			if got, want := task.NumPartition, 1; got != want {
+				t.Errorf("%v: got %v, want %v", task, got, want)
+			}
+		}
+	})
+	if got, want := npassThru, N; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestSplitPartitionColumns verifies that each partition of a split
+// stores only the columns of its output.
+func TestSplitPartitionColumns(t *testing.T) {
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(2, []int{1, 2, 3})
+		outs := bigslice.SplitEmit(slice, func(i int, ints func(int, string), strs func(int, string, string)) {})
+		return bigslice.Cogroup(outs[0], outs[1])
+	})
+	inv := f.Invocation("<unknown>")
+	slice := inv.Invoke()
+	tasks, err := compile(slice, inv, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nsplit int
+	iterTasks(tasks, func(task *Task) {
+		if task.PartitionColumns == nil {
+			return
+		}
+		nsplit++
+		for p, want := range []string{"slice[1]int,string", "slice[1]int,string,string"} {
+			if got := slicetype.String(task.PartitionType(p)); got != want {
+				t.Errorf("%v: partition %d: got %v, want %v", task, p, got, want)
+			}
+		}
+	})
+	if got, want := nsplit, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// DepPartitionSlice reads the first of two hash partitions of each of
+// its dependency's shards.
+type depPartitionSlice struct {
+	name bigslice.Name
+	bigslice.Slice
+}
+
+func (s *depPartitionSlice) Name() bigslice.Name    { return s.name }
+func (*depPartitionSlice) NumDep() int              { return 1 }
+func (s *depPartitionSlice) Dep(i int) bigslice.Dep { return bigslice.Dep{Slice: s.Slice} }
+func (*depPartitionSlice) Combiner() *reflect.Value { return nil }
+
+func (*depPartitionSlice) DepShards(shard, dep int) []int { return []int{shard} }
+
+func (*depPartitionSlice) DepPartition(dep int) (bigslice.Partitioner, int, int) {
+	return func(f frame.Frame, i, nshard int) int { return int(f.Hash(i)) % nshard }, 2, 0
+}
+
+func (s *depPartitionSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return deps[0]
+}
+
 func makeMaterializeReader(numShards int) bigslice.Slice {
 	return bigslice.ReaderFunc(numShards, func(shard int, x *int, xs []int) (int, error) {
 		var i int
@@ -131,3 +323,43 @@ func countTasks(t *testing.T, f *bigslice.FuncValue) int {
 	})
 	return numTasks
 }
+
+// TestRepartitionRerun verifies that a task whose output is
+// repartitioned assigns its rows to the same partitions each time
+// that it is run.
+func TestRepartitionRerun(t *testing.T) {
+	const N = 100
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(2, rangeSlice(0, N))
+		return bigslice.Repartition(slice, 3)
+	})
+	inv := f.Invocation("<unknown>")
+	slice := inv.Invoke()
+	tasks, err := compile(slice, inv, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := tasks[0].Deps[0].Task(1)
+	run := func() [][]int {
+		buf, err := bufferOutput(context.Background(), task, task.Do(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := make([][]int, task.NumPartition)
+		for p := range parts {
+			if err := sliceio.ReadAll(context.Background(), buf.Reader(p), &parts[p]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return parts
+	}
+	first, second := run(), run()
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("rerun partitioned %v, first run partitioned %v", second, first)
+	}
+	for p, rows := range first {
+		if got, want := len(rows), 16; got < want {
+			t.Errorf("partition %d: got %v rows, want at least %v", p, got, want)
+		}
+	}
+}
@@ -17,6 +17,9 @@ func init() {
 		var system bigmachine.System
 		constr.InstanceVar(&system, "system", "", "the bigmachine system used for job execution")
 		constr.FloatVar(&sess.maxLoad, "max-load", DefaultMaxLoad, "per-machine maximum load")
+		constr.StringVar(&sess.eventLogFile, "eventlog", "", "path of a file to which task and machine events are appended; see bigslice log")
+		constr.StringVar(&sess.captureDir, "capture-failures", "", "path under which the inputs of failed tasks are captured; see slicetest.ReplayTask")
+		constr.IntVar(&sess.captureRecords, "capture-records", 100000, "maximum number of records captured from each input of a failed task, or 0 for all")
 		constr.Doc = "bigslice configures the bigslice runtime"
 		constr.New = func() (interface{}, error) {
 			if system != nil {
@@ -0,0 +1,774 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/stats"
+)
+
+const (
+	// DashboardInterval is the interval at which the dashboard's state
+	// is sent to clients even if no task has changed state, so that
+	// machine utilization remains current.
+	dashboardInterval = 2 * time.Second
+	// DashboardCoalesce is the amount of time for which task state
+	// changes are accumulated before the dashboard's state is sent to
+	// clients.
+	dashboardCoalesce = 250 * time.Millisecond
+)
+
+// A machineStatusExecutor is an Executor that reports the status of
+// the machines that it manages.
+type machineStatusExecutor interface {
+	machineStatus() []machineStatus
+}
+
+// MachineStatus describes the utilization of a machine, as displayed
+// by the dashboard.
+type machineStatus struct {
+	Addr      string  `json:"addr"`
+	Lost      bool    `json:"lost,omitempty"`
+	Tasks     int64   `json:"tasks"`
+	Maxprocs  int     `json:"maxprocs"`
+	Load1     float64 `json:"load1"`
+	MemUsed   uint64  `json:"memUsed"`
+	MemTotal  uint64  `json:"memTotal"`
+	DiskUsed  uint64  `json:"diskUsed"`
+	DiskTotal uint64  `json:"diskTotal"`
+	Counters  string  `json:"counters,omitempty"`
+}
+
+// DashboardState is a snapshot of the evaluation state of a session,
+// as displayed by the dashboard.
+type dashboardState struct {
+	Invocations []dashboardInvocation `json:"invocations"`
+	Machines    []machineStatus       `json:"machines,omitempty"`
+	Errors      []dashboardTask       `json:"errors,omitempty"`
+}
+
+// DashboardInvocation describes the slices computed by an
+// invocation, in execution order.
+type dashboardInvocation struct {
+	Index    uint64           `json:"index"`
+	Location string           `json:"location"`
+	Slices   []dashboardSlice `json:"slices"`
+}
+
+// DashboardSlice describes the progress of a slice's tasks. Slices
+// are identified by their index in the invocation's slices; Deps
+// contains the identifiers of the slices on which the slice depends.
+type dashboardSlice struct {
+	Name     string `json:"name"`
+	Deps     []int  `json:"deps,omitempty"`
+	Idle     int32  `json:"idle"`
+	Running  int32  `json:"running"`
+	Done     int32  `json:"done"`
+	Lost     int32  `json:"lost"`
+	Error    int32  `json:"error"`
+	TaskTime string `json:"taskTime,omitempty"`
+	Counters string `json:"counters,omitempty"`
+}
+
+// DashboardTask describes a task's state change, or a failed task.
+type dashboardTask struct {
+	Time       time.Time `json:"time"`
+	Task       string    `json:"task"`
+	Invocation uint64    `json:"invocation"`
+	State      string    `json:"state"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func newDashboardTask(task *Task, state TaskState) dashboardTask {
+	d := dashboardTask{
+		Time:       time.Now(),
+		Task:       task.Name.String(),
+		Invocation: task.Invocation.Index,
+		State:      state.String(),
+	}
+	if state == TaskErr {
+		if err := task.Err(); err != nil {
+			d.Error = err.Error()
+		}
+	}
+	return d
+}
+
+// AllTasks returns all of the tasks that have been compiled by the
+// session.
+func (s *Session) allTasks() map[*Task]bool {
+	s.mu.Lock()
+	roots := make([]*Task, 0, len(s.roots))
+	for task := range s.roots {
+		roots = append(roots, task)
+	}
+	s.mu.Unlock()
+	tasks := make(map[*Task]bool, 2*len(roots))
+	for _, task := range roots {
+		task.all(tasks)
+	}
+	return tasks
+}
+
+// DashboardState returns a snapshot of the session's evaluation
+// state. It subscribes to the tasks of the session's unfinished
+// invocations for the duration of the call; the dashboard's event
+// stream instead maintains a dashboardSubscription across snapshots.
+func (s *Session) dashboardState() *dashboardState {
+	subs := newDashboardSubscription()
+	defer subs.close()
+	subs.subscribe(s)
+	return subs.state(s)
+}
+
+// A dashboardSummary is the dashboard description of an invocation,
+// together with the invocation's failed tasks.
+type dashboardSummary struct {
+	inv    dashboardInvocation
+	errors []dashboardTask
+}
+
+// SummarizeInvocation returns the dashboard summary of the invocation
+// with the provided roots.
+func summarizeInvocation(roots []*Task) dashboardSummary {
+	roots = append([]*Task(nil), roots...)
+	sort.Slice(roots, func(i, j int) bool { return roots[i].Name.Shard < roots[j].Name.Shard })
+	failed := make(map[*Task]bool)
+	sum := dashboardSummary{inv: newDashboardInvocation(roots, failed)}
+	for task := range failed {
+		sum.errors = append(sum.errors, newDashboardTask(task, TaskErr))
+	}
+	return sum
+}
+
+// NewDashboardInvocation returns the dashboard description of the
+// invocation whose root tasks are provided. Slice status is computed
+// in the same manner as by maintainSliceGroup. The invocation's
+// failed tasks are added to failed.
+func newDashboardInvocation(tasks []*Task, failed map[*Task]bool) dashboardInvocation {
+	inv := dashboardInvocation{
+		Index:    tasks[0].Invocation.Index,
+		Location: tasks[0].Invocation.Location,
+	}
+	var (
+		ids      = make(map[bigslice.Name]int)
+		statuses []sliceStatus
+		counters []stats.Values
+		deps     []map[int]bool
+	)
+	id := func(slice bigslice.Slice) int {
+		name := slice.Name()
+		i, ok := ids[name]
+		if !ok {
+			i = len(statuses)
+			ids[name] = i
+			statuses = append(statuses, sliceStatus{sliceName: name, runtimes: stats.NewTimer()})
+			counters = append(counters, make(stats.Values))
+			deps = append(deps, make(map[int]bool))
+		}
+		return i
+	}
+	iterTasks(tasks, func(task *Task) {
+		state := task.State()
+		if state == TaskErr {
+			failed[task] = true
+		}
+		// The slices are in dependency order, so we visit them in reverse
+		// to get them in execution order.
+		for i := len(task.Slices) - 1; i >= 0; i-- {
+			j := id(task.Slices[i])
+			if i+1 < len(task.Slices) {
+				deps[j][id(task.Slices[i+1])] = true
+			} else {
+				for _, dep := range task.Deps {
+					if head := dep.Head; head != nil && len(head.Slices) > 0 {
+						deps[j][id(head.Slices[0])] = true
+					}
+				}
+			}
+			// Statuses may have been reallocated by id.
+			status := &statuses[j]
+			status.counts[state]++
+			if state != TaskOk {
+				continue
+			}
+			if runtime := task.Runtime(); runtime > 0 {
+				status.runtimes.Observe(runtime)
+			}
+			for k, v := range task.Counters()[status.sliceName] {
+				counters[j][k] += v
+			}
+		}
+	})
+	for i, status := range statuses {
+		slice := dashboardSlice{
+			Name:    status.sliceName.String(),
+			Idle:    status.idleCount(),
+			Running: status.counts[TaskRunning],
+			Done:    status.counts[TaskOk],
+			Lost:    status.counts[TaskLost],
+			Error:   status.counts[TaskErr],
+		}
+		for dep := range deps[i] {
+			slice.Deps = append(slice.Deps, dep)
+		}
+		sort.Ints(slice.Deps)
+		if d := status.runtimes.Distribution(); d.Count > 0 {
+			slice.TaskTime = fmt.Sprintf("%s/%s", d.Format(d.Quantile(0.5)), d.Format(d.Quantile(0.99)))
+		}
+		if len(counters[i]) > 0 {
+			slice.Counters = counters[i].String()
+		}
+		inv.Slices = append(inv.Slices, slice)
+	}
+	return inv
+}
+
+// handleDashboardState serves a JSON snapshot of the dashboard's
+// state.
+func (s *Session) handleDashboardState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(s.dashboardState()); err != nil {
+		log.Error.Printf("exec.Session: /debug/tasks/state: %v", err)
+	}
+}
+
+// handleDashboardEvents streams the dashboard's state to the client
+// as server-sent events: a "state" event carries a snapshot of the
+// dashboard's state, and is sent whenever tasks change state; a
+// "task" event is sent for each task state change that is observed.
+// The events are produced once for all clients by the session's
+// dashboardHub.
+func (s *Session) handleDashboardEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	events, leave := s.dashboard.join(s)
+	defer leave()
+	for {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				// The client fell behind; it reconnects and starts afresh.
+				return
+			}
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// DashboardClientBuffer is the number of messages that are buffered
+// for each dashboard client. Clients that fall further behind are
+// disconnected.
+const dashboardClientBuffer = 16
+
+// A dashboardHub produces the dashboard's events once for all of a
+// session's dashboard clients: it builds the dashboard's state at
+// most once per task state change or dashboardInterval, and
+// broadcasts the encoded events to the clients. The hub runs while
+// it has clients.
+type dashboardHub struct {
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+	// last is the most recent "state" event, with which new clients
+	// are started.
+	last []byte
+	// cancel stops the hub; it is nil if the hub is not running.
+	cancel func()
+}
+
+// Join registers a new client with the hub, starting the hub if
+// needed. It returns the channel on which the client receives encoded
+// events, and a function that unregisters the client. The channel is
+// closed if the client falls behind.
+func (h *dashboardHub) join(s *Session) (events <-chan []byte, leave func()) {
+	ch := make(chan []byte, dashboardClientBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients == nil {
+		h.clients = make(map[chan []byte]bool)
+	}
+	h.clients[ch] = true
+	if h.last != nil {
+		ch <- h.last
+	}
+	if h.cancel == nil {
+		var ctx context.Context
+		ctx, h.cancel = context.WithCancel(context.Background())
+		go h.run(ctx, s)
+	}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.clients, ch)
+		if len(h.clients) == 0 && h.cancel != nil {
+			h.cancel()
+			h.cancel = nil
+			h.last = nil
+		}
+	}
+}
+
+// Broadcast sends msg to each of the hub's clients, and records state
+// as the hub's latest state event. Clients whose buffers are full are
+// disconnected. Broadcast does nothing once ctx is canceled, so that a
+// stopped hub does not interleave with its successor.
+func (h *dashboardHub) broadcast(ctx context.Context, msg, state []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	h.last = state
+	for ch := range h.clients {
+		select {
+		case ch <- msg:
+		default:
+			close(ch)
+			delete(h.clients, ch)
+		}
+	}
+}
+
+// Run produces the dashboard's events until ctx is canceled.
+func (h *dashboardHub) run(ctx context.Context, s *Session) {
+	var (
+		subs   = newDashboardSubscription()
+		ticker = time.NewTicker(dashboardInterval)
+	)
+	defer ticker.Stop()
+	defer subs.close()
+	for {
+		subs.subscribe(s)
+		// Invocations that are found to be finished are released only
+		// after their tasks' final changes have been reported.
+		finished := subs.finished()
+		var msg, state bytes.Buffer
+		for _, task := range subs.changes() {
+			_ = writeEvent(&msg, "task", task)
+		}
+		subs.release(finished)
+		if err := writeEvent(&state, "state", subs.state(s)); err == nil {
+			if msg.Len() == 0 {
+				h.broadcast(ctx, state.Bytes(), state.Bytes())
+			} else {
+				msg.Write(state.Bytes())
+				h.broadcast(ctx, msg.Bytes(), state.Bytes())
+			}
+		}
+		select {
+		case <-subs.sub.Ready():
+			// Coalesce bursts of state changes.
+			select {
+			case <-time.After(dashboardCoalesce):
+			case <-ctx.Done():
+				return
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// A dashboardSubscription subscribes to the tasks of a session's
+// unfinished invocations, and reports their state changes. The tasks
+// of an invocation are subscribed to once, when its roots are first
+// observed, and they are released once the invocation has finished,
+// so that the cost of tracking tasks is bounded by the session's
+// in-flight invocations.
+type dashboardSubscription struct {
+	sub *TaskSubscriber
+	// seen is the set of invocations whose roots have been observed.
+	seen map[uint64]bool
+	// roots and tasks hold the root tasks and all of the tasks of
+	// each unfinished invocation.
+	roots map[uint64][]*Task
+	tasks map[uint64][]*Task
+	// done holds the summaries of the finished invocations, which are
+	// computed once, as the invocations are found to be finished.
+	done map[uint64]dashboardSummary
+	// subscribed holds the last observed state of each subscribed
+	// task, and the number of unfinished invocations that include it.
+	subscribed map[*Task]*subscribedTask
+}
+
+type subscribedTask struct {
+	state TaskState
+	refs  int
+}
+
+func newDashboardSubscription() *dashboardSubscription {
+	return &dashboardSubscription{
+		sub:        NewTaskSubscriber(),
+		seen:       make(map[uint64]bool),
+		roots:      make(map[uint64][]*Task),
+		tasks:      make(map[uint64][]*Task),
+		done:       make(map[uint64]dashboardSummary),
+		subscribed: make(map[*Task]*subscribedTask),
+	}
+}
+
+// Subscribe subscribes to the tasks of the session's invocations that
+// have not yet been observed. As in monitorSliceStatus, we subscribe
+// before retrieving a task's state, so that we observe every
+// subsequent change.
+func (d *dashboardSubscription) subscribe(s *Session) {
+	roots := make(map[uint64][]*Task)
+	s.mu.Lock()
+	for task := range s.roots {
+		if index := task.Invocation.Index; !d.seen[index] {
+			roots[index] = append(roots[index], task)
+		}
+	}
+	s.mu.Unlock()
+	for index, invRoots := range roots {
+		d.seen[index] = true
+		if invocationFinished(invRoots) {
+			d.done[index] = summarizeInvocation(invRoots)
+			continue
+		}
+		all := make(map[*Task]bool)
+		for _, root := range invRoots {
+			root.all(all)
+		}
+		tasks := make([]*Task, 0, len(all))
+		for task := range all {
+			tasks = append(tasks, task)
+			sub := d.subscribed[task]
+			if sub == nil {
+				task.Subscribe(d.sub)
+				sub = &subscribedTask{state: task.State()}
+				d.subscribed[task] = sub
+			}
+			sub.refs++
+		}
+		d.roots[index] = invRoots
+		d.tasks[index] = tasks
+	}
+}
+
+// Changes returns the state changes of the subscribed tasks since the
+// last call, ordered by task name.
+func (d *dashboardSubscription) changes() []dashboardTask {
+	changed := d.sub.Tasks()
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Name.String() < changed[j].Name.String() })
+	var tasks []dashboardTask
+	for _, task := range changed {
+		sub := d.subscribed[task]
+		if sub == nil {
+			continue
+		}
+		state := task.State()
+		if state == sub.state {
+			continue
+		}
+		sub.state = state
+		tasks = append(tasks, newDashboardTask(task, state))
+	}
+	return tasks
+}
+
+// Finished returns the indices of the subscribed invocations that
+// have finished.
+func (d *dashboardSubscription) finished() []uint64 {
+	var indices []uint64
+	for index, roots := range d.roots {
+		if invocationFinished(roots) {
+			indices = append(indices, index)
+		}
+	}
+	return indices
+}
+
+// Release summarizes the provided invocations, and unsubscribes from
+// their tasks that are not included in another unfinished invocation.
+func (d *dashboardSubscription) release(indices []uint64) {
+	for _, index := range indices {
+		d.done[index] = summarizeInvocation(d.roots[index])
+		for _, task := range d.tasks[index] {
+			sub := d.subscribed[task]
+			if sub.refs--; sub.refs == 0 {
+				task.Unsubscribe(d.sub)
+				delete(d.subscribed, task)
+			}
+		}
+		delete(d.roots, index)
+		delete(d.tasks, index)
+	}
+}
+
+// State returns a snapshot of the session's evaluation state. Only
+// the tasks of the unfinished invocations are visited; finished
+// invocations are described by their summaries.
+func (d *dashboardSubscription) state(s *Session) *dashboardState {
+	var (
+		state  = new(dashboardState)
+		failed = make(map[string]dashboardTask)
+	)
+	add := func(sum dashboardSummary) {
+		state.Invocations = append(state.Invocations, sum.inv)
+		for _, task := range sum.errors {
+			failed[task.Task] = task
+		}
+	}
+	for _, sum := range d.done {
+		add(sum)
+	}
+	for _, roots := range d.roots {
+		add(summarizeInvocation(roots))
+	}
+	sort.Slice(state.Invocations, func(i, j int) bool {
+		return state.Invocations[i].Index < state.Invocations[j].Index
+	})
+	for _, task := range failed {
+		state.Errors = append(state.Errors, task)
+	}
+	sort.Slice(state.Errors, func(i, j int) bool { return state.Errors[i].Task < state.Errors[j].Task })
+	for _, executor := range unwrapExecutors(s.executor) {
+		if exec, ok := executor.(machineStatusExecutor); ok {
+			state.Machines = exec.machineStatus()
+			break
+		}
+	}
+	return state
+}
+
+// Close unsubscribes from all subscribed tasks.
+func (d *dashboardSubscription) close() {
+	for task := range d.subscribed {
+		task.Unsubscribe(d.sub)
+	}
+	d.subscribed = nil
+}
+
+// InvocationFinished tells whether the invocation with the provided
+// roots has finished, as it does when Eval returns: once all of the
+// roots have completed, or any of them has failed.
+func invocationFinished(roots []*Task) bool {
+	done := true
+	for _, root := range roots {
+		switch root.State() {
+		case TaskErr:
+			return true
+		case TaskOk:
+		default:
+			done = false
+		}
+	}
+	return done
+}
+
+// WriteEvent writes a server-sent event with the provided name and
+// JSON-encoded data to w.
+func writeEvent(w io.Writer, name string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Error.Printf("exec.Session: marshal %s event: %v", name, err)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b)
+	return err
+}
+
+func (s *Session) handleTasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("content-type", "text/html; charset=utf-8")
+	io.WriteString(w, dashboardHtml)
+}
+
+var dashboardHtml = `<!DOCTYPE html>
+<meta charset="utf-8">
+<head>
+<title>bigslice</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 1em; }
+a { color: #36c; }
+h2 { font-size: 15px; margin: 1.5em 0 0.5em 0; }
+.links a { margin-right: 1em; }
+.dag { position: relative; display: flex; align-items: flex-start; }
+.dag svg { position: absolute; left: 0; top: 0; pointer-events: none; }
+.layer { display: flex; flex-direction: column; margin-right: 3em; }
+.slice { border: 1px solid #999; border-radius: 3px; padding: 4px 6px; margin-bottom: 1em; width: 22em; background: #fff; }
+.slice .name { font-family: monospace; word-break: break-all; }
+.slice .detail { color: #555; font-size: 11px; }
+.bar { display: flex; height: 8px; margin: 4px 0; background: #eee; }
+.bar div { height: 100%; }
+.done { background: #4a4; }
+.running { background: #48c; }
+.lost { background: #e92; }
+.error { background: #c33; }
+table { border-collapse: collapse; }
+td, th { text-align: left; padding: 2px 8px; border-bottom: 1px solid #eee; }
+#log { font-family: monospace; font-size: 11px; max-height: 20em; overflow-y: scroll; white-space: pre-wrap; }
+.err { color: #c33; }
+#status { color: #888; }
+</style>
+</head>
+<body>
+<div class="links">
+<a href="/debug">/debug</a>
+<a href="/debug/status">status</a>
+<a href="/debug/trace">trace</a>
+<a href="/debug/metrics">metrics</a>
+<a href="/debug/pprof/">pprof</a>
+<a href="/debug/bigslice/pprof/profile?debug=1&seconds=10">cluster cpu by slice</a>
+<span id="status">connecting</span>
+</div>
+<div id="invocations"></div>
+<h2>Machines</h2>
+<div id="machines">none</div>
+<h2>Errors</h2>
+<div id="errors">none</div>
+<h2>Task log</h2>
+<div id="log"></div>
+<script>
+
+function el(tag, cls, text) {
+  var e = document.createElement(tag);
+  if (cls) e.className = cls;
+  if (text !== undefined) e.textContent = text;
+  return e;
+}
+
+function bytes(n) {
+  var units = ["B", "KiB", "MiB", "GiB", "TiB"], i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return n.toFixed(1) + units[i];
+}
+
+function renderInvocation(inv) {
+  var div = el("div");
+  div.appendChild(el("h2", null, "Invocation " + inv.index + " at " + inv.location));
+  // Assign each slice to a layer one past its deepest dependency.
+  var depth = [];
+  inv.slices.forEach(function(s, i) {
+    depth[i] = 0;
+    (s.deps || []).forEach(function(d) {
+      if (d < i) depth[i] = Math.max(depth[i], depth[d] + 1);
+    });
+  });
+  var dag = el("div", "dag"), layers = [], boxes = [];
+  inv.slices.forEach(function(s, i) {
+    while (layers.length <= depth[i]) {
+      var layer = el("div", "layer");
+      layers.push(layer);
+      dag.appendChild(layer);
+    }
+    var box = el("div", "slice");
+    box.appendChild(el("div", "name", s.name));
+    var total = s.idle + s.running + s.done + s.lost + s.error;
+    var bar = el("div", "bar");
+    [["done", s.done], ["running", s.running], ["lost", s.lost], ["error", s.error]].forEach(function(p) {
+      if (p[1] == 0) return;
+      var part = el("div", p[0]);
+      part.style.width = (100 * p[1] / total) + "%";
+      bar.appendChild(part);
+    });
+    box.appendChild(bar);
+    var detail = "tasks idle/running/done: " + s.idle + "/" + s.running + "/" + s.done;
+    if (s.lost) detail += " lost: " + s.lost;
+    if (s.error) detail += " error: " + s.error;
+    box.appendChild(el("div", "detail", detail));
+    if (s.taskTime) box.appendChild(el("div", "detail", "task time p50/p99: " + s.taskTime));
+    if (s.counters) box.appendChild(el("div", "detail", "counters " + s.counters));
+    layers[depth[i]].appendChild(box);
+    boxes[i] = box;
+  });
+  div.appendChild(dag);
+  // Edges are drawn once the boxes have been laid out.
+  setTimeout(function() {
+    var ns = "http://www.w3.org/2000/svg", svg = document.createElementNS(ns, "svg");
+    var origin = dag.getBoundingClientRect();
+    svg.setAttribute("width", dag.scrollWidth);
+    svg.setAttribute("height", dag.scrollHeight);
+    inv.slices.forEach(function(s, i) {
+      (s.deps || []).forEach(function(d) {
+        var from = boxes[d].getBoundingClientRect(), to = boxes[i].getBoundingClientRect();
+        var line = document.createElementNS(ns, "line");
+        line.setAttribute("x1", from.right - origin.left);
+        line.setAttribute("y1", from.top + from.height / 2 - origin.top);
+        line.setAttribute("x2", to.left - origin.left);
+        line.setAttribute("y2", to.top + to.height / 2 - origin.top);
+        line.setAttribute("stroke", "#999");
+        svg.appendChild(line);
+      });
+    });
+    dag.insertBefore(svg, dag.firstChild);
+  }, 0);
+  return div;
+}
+
+function renderMachines(machines) {
+  if (!machines || machines.length == 0) return document.createTextNode("none");
+  var table = el("table"), head = el("tr");
+  ["machine", "tasks", "load", "memory", "disk", "counters"].forEach(function(h) { head.appendChild(el("th", null, h)); });
+  table.appendChild(head);
+  machines.forEach(function(m) {
+    var row = el("tr", m.lost ? "err" : null);
+    [m.addr + (m.lost ? " (lost)" : ""), m.tasks + "/" + m.maxprocs, m.load1.toFixed(1),
+     bytes(m.memUsed) + "/" + bytes(m.memTotal), bytes(m.diskUsed) + "/" + bytes(m.diskTotal),
+     m.counters || ""].forEach(function(v) { row.appendChild(el("td", null, v)); });
+    table.appendChild(row);
+  });
+  return table;
+}
+
+function renderErrors(errors) {
+  if (!errors || errors.length == 0) return document.createTextNode("none");
+  var div = el("div");
+  errors.forEach(function(e) {
+    div.appendChild(el("div", "err", e.task + " [" + e.invocation + "]: " + e.error));
+  });
+  return div;
+}
+
+function replace(id, child) {
+  var e = document.getElementById(id);
+  e.innerHTML = "";
+  e.appendChild(child);
+}
+
+var events = new EventSource("/debug/tasks/events");
+events.onopen = function() { document.getElementById("status").textContent = "live"; };
+events.onerror = function() { document.getElementById("status").textContent = "disconnected"; };
+events.addEventListener("state", function(e) {
+  var state = JSON.parse(e.data), invs = el("div");
+  (state.invocations || []).forEach(function(inv) { invs.appendChild(renderInvocation(inv)); });
+  replace("invocations", invs);
+  replace("machines", renderMachines(state.machines));
+  replace("errors", renderErrors(state.errors));
+});
+events.addEventListener("task", function(e) {
+  var t = JSON.parse(e.data), log = document.getElementById("log");
+  var line = new Date(t.time).toLocaleTimeString() + " " + t.task + " [" + t.invocation + "] " + t.state;
+  if (t.error) line += ": " + t.error;
+  log.appendChild(el("div", t.error ? "err" : null, line));
+  log.scrollTop = log.scrollHeight;
+});
+
+</script>
+</body>
+</html>
+`
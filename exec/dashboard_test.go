@@ -0,0 +1,273 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+)
+
+func TestDashboardState(t *testing.T) {
+	var mapName, reduceName bigslice.Name
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(3, rangeSlice(0, 100))
+		slice = bigslice.Map(slice, func(ctx context.Context, i int) (int, int) {
+			bigslice.Counter(ctx, "rows").Add(1)
+			return i % 10, i
+		})
+		mapName = slice.Name()
+		slice = bigslice.Reduce(slice, func(a, e int) int { return a + e })
+		reduceName = slice.Name()
+		return slice
+	})
+	testSession(t, func(t *testing.T, sess *Session) {
+		sess.Must(context.Background(), f)
+		state := sess.dashboardState()
+		if got, want := len(state.Invocations), 1; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		slices := make(map[string]int)
+		for i, slice := range state.Invocations[0].Slices {
+			slices[slice.Name] = i
+			if got, want := slice.Done, int32(3); got != want {
+				t.Errorf("slice %s: got %v, want %v", slice.Name, got, want)
+			}
+			if slice.Idle+slice.Running+slice.Lost+slice.Error != 0 {
+				t.Errorf("slice %s: unexpected task counts %+v", slice.Name, slice)
+			}
+			if slice.TaskTime == "" {
+				t.Errorf("slice %s: missing task time", slice.Name)
+			}
+		}
+		mapIndex, ok := slices[mapName.String()]
+		if !ok {
+			t.Fatalf("slice %s missing from %v", mapName, slices)
+		}
+		reduceIndex, ok := slices[reduceName.String()]
+		if !ok {
+			t.Fatalf("slice %s missing from %v", reduceName, slices)
+		}
+		mapSlice := state.Invocations[0].Slices[mapIndex]
+		if got, want := mapSlice.Counters, "rows:100"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := state.Invocations[0].Slices[reduceIndex].Deps, []int{mapIndex}; len(got) != 1 || got[0] != want[0] {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if _, ok := sess.executor.(*bigmachineExecutor); ok && len(state.Machines) == 0 {
+			t.Error("missing machines")
+		}
+	})
+}
+
+func TestDashboardEvents(t *testing.T) {
+	release := make(chan struct{})
+	f := bigslice.Func(func(wait bool) bigslice.Slice {
+		slice := bigslice.Const(3, rangeSlice(0, 100))
+		return bigslice.Map(slice, func(i int) int {
+			if wait {
+				<-release
+			}
+			return i
+		})
+	})
+	sess := Start(Local)
+	sess.Must(context.Background(), f, false)
+	mux := http.NewServeMux()
+	mux.Handle("/debug/tasks/events", http.HandlerFunc(sess.handleDashboardEvents))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest("GET", srv.URL+"/debug/tasks/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.Header.Get("content-type"), "text/event-stream"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	events := bufio.NewScanner(resp.Body)
+	// next returns the name and data of the next event.
+	next := func() (string, []byte) {
+		t.Helper()
+		var name string
+		for events.Scan() {
+			line := events.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				return name, []byte(strings.TrimPrefix(line, "data: "))
+			}
+		}
+		t.Fatalf("event stream ended: %v", events.Err())
+		panic("unreachable")
+	}
+	name, data := next()
+	if got, want := name, "state"; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var state dashboardState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(state.Invocations), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Run another invocation, whose tasks block until they are
+	// released: once the invocation appears in the dashboard's state,
+	// its tasks have been subscribed to, and we should observe their
+	// state changes.
+	go sess.Must(context.Background(), f, true)
+	var (
+		done     int
+		released bool
+	)
+	for done < 3 {
+		name, data := next()
+		switch name {
+		case "task":
+			var task dashboardTask
+			if err := json.Unmarshal(data, &task); err != nil {
+				t.Fatal(err)
+			}
+			if released && task.Invocation == state.Invocations[1].Index && task.State == TaskOk.String() {
+				done++
+			}
+		case "state":
+			state = dashboardState{}
+			if err := json.Unmarshal(data, &state); err != nil {
+				t.Fatal(err)
+			}
+			if len(state.Invocations) == 2 && !released {
+				close(release)
+				released = true
+			}
+		default:
+			t.Fatalf("unexpected event %s", name)
+		}
+	}
+}
+
+func TestDashboardHub(t *testing.T) {
+	sess := Start(Local)
+	sess.Must(context.Background(), bigslice.Func(func() bigslice.Slice {
+		return bigslice.Const(3, rangeSlice(0, 100))
+	}))
+	events1, leave1 := sess.dashboard.join(sess)
+	events2, leave2 := sess.dashboard.join(sess)
+	// Both clients receive the same events, which are built once.
+	msg1, msg2 := <-events1, <-events2
+	if len(msg1) == 0 || &msg1[0] != &msg2[0] {
+		t.Errorf("clients received distinct messages %q and %q", msg1, msg2)
+	}
+	leave1()
+	leave2()
+	sess.dashboard.mu.Lock()
+	running := sess.dashboard.cancel != nil
+	sess.dashboard.mu.Unlock()
+	if running {
+		t.Error("hub is running without clients")
+	}
+}
+
+func TestDashboardSubscription(t *testing.T) {
+	sess := Start(Local)
+	sess.Must(context.Background(), bigslice.Func(func() bigslice.Slice {
+		return bigslice.Const(3, rangeSlice(0, 100))
+	}))
+	subs := newDashboardSubscription()
+	defer subs.close()
+	// Finished invocations are not subscribed to, but are summarized.
+	subs.subscribe(sess)
+	if got, want := len(subs.subscribed), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(subs.done), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var (
+		started = make(chan struct{})
+		unblock = make(chan struct{})
+		done    = make(chan error)
+	)
+	go func() {
+		_, err := sess.Run(context.Background(), bigslice.Func(func() bigslice.Slice {
+			slice := bigslice.Const(1, []int{1})
+			return bigslice.Map(slice, func(i int) int {
+				close(started)
+				<-unblock
+				return i
+			})
+		}))
+		done <- err
+	}()
+	<-started
+	subs.subscribe(sess)
+	if got, want := len(subs.subscribed), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := len(subs.finished()), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var task *Task
+	for task = range subs.subscribed {
+	}
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	finished := subs.finished()
+	if got, want := len(finished), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	changes := subs.changes()
+	if len(changes) == 0 || changes[len(changes)-1].State != TaskOk.String() {
+		t.Errorf("got %v, want final change to %v", changes, TaskOk)
+	}
+	subs.release(finished)
+	if got, want := len(subs.subscribed), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The snapshot describes the released invocation by its summary.
+	if got, want := len(subs.roots), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	state := subs.state(sess)
+	if got, want := len(state.Invocations), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, inv := range state.Invocations {
+		for _, slice := range inv.Slices {
+			if slice.Done == 0 || slice.Idle+slice.Running != 0 {
+				t.Errorf("invocation %d: slice %s: unexpected task counts %+v", inv.Index, slice.Name, slice)
+			}
+		}
+	}
+	task.Lock()
+	nsubs := len(task.subs)
+	task.Unlock()
+	if got, want := nsubs, 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Released invocations are not subscribed to again.
+	subs.subscribe(sess)
+	if got, want := len(subs.subscribed), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
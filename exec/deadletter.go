@@ -0,0 +1,178 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bufio"
+	"context"
+	"sort"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+)
+
+// DeadLetters is the set of rows that were rejected by the user
+// function of a slice under the bigslice.DeadLetterOnError policy.
+// Its type is the slice's bigslice.DeadLetterType: the columns of the
+// rejected rows, followed by a string column that contains the error
+// returned for each row. Dead letters are stored with the output of
+// the tasks that rejected them, and may be scanned (and thus written
+// out) for as long as that output is available.
+type DeadLetters struct {
+	slicetype.Type
+	// Slice is the name of the slice whose user function rejected the
+	// rows.
+	Slice bigslice.Name
+
+	sess  *Session
+	tasks []*Task
+	parts []DeadLetterOutput
+}
+
+// Len returns the number of dead letters.
+func (d *DeadLetters) Len() int64 {
+	var n int64
+	for _, part := range d.parts {
+		n += part.Records
+	}
+	return n
+}
+
+// Scan returns a scanner that scans the dead letters. The dead
+// letters of each task are scanned sequentially.
+func (d *DeadLetters) Scan(ctx context.Context) *sliceio.Scanner {
+	readers := make([]sliceio.Reader, len(d.tasks))
+	for i := range readers {
+		readers[i] = d.sess.executor.Reader(ctx, d.tasks[i], d.parts[i].Partition)
+	}
+	return &sliceio.Scanner{
+		Type:   d,
+		Reader: sliceio.MultiReader(readers...),
+	}
+}
+
+// DeadLetters returns the dead letters of the provided tasks, grouped
+// by the slice whose user function rejected them, and ordered by
+// slice name.
+func deadLetters(sess *Session, tasks []*Task) []*DeadLetters {
+	var (
+		bySlice = make(map[bigslice.Name]*DeadLetters)
+		list    []*DeadLetters
+	)
+	for _, task := range tasks {
+		_, outputs := task.RowErrors()
+		for _, out := range outputs {
+			d := bySlice[out.Slice]
+			if d == nil {
+				slice := taskSlice(task, out.Slice)
+				if slice == nil {
+					continue
+				}
+				d = &DeadLetters{
+					Type:  bigslice.DeadLetterType(slice),
+					Slice: out.Slice,
+					sess:  sess,
+				}
+				bySlice[out.Slice] = d
+				list = append(list, d)
+			}
+			d.tasks = append(d.tasks, task)
+			d.parts = append(d.parts, out)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Slice.String() < list[j].Slice.String()
+	})
+	return list
+}
+
+// TaskSlice returns the slice of the provided task that has the
+// provided name, or nil if there is none.
+func taskSlice(task *Task, name bigslice.Name) bigslice.Slice {
+	for _, slice := range task.Slices {
+		if slice.Name() == name {
+			return slice
+		}
+	}
+	return nil
+}
+
+// A deadLetterWriter writes the dead letters of a task to a store, in
+// partitions that follow the task's output partitions. It is used as
+// a bigslice.RowErrors' WriteDeadLetters.
+type deadLetterWriter struct {
+	ctx     context.Context
+	store   Store
+	task    *Task
+	outputs []DeadLetterOutput
+	parts   []*deadLetterPartition
+	index   map[bigslice.Name]int
+}
+
+type deadLetterPartition struct {
+	wc  writeCommitter
+	buf *bufio.Writer
+	*sliceio.Encoder
+}
+
+func newDeadLetterWriter(ctx context.Context, store Store, task *Task) *deadLetterWriter {
+	return &deadLetterWriter{
+		ctx:   ctx,
+		store: store,
+		task:  task,
+		index: make(map[bigslice.Name]int),
+	}
+}
+
+// Write writes a frame of the dead letters of the named slice.
+func (w *deadLetterWriter) Write(slice bigslice.Name, f frame.Frame) error {
+	i, ok := w.index[slice]
+	if !ok {
+		i = len(w.outputs)
+		partition := w.task.NumPartition + i
+		wc, err := w.store.Create(w.ctx, w.task.Name, partition)
+		if err != nil {
+			return err
+		}
+		part := &deadLetterPartition{wc: wc, buf: bufio.NewWriter(wc)}
+		part.Encoder = sliceio.NewEncoder(part.buf)
+		w.index[slice] = i
+		w.outputs = append(w.outputs, DeadLetterOutput{Slice: slice, Partition: partition})
+		w.parts = append(w.parts, part)
+	}
+	if err := w.parts[i].Encode(f); err != nil {
+		return err
+	}
+	w.outputs[i].Records += int64(f.Len())
+	return nil
+}
+
+// Commit commits the dead letters that have been written, and returns
+// a description of them.
+func (w *deadLetterWriter) Commit(ctx context.Context) ([]DeadLetterOutput, error) {
+	for i, part := range w.parts {
+		if err := part.buf.Flush(); err != nil {
+			return nil, err
+		}
+		w.parts[i] = nil
+		if err := part.wc.Commit(ctx, w.outputs[i].Records); err != nil {
+			return nil, err
+		}
+	}
+	w.parts = nil
+	return w.outputs, nil
+}
+
+// Discard discards the dead letters that have not been committed.
+func (w *deadLetterWriter) Discard(ctx context.Context) {
+	for _, part := range w.parts {
+		if part != nil {
+			part.wc.Discard(ctx)
+		}
+	}
+	w.parts = nil
+}
@@ -31,6 +31,19 @@ const maxConsecutiveLost = 5
 // task runners. An Executor is responsible for running single tasks,
 // partitioning their outputs, and instantiating readers to retrieve the
 // output of any given task.
+//
+// Sessions are configured with custom executors by the CustomExecutor
+// option. Executors read the session's configuration through its
+// accessors (e.g., Session.Parallelism and Session.ErrorPolicy).
+// Implementations may wrap the executors returned by
+// NewLocalExecutor and NewBigmachineExecutor, for example to
+// instrument them. An executor that wraps another should implement
+//
+//	Unwrap() Executor
+//
+// returning the wrapped executor, so that the session can use the
+// wrapped executor's profiles, metrics, and machine status in its
+// debug handlers.
 type Executor interface {
 	// Start starts the executor. It is called before evaluation has started
 	// and after all funcs have been registered. Start need not return:
@@ -41,6 +54,31 @@ type Executor interface {
 	// Run runs a task. The executor sets the state of the task as it
 	// progresses. The task should enter in state TaskWaiting; by the
 	// time Run returns the task state is >= TaskOk.
+	//
+	// Run reads the task's inputs from the outputs of the tasks in
+	// task.Deps (using Reader), applies task.Do, and partitions its
+	// output into task.NumPartition partitions with a partitioner
+	// that is obtained from task.NewPartitioner for each run. Each
+	// partition stores only the columns that are selected by
+	// task.ProjectPartition.
+	//
+	// Before a successful task is set to TaskOk, the executor reports
+	// the run's statistics with Task.SetRowErrors and Task.SetCounters,
+	// which populate Result.SkippedRows, Result.DeadLetters, and
+	// Result.Counters, and reports the numbers of records and shuffled
+	// bytes to the session's metrics and event log with
+	// Session.ReportRun. Under the bigslice.DeadLetterOnError policy,
+	// the executor provides the task's readers with a
+	// bigslice.RowErrors that writes dead letters to partitions of the
+	// task that follow its output partitions, and reports these
+	// partitions with Task.SetRowErrors.
+	//
+	// A task that fails is set to TaskErr (see Task.Error); a task
+	// whose output is lost, for example because the process that
+	// computed it has died, is set to TaskLost, upon which Eval
+	// reschedules it and, as needed, its dependencies. Run may be
+	// called concurrently, and may be called again for a task that has
+	// been lost.
 	Run(*Task)
 
 	// Reader returns a locally accessible reader for the requested task.
@@ -52,6 +90,22 @@ type Executor interface {
 	HandleDebug(handler *http.ServeMux)
 }
 
+// UnwrapExecutors returns the provided executor followed by the
+// executors that it wraps, in order, as reported by their Unwrap
+// methods.
+func unwrapExecutors(executor Executor) []Executor {
+	var executors []Executor
+	for executor != nil {
+		executors = append(executors, executor)
+		wrapper, ok := executor.(interface{ Unwrap() Executor })
+		if !ok {
+			break
+		}
+		executor = wrapper.Unwrap()
+	}
+	return executors
+}
+
 // Eval simultaneously evaluates a set of task graphs from the
 // provided set of roots. Eval uses the provided executor to dispatch
 // tasks when their dependencies have been satisfied. Eval returns on
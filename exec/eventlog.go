@@ -0,0 +1,318 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/log"
+)
+
+// Event types.
+const (
+	// EventInvocation is the type of events that record the start
+	// and completion of invocations.
+	EventInvocation = "invocation"
+	// EventTask is the type of events that record task state
+	// transitions.
+	EventTask = "task"
+	// EventMachine is the type of events that record the lifecycle
+	// of machines.
+	EventMachine = "machine"
+)
+
+// An Event is an entry in a session's event log (see EventLog). The
+// log is written as a sequence of JSON-encoded Events, one per line.
+type Event struct {
+	// Time is the time at which the event occurred.
+	Time time.Time `json:"time"`
+	// Type is the type of the event: EventInvocation, EventTask, or
+	// EventMachine.
+	Type string `json:"type"`
+	// Invocation is the index of the invocation of an invocation or
+	// task event.
+	Invocation uint64 `json:"invocation,omitempty"`
+	// Location is the location of an invocation.
+	Location string `json:"location,omitempty"`
+	// Task is the name of the task of a task event.
+	Task string `json:"task,omitempty"`
+	// State is the state that the subject of the event entered. For
+	// task events, this is a TaskState; for invocation events, it is
+	// one of "start", "ok", or "error"; for machine events, it is one
+	// of "started", "probation", "ok", or "lost".
+	State string `json:"state"`
+	// Machine is the address of the machine of a machine event, or
+	// of the machine on which the task of a task event was run.
+	Machine string `json:"machine,omitempty"`
+	// Attempt is the (1-based) run attempt of the task of a task
+	// event: it is incremented each time the task is run.
+	Attempt int `json:"attempt,omitempty"`
+	// Error is the error text of failed tasks, invocations, or lost
+	// machines.
+	Error string `json:"error,omitempty"`
+	// Duration is the time that the task of a task event spent
+	// running, recorded when the task leaves TaskRunning.
+	Duration time.Duration `json:"duration,omitempty"`
+	// RecordsIn and RecordsOut are the number of records read and
+	// written by the task of a task event that entered TaskOk.
+	RecordsIn  int64 `json:"recordsIn,omitempty"`
+	RecordsOut int64 `json:"recordsOut,omitempty"`
+}
+
+// An eventLog appends events, as JSON lines, to a writer. Events are
+// captured as they are reported and written by a separate goroutine,
+// so that the scheduler does not wait for I/O; the writer is flushed
+// whenever no events are pending, so that the log is available for
+// post-mortem analysis even if the process fails. Task state
+// transitions are reported, in order, by the session's taskObserver.
+// A nil eventLog ignores events.
+type eventLog struct {
+	mu     sync.Mutex
+	closed bool
+	// tasks holds the state of the tasks' current runs. A task's state
+	// is discarded when its run ends.
+	tasks  map[*Task]*taskLogState
+	events chan Event
+	done   chan error
+}
+
+// TaskLogState is the state that an eventLog maintains for a task's
+// current run in order to annotate the task's events.
+type taskLogState struct {
+	// Machine is the address of the machine to which the run was
+	// assigned, start the time at which it started, and stats its
+	// reported statistics.
+	machine string
+	start   time.Time
+	stats   TaskRunStats
+}
+
+// eventLogBuffer is the number of events that may be pending in an
+// eventLog before events are written synchronously.
+const eventLogBuffer = 1024
+
+func newEventLog(w io.Writer) *eventLog {
+	l := &eventLog{
+		tasks:  make(map[*Task]*taskLogState),
+		events: make(chan Event, eventLogBuffer),
+		done:   make(chan error, 1),
+	}
+	go l.writeEvents(w)
+	return l
+}
+
+// WriteEvents writes the log's events to w until the log is closed,
+// and then closes w if it is an io.Closer.
+func (l *eventLog) writeEvents(w io.Writer) {
+	var (
+		bw  = bufio.NewWriter(w)
+		enc = json.NewEncoder(bw)
+		err error
+	)
+	for event := range l.events {
+		if err != nil {
+			continue
+		}
+		err = enc.Encode(event)
+		if err == nil && len(l.events) == 0 {
+			err = bw.Flush()
+		}
+		if err != nil {
+			log.Error.Printf("eventlog: stopped writing events: %v", err)
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closer, ok := w.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	l.done <- err
+}
+
+// Invocation records an invocation event.
+func (l *eventLog) invocation(index uint64, location, state string, err error) {
+	if l == nil {
+		return
+	}
+	event := Event{
+		Type:       EventInvocation,
+		Invocation: index,
+		Location:   location,
+		State:      state,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	l.mu.Lock()
+	l.write(event)
+	l.mu.Unlock()
+}
+
+// Machine records a machine event.
+func (l *eventLog) machine(addr, state string, err error) {
+	if l == nil {
+		return
+	}
+	event := Event{
+		Type:    EventMachine,
+		Machine: addr,
+		State:   state,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	l.mu.Lock()
+	l.write(event)
+	l.mu.Unlock()
+}
+
+// Assign records that the task is about to be run on the machine
+// with the provided address. The address is recorded with the
+// task's subsequent events, until its run ends.
+func (l *eventLog) assign(task *Task, addr string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.taskState(task).machine = addr
+	l.mu.Unlock()
+}
+
+// Run records the statistics of a successful run of the provided
+// task, to be logged with its TaskOk event.
+func (l *eventLog) run(task *Task, stats TaskRunStats) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.taskState(task).stats = stats
+	l.mu.Unlock()
+}
+
+// Task records a state transition of a task, as captured by the
+// session's taskObserver. The state of the task's run is discarded
+// when the run ends.
+func (l *eventLog) task(e taskEvent) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	event := Event{
+		Time:       e.time,
+		Type:       EventTask,
+		Invocation: e.task.Invocation.Index,
+		Task:       e.task.Name.String(),
+		State:      e.state.String(),
+		Attempt:    e.runs,
+	}
+	ts := l.tasks[e.task]
+	if e.state == TaskRunning {
+		ts = l.taskState(e.task)
+		ts.start = e.time
+	}
+	if ts != nil {
+		event.Machine = ts.machine
+	}
+	switch e.state {
+	case TaskOk:
+		event.Duration = e.runtime
+		if ts != nil {
+			event.RecordsIn, event.RecordsOut = ts.stats.RecordsIn, ts.stats.RecordsOut
+		}
+	case TaskErr, TaskLost:
+		if ts != nil && !ts.start.IsZero() {
+			event.Duration = e.time.Sub(ts.start)
+		}
+		if e.state == TaskErr && e.err != nil {
+			event.Error = e.err.Error()
+		}
+	}
+	if e.state >= TaskOk {
+		delete(l.tasks, e.task)
+	}
+	l.write(event)
+}
+
+// Release discards the state of the provided task, whose subsequent
+// transitions are not reported.
+func (l *eventLog) release(task *Task) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.tasks, task)
+	l.mu.Unlock()
+}
+
+// TaskState returns the log's state for the provided task. It must
+// be called with l.mu held.
+func (l *eventLog) taskState(task *Task) *taskLogState {
+	state := l.tasks[task]
+	if state == nil {
+		state = new(taskLogState)
+		l.tasks[task] = state
+	}
+	return state
+}
+
+// Write queues the provided event to be written, setting its time if
+// it is not already set. It must be called with l.mu held.
+func (l *eventLog) write(event Event) {
+	if l.closed {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	l.events <- event
+}
+
+// Close writes the log's pending events and closes its writer, if it
+// is an io.Closer. Subsequent events are ignored.
+func (l *eventLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.events)
+	l.mu.Unlock()
+	return <-l.done
+}
+
+// ReadEvents reads an event log written by a session that was
+// configured with EventLog. A truncated final line, as may be left
+// by a crashed process, is ignored.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var (
+		dec    = json.NewDecoder(r)
+		events []Event
+	)
+	for {
+		var event Event
+		err := dec.Decode(&event)
+		switch {
+		case err == io.EOF:
+			return events, nil
+		case err == io.ErrUnexpectedEOF:
+			return events, nil
+		case err != nil:
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
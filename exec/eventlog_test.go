@@ -0,0 +1,229 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/bigmachine/testsystem"
+	"github.com/grailbio/bigslice"
+)
+
+// runEventLog runs the provided func with a session that is
+// configured with the provided executor and an event log, and
+// returns the run's error together with the logged events.
+func runEventLog(t *testing.T, executor Option, funcv *bigslice.FuncValue) (error, []Event) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.json")
+	sess := Start(executor, EventLog(path))
+	_, runErr := sess.Run(context.Background(), funcv)
+	sess.eventLog.mu.Lock()
+	if got, want := len(sess.eventLog.tasks), 0; got != want {
+		t.Errorf("log retains state for %v tasks", got)
+	}
+	sess.eventLog.mu.Unlock()
+	sess.Shutdown()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events, err := ReadEvents(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runErr, events
+}
+
+func TestEventLog(t *testing.T) {
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(3, rangeSlice(0, 100))
+		slice = bigslice.Map(slice, func(i int) (int, int) { return i % 10, i })
+		return bigslice.Reduce(slice, func(a, e int) int { return a + e })
+	})
+	// Use a separate test system, since the session is shut down in
+	// order to close its log.
+	executors := map[string]Option{
+		"Local":           Local,
+		"Bigmachine.Test": Bigmachine(testsystem.New()),
+	}
+	for name, executor := range executors {
+		t.Run(name, func(t *testing.T) {
+			err, events := runEventLog(t, executor, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var (
+				invocations []string
+				machines    int
+				states      = make(map[string][]string)
+				last        = make(map[string]Event)
+			)
+			for _, event := range events {
+				switch event.Type {
+				case EventInvocation:
+					invocations = append(invocations, event.State)
+				case EventMachine:
+					if event.State == "started" {
+						machines++
+					}
+				case EventTask:
+					states[event.Task] = append(states[event.Task], event.State)
+					last[event.Task] = event
+				}
+			}
+			if got, want := strings.Join(invocations, ","), "start,ok"; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if got, want := len(states), 6; got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			var mapOut, reduceOut int64
+			for task, states := range states {
+				if got, want := states[len(states)-2:], []string{"RUNNING", "OK"}; got[0] != want[0] || got[1] != want[1] {
+					t.Errorf("task %s: got %v, want %v", task, got, want)
+				}
+				event := last[task]
+				if got, want := event.Attempt, 1; got != want {
+					t.Errorf("task %s: got %v, want %v", task, got, want)
+				}
+				if event.Duration <= 0 {
+					t.Errorf("task %s: missing duration", task)
+				}
+				if name == "Bigmachine.Test" && event.Machine == "" {
+					t.Errorf("task %s: missing machine", task)
+				}
+				if event.RecordsIn == 0 {
+					mapOut += event.RecordsOut
+				} else {
+					reduceOut += event.RecordsOut
+				}
+			}
+			if got, want := mapOut, int64(100); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if got, want := reduceOut, int64(10); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if name == "Bigmachine.Test" && machines == 0 {
+				t.Error("no machine events")
+			}
+		})
+	}
+}
+
+func TestEventLogError(t *testing.T) {
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(3, rangeSlice(0, 100))
+		return bigslice.Map(slice, func(i int) int {
+			if i == 50 {
+				panic("bad row")
+			}
+			return i
+		})
+	})
+	err, events := runEventLog(t, Local, f)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	var failed []Event
+	for _, event := range events {
+		if event.State == TaskErr.String() || event.State == "error" {
+			failed = append(failed, event)
+		}
+	}
+	if got, want := len(failed), 2; got != want {
+		t.Fatalf("got %v, want %v: %v", got, want, failed)
+	}
+	for _, event := range failed {
+		if !strings.Contains(event.Error, "bad row") {
+			t.Errorf("%s event: error %q does not mention the panic", event.Type, event.Error)
+		}
+	}
+}
+
+// blockingWriter is an io.Writer whose writes block until it is
+// released.
+type blockingWriter struct {
+	release chan struct{}
+	bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.Buffer.Write(p)
+}
+
+func TestEventLogAsync(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	l := newEventLog(w)
+	task := &Task{Name: TaskName{Op: "op", NumShard: 1}}
+	now := time.Now()
+	// Events are recorded without waiting for the writer.
+	l.task(taskEvent{task: task, time: now, state: TaskWaiting})
+	l.assign(task, "machine")
+	l.task(taskEvent{task: task, time: now, state: TaskRunning, runs: 1})
+	l.run(task, TaskRunStats{RecordsIn: 1, RecordsOut: 2})
+	l.task(taskEvent{task: task, time: now.Add(time.Second), state: TaskOk, runs: 1, runtime: time.Second})
+	close(w.release)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ReadEvents(&w.Buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(events), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	ok := events[2]
+	if got, want := ok.State, TaskOk.String(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := ok.Attempt, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := ok.Machine, "machine"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := ok.Duration, time.Second; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := ok.RecordsOut, int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The state of the run is discarded when it ends.
+	if got, want := len(l.tasks), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReadEventsTruncated(t *testing.T) {
+	const log = `{"time":"2019-01-01T00:00:00Z","type":"task","task":"inv1_a_0of1","state":"RUNNING","attempt":1}
+{"time":"2019-01-01T00:00:01Z","type":"task","task":"inv1_a_0of1","state":"OK","attempt":1,"duration":1000000000}
+{"time":"2019-01-01T00:00:02Z","type":"task","task":"inv1_b_0of1","sta`
+	events, err := ReadEvents(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := events[1].Duration.Seconds(), 1.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
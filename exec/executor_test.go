@@ -0,0 +1,147 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/exec"
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+)
+
+// MemExecutor is a minimal Executor, implemented outside of package
+// exec, that runs tasks in memory. It supports only tasks with a
+// single output partition.
+type memExecutor struct {
+	sess *exec.Session
+
+	mu      sync.Mutex
+	outputs map[*exec.Task]frame.Frame
+}
+
+func (m *memExecutor) Start(sess *exec.Session) func() {
+	m.sess = sess
+	m.outputs = make(map[*exec.Task]frame.Frame)
+	return nil
+}
+
+func (m *memExecutor) Run(task *exec.Task) {
+	task.Set(exec.TaskRunning)
+	ctx := context.Background()
+	var in []sliceio.Reader
+	for _, dep := range task.Deps {
+		for i := 0; i < dep.NumTask(); i++ {
+			in = append(in, m.Reader(ctx, dep.Task(i), dep.Partition))
+		}
+	}
+	var (
+		r   = task.Do(in)
+		out frame.Frame
+		buf = frame.Make(task, 128, 128)
+	)
+	for {
+		n, err := r.Read(ctx, buf)
+		out = frame.AppendFrame(out, buf.Slice(0, n))
+		if err == sliceio.EOF {
+			break
+		}
+		if err != nil {
+			task.Error(err)
+			return
+		}
+	}
+	m.mu.Lock()
+	m.outputs[task] = out
+	m.mu.Unlock()
+	m.sess.ReportRun(task, exec.TaskRunStats{RecordsOut: int64(out.Len())})
+	task.Set(exec.TaskOk)
+}
+
+func (m *memExecutor) Reader(_ context.Context, task *exec.Task, partition int) sliceio.Reader {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sliceio.FrameReader(m.outputs[task])
+}
+
+func (*memExecutor) HandleDebug(*http.ServeMux) {}
+
+// TestCustomExecutorReportRun tests that the run statistics reported
+// by a custom executor appear in the session's event log and metrics.
+func TestCustomExecutorReportRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events")
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(1, []int{1, 2, 3})
+		return bigslice.Map(slice, func(i int) int { return i * 2 })
+	})
+	sess := exec.Start(exec.CustomExecutor(new(memExecutor)), exec.EventLog(path))
+	res := sess.Must(context.Background(), f)
+	var values []int
+	scanner := res.Scan(context.Background())
+	for v := 0; scanner.Scan(context.Background(), &v); {
+		values = append(values, v)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(values), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	mux := http.NewServeMux()
+	sess.HandleDebug(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/debug/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := regexp.MustCompile(`\nbigslice_slice_records_written_total{slice="[^"]+"} 3\n`)
+	if !want.Match(p) {
+		t.Errorf("metrics %q do not match %v", p, want)
+	}
+
+	sess.Shutdown()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := exec.ReadEvents(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ok bool
+	for _, event := range events {
+		if event.Type == exec.EventTask && event.State == exec.TaskOk.String() {
+			ok = true
+			if got, want := event.RecordsOut, int64(3); got != want {
+				t.Errorf("%s: got %v, want %v", event.Task, got, want)
+			}
+		}
+	}
+	if !ok {
+		t.Error("no task completion was logged")
+	}
+}
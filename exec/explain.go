@@ -0,0 +1,310 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/internal/slicecache"
+)
+
+// ErrExplained is returned by Session.Run when the session is
+// configured with ExplainOnly: the computation was explained instead
+// of run.
+var ErrExplained = errors.New("computation was explained, not run")
+
+// A Plan describes the physical plan of a compiled bigslice
+// computation: the stages of tasks into which its slices are
+// pipelined, and the dependencies between them.
+type Plan struct {
+	// Invocation is the index of the invocation that was compiled.
+	Invocation uint64 `json:"invocation"`
+	// Location is the location of the invocation.
+	Location string `json:"location"`
+	// Stages contains the plan's stages in dependency order: each
+	// stage appears after the stages on which it depends. The last
+	// stage computes the invoked slice.
+	Stages []*Stage `json:"stages"`
+}
+
+// A Stage is a set of tasks, one per shard, that compute the same
+// pipelined slice operations.
+type Stage struct {
+	// Op is the operation name shared by the stage's tasks, for
+	// example "inv1_reader_map_filter".
+	Op string `json:"op"`
+	// NumShard is the number of shards (tasks) in the stage.
+	NumShard int `json:"numShard"`
+	// NumPartition is the number of partitions into which the output
+	// of each of the stage's tasks is partitioned.
+	NumPartition int `json:"numPartition"`
+	// Slices are the names of the slices that are pipelined in the
+	// stage, in the order in which they are computed.
+	Slices []string `json:"slices"`
+	// Combiner indicates whether the stage's output is combined.
+	Combiner bool `json:"combiner,omitempty"`
+	// CombineKey is the key of the machine-local combine buffers used
+	// for the stage's output, if any.
+	CombineKey string `json:"combineKey,omitempty"`
+	// Pragmas lists the pragmas in effect for the stage.
+	Pragmas []string `json:"pragmas,omitempty"`
+	// CachedShards is the number of shards that are read from a
+	// cache, and are thus not recomputed.
+	CachedShards int `json:"cachedShards,omitempty"`
+	// Reused indicates that the stage's tasks were compiled by a
+	// previous invocation, whose results are reused.
+	Reused bool `json:"reused,omitempty"`
+	// Deps are the stage's dependencies.
+	Deps []StageDep `json:"deps,omitempty"`
+}
+
+// A StageDep describes a dependency of a stage on another stage.
+type StageDep struct {
+	// Op is the operation name of the stage on which the dependency
+	// is made.
+	Op string `json:"op"`
+	// Shuffle indicates that the dependency is a shuffle dependency:
+	// each task reads its partition from all of the dependency's
+	// tasks.
+	Shuffle bool `json:"shuffle,omitempty"`
+	// Expand indicates that the dependency's tasks are read
+	// individually instead of merged.
+	Expand bool `json:"expand,omitempty"`
+	// CombineKey is the combine key through which the dependency is
+	// read, if any.
+	CombineKey string `json:"combineKey,omitempty"`
+}
+
+// Explain compiles the slice returned by the bigslice func funcv
+// applied to the provided arguments, and returns its physical plan.
+// Nothing is evaluated, and no invocation index is consumed: the
+// plan is named as the next invocation would be.
+func (s *Session) Explain(funcv *bigslice.FuncValue, args ...interface{}) (*Plan, error) {
+	inv, _, tasks, err := s.compileInvocation(1, funcv.PeekInvocation, args...)
+	if err != nil {
+		return nil, err
+	}
+	return explain(inv, tasks), nil
+}
+
+// Explain returns the plan of the provided tasks, compiled from
+// the invocation inv.
+func explain(inv bigslice.Invocation, tasks []*Task) *Plan {
+	// Gather the tasks of each stage, in the order in which they are
+	// encountered. The dependencies of reused tasks belong to a
+	// previous invocation's plan.
+	var (
+		ops    []string
+		shards = make(map[string][]*Task)
+		seen   = make(map[*Task]bool)
+		walk   func(task *Task)
+	)
+	walk = func(task *Task) {
+		if seen[task] {
+			return
+		}
+		seen[task] = true
+		op := task.Name.Op
+		if shards[op] == nil {
+			ops = append(ops, op)
+		}
+		shards[op] = append(shards[op], task)
+		if task.Invocation.Index != inv.Index {
+			return
+		}
+		for _, dep := range task.Deps {
+			for i := 0; i < dep.NumTask(); i++ {
+				walk(dep.Task(i))
+			}
+		}
+	}
+	for _, task := range tasks {
+		walk(task)
+	}
+	stages := make(map[string]*Stage)
+	for _, op := range ops {
+		stages[op] = newStage(inv, shards[op])
+	}
+	// Order the stages so that each appears after its dependencies.
+	var (
+		plan    = &Plan{Invocation: inv.Index, Location: inv.Location}
+		ordered = make(map[string]bool)
+		order   func(stage *Stage)
+	)
+	order = func(stage *Stage) {
+		if ordered[stage.Op] {
+			return
+		}
+		ordered[stage.Op] = true
+		for _, dep := range stage.Deps {
+			order(stages[dep.Op])
+		}
+		plan.Stages = append(plan.Stages, stage)
+	}
+	for _, op := range ops {
+		order(stages[op])
+	}
+	return plan
+}
+
+// NewStage returns the stage comprising the provided tasks, which
+// share an op, in the plan of invocation inv.
+func newStage(inv bigslice.Invocation, tasks []*Task) *Stage {
+	task := tasks[0]
+	stage := &Stage{
+		Op:           task.Name.Op,
+		NumShard:     task.Name.NumShard,
+		NumPartition: task.NumPartition,
+		Combiner:     task.Combiner != nil,
+		CombineKey:   task.CombineKey,
+		Reused:       task.Invocation.Index != inv.Index,
+	}
+	for i := len(task.Slices) - 1; i >= 0; i-- {
+		stage.Slices = append(stage.Slices, task.Slices[i].Name().String())
+	}
+	if task.Pragma != nil {
+		if task.Exclusive() {
+			stage.Pragmas = append(stage.Pragmas, "exclusive")
+		}
+		if task.Materialize() {
+			stage.Pragmas = append(stage.Pragmas, "materialize")
+		}
+		if recompute(task.Pragma) {
+			stage.Pragmas = append(stage.Pragmas, "recompute")
+		}
+	}
+	seen := make(map[StageDep]bool)
+	for _, task := range tasks {
+		for _, dep := range task.Deps {
+			stageDep := StageDep{
+				Op:         dep.Head.Name.Op,
+				Shuffle:    dep.Shuffle,
+				Expand:     dep.Expand,
+				CombineKey: dep.CombineKey,
+			}
+			if !seen[stageDep] {
+				seen[stageDep] = true
+				stage.Deps = append(stage.Deps, stageDep)
+			}
+		}
+		for _, slice := range task.Slices {
+			c, ok := bigslice.Unwrap(slice).(slicecache.Cacheable)
+			if ok && c.Cache().IsCached(task.Name.Shard) {
+				stage.CachedShards++
+				break
+			}
+		}
+	}
+	return stage
+}
+
+// Write writes the plan to w in the provided format, which is one
+// of "text", "json", or "dot".
+func (p *Plan) Write(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		return p.WriteText(w)
+	case "json":
+		return p.WriteJSON(w)
+	case "dot":
+		return p.WriteDOT(w)
+	default:
+		return fmt.Errorf("unknown plan format %q", format)
+	}
+}
+
+// WriteText writes a human-readable representation of the plan to w.
+func (p *Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invocation %d at %s\n", p.Invocation, p.Location)
+	for _, stage := range p.Stages {
+		fmt.Fprintf(&b, "stage %s: %d shards, %d partitions", stage.Op, stage.NumShard, stage.NumPartition)
+		if stage.Reused {
+			b.WriteString(" (reused)")
+		}
+		b.WriteString("\n")
+		for _, slice := range stage.Slices {
+			fmt.Fprintf(&b, "\tslice %s\n", slice)
+		}
+		if stage.Combiner {
+			b.WriteString("\tcombiner")
+			if stage.CombineKey != "" {
+				fmt.Fprintf(&b, " (combine key %s)", stage.CombineKey)
+			}
+			b.WriteString("\n")
+		}
+		if len(stage.Pragmas) > 0 {
+			fmt.Fprintf(&b, "\tpragmas %s\n", strings.Join(stage.Pragmas, ", "))
+		}
+		if stage.CachedShards > 0 {
+			fmt.Fprintf(&b, "\tcached %d/%d shards\n", stage.CachedShards, stage.NumShard)
+		}
+		for _, dep := range stage.Deps {
+			fmt.Fprintf(&b, "\tdep %s", dep.Op)
+			if dep.Shuffle {
+				b.WriteString(" (shuffle)")
+			}
+			if dep.Expand {
+				b.WriteString(" (expand)")
+			}
+			if dep.CombineKey != "" {
+				fmt.Fprintf(&b, " (combine key %s)", dep.CombineKey)
+			}
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes a JSON representation of the plan to w.
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// WriteDOT writes a Graphviz DOT representation of the plan to w.
+// Each stage is a node; edges point from a dependency to its
+// dependent, and shuffle dependencies are drawn as dashed edges.
+func (p *Plan) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", fmt.Sprintf("inv%d", p.Invocation))
+	b.WriteString("\tnode [shape=box];\n")
+	for _, stage := range p.Stages {
+		label := []string{stage.Op, fmt.Sprintf("%d shards", stage.NumShard)}
+		if stage.Combiner {
+			label = append(label, "combiner")
+		}
+		if len(stage.Pragmas) > 0 {
+			label = append(label, strings.Join(stage.Pragmas, ", "))
+		}
+		if stage.CachedShards > 0 {
+			label = append(label, fmt.Sprintf("cached %d/%d", stage.CachedShards, stage.NumShard))
+		}
+		var style string
+		if stage.Reused {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\t%q [label=%q%s];\n", stage.Op, strings.Join(label, "\n"), style)
+	}
+	for _, stage := range p.Stages {
+		for _, dep := range stage.Deps {
+			var attrs string
+			if dep.Shuffle {
+				attrs = ` [label="shuffle", style=dashed]`
+			}
+			fmt.Fprintf(&b, "\t%q -> %q%s;\n", dep.Op, stage.Op, attrs)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
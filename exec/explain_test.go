@@ -0,0 +1,166 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+)
+
+func TestExplain(t *testing.T) {
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(4, []string{"a", "b", "a"}, []int{1, 2, 3})
+		slice = bigslice.Map(slice, func(k string, v int) (string, int) { return k, v })
+		slice = bigslice.Reduce(slice, func(a, e int) int { return a + e })
+		return bigslice.Filter(slice, func(k string, v int) bool { return v > 0 }, bigslice.Exclusive)
+	})
+	sess := Start(Local)
+	plan, err := sess.Explain(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(plan.Stages), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	mapStage, reduceStage := plan.Stages[0], plan.Stages[1]
+	if got, want := mapStage.Op, "_const_map"; !strings.HasSuffix(got, want) {
+		t.Errorf("got %v, want suffix %v", got, want)
+	}
+	if got, want := reduceStage.Op, "_reduce_filter"; !strings.HasSuffix(got, want) {
+		t.Errorf("got %v, want suffix %v", got, want)
+	}
+	if got, want := mapStage.NumShard, 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := mapStage.NumPartition, 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !mapStage.Combiner {
+		t.Error("expected combiner")
+	}
+	if got, want := len(mapStage.Slices), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := mapStage.Slices[0], "const@"; !strings.HasPrefix(got, want) {
+		t.Errorf("got %v, want prefix %v", got, want)
+	}
+	if got, want := reduceStage.Deps, []StageDep{{Op: mapStage.Op, Shuffle: true, Expand: true}}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := reduceStage.Pragmas, []string{"exclusive"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var b bytes.Buffer
+	if err := plan.Write(&b, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "dep "+mapStage.Op+" (shuffle) (expand)\n"; !strings.Contains(got, want) {
+		t.Errorf("text plan %q does not contain %q", got, want)
+	}
+	b.Reset()
+	if err := plan.Write(&b, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Plan
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(decoded.Stages), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	b.Reset()
+	if err := plan.Write(&b, "dot"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), `"`+mapStage.Op+`" -> "`+reduceStage.Op+`"`; !strings.Contains(got, want) {
+		t.Errorf("dot plan %q does not contain %q", got, want)
+	}
+	if err := plan.Write(&b, "xml"); err == nil {
+		t.Error("expected error")
+	}
+
+	// Explain does not consume an invocation index, so the plan is
+	// named as the run is.
+	res, err := sess.Run(context.Background(), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.inv.Index, plan.Invocation; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := res.tasks[0].Name.Op, reduceStage.Op; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestExplainOnly(t *testing.T) {
+	f := bigslice.Func(func() bigslice.Slice {
+		return bigslice.Const(2, []int{1, 2, 3})
+	})
+	var (
+		b     bytes.Buffer
+		ndone int
+	)
+	sess := Start(Local, ExplainOnly(&b, "text", func() { ndone++ }))
+	if _, err := sess.Run(context.Background(), f); err != ErrExplained {
+		t.Fatalf("got %v, want %v", err, ErrExplained)
+	}
+	if got, want := ndone, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	plan := b.String()
+	if !strings.Contains(plan, "const") {
+		t.Errorf("unexpected plan %q", plan)
+	}
+	// Only the first computation is explained.
+	if _, err := sess.Run(context.Background(), f); err != ErrExplained {
+		t.Fatalf("got %v, want %v", err, ErrExplained)
+	}
+	if got, want := b.String(), plan; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := ndone, 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	sess.Shutdown()
+}
+
+func TestExplainShardMapper(t *testing.T) {
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(4, []string{"a", "b", "a"}, []int{1, 2, 3})
+		split := bigslice.Split(slice, 2, func(k string, v int) int { return v % 2 })
+		return bigslice.Cogroup(slice, bigslice.Union(split...), bigslice.Coalesce(slice, 2))
+	})
+	sess := Start(Local)
+	defer sess.Shutdown()
+	plan, err := sess.Explain(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ncogroup int
+	for _, stage := range plan.Stages {
+		// Only the cogroup shuffles its dependencies; the union, split
+		// outputs, and coalesce read their dependencies' tasks directly,
+		// even though those tasks are also shuffled.
+		cogroup := strings.HasSuffix(stage.Op, "_cogroup")
+		if cogroup {
+			ncogroup += len(stage.Deps)
+		}
+		for _, dep := range stage.Deps {
+			if got, want := dep.Shuffle, cogroup; got != want {
+				t.Errorf("%s: dep %s: got shuffle %v, want %v", stage.Op, dep.Op, got, want)
+			}
+		}
+	}
+	if got, want := ncogroup, 3; got != want {
+		t.Errorf("got %v cogroup deps, want %v", got, want)
+	}
+}
@@ -10,13 +10,16 @@ import (
 	"net/http"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/grailbio/base/backgroundcontext"
 	"github.com/grailbio/base/errors"
 	"github.com/grailbio/base/limiter"
 	"github.com/grailbio/base/log"
+	"github.com/grailbio/bigslice"
 	"github.com/grailbio/bigslice/frame"
 	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/stats"
 )
 
 // LocalExecutor is an executor that runs tasks in-process in
@@ -27,6 +30,10 @@ type localExecutor struct {
 	buffers map[*Task]taskBuffer
 	limiter *limiter.Limiter
 	sess    *Session
+	// stats holds the distributions recorded by the executor, e.g.,
+	// task run times (tasktime) and the latencies of user function
+	// calls (functime).
+	stats *stats.Map
 }
 
 func newLocalExecutor() *localExecutor {
@@ -34,9 +41,16 @@ func newLocalExecutor() *localExecutor {
 		state:   make(map[*Task]TaskState),
 		buffers: make(map[*Task]taskBuffer),
 		limiter: limiter.New(),
+		stats:   stats.NewMap(),
 	}
 }
 
+// NewLocalExecutor returns a new executor that runs tasks
+// in-process, as configured by the Local option.
+func NewLocalExecutor() Executor {
+	return newLocalExecutor()
+}
+
 func (l *localExecutor) Start(sess *Session) (shutdown func()) {
 	l.sess = sess
 	l.limiter.Release(sess.p)
@@ -58,13 +72,141 @@ func (l *localExecutor) Run(task *Task) {
 		return
 	}
 	defer l.limiter.Release(n)
-	in := make([]sliceio.Reader, 0, len(task.Deps))
-	for _, dep := range task.Deps {
-		reader := new(multiReader)
-		reader.q = make([]sliceio.Reader, dep.NumTask())
-		for j := 0; j < dep.NumTask(); j++ {
-			reader.q[j] = l.Reader(ctx, dep.Task(j), dep.Partition)
+	// Dead letters are buffered by slice, and are appended to the
+	// task's buffer, after its output partitions, once the task has
+	// completed.
+	var (
+		deadLetters     = make(map[bigslice.Name][]frame.Frame)
+		deadLetterNames []bigslice.Name
+	)
+	rowErrors := &bigslice.RowErrors{
+		Policy: l.sess.errorPolicy,
+		WriteDeadLetters: func(slice bigslice.Name, f frame.Frame) error {
+			if _, ok := deadLetters[slice]; !ok {
+				deadLetterNames = append(deadLetterNames, slice)
+			}
+			deadLetters[slice] = append(deadLetters[slice], f)
+			return nil
+		},
+	}
+	ctx = bigslice.WithRowErrors(ctx, rowErrors)
+	counters := new(bigslice.Counters)
+	ctx = bigslice.WithCounters(ctx, counters)
+	// Function latencies are recorded by a timer that is local to the
+	// task, so that concurrent tasks do not contend on the shared one.
+	funcTime := stats.NewTimer()
+	defer l.stats.Timer("functime").Merge(funcTime)
+	ctx = bigslice.WithFuncTimer(ctx, funcTime)
+	ctx = bigslice.WithTask(ctx, task.Name.String(), task.Name.Shard, task.Invocation.Index)
+	// Release the resources held by the task's readers, including
+	// those that were abandoned, once the task has completed.
+	ctx, cleanup := bigslice.WithTaskCleanup(ctx)
+	defer cleanup()
+	deps := make([][]sliceio.Reader, len(task.Deps))
+	for i, dep := range task.Deps {
+		deps[i] = make([]sliceio.Reader, dep.NumTask())
+		for j := range deps[i] {
+			deps[i][j] = l.Reader(ctx, dep.Task(j), dep.Partition)
+		}
+	}
+	in, err := taskInputs(ctx, task, deps)
+	if err != nil {
+		l.capture(ctx, task, err)
+		task.Error(err)
+		return
+	}
+	var recordsIn, recordsOut stats.Int
+	for i := range in {
+		in[i] = &statsReader{in[i], &recordsIn, nil}
+	}
+	task.Set(TaskRunning)
+	start := time.Now()
+
+	// Start execution, then place output in a task buffer.
+	out := &statsReader{task.Do(in), &recordsOut, nil}
+	buf, err := bufferOutput(ctx, task, out)
+	if err == nil {
+		err = rowErrors.Flush()
+	}
+	if err == nil {
+		for len(buf) < task.NumPartition {
+			buf = append(buf, nil)
+		}
+		outputs := make([]DeadLetterOutput, len(deadLetterNames))
+		for i, name := range deadLetterNames {
+			outputs[i] = DeadLetterOutput{Slice: name, Partition: len(buf)}
+			for _, f := range deadLetters[name] {
+				outputs[i].Records += int64(f.Len())
+			}
+			buf = append(buf, deadLetters[name])
+		}
+		task.SetRowErrors(rowErrors.Skipped(), outputs)
+		task.SetCounters(counters.Values())
+		l.sess.ReportRun(task, TaskRunStats{RecordsIn: recordsIn.Get(), RecordsOut: recordsOut.Get()})
+		l.stats.Timer("tasktime").Observe(time.Since(start))
+	} else {
+		l.capture(ctx, task, err)
+	}
+	task.Lock()
+	if err == nil {
+		l.mu.Lock()
+		l.buffers[task] = buf
+		l.mu.Unlock()
+		task.state = TaskOk
+	} else {
+		task.state = TaskErr
+		task.err = err
+	}
+	task.Broadcast()
+	task.Unlock()
+}
+
+// Capture captures the inputs of the provided failed task, if the
+// session is configured to capture failures.
+func (l *localExecutor) capture(ctx context.Context, task *Task, taskErr error) {
+	if l.sess.captureDir == "" || ctx.Err() != nil {
+		return
+	}
+	chain, err := invocationChain(task.Invocation, nil)
+	if err != nil {
+		log.Error.Printf("task %s: capture: %v", task.Name, err)
+		return
+	}
+	deps := make([][]sliceio.Reader, len(task.Deps))
+	for i, dep := range task.Deps {
+		deps[i] = make([]sliceio.Reader, dep.NumTask())
+		for j := range deps[i] {
+			deps[i][j] = l.Reader(ctx, dep.Task(j), dep.Partition)
 		}
+	}
+	captureTask(ctx, l.sess.captureDir, l.sess.captureRecords, chain, l.sess.machineCombiners, task, taskErr, deps)
+}
+
+func (l *localExecutor) Reader(_ context.Context, task *Task, partition int) sliceio.Reader {
+	l.mu.Lock()
+	buf := l.buffers[task]
+	l.mu.Unlock()
+	return buf.Reader(partition)
+}
+
+func (*localExecutor) HandleDebug(*http.ServeMux) {}
+
+// addMetrics implements metricsExecutor.
+func (l *localExecutor) addMetrics(m *metrics) {
+	dists := make(stats.Distributions)
+	l.stats.AddDistributions(dists)
+	addDistributions(m, dists)
+	m.Add("bigslice_spill_bytes_total", "counter", spillBytesHelp, float64(combineSpillBytes.Value()))
+}
+
+// TaskInputs returns the task's input readers, given readers for the
+// outputs of the tasks of each of its dependencies. The outputs of
+// tasks with combiners are combined in-line, one combiner for each
+// dependency.
+func taskInputs(ctx context.Context, task *Task, deps [][]sliceio.Reader) ([]sliceio.Reader, error) {
+	in := make([]sliceio.Reader, 0, len(task.Deps))
+	for i, dep := range task.Deps {
+		reader := &multiReader{q: deps[i]}
 		if dep.NumTask() > 0 && dep.Task(0).Combiner != nil {
 			// Perform input combination in-line, one for each partition.
 			combineKey := task.Name
@@ -73,19 +215,16 @@ func (l *localExecutor) Run(task *Task) {
 			}
 			combiner, err := newCombiner(dep.Task(0), combineKey.String(), *dep.Task(0).Combiner, *defaultChunksize*100)
 			if err != nil {
-				task.Error(err)
-				return
+				return nil, err
 			}
 			buf := frame.Make(dep.Task(0), *defaultChunksize, *defaultChunksize)
 			for {
 				n, err := reader.Read(ctx, buf)
 				if err != nil && err != sliceio.EOF {
-					task.Error(err)
-					return
+					return nil, err
 				}
 				if err := combiner.Combine(ctx, buf.Slice(0, n)); err != nil {
-					task.Error(err)
-					return
+					return nil, err
 				}
 				if err == sliceio.EOF {
 					break
@@ -93,8 +232,7 @@ func (l *localExecutor) Run(task *Task) {
 			}
 			reader, err := combiner.Reader()
 			if err != nil {
-				task.Error(err)
-				return
+				return nil, err
 			}
 			in = append(in, reader)
 		} else if dep.Expand {
@@ -103,34 +241,9 @@ func (l *localExecutor) Run(task *Task) {
 			in = append(in, reader)
 		}
 	}
-	task.Set(TaskRunning)
-
-	// Start execution, then place output in a task buffer.
-	out := task.Do(in)
-	buf, err := bufferOutput(ctx, task, out)
-	task.Lock()
-	if err == nil {
-		l.mu.Lock()
-		l.buffers[task] = buf
-		l.mu.Unlock()
-		task.state = TaskOk
-	} else {
-		task.state = TaskErr
-		task.err = err
-	}
-	task.Broadcast()
-	task.Unlock()
+	return in, nil
 }
 
-func (l *localExecutor) Reader(_ context.Context, task *Task, partition int) sliceio.Reader {
-	l.mu.Lock()
-	buf := l.buffers[task]
-	l.mu.Unlock()
-	return buf.Reader(partition)
-}
-
-func (*localExecutor) HandleDebug(*http.ServeMux) {}
-
 // BufferOutput reads the output from reader and places it in a
 // task buffer. If the output is partitioned, bufferOutput invokes
 // the task's partitioner in order to determine the correct partition.
@@ -143,7 +256,10 @@ func bufferOutput(ctx context.Context, task *Task, out sliceio.Reader) (buf task
 		return nil, err
 	}
 	buf = make(taskBuffer, task.NumPartition)
-	var in frame.Frame
+	var (
+		in        frame.Frame
+		partition = task.NewPartitioner()
+	)
 	defer func() {
 		if e := recover(); e != nil {
 			stack := debug.Stack()
@@ -165,7 +281,10 @@ func bufferOutput(ctx context.Context, task *Task, out sliceio.Reader) (buf task
 		// maintain buffer slices of defaultChunksize each.
 		if task.NumPartition > 1 {
 			for i := 0; i < n; i++ {
-				p := int(in.Hash(i)) % task.NumPartition
+				p, err := partition(in, i)
+				if err != nil {
+					return nil, err
+				}
 				// If we don't yet have a buffer or the current one is at capacity,
 				// create a new one.
 				m := len(buf[p])
@@ -185,6 +304,13 @@ func bufferOutput(ctx context.Context, task *Task, out sliceio.Reader) (buf task
 			break
 		}
 	}
+	if task.PartitionColumns != nil {
+		for p := range buf {
+			for i := range buf[p] {
+				buf[p][i] = task.ProjectPartition(buf[p][i], p)
+			}
+		}
+	}
 	return buf, nil
 }
 
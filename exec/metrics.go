@@ -0,0 +1,307 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/bigslice/stats"
+)
+
+// A metricsExecutor is an Executor that exports metrics of its own,
+// for example about the machines that it manages.
+type metricsExecutor interface {
+	addMetrics(m *metrics)
+}
+
+// Metrics is a set of metrics that are rendered in the Prometheus
+// text exposition format. Each metric is a family of samples that
+// are distinguished by their labels.
+type metrics struct {
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	name, typ, help string
+	samples         []metricSample
+}
+
+type metricSample struct {
+	// Suffix is appended to the family's name, e.g., "_sum" for the
+	// sum of a summary.
+	suffix string
+	labels []string
+	value  float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{families: make(map[string]*metricFamily)}
+}
+
+// Add adds a sample to the metric with the provided name, type
+// ("counter" or "gauge"), and help text. Labels is a list of
+// interleaved label names and values.
+func (m *metrics) Add(name, typ, help string, value float64, labels ...string) {
+	m.family(name, typ, help, labels).add("", value, labels...)
+}
+
+// AddSummary adds the distribution d to the summary metric with the
+// provided name and help text: its quantiles are added as samples
+// labeled by quantile, followed by its sum (name_sum) and count
+// (name_count). Durations are reported in seconds.
+func (m *metrics) AddSummary(name, help string, d *stats.Distribution, labels ...string) {
+	family := m.family(name, "summary", help, labels)
+	scale := 1.0
+	if d.Duration {
+		scale = 1e-9
+	}
+	for _, q := range summaryQuantiles {
+		family.add("", float64(d.Quantile(q))*scale,
+			append(labels[:len(labels):len(labels)], "quantile", strconv.FormatFloat(q, 'g', -1, 64))...)
+	}
+	family.add("_sum", float64(d.Sum)*scale, labels...)
+	family.add("_count", float64(d.Count), labels...)
+}
+
+// SummaryQuantiles are the quantiles that are reported for summaries.
+var summaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+func (m *metrics) family(name, typ, help string, labels []string) *metricFamily {
+	if len(labels)%2 != 0 {
+		panic("metrics: invalid labels")
+	}
+	family := m.families[name]
+	if family == nil {
+		family = &metricFamily{name: name, typ: typ, help: help}
+		m.families[name] = family
+	}
+	return family
+}
+
+func (f *metricFamily) add(suffix string, value float64, labels ...string) {
+	f.samples = append(f.samples, metricSample{suffix, labels, value})
+}
+
+// AddDistributions adds the provided worker distributions, keyed by
+// name, to m as a single summary family.
+func addDistributions(m *metrics, dists stats.Distributions) {
+	names := make([]string, 0, len(dists))
+	for name := range dists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		family, ok := workerDistributions[name]
+		if !ok {
+			family.name = "bigslice_" + name
+			if dists[name].Duration {
+				family.name += "_seconds"
+			}
+			family.help = "Distribution " + name + " recorded by workers."
+		}
+		m.AddSummary(family.name, family.help, dists[name])
+	}
+}
+
+// WorkerDistributions names the metric families of the distributions
+// recorded by workers. Each distribution is reported in its own
+// family, since a family's samples must share a unit.
+var workerDistributions = map[string]struct{ name, help string }{
+	"tasktime":      {"bigslice_task_duration_seconds", "Run times of tasks."},
+	"functime":      {"bigslice_function_duration_seconds", "Latencies of calls to the user functions of Map, Filter, Flatmap, and ReaderFunc."},
+	"partitionrows": {"bigslice_partition_rows", "Number of rows written to each output partition of a task."},
+	"readbatch":     {"bigslice_read_batch_rows", "Number of rows in each batch read by a task from its dependencies."},
+}
+
+// Write writes the metrics to w in the Prometheus text exposition
+// format, ordered by name.
+func (m *metrics) Write(w io.Writer) error {
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := bufio.NewWriter(w)
+	for _, name := range names {
+		family := m.families[name]
+		fmt.Fprintf(b, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(b, "# TYPE %s %s\n", name, family.typ)
+		for _, sample := range family.samples {
+			b.WriteString(name)
+			b.WriteString(sample.suffix)
+			if len(sample.labels) > 0 {
+				b.WriteString("{")
+				for i := 0; i < len(sample.labels); i += 2 {
+					if i > 0 {
+						b.WriteString(",")
+					}
+					fmt.Fprintf(b, "%s=\"%s\"", sample.labels[i], escapeLabel(sample.labels[i+1]))
+				}
+				b.WriteString("}")
+			}
+			fmt.Fprintf(b, " %s\n", strconv.FormatFloat(sample.value, 'g', -1, 64))
+		}
+	}
+	return b.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// TaskMetrics maintains running counts of the tasks of a session's
+// unfinished invocations by slice and state, and totals of the
+// records and bytes processed by the successful runs of each slice's
+// tasks, so that the session's metrics are rendered without walking
+// its task graphs. A task is attributed to the slice that it computes,
+// and slices are identified by their names (see bigslice.Name), which
+// are shared by the slices that are defined at the same location by
+// each invocation: the number of series is thus bounded by the
+// program rather than by the number of invocations. Tasks are reported
+// by the session's taskObserver. A nil taskMetrics ignores reports.
+type taskMetrics struct {
+	mu     sync.Mutex
+	states map[sliceState]int
+	slices map[string]*sliceMetrics
+}
+
+type sliceState struct {
+	slice string
+	state TaskState
+}
+
+// SliceMetrics are the totals of the successful runs of a slice's
+// tasks, including reruns.
+type sliceMetrics struct {
+	recordsIn, recordsOut, shuffleBytes int64
+}
+
+func newTaskMetrics() *taskMetrics {
+	return &taskMetrics{
+		states: make(map[sliceState]int),
+		slices: make(map[string]*sliceMetrics),
+	}
+}
+
+// TaskSliceName returns the name of the slice computed by the provided
+// task, by which the task's metrics are labeled.
+func taskSliceName(task *Task) string {
+	if len(task.Slices) == 0 {
+		return task.Name.Op
+	}
+	return task.Slices[0].Name().String()
+}
+
+// Add counts the provided task in the provided state.
+func (m *taskMetrics) add(task *Task, state TaskState) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.states[sliceState{taskSliceName(task), state}]++
+	m.mu.Unlock()
+}
+
+// Remove stops counting the provided task in the provided state.
+func (m *taskMetrics) remove(task *Task, state TaskState) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.states[sliceState{taskSliceName(task), state}]--
+	m.mu.Unlock()
+}
+
+// Run adds the statistics of a successful run of the provided task
+// to the totals of its slice.
+func (m *taskMetrics) run(task *Task, stats TaskRunStats) {
+	if m == nil {
+		return
+	}
+	name := taskSliceName(task)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	slice := m.slices[name]
+	if slice == nil {
+		slice = new(sliceMetrics)
+		m.slices[name] = slice
+	}
+	slice.recordsIn += stats.RecordsIn
+	slice.recordsOut += stats.RecordsOut
+	slice.shuffleBytes += stats.ShuffleBytes
+}
+
+// AddMetrics adds the task counts and per-slice totals to metrics.
+func (m *taskMetrics) addMetrics(metrics *metrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]sliceState, 0, len(m.states))
+	for key := range m.states {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].slice != keys[j].slice {
+			return keys[i].slice < keys[j].slice
+		}
+		return keys[i].state < keys[j].state
+	})
+	for _, key := range keys {
+		metrics.Add("bigslice_tasks", "gauge", "Number of tasks of unfinished invocations by slice and state.",
+			float64(m.states[key]), "slice", key.slice, "state", key.state.String())
+	}
+	names := make([]string, 0, len(m.slices))
+	for name := range m.slices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		slice := m.slices[name]
+		metrics.Add("bigslice_slice_records_read_total", "counter", "Number of records read by the successful runs of the tasks that compute a slice.",
+			float64(slice.recordsIn), "slice", name)
+		metrics.Add("bigslice_slice_records_written_total", "counter", "Number of records written by the successful runs of the tasks that compute a slice.",
+			float64(slice.recordsOut), "slice", name)
+		metrics.Add("bigslice_slice_shuffle_bytes_total", "counter", "Number of encoded bytes of dependency output read by the successful runs of the tasks that compute a slice.",
+			float64(slice.shuffleBytes), "slice", name)
+	}
+}
+
+// SpillBytesHelp describes the bigslice_spill_bytes_total metric,
+// which executors report from the processes in which their combiners
+// run.
+const spillBytesHelp = "Number of bytes spilled to disk by combiners, across all machines."
+
+// addMetrics adds the session's metrics, and those of its executor,
+// to m.
+func (s *Session) addMetrics(m *metrics) {
+	s.taskMetrics.addMetrics(m)
+	for _, executor := range unwrapExecutors(s.executor) {
+		if exec, ok := executor.(metricsExecutor); ok {
+			exec.addMetrics(m)
+			break
+		}
+	}
+}
+
+// handleMetrics serves the session's metrics in the Prometheus text
+// exposition format.
+func (s *Session) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.observer.observe()
+	m := newMetrics()
+	s.addMetrics(m)
+	w.Header().Add("content-type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.Write(w); err != nil {
+		log.Error.Printf("exec.Session: /debug/metrics: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grailbio/bigmachine"
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/stats"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	m := newMetrics()
+	m.Add("b_total", "counter", "A counter.", 2, "name", `x"y`)
+	m.Add("a", "gauge", "A gauge.", 1.5)
+	m.Add("b_total", "counter", "A counter.", 3, "name", "z")
+	timer := stats.NewTimer()
+	timer.Observe(time.Second)
+	timer.Observe(3 * time.Second)
+	m.AddSummary("c", "A summary.", timer.Distribution(), "name", "t")
+	var b bytes.Buffer
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP a A gauge.
+# TYPE a gauge
+a 1.5
+# HELP b_total A counter.
+# TYPE b_total counter
+b_total{name="x\"y"} 2
+b_total{name="z"} 3
+# HELP c A summary.
+# TYPE c summary
+c{name="t",quantile="0.5"} 1
+c{name="t",quantile="0.9"} 3
+c{name="t",quantile="0.99"} 3
+c_sum{name="t"} 4
+c_count{name="t"} 2
+`
+	if got := b.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTaskMetrics(t *testing.T) {
+	m := newTaskMetrics()
+	task := &Task{Name: TaskName{Op: "op"}}
+	m.add(task, TaskInit)
+	m.remove(task, TaskInit)
+	m.add(task, TaskRunning)
+	m.run(task, TaskRunStats{RecordsIn: 1, RecordsOut: 2, ShuffleBytes: 3})
+	m.remove(task, TaskRunning)
+	m.add(task, TaskOk)
+	if got, want := m.states, map[sliceState]int{{"op", TaskInit}: 0, {"op", TaskRunning}: 0, {"op", TaskOk}: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := *m.slices["op"], (sliceMetrics{1, 2, 3}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBigmachineMetricsLost(t *testing.T) {
+	newMachine := func(addr string, lost bool) *sliceMachine {
+		timer := stats.NewTimer()
+		timer.Observe(time.Second)
+		return &sliceMachine{
+			Machine: &bigmachine.Machine{Addr: addr},
+			lost:    lost,
+			vals:    stats.Values{"spillbytes": 10},
+			dists:   stats.Distributions{"tasktime": timer.Distribution()},
+		}
+	}
+	b := &bigmachineExecutor{managers: []*machineManager{{
+		all: []*sliceMachine{newMachine("a", false), newMachine("b", true)},
+	}}}
+	m := newMetrics()
+	b.addMetrics(m)
+	var w bytes.Buffer
+	if err := m.Write(&w); err != nil {
+		t.Fatal(err)
+	}
+	body := w.String()
+	// The lost machine remains in the totals.
+	for _, want := range []string{
+		"\nbigslice_task_duration_seconds_count 2\n",
+		"\nbigslice_task_duration_seconds_sum 2\n",
+		"\nbigslice_spill_bytes_total 20\n",
+		"\nbigslice_machines 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics %q do not contain %q", body, want)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(3, []int{1, 2, 3}, []int{1, 1, 1})
+		slice = bigslice.Map(slice, func(k, v int) (int, int) { return k, v })
+		return bigslice.Reduce(slice, func(a, b int) int { return a + b })
+	})
+	testSession(t, func(t *testing.T, sess *Session) {
+		res, err := sess.Run(context.Background(), f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/debug/metrics", http.HandlerFunc(sess.handleMetrics))
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+		resp, err := http.Get(srv.URL + "/debug/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := string(p)
+		slice := taskSliceName(res.tasks[0])
+		// The invocation's tasks are no longer counted once it has
+		// finished.
+		if want := `bigslice_tasks{slice="` + slice + `",state="OK"} 0` + "\n"; !strings.Contains(body, want) {
+			t.Errorf("metrics %q do not contain %q", body, want)
+		}
+		if want := `bigslice_slice_records_written_total{slice="` + slice + `"} 3` + "\n"; !strings.Contains(body, want) {
+			t.Errorf("metrics %q do not contain %q", body, want)
+		}
+		if want := "\nbigslice_spill_bytes_total "; !strings.Contains(body, want) {
+			t.Errorf("metrics %q do not contain %q", body, want)
+		}
+		if _, ok := sess.executor.(*localExecutor); ok {
+			// The map's function is called once for each row.
+			if want := "\nbigslice_function_duration_seconds_count 3\n"; !strings.Contains(body, want) {
+				t.Errorf("metrics %q do not contain %q", body, want)
+			}
+			if want := "\n# TYPE bigslice_task_duration_seconds summary\n"; !strings.Contains(body, want) {
+				t.Errorf("metrics %q do not contain %q", body, want)
+			}
+		}
+		if _, ok := sess.executor.(*bigmachineExecutor); ok {
+			if want := "\nbigslice_machines "; !strings.Contains(body, want) {
+				t.Errorf("metrics %q do not contain %q", body, want)
+			}
+			// The reduce reads the shuffled output of the const.
+			want := regexp.MustCompile(`\nbigslice_slice_shuffle_bytes_total{slice="` + regexp.QuoteMeta(slice) + `"} [1-9]`)
+			if !want.MatchString(body) {
+				t.Errorf("metrics %q do not match %v", body, want)
+			}
+		}
+	})
+}
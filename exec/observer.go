@@ -0,0 +1,284 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"sync"
+	"time"
+)
+
+// TaskRunStats are the statistics of a successful run of a task, as
+// reported by its executor (see Session.ReportRun).
+type TaskRunStats struct {
+	// RecordsIn and RecordsOut are the number of records read and
+	// written by the run.
+	RecordsIn, RecordsOut int64
+	// ShuffleBytes is the encoded size of the dependency outputs read
+	// by the run, where known.
+	ShuffleBytes int64
+}
+
+// ReportRun reports the statistics of a successful run of the
+// provided task to the session's task metrics and event log.
+// Executors, including those configured with CustomExecutor, report
+// a run before they set the task to TaskOk.
+func (s *Session) ReportRun(task *Task, stats TaskRunStats) {
+	s.observer.run(task, stats)
+}
+
+// TaskEventKind is the kind of a taskEvent.
+type taskEventKind int
+
+const (
+	// TaskTransition is a state transition of the task.
+	taskTransition taskEventKind = iota
+	// TaskAssign records that the task is about to be run on a
+	// machine.
+	taskAssign
+	// TaskRun reports the statistics of a successful run of the task,
+	// before the task is set to TaskOk.
+	taskRun
+)
+
+// A taskEvent is an event in a taskObserver's queue.
+type taskEvent struct {
+	kind taskEventKind
+	task *Task
+	// Time is the time at which the event occurred.
+	time time.Time
+	// State, err, runs, and runtime capture the task upon a state
+	// transition.
+	state   TaskState
+	err     error
+	runs    int
+	runtime time.Duration
+	// Machine is the address of the machine to which the task is
+	// assigned.
+	machine string
+	// Stats are the statistics of the task's run.
+	stats TaskRunStats
+}
+
+// NewTransitionEvent captures the current state of the provided
+// task. It must be called with the task's lock held.
+func newTransitionEvent(task *Task) taskEvent {
+	return taskEvent{
+		kind:    taskTransition,
+		task:    task,
+		time:    time.Now(),
+		state:   task.state,
+		err:     task.err,
+		runs:    task.runs,
+		runtime: task.runtime,
+	}
+}
+
+// A taskObserver observes the state transitions of a session's tasks
+// through a TaskSubscriber, as the dashboard does, and reports them
+// to the session's task metrics and event log. Its subscriber queues
+// every transition as it is notified, together with the machine
+// assignments and run statistics reported by the executor, and the
+// queue is processed in order by a separate goroutine, so that the
+// metrics and log are not updated while the tasks' locks are held.
+//
+// The tasks of an invocation are subscribed to when the invocation
+// starts, and are released when it has finished, so that the
+// observer's state is bounded by the session's in-flight
+// invocations.
+type taskObserver struct {
+	metrics *taskMetrics
+	log     *eventLog
+
+	sub *TaskSubscriber
+	// Mu serializes the processing of events, and protects
+	// invocations and tasks.
+	mu sync.Mutex
+	// Invocations holds the tasks of each unfinished invocation.
+	invocations map[uint64][]*Task
+	// Tasks holds the state of each subscribed task.
+	tasks map[*Task]*observedTask
+
+	stopc, donec chan struct{}
+}
+
+// An observedTask is a taskObserver's state for a subscribed task.
+type observedTask struct {
+	// State is the task's last observed state.
+	state TaskState
+	// Refs is the number of unfinished invocations that include the
+	// task. A task that is no longer included in any is released once
+	// its state is final (>= TaskOk), so that the end of its run is
+	// observed even if its invocation has failed.
+	refs int
+}
+
+// NewTaskObserver returns a new observer that reports to the
+// provided metrics and log, either of which may be nil, and starts
+// its goroutine.
+func newTaskObserver(metrics *taskMetrics, log *eventLog) *taskObserver {
+	sub := NewTaskSubscriber()
+	sub.queue = true
+	o := &taskObserver{
+		metrics:     metrics,
+		log:         log,
+		sub:         sub,
+		invocations: make(map[uint64][]*Task),
+		tasks:       make(map[*Task]*observedTask),
+		stopc:       make(chan struct{}),
+		donec:       make(chan struct{}),
+	}
+	go o.loop()
+	return o
+}
+
+func (o *taskObserver) loop() {
+	defer close(o.donec)
+	for {
+		select {
+		case <-o.sub.Ready():
+			o.observe()
+		case <-o.stopc:
+			return
+		}
+	}
+}
+
+// Add subscribes to the tasks of the invocation with the provided
+// index and roots. Each task's state is retrieved as it is subscribed
+// to, so that every subsequent transition is observed; this state is
+// the baseline of the task's transitions, and is not logged.
+func (o *taskObserver) add(index uint64, roots []*Task) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	all := make(map[*Task]bool)
+	for _, root := range roots {
+		root.all(all)
+	}
+	tasks := make([]*Task, 0, len(all))
+	for task := range all {
+		tasks = append(tasks, task)
+		ot := o.tasks[task]
+		if ot == nil {
+			task.Lock()
+			task.subscribe(o.sub)
+			ot = &observedTask{state: task.state}
+			task.Unlock()
+			o.tasks[task] = ot
+			o.metrics.add(task, ot.state)
+		}
+		ot.refs++
+	}
+	o.invocations[index] = tasks
+}
+
+// Finish processes the pending events, so that these precede any
+// that the caller subsequently logs, and then releases the tasks of
+// the invocation with the provided index that are not included in
+// another unfinished invocation.
+func (o *taskObserver) finish(index uint64) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.process()
+	for _, task := range o.invocations[index] {
+		ot := o.tasks[task]
+		if ot.refs--; ot.refs == 0 && ot.state >= TaskOk {
+			o.release(task, ot)
+		}
+	}
+	delete(o.invocations, index)
+}
+
+// Assign queues the assignment of the provided task to the machine
+// with the provided address.
+func (o *taskObserver) assign(task *Task, addr string) {
+	if o == nil {
+		return
+	}
+	o.sub.enqueue(taskEvent{kind: taskAssign, task: task, time: time.Now(), machine: addr})
+}
+
+// Run queues the statistics of a successful run of the provided
+// task.
+func (o *taskObserver) run(task *Task, stats TaskRunStats) {
+	if o == nil {
+		return
+	}
+	o.sub.enqueue(taskEvent{kind: taskRun, task: task, time: time.Now(), stats: stats})
+}
+
+// Observe processes the pending events. It is called by the
+// observer's goroutine, and also directly, to bring the metrics up to
+// date before they are served.
+func (o *taskObserver) observe() {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	o.process()
+	o.mu.Unlock()
+}
+
+// Process processes the pending events in order. Events of tasks that
+// are not subscribed to, for example because they were released, are
+// ignored. It must be called with o.mu held.
+func (o *taskObserver) process() {
+	for _, event := range o.sub.dequeue() {
+		ot := o.tasks[event.task]
+		if ot == nil {
+			continue
+		}
+		switch event.kind {
+		case taskTransition:
+			if event.state == ot.state {
+				continue
+			}
+			o.metrics.remove(event.task, ot.state)
+			o.metrics.add(event.task, event.state)
+			o.log.task(event)
+			ot.state = event.state
+			if ot.refs == 0 && ot.state >= TaskOk {
+				o.release(event.task, ot)
+			}
+		case taskAssign:
+			o.log.assign(event.task, event.machine)
+		case taskRun:
+			o.metrics.run(event.task, event.stats)
+			o.log.run(event.task, event.stats)
+		}
+	}
+}
+
+// Release unsubscribes from the provided task, and discards the state
+// maintained for it. It must be called with o.mu held.
+func (o *taskObserver) release(task *Task, ot *observedTask) {
+	task.Unsubscribe(o.sub)
+	o.metrics.remove(task, ot.state)
+	o.log.release(task)
+	delete(o.tasks, task)
+}
+
+// Stop stops the observer's goroutine, processes the pending events,
+// and unsubscribes from all tasks.
+func (o *taskObserver) stop() {
+	if o == nil {
+		return
+	}
+	close(o.stopc)
+	<-o.donec
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.process()
+	for task := range o.tasks {
+		task.Unsubscribe(o.sub)
+	}
+	o.tasks = make(map[*Task]*observedTask)
+	o.invocations = make(map[uint64][]*Task)
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+)
+
+// TestTaskObserver tests that the observer logs every transition of
+// a task, in order, even when the transitions occur faster than they
+// are observed, and that it discards its state, and that of the log,
+// once the task's invocation has finished.
+func TestTaskObserver(t *testing.T) {
+	var (
+		b   bytes.Buffer
+		l   = newEventLog(&b)
+		m   = newTaskMetrics()
+		o   = newTaskObserver(m, l)
+		inv = bigslice.Invocation{Index: 1}
+	)
+	task := &Task{Name: TaskName{Op: "op", NumShard: 1}, Invocation: inv}
+	o.add(inv.Index, []*Task{task})
+	task.Set(TaskRunning)
+	task.Error(errors.New("boom"))
+	// The task is rescheduled, as by Eval, without a notification.
+	task.Lock()
+	task.state = TaskInit
+	task.Unlock()
+	o.assign(task, "machine")
+	task.Set(TaskRunning)
+	o.run(task, TaskRunStats{RecordsOut: 3})
+	task.Set(TaskOk)
+	task.Set(TaskLost)
+	// Notifications that do not change the state are ignored.
+	task.Set(TaskLost)
+	task.Set(TaskRunning)
+	task.Set(TaskOk)
+	o.finish(inv.Index)
+	o.stop()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ReadEvents(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		states   []string
+		attempts []int
+		machines []string
+	)
+	for _, event := range events {
+		states = append(states, event.State)
+		attempts = append(attempts, event.Attempt)
+		machines = append(machines, event.Machine)
+	}
+	if got, want := states, []string{"RUNNING", "ERROR", "RUNNING", "OK", "LOST", "RUNNING", "OK"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := attempts, []int{1, 1, 2, 2, 2, 3, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := machines, []string{"", "", "machine", "machine", "", "", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := events[1].Error, "boom"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := events[3].RecordsOut, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := events[6].RecordsOut, int64(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(o.tasks), 0; got != want {
+		t.Errorf("observer retains state for %v tasks", got)
+	}
+	if got, want := len(l.tasks), 0; got != want {
+		t.Errorf("log retains state for %v tasks", got)
+	}
+	if got, want := len(task.subs), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for key, n := range m.states {
+		if n != 0 {
+			t.Errorf("%v: got %v, want 0", key, n)
+		}
+	}
+}
+
+// TestTaskObserverRunning tests that a task that is still running
+// when its invocation finishes, for example because another task of
+// the invocation has failed, is released only once its run has ended.
+func TestTaskObserverRunning(t *testing.T) {
+	var (
+		b   bytes.Buffer
+		l   = newEventLog(&b)
+		o   = newTaskObserver(nil, l)
+		inv = bigslice.Invocation{Index: 1}
+	)
+	task := &Task{Name: TaskName{Op: "op", NumShard: 1}, Invocation: inv}
+	o.add(inv.Index, []*Task{task})
+	task.Set(TaskRunning)
+	o.finish(inv.Index)
+	task.Set(TaskOk)
+	o.observe()
+	o.mu.Lock()
+	ntasks := len(o.tasks)
+	o.mu.Unlock()
+	if got, want := ntasks, 0; got != want {
+		t.Errorf("observer retains state for %v tasks", got)
+	}
+	o.stop()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ReadEvents(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := events[1].State, "OK"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/grailbio/base/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// SliceLabel is the pprof label with which sliceio.PprofReader
+// labels the goroutines that compute a slice.
+const sliceLabel = "sliceName"
+
+// A profileExecutor is an Executor that can collect profiles from
+// the processes in which it evaluates tasks.
+type profileExecutor interface {
+	// Profiles collects the named profile (as in pprof.Lookup, or
+	// "profile" for a CPU profile) from each process. CPU profiles
+	// are collected over the provided duration. If gc is true, a
+	// garbage collection is run before heap profiles are collected.
+	profiles(ctx context.Context, which string, dur time.Duration, gc bool) ([]*profile.Profile, error)
+}
+
+// ProfileRequest mirrors the request of bigmachine's
+// Supervisor.Profile.
+type profileRequest struct {
+	Name  string
+	Debug int
+	GC    bool
+}
+
+// profiles implements profileExecutor for the local executor: tasks
+// are evaluated in the current process.
+func (*localExecutor) profiles(ctx context.Context, which string, dur time.Duration, gc bool) ([]*profile.Profile, error) {
+	var b bytes.Buffer
+	switch which {
+	case "profile":
+		if err := pprof.StartCPUProfile(&b); err != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(dur):
+		case <-ctx.Done():
+		}
+		pprof.StopCPUProfile()
+	default:
+		p := pprof.Lookup(which)
+		if p == nil {
+			return nil, fmt.Errorf("no such profile %s", which)
+		}
+		if which == "heap" && gc {
+			runtime.GC()
+		}
+		if err := p.WriteTo(&b, 0); err != nil {
+			return nil, err
+		}
+	}
+	prof, err := profile.Parse(&b)
+	if err != nil {
+		return nil, err
+	}
+	return []*profile.Profile{prof}, nil
+}
+
+// profiles implements profileExecutor for the bigmachine executor:
+// profiles are collected from each of the executor's live machines.
+// Machines from which a profile cannot be collected are skipped.
+func (b *bigmachineExecutor) profiles(ctx context.Context, which string, dur time.Duration, gc bool) ([]*profile.Profile, error) {
+	b.mu.Lock()
+	managers := append([]*machineManager(nil), b.managers...)
+	b.mu.Unlock()
+	var (
+		mu       sync.Mutex
+		profiles []*profile.Profile
+		g, gctx  = errgroup.WithContext(ctx)
+	)
+	for _, manager := range managers {
+		if manager == nil {
+			continue
+		}
+		for _, m := range manager.Machines() {
+			if m.Lost() {
+				continue
+			}
+			m := m
+			g.Go(func() error {
+				var (
+					rc  io.ReadCloser
+					err error
+				)
+				if which == "profile" {
+					err = m.Call(gctx, "Supervisor.CPUProfile", dur, &rc)
+				} else {
+					err = m.Call(gctx, "Supervisor.Profile", profileRequest{Name: which, GC: gc}, &rc)
+				}
+				if err != nil {
+					log.Error.Printf("failed to collect %s profile from %s: %v", which, m.Addr, err)
+					return nil
+				}
+				defer rc.Close()
+				prof, err := profile.Parse(rc)
+				if err != nil {
+					log.Error.Printf("failed to parse %s profile from %s: %v", which, m.Addr, err)
+					return nil
+				}
+				mu.Lock()
+				profiles = append(profiles, prof)
+				mu.Unlock()
+				return nil
+			})
+		}
+	}
+	err := g.Wait()
+	return profiles, err
+}
+
+// FilterProfile returns the profile p restricted to the samples
+// whose slice label matches re.
+func filterProfile(p *profile.Profile, re *regexp.Regexp) *profile.Profile {
+	samples := p.Sample[:0]
+	for _, sample := range p.Sample {
+		for _, label := range sample.Label[sliceLabel] {
+			if re.MatchString(label) {
+				samples = append(samples, sample)
+				break
+			}
+		}
+	}
+	p.Sample = samples
+	return p.Compact()
+}
+
+// WriteProfileSummary writes to w a table of the totals of p's
+// default sample type (or its last, if no default is defined) by
+// slice label, in decreasing order.
+func writeProfileSummary(w io.Writer, p *profile.Profile) error {
+	if len(p.SampleType) == 0 {
+		return fmt.Errorf("profile has no sample types")
+	}
+	index := len(p.SampleType) - 1
+	for i, typ := range p.SampleType {
+		if typ.Type == p.DefaultSampleType {
+			index = i
+		}
+	}
+	var (
+		totals = make(map[string]int64)
+		total  int64
+	)
+	for _, sample := range p.Sample {
+		label := "(none)"
+		if labels := sample.Label[sliceLabel]; len(labels) > 0 {
+			label = labels[0]
+		}
+		totals[label] += sample.Value[index]
+		total += sample.Value[index]
+	}
+	labels := make([]string, 0, len(totals))
+	for label := range totals {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if totals[labels[i]] != totals[labels[j]] {
+			return totals[labels[i]] > totals[labels[j]]
+		}
+		return labels[i] < labels[j]
+	})
+	typ := p.SampleType[index]
+	tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s/%s\tpercent\tslice\n", typ.Type, typ.Unit)
+	for _, label := range labels {
+		var percent float64
+		if total != 0 {
+			percent = 100 * float64(totals[label]) / float64(total)
+		}
+		fmt.Fprintf(tw, "%d\t%.1f%%\t%s\n", totals[label], percent, label)
+	}
+	return tw.Flush()
+}
+
+// handleProfile serves profiles that are merged across all of the
+// processes in which the session evaluates tasks. The profile is
+// named by the last path component: "profile" for a CPU profile, or
+// a profile known to pprof.Lookup, e.g., "heap" or "goroutine". The
+// following parameters are accepted:
+//
+//	seconds: the duration of CPU profiles (default 30)
+//	gc:      if nonzero, run a garbage collection before collecting heap profiles
+//	slice:   a regular expression that restricts the profile to the samples
+//	         of matching slices, named as "name(location)"
+//	debug:   if nonzero, write a textual summary of the profile by slice
+//
+// Go does not label the samples of heap profiles, and so they may not
+// be filtered or summarized by slice.
+func (s *Session) handleProfile(w http.ResponseWriter, r *http.Request) {
+	var exec profileExecutor
+	for _, executor := range unwrapExecutors(s.executor) {
+		if p, ok := executor.(profileExecutor); ok {
+			exec = p
+			break
+		}
+	}
+	if exec == nil {
+		profileErrorf(w, http.StatusNotImplemented, "executor does not support profiling")
+		return
+	}
+	which := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if which == "" {
+		profileErrorf(w, http.StatusNotFound, "no profile specified; use e.g. /debug/bigslice/pprof/profile")
+		return
+	}
+	sec, _ := strconv.Atoi(r.FormValue("seconds"))
+	if sec <= 0 {
+		sec = 30
+	}
+	gc, _ := strconv.Atoi(r.FormValue("gc"))
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	var re *regexp.Regexp
+	if expr := r.FormValue("slice"); expr != "" {
+		var err error
+		if re, err = regexp.Compile(expr); err != nil {
+			profileErrorf(w, http.StatusBadRequest, "invalid slice expression: %v", err)
+			return
+		}
+	}
+	if (re != nil || debug > 0) && (which == "heap" || which == "allocs") {
+		profileErrorf(w, http.StatusBadRequest, "%s profiles are not labeled by slice", which)
+		return
+	}
+	profiles, err := exec.profiles(r.Context(), which, time.Duration(sec)*time.Second, gc > 0)
+	if err != nil {
+		profileErrorf(w, http.StatusInternalServerError, "failed to collect %s profiles: %v", which, err)
+		return
+	}
+	if len(profiles) == 0 {
+		profileErrorf(w, http.StatusNotFound, "no profiles are available at this time")
+		return
+	}
+	prof, err := profile.Merge(profiles)
+	if err != nil {
+		profileErrorf(w, http.StatusInternalServerError, "failed to merge %s profiles: %v", which, err)
+		return
+	}
+	if re != nil {
+		prof = filterProfile(prof, re)
+	}
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = writeProfileSummary(w, prof)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", which))
+		err = prof.Write(w)
+	}
+	if err != nil {
+		log.Error.Printf("exec.Session: /debug/bigslice/pprof/%s: %v", which, err)
+	}
+}
+
+func profileErrorf(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Go-Pprof", "1")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, format, args...)
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/grailbio/bigslice"
+)
+
+func testProfile() *profile.Profile {
+	fn := &profile.Function{ID: 1, Name: "f"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	sample := func(value int64, slice string) *profile.Sample {
+		s := &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{value}}
+		if slice != "" {
+			s.Label = map[string][]string{sliceLabel: {slice}}
+		}
+		return s
+	}
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			sample(1, "map(a.go:1)"),
+			sample(3, "reduce(a.go:2)"),
+			sample(2, "map(a.go:1)"),
+			sample(4, ""),
+		},
+		Location: []*profile.Location{loc},
+		Function: []*profile.Function{fn},
+	}
+}
+
+func TestProfileSummary(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeProfileSummary(&b, testProfile()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if got, want := len(lines), 4; got != want {
+		t.Fatalf("got %v, want %v:\n%s", got, want, b.String())
+	}
+	for i, want := range []string{"samples/count", "(none)", "map(a.go:1)", "reduce(a.go:2)"} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("line %d: got %q, want %q", i, lines[i], want)
+		}
+	}
+	// Ties are broken by name.
+	if !strings.HasPrefix(lines[2], "3 ") || !strings.Contains(lines[2], "30.0%") {
+		t.Errorf("unexpected line %q", lines[2])
+	}
+}
+
+func TestProfileFilter(t *testing.T) {
+	p := filterProfile(testProfile(), regexp.MustCompile(`^map\(`))
+	var total int64
+	for _, sample := range p.Sample {
+		if got, want := sample.Label[sliceLabel][0], "map(a.go:1)"; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		total += sample.Value[0]
+	}
+	if got, want := total, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestProfileHandler(t *testing.T) {
+	f := bigslice.Func(func() bigslice.Slice {
+		return bigslice.Const(3, rangeSlice(0, 100))
+	})
+	testSession(t, func(t *testing.T, sess *Session) {
+		sess.Must(context.Background(), f)
+		mux := http.NewServeMux()
+		mux.Handle("/debug/bigslice/pprof/", http.HandlerFunc(sess.handleProfile))
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		get := func(path string) (int, []byte) {
+			t.Helper()
+			resp, err := http.Get(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var b bytes.Buffer
+			if _, err := b.ReadFrom(resp.Body); err != nil {
+				t.Fatal(err)
+			}
+			return resp.StatusCode, b.Bytes()
+		}
+
+		code, body := get("/debug/bigslice/pprof/goroutine")
+		if got, want := code, http.StatusOK; got != want {
+			t.Fatalf("got %v, want %v: %s", got, want, body)
+		}
+		p, err := profile.Parse(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Sample) == 0 {
+			t.Error("empty goroutine profile")
+		}
+
+		code, body = get("/debug/bigslice/pprof/goroutine?debug=1&slice=nonexistent")
+		if got, want := code, http.StatusOK; got != want {
+			t.Fatalf("got %v, want %v: %s", got, want, body)
+		}
+		if got, want := strings.TrimSpace(string(body)), "goroutine/count  percent  slice"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+
+		code, body = get("/debug/bigslice/pprof/heap?slice=.")
+		if got, want := code, http.StatusBadRequest; got != want {
+			t.Errorf("got %v, want %v: %s", got, want, body)
+		}
+		code, _ = get("/debug/bigslice/pprof/nonexistent")
+		if code == http.StatusOK {
+			t.Error("expected error for nonexistent profile")
+		}
+	})
+}
@@ -8,8 +8,11 @@ import (
 	"context"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"runtime"
+	"sort"
 	"sync"
 
 	"github.com/grailbio/base/backgroundcontext"
@@ -18,6 +21,7 @@ import (
 	"github.com/grailbio/bigmachine"
 	"github.com/grailbio/bigslice"
 	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/stats"
 	"github.com/grailbio/bigslice/typecheck"
 )
 
@@ -66,8 +70,33 @@ type Session struct {
 	status   *status.Status
 
 	machineCombiners bool
+	errorPolicy      bigslice.ErrorPolicy
 
-	tracer *tracer
+	explainWriter io.Writer
+	explainFormat string
+	explainDone   func()
+	explained     bool
+
+	tracer      *tracer
+	traceFile   string
+	traceEvents int
+
+	// taskMetrics maintains the running task counts that are
+	// exported by /debug/metrics.
+	taskMetrics *taskMetrics
+	// dashboard shares the dashboard's state among the clients of
+	// /debug/tasks/events.
+	dashboard dashboardHub
+
+	eventLog     *eventLog
+	eventLogFile string
+
+	// observer reports the state changes of the session's tasks to
+	// taskMetrics and eventLog.
+	observer *taskObserver
+
+	captureDir     string
+	captureRecords int
 
 	mu sync.Mutex
 	// roots stores all task roots compiled by this session;
@@ -77,8 +106,9 @@ type Session struct {
 
 func newSession() *Session {
 	return &Session{
-		Context: backgroundcontext.Get(),
-		roots:   make(map[*Task]struct{}),
+		Context:     backgroundcontext.Get(),
+		roots:       make(map[*Task]struct{}),
+		taskMetrics: newTaskMetrics(),
 	}
 }
 
@@ -99,6 +129,49 @@ func Bigmachine(system bigmachine.System, params ...bigmachine.Param) Option {
 	}
 }
 
+// LocalProcesses configures a session to run its tasks in up to n
+// worker processes on the local machine. Workers are created by
+// re-executing the current binary, and each runs one task at a time.
+// As with any bigmachine executor, task inputs, outputs, and
+// invocation arguments are serialized between processes, and a
+// worker's failure is handled as the loss of a machine. This makes
+// LocalProcesses useful for testing computations with the same
+// serialization, shuffle, and failure semantics as a cluster, which
+// the Local executor does not provide.
+//
+// Because workers re-execute the binary, the session must be started
+// before any other work is done, for example at the beginning of
+// main, or in TestMain for tests:
+//
+//	var sess *exec.Session
+//
+//	func TestMain(m *testing.M) {
+//		sess = exec.Start(exec.LocalProcesses(4))
+//		os.Exit(m.Run())
+//	}
+//
+// In worker processes, Start does not return.
+func LocalProcesses(n int) Option {
+	if n <= 0 {
+		panic("exec.LocalProcesses: n <= 0")
+	}
+	return func(s *Session) {
+		s.executor = newBigmachineExecutor(bigmachine.Local)
+		s.p = n
+	}
+}
+
+// CustomExecutor configures a session to run its tasks with the
+// provided executor. Executors may be implemented from scratch, or
+// by wrapping the executors returned by NewLocalExecutor or
+// NewBigmachineExecutor. See Executor for the contract that
+// implementations must satisfy.
+func CustomExecutor(executor Executor) Option {
+	return func(s *Session) {
+		s.executor = executor
+	}
+}
+
 // Parallelism configures the session with the provided target
 // parallelism.
 func Parallelism(p int) Option {
@@ -141,6 +214,88 @@ var MachineCombiners Option = func(s *Session) {
 	s.machineCombiners = true
 }
 
+// ErrorPolicy configures the session's policy for errors returned by
+// user functions for individual rows. By default, such errors fail
+// the computation. Rows that are skipped because of errors are
+// reported by Result.SkippedRows and Result.DeadLetters.
+func ErrorPolicy(policy bigslice.ErrorPolicy) Option {
+	return func(s *Session) {
+		s.errorPolicy = policy
+	}
+}
+
+// ExplainOnly configures a session to explain, instead of run, the
+// computations submitted to it. The first call to Run writes the
+// physical plan (see Session.Explain) of its computation to w in the
+// provided format ("text", "json", or "dot"), calls done, if it is
+// non-nil, and returns ErrExplained. Later calls to Run return
+// ErrExplained without writing a plan: later computations usually
+// depend on the results of earlier ones, and so cannot be explained
+// without running them. Done allows the program to decide what
+// to do once the plan is written; for example, sliceconfig uses it
+// to exit.
+func ExplainOnly(w io.Writer, format string, done func()) Option {
+	return func(s *Session) {
+		s.explainWriter = w
+		s.explainFormat = format
+		s.explainDone = done
+	}
+}
+
+// TraceFile configures the session to stream its trace events to
+// the file at the provided path as they occur. The file contains
+// events in Chrome's tracing format (see chrome://tracing). Because
+// events are written incrementally, the trace is usable even if the
+// process fails; the file is finalized by Session.Shutdown.
+func TraceFile(path string) Option {
+	return func(s *Session) {
+		s.traceFile = path
+	}
+}
+
+// TraceRetention configures the maximum number of trace events that
+// the session retains in memory for /debug/trace; the events of the
+// oldest tasks and invocations are discarded first. If n is
+// negative, events are retained without bound. By default, 100000
+// events are retained. TraceRetention does not affect the events
+// written to a trace file.
+func TraceRetention(n int) Option {
+	return func(s *Session) {
+		s.traceEvents = n
+	}
+}
+
+// EventLog configures the session to append a durable log of its
+// events to the file at the provided path. The log records each task
+// state transition, together with the task's machine, run attempt,
+// error, and record counts, as well as the lifecycle of invocations
+// and machines. Events are written as JSON lines (see Event) as they
+// occur, so that the log may be used to analyze a failed computation
+// after the fact; see ReadEvents and "bigslice log".
+func EventLog(path string) Option {
+	return func(s *Session) {
+		s.eventLogFile = path
+	}
+}
+
+// CaptureFailures configures the session to capture the inputs of
+// tasks that fail, so that they may be reproduced in-process with
+// slicetest.ReplayTask. Each failed task is captured to the directory
+// dir/{task name}, which may be any path supported by package
+// github.com/grailbio/base/file (e.g., an S3 prefix), since workers
+// write their captures directly. At most maxRecords records are
+// captured from each of the task's input partitions; if maxRecords
+// is zero, inputs are captured in full.
+func CaptureFailures(dir string, maxRecords int) Option {
+	if maxRecords < 0 {
+		panic("exec.CaptureFailures: maxRecords < 0")
+	}
+	return func(s *Session) {
+		s.captureDir = dir
+		s.captureRecords = maxRecords
+	}
+}
+
 // Start creates and starts a new bigslice session, configuring it
 // according to the provided options. Only one session may be created
 // in a single binary invocation. The returned session remains valid for
@@ -184,7 +339,32 @@ func (s *Session) Must(ctx context.Context, funcv *bigslice.FuncValue, args ...i
 
 func (s *Session) start() {
 	s.shutdown = s.executor.Start(s)
-	s.tracer = newTracer()
+	switch {
+	case s.traceEvents == 0:
+		s.tracer = newTracer(defaultTraceEvents)
+	case s.traceEvents < 0:
+		s.tracer = newTracer(0)
+	default:
+		s.tracer = newTracer(s.traceEvents)
+	}
+	if s.traceFile != "" {
+		f, err := os.Create(s.traceFile)
+		if err == nil {
+			err = s.tracer.Stream(f)
+		}
+		if err != nil {
+			log.Error.Printf("exec.Session: trace file %s: %v", s.traceFile, err)
+		}
+	}
+	if s.eventLogFile != "" {
+		f, err := os.OpenFile(s.eventLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			log.Error.Printf("exec.Session: event log %s: %v", s.eventLogFile, err)
+		} else {
+			s.eventLog = newEventLog(f)
+		}
+	}
+	s.observer = newTaskObserver(s.taskMetrics, s.eventLog)
 }
 
 // statusMu is used to prevent interleaving of slice and task status groups.
@@ -192,25 +372,65 @@ func (s *Session) start() {
 // consistent.
 var statusMu sync.Mutex
 
-func (s *Session) run(ctx context.Context, calldepth int, funcv *bigslice.FuncValue, args ...interface{}) (*Result, error) {
+// compile invokes the bigslice func funcv with the provided arguments
+// and compiles the returned slice. The invocation's location is that
+// of the caller at the provided call depth.
+func (s *Session) compile(calldepth int, funcv *bigslice.FuncValue, args ...interface{}) (bigslice.Invocation, bigslice.Slice, []*Task, error) {
+	return s.compileInvocation(calldepth+1, funcv.Invocation, args...)
+}
+
+// CompileInvocation is like compile, but creates the invocation
+// with the provided function, one of funcv.Invocation or
+// funcv.PeekInvocation.
+func (s *Session) compileInvocation(calldepth int, invocation func(string, ...interface{}) bigslice.Invocation, args ...interface{}) (bigslice.Invocation, bigslice.Slice, []*Task, error) {
 	location := "<unknown>"
 	if _, file, line, ok := runtime.Caller(calldepth + 1); ok {
 		location = fmt.Sprintf("%s:%d", file, line)
 		defer typecheck.Location(file, line)
 	}
+	inv := invocation(location, args...)
+	slice := inv.Invoke()
+	tasks, err := compile(slice, inv, s.machineCombiners)
+	return inv, slice, tasks, err
+}
+
+// Explain writes the plan of the provided tasks, compiled from the
+// invocation inv, if no plan has yet been written by the session,
+// as configured by ExplainOnly.
+func (s *Session) explain(inv bigslice.Invocation, tasks []*Task) error {
+	s.mu.Lock()
+	explained := s.explained
+	s.explained = true
+	s.mu.Unlock()
+	if explained {
+		return ErrExplained
+	}
+	if err := explain(inv, tasks).Write(s.explainWriter, s.explainFormat); err != nil {
+		return err
+	}
+	if s.explainDone != nil {
+		s.explainDone()
+	}
+	return ErrExplained
+}
+
+func (s *Session) run(ctx context.Context, calldepth int, funcv *bigslice.FuncValue, args ...interface{}) (*Result, error) {
 	// Make invocation and status setup atomic so that status displays in
 	// invocation index order.
 	//
 	// TODO(jcharumilind): Add functionality to status package to control
 	// ordering.
 	statusMu.Lock()
-	inv := funcv.Invocation(location, args...)
-	slice := inv.Invoke()
-	tasks, err := compile(slice, inv, s.machineCombiners)
+	inv, slice, tasks, err := s.compile(calldepth+1, funcv, args...)
 	if err != nil {
 		statusMu.Unlock()
 		return nil, err
 	}
+	if s.explainWriter != nil {
+		statusMu.Unlock()
+		return nil, s.explain(inv, tasks)
+	}
+	location := inv.Location
 	// TODO(marius): give a way to provide names for these groups
 	var taskGroup *status.Group
 	if s.status != nil {
@@ -234,12 +454,23 @@ func (s *Session) run(ctx context.Context, calldepth int, funcv *bigslice.FuncVa
 		s.roots[task] = struct{}{}
 	}
 	s.mu.Unlock()
+	s.observer.add(inv.Index, tasks)
+	s.eventLog.invocation(inv.Index, location, "start", nil)
+	err = Eval(ctx, s.executor, inv, tasks, taskGroup)
+	// Report the tasks' final states before the invocation's
+	// completion.
+	s.observer.finish(inv.Index)
+	state := "ok"
+	if err != nil {
+		state = "error"
+	}
+	s.eventLog.invocation(inv.Index, location, state, err)
 	return &Result{
 		Slice: slice,
 		sess:  s,
 		inv:   inv,
 		tasks: tasks,
-	}, Eval(ctx, s.executor, inv, tasks, taskGroup)
+	}, err
 }
 
 // Parallelism returns the desired amount of evaluation parallelism.
@@ -252,12 +483,31 @@ func (s *Session) MaxLoad() float64 {
 	return s.maxLoad
 }
 
+// ErrorPolicy returns the session's policy for errors returned by
+// user functions for individual rows.
+func (s *Session) ErrorPolicy() bigslice.ErrorPolicy {
+	return s.errorPolicy
+}
+
+// MachineCombiners tells whether the session is configured with
+// machine-local combine buffers.
+func (s *Session) MachineCombiners() bool {
+	return s.machineCombiners
+}
+
 // Shutdown tears down resources associated with this session.
 // It should be called when the session is discarded.
 func (s *Session) Shutdown() {
 	if s.shutdown != nil {
 		s.shutdown()
 	}
+	if err := s.tracer.Close(); err != nil {
+		log.Error.Printf("exec.Session: trace file %s: %v", s.traceFile, err)
+	}
+	s.observer.stop()
+	if err := s.eventLog.Close(); err != nil {
+		log.Error.Printf("exec.Session: event log %s: %v", s.eventLogFile, err)
+	}
 }
 
 // Status returns the session's status aggregator.
@@ -270,6 +520,10 @@ func (s *Session) HandleDebug(handler *http.ServeMux) {
 	handler.Handle("/debug", http.HandlerFunc(s.handleDebug))
 	handler.Handle("/debug/tasks/graph", http.HandlerFunc(s.handleTasksGraph))
 	handler.Handle("/debug/tasks", http.HandlerFunc(s.handleTasks))
+	handler.Handle("/debug/tasks/state", http.HandlerFunc(s.handleDashboardState))
+	handler.Handle("/debug/tasks/events", http.HandlerFunc(s.handleDashboardEvents))
+	handler.Handle("/debug/metrics", http.HandlerFunc(s.handleMetrics))
+	handler.Handle("/debug/bigslice/pprof/", http.HandlerFunc(s.handleProfile))
 	if s.tracer != nil {
 		handler.HandleFunc("/debug/trace", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add("content-type", "application/json; charset=utf-8")
@@ -290,6 +544,75 @@ type Result struct {
 	tasks []*Task
 }
 
+// SkippedRows returns the number of rows that were skipped, in all
+// of the tasks of the computation, because of errors returned by user
+// functions. Rows are skipped only if the session is configured with
+// an error policy other than bigslice.FailOnError.
+func (r *Result) SkippedRows() int64 {
+	var skipped int64
+	for _, task := range r.all() {
+		n, _ := task.RowErrors()
+		skipped += n
+	}
+	return skipped
+}
+
+// DeadLetters returns the rows that were rejected, together with
+// their errors, by user functions in all of the tasks of the
+// computation, grouped by the slice whose user function rejected
+// them. Dead letters are written only if the session is configured
+// with the bigslice.DeadLetterOnError policy.
+func (r *Result) DeadLetters() []*DeadLetters {
+	return deadLetters(r.sess, r.all())
+}
+
+// Counters returns the values of the user-defined counters (see
+// bigslice.Counter) of the result's computation, aggregated across
+// all of its tasks and slices.
+func (r *Result) Counters() stats.Values {
+	vals := make(stats.Values)
+	for _, counters := range r.SliceCounters() {
+		for k, v := range counters {
+			vals[k] += v
+		}
+	}
+	return vals
+}
+
+// SliceCounters returns the values of the user-defined counters of
+// the result's computation, aggregated across all of its tasks, and
+// keyed by the slice whose user functions incremented them.
+func (r *Result) SliceCounters() map[bigslice.Name]stats.Values {
+	vals := make(map[bigslice.Name]stats.Values)
+	for _, task := range r.all() {
+		for slice, counters := range task.Counters() {
+			if vals[slice] == nil {
+				vals[slice] = make(stats.Values)
+			}
+			for k, v := range counters {
+				vals[slice][k] += v
+			}
+		}
+	}
+	return vals
+}
+
+// All returns all of the tasks of the result's computation.
+func (r *Result) all() []*Task {
+	all := make(map[*Task]bool)
+	for _, task := range r.tasks {
+		task.all(all)
+	}
+	tasks := make([]*Task, 0, len(all))
+	for task := range all {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Name.String() < tasks[j].Name.String()
+	})
+	return tasks
+}
+
 // Scan returns a scanner that scans the output. If the output
 // contains multiple shards, they are scanned sequentially.
 func (r *Result) Scan(ctx context.Context) *sliceio.Scanner {
@@ -6,7 +6,10 @@ package exec
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -16,6 +19,8 @@ import (
 	"github.com/grailbio/bigslice"
 	"github.com/grailbio/bigslice/frame"
 	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/stats"
 )
 
 func init() {
@@ -159,11 +164,302 @@ func TestSessionReuse(t *testing.T) {
 	})
 }
 
+func TestSessionShared(t *testing.T) {
+	const N = 1000
+	var nmap int64
+	shared := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(5, rangeSlice(0, N))
+		slice = bigslice.Map(slice, func(i int) (int, int) {
+			atomic.AddInt64(&nmap, 1)
+			return i % 10, 1
+		})
+		// The shared slice is consumed by a shuffle dependency before it
+		// is consumed by a non-shuffle dependency.
+		reduced := bigslice.Reduce(slice, func(a, e int) int { return a + e })
+		mapped := bigslice.Map(slice, func(k, v int) (int, int) { return k, v })
+		slice = bigslice.Cogroup(reduced, mapped)
+		return bigslice.Map(slice, func(k int, sums, vs []int) (int, int, int) {
+			return k, sums[0], len(vs)
+		})
+	})
+	ctx := context.Background()
+	testSession(t, func(t *testing.T, sess *Session) {
+		atomic.StoreInt64(&nmap, 0)
+		res := sess.Must(ctx, shared)
+		if got, want := atomic.LoadInt64(&nmap), int64(N); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		var (
+			f     = readFrame(t, res, 10)
+			sums  = f.Interface(1).([]int)
+			count = f.Interface(2).([]int)
+		)
+		for i := range sums {
+			if got, want := sums[i], N/10; got != want {
+				t.Errorf("index %d: got %v, want %v", i, got, want)
+			}
+			if got, want := count[i], N/10; got != want {
+				t.Errorf("index %d: got %v, want %v", i, got, want)
+			}
+		}
+	})
+}
+
+func TestSessionSharedSelfJoin(t *testing.T) {
+	const N = 1000
+	var nmap int64
+	join := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(5, rangeSlice(0, N))
+		slice = bigslice.Map(slice, func(i int) (int, int) {
+			atomic.AddInt64(&nmap, 1)
+			return i % 10, i
+		})
+		slice = bigslice.Cogroup(slice, slice)
+		return bigslice.Map(slice, func(k int, lhs, rhs []int) (int, int, int) {
+			return k, len(lhs), len(rhs)
+		})
+	})
+	ctx := context.Background()
+	testSession(t, func(t *testing.T, sess *Session) {
+		atomic.StoreInt64(&nmap, 0)
+		res := sess.Must(ctx, join)
+		if got, want := atomic.LoadInt64(&nmap), int64(N); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		var (
+			f   = readFrame(t, res, 10)
+			lhs = f.Interface(1).([]int)
+			rhs = f.Interface(2).([]int)
+		)
+		for i := range lhs {
+			if got, want := lhs[i], N/10; got != want {
+				t.Errorf("index %d: got %v, want %v", i, got, want)
+			}
+			if got, want := rhs[i], N/10; got != want {
+				t.Errorf("index %d: got %v, want %v", i, got, want)
+			}
+		}
+	})
+}
+
+func TestSessionErrorPolicy(t *testing.T) {
+	const N = 100
+	parse := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(4, rangeSlice(0, N))
+		// Reject multiples of 10 in the map, and of 7 in the filter.
+		slice = bigslice.Map(slice, func(i int) (int, error) {
+			if i%10 == 0 {
+				return 0, fmt.Errorf("bad row %d", i)
+			}
+			return i, nil
+		})
+		return bigslice.Filter(slice, func(i int) (bool, error) {
+			if i%7 == 0 {
+				return false, fmt.Errorf("bad row %d", i)
+			}
+			return true, nil
+		})
+	})
+	var (
+		ctx     = context.Background()
+		want    []int
+		letters []string
+	)
+	for i := 0; i < N; i++ {
+		switch {
+		case i%10 == 0:
+			letters = append(letters, fmt.Sprint(i))
+		case i%7 == 0:
+			letters = append(letters, fmt.Sprint(i))
+		default:
+			want = append(want, i)
+		}
+	}
+	sort.Strings(letters)
+	for name, opt := range executors {
+		t.Run(name, func(t *testing.T) {
+			sess := Start(opt)
+			if _, err := sess.Run(ctx, parse); err == nil || !strings.Contains(err.Error(), "bad row") {
+				t.Errorf("got %v, want bad row error", err)
+			}
+
+			sess = Start(opt, ErrorPolicy(bigslice.SkipOnError))
+			res, err := sess.Run(ctx, parse)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := readFrame(t, res, len(want))
+			got := append([]int{}, f.Value(0).Interface().([]int)...)
+			sort.Ints(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if got, want := res.SkippedRows(), int64(len(letters)); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if got := res.DeadLetters(); len(got) != 0 {
+				t.Errorf("unexpected dead letters %v", got)
+			}
+
+			sess = Start(opt, ErrorPolicy(bigslice.DeadLetterOnError))
+			res, err = sess.Run(ctx, parse)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readFrame(t, res, len(want))
+			// The map and the filter each have their own dead letters,
+			// which retain the rejected rows' columns and their errors.
+			deadLetters := res.DeadLetters()
+			if got, want := len(deadLetters), 2; got != want {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			var rows []string
+			for _, d := range deadLetters {
+				if got, want := slicetype.String(d), "slice[1]int,string"; got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+				var (
+					scanner = d.Scan(ctx)
+					row     int
+					msg     string
+					n       int64
+				)
+				for scanner.Scan(ctx, &row, &msg) {
+					if got, want := msg, fmt.Sprintf("bad row %d", row); got != want {
+						t.Errorf("got %v, want %v", got, want)
+					}
+					rows = append(rows, fmt.Sprint(row))
+					n++
+				}
+				if err := scanner.Err(); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := d.Len(), n; got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			}
+			sort.Strings(rows)
+			if !reflect.DeepEqual(rows, letters) {
+				t.Errorf("got %v, want %v", rows, letters)
+			}
+			if got, want := res.SkippedRows(), int64(len(letters)); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSessionCounters(t *testing.T) {
+	const N = 100
+	var mapName, filterName bigslice.Name
+	count := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(4, rangeSlice(0, N))
+		slice = bigslice.Map(slice, func(ctx context.Context, i int) int {
+			bigslice.Counter(ctx, "rows").Add(1)
+			if i%2 == 1 {
+				bigslice.Counter(ctx, "odd").Add(1)
+			}
+			return i
+		})
+		mapName = slice.Name()
+		slice = bigslice.Filter(slice, func(ctx context.Context, i int) bool {
+			if i%10 == 0 {
+				bigslice.Counter(ctx, "rejected").Add(1)
+				return false
+			}
+			return true
+		})
+		filterName = slice.Name()
+		return slice
+	})
+	ctx := context.Background()
+	testSession(t, func(t *testing.T, sess *Session) {
+		res := sess.Must(ctx, count)
+		readFrame(t, res, N-N/10)
+		// Slice names are compared by their string representation, as
+		// the slices are also instantiated by the Bigmachine workers.
+		counters := make(map[string]stats.Values)
+		for name, vals := range res.SliceCounters() {
+			counters[name.String()] = vals
+		}
+		if got, want := counters[mapName.String()], (stats.Values{"rows": N, "odd": N / 2}); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := counters[filterName.String()], (stats.Values{"rejected": N / 10}); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := res.Counters(), (stats.Values{"rows": N, "odd": N / 2, "rejected": N / 10}); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+	// Counters are ignored outside of tasks.
+	bigslice.Counter(ctx, "rows").Add(1)
+}
+
 var executors = map[string]Option{
 	"Local":           Local,
 	"Bigmachine.Test": Bigmachine(testsystem.New()),
 }
 
+// CountingExecutor is an Executor that counts the tasks that are run
+// by its underlying executor.
+type countingExecutor struct {
+	Executor
+	n int64
+}
+
+func (c *countingExecutor) Run(task *Task) {
+	atomic.AddInt64(&c.n, 1)
+	c.Executor.Run(task)
+}
+
+func (c *countingExecutor) Unwrap() Executor {
+	return c.Executor
+}
+
+func TestSessionCustomExecutor(t *testing.T) {
+	f := bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(3, rangeSlice(0, 100))
+		slice = bigslice.Map(slice, func(i int) (int, int) { return i % 10, i })
+		return bigslice.Reduce(slice, func(a, e int) int { return a + e })
+	})
+	executors := map[string]Executor{
+		"Local":           NewLocalExecutor(),
+		"Bigmachine.Test": NewBigmachineExecutor(testsystem.New()),
+	}
+	for name, executor := range executors {
+		t.Run(name, func(t *testing.T) {
+			counting := &countingExecutor{Executor: executor}
+			sess := Start(CustomExecutor(counting), ErrorPolicy(bigslice.SkipOnError))
+			if got, want := sess.ErrorPolicy(), bigslice.SkipOnError; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			// The wrapped executor's profiles are available to the session.
+			var profiles bool
+			for _, executor := range unwrapExecutors(sess.executor) {
+				_, ok := executor.(profileExecutor)
+				profiles = profiles || ok
+			}
+			if !profiles {
+				t.Error("profiles are not available through the wrapping executor")
+			}
+			res := sess.Must(context.Background(), f)
+			// 3 map tasks and 3 reduce tasks.
+			if got, want := atomic.LoadInt64(&counting.n), int64(6); got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			f := readFrame(t, res, 10)
+			var sum int
+			for _, v := range f.Interface(1).([]int) {
+				sum += v
+			}
+			if got, want := sum, 99*100/2; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
 func testSession(t *testing.T, run func(t *testing.T, sess *Session)) {
 	t.Helper()
 	for name, opt := range executors {
@@ -81,10 +81,11 @@ type sliceMachine struct {
 	// It is used to mark tasks lost when a machine fails.
 	tasks []*Task
 
-	disk bigmachine.DiskInfo
-	mem  bigmachine.MemInfo
-	load bigmachine.LoadInfo
-	vals stats.Values
+	disk  bigmachine.DiskInfo
+	mem   bigmachine.MemInfo
+	load  bigmachine.LoadInfo
+	vals  stats.Values
+	dists stats.Distributions
 }
 
 func (s *sliceMachine) String() string {
@@ -127,14 +128,16 @@ loop:
 		tctx, cancel := context.WithTimeout(ctx, statTimeout)
 		g, gctx := errgroup.WithContext(tctx)
 		var (
-			mem  bigmachine.MemInfo
-			merr error
-			disk bigmachine.DiskInfo
-			derr error
-			load bigmachine.LoadInfo
-			lerr error
-			vals stats.Values
-			verr error
+			mem   bigmachine.MemInfo
+			merr  error
+			disk  bigmachine.DiskInfo
+			derr  error
+			load  bigmachine.LoadInfo
+			lerr  error
+			vals  stats.Values
+			verr  error
+			dists stats.Distributions
+			herr  error
 		)
 		g.Go(func() error {
 			mem, merr = s.Machine.MemInfo(gctx, false)
@@ -152,6 +155,10 @@ loop:
 			verr = s.Machine.Call(ctx, "Worker.Stats", struct{}{}, &vals)
 			return nil
 		})
+		g.Go(func() error {
+			herr = s.Machine.Call(ctx, "Worker.Distributions", struct{}{}, &dists)
+			return nil
+		})
 		_ = g.Wait()
 		cancel()
 		if merr != nil {
@@ -166,6 +173,9 @@ loop:
 		if verr != nil {
 			log.Printf("stats %s: %v", s.Machine.Addr, verr)
 		}
+		if herr != nil {
+			log.Printf("distributions %s: %v", s.Machine.Addr, herr)
+		}
 		s.mu.Lock()
 		if merr == nil {
 			s.mem = mem
@@ -179,6 +189,9 @@ loop:
 		if verr == nil {
 			s.vals = vals
 		}
+		if herr == nil {
+			s.dists = dists
+		}
 		s.mu.Unlock()
 		s.UpdateStatus()
 		select {
@@ -363,11 +376,27 @@ type machineManager struct {
 	maxp    int
 	maxLoad float64
 	worker  *worker
+	// eventLog, if not nil, records the lifecycle of the manager's
+	// machines.
+	eventLog *eventLog
 	// schedQ is the priority queue of scheduling requests, which determines the
 	// order in which requests are satisfied. See Offer.
 	schedQ   scheduleRequestQ
 	schedc   chan scheduleRequest
 	unschedc chan scheduleRequest
+
+	mu sync.Mutex
+	// all is the set of machines started by the manager, including
+	// those that have since been lost.
+	all []*sliceMachine
+}
+
+// Machines returns the machines started by the manager, including
+// those that have been lost.
+func (m *machineManager) Machines() []*sliceMachine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*sliceMachine(nil), m.all...)
 }
 
 // NewMachineManager returns a new machineManager paramterized by the
@@ -475,6 +504,7 @@ func (m *machineManager) Do(ctx context.Context) {
 			mach := probation[0]
 			mach.health = machineOk
 			log.Printf("removing machine %s from probation", mach.Addr)
+			m.eventLog.machine(mach.Addr, "ok", nil)
 			heap.Remove(&probation, 0)
 			heap.Push(&machines, mach)
 			probationTimer.Clear()
@@ -488,12 +518,14 @@ func (m *machineManager) Do(ctx context.Context) {
 				// originate from a transitive machine failure. The evaluator will reschedule
 				// these tasks for us.
 				log.Error.Printf("putting machine %s on probation after error: %v", mach, done.Err)
+				m.eventLog.machine(mach.Addr, "probation", done.Err)
 				mach.health = machineProbation
 				heap.Remove(&machines, mach.index)
 				mach.lastFailure = time.Now()
 				heap.Push(&probation, mach)
 			case done.Err == nil && mach.health == machineProbation:
 				log.Printf("machine %s returned successful result; removing probation", mach)
+				m.eventLog.machine(mach.Addr, "ok", nil)
 				mach.health = machineOk
 				heap.Remove(&probation, mach.index)
 				heap.Push(&machines, mach)
@@ -521,7 +553,11 @@ func (m *machineManager) Do(ctx context.Context) {
 			heap.Remove(&m.schedQ, s.index)
 		case result := <-startc:
 			pending -= machprocs * (len(result.machines) + result.nFailures)
+			m.mu.Lock()
+			m.all = append(m.all, result.machines...)
+			m.mu.Unlock()
 			for _, mach := range result.machines {
+				m.eventLog.machine(mach.Addr, "started", nil)
 				heap.Push(&machines, mach)
 				mach.donec = donec
 				go func(mach *sliceMachine) {
@@ -541,6 +577,7 @@ func (m *machineManager) Do(ctx context.Context) {
 			// Remove the machine from management. We let the sliceMachine
 			// instance deal with failing the tasks.
 			log.Error.Printf("machine %s stopped with error %s", mach, mach.Err())
+			m.eventLog.machine(mach.Addr, "lost", mach.Err())
 			switch mach.health {
 			case machineOk:
 				heap.Remove(&machines, mach.index)
@@ -6,9 +6,12 @@ package exec
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/grailbio/base/status"
 	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/stats"
 )
 
 // sliceStatus is the information directly used to print a slice's status (in a
@@ -19,6 +22,12 @@ type sliceStatus struct {
 	sliceName bigslice.Name
 	// counts is the count of tasks of the slice, by TaskState.
 	counts [maxState]int32
+	// runtimes is the distribution of the run times of the slice's
+	// completed tasks.
+	runtimes *stats.Timer
+	// counters is a summary of the values of the slice's user-defined
+	// counters, aggregated across its completed tasks.
+	counters string
 }
 
 // idleCount returns the number of tasks considered idle for status display.
@@ -29,16 +38,24 @@ func (s sliceStatus) idleCount() int32 {
 // printTo prints s to t, translating our slice status information to a
 // status.Task update.
 func (s sliceStatus) printTo(t *status.Task) {
+	var runtimes string
+	if d := s.runtimes.Distribution(); d.Count > 0 {
+		runtimes = fmt.Sprintf("; task time p50/p99: %s/%s",
+			d.Format(d.Quantile(0.5)), d.Format(d.Quantile(0.99)))
+	}
+	if s.counters != "" {
+		runtimes += "; counters " + s.counters
+	}
 	if s.counts[TaskLost] > 0 || s.counts[TaskErr] > 0 {
 		// Provide a more detailed view if there are tasks that are lost or in
 		// error.
-		t.Printf("tasks idle/running/done(lost)/error: %d/%d/%d(%d)/%d",
+		t.Printf("tasks idle/running/done(lost)/error: %d/%d/%d(%d)/%d%s",
 			s.idleCount(), s.counts[TaskRunning], s.counts[TaskOk],
-			s.counts[TaskLost], s.counts[TaskErr])
+			s.counts[TaskLost], s.counts[TaskErr], runtimes)
 		return
 	}
-	t.Printf("tasks idle/running/done: %d/%d/%d", s.idleCount(),
-		s.counts[TaskRunning], s.counts[TaskOk])
+	t.Printf("tasks idle/running/done: %d/%d/%d%s", s.idleCount(),
+		s.counts[TaskRunning], s.counts[TaskOk], runtimes)
 }
 
 // iterTasks calls f for each task in the full graph specified by tasks. It is
@@ -105,15 +122,60 @@ func monitorSliceStatus(ctx context.Context, tasks []*Task, group *status.Group,
 	sub := NewTaskSubscriber()
 	taskToLastState := make(map[*Task]TaskState)
 	sliceToStatus := make(map[bigslice.Name]sliceStatus)
+	// taskToCounters holds the counters of each task that was in
+	// TaskOk when it was last observed, and sliceToCounters their sum
+	// for each slice. Each observation replaces the counters of the
+	// task, so that the sums reflect exactly the tasks that are
+	// currently complete, with their current counters, however many
+	// transitions occur between observations.
+	taskToCounters := make(map[*Task]map[bigslice.Name]stats.Values)
+	sliceToCounters := make(map[bigslice.Name]stats.Values)
+	// addCounters adds the provided counters of a task, scaled by
+	// sign, to the counters of its slices.
+	addCounters := func(counters map[bigslice.Name]stats.Values, sign int64) {
+		for name, taskVals := range counters {
+			vals := sliceToCounters[name]
+			if vals == nil {
+				vals = make(stats.Values)
+				sliceToCounters[name] = vals
+			}
+			for k, v := range taskVals {
+				vals[k] += sign * v
+			}
+		}
+	}
+	// observeCounters replaces the counters of task, given its state.
+	observeCounters := func(task *Task, state TaskState) {
+		addCounters(taskToCounters[task], -1)
+		delete(taskToCounters, task)
+		if state == TaskOk {
+			counters := task.Counters()
+			taskToCounters[task] = counters
+			addCounters(counters, 1)
+		}
+	}
+	// sliceCounters returns the summary of the counters of the named
+	// slice.
+	sliceCounters := func(name bigslice.Name) string {
+		if vals := sliceToCounters[name]; len(vals) > 0 {
+			return vals.String()
+		}
+		return ""
+	}
 	iterTasks(tasks, func(t *Task) {
 		// Subscribe to updates before we grab the initial state so that we
 		// are guaranteed to see every subsequent update.
 		t.Subscribe(sub)
 		taskState := t.State()
 		taskToLastState[t] = taskState
+		observeCounters(t, taskState)
 		for _, s := range t.Slices {
 			status := sliceToStatus[s.Name()]
 			status.sliceName = s.Name()
+			if status.runtimes == nil {
+				status.runtimes = stats.NewTimer()
+			}
+			status.counters = sliceCounters(s.Name())
 			status.counts[taskState]++
 			sliceToStatus[s.Name()] = status
 			statusc <- status
@@ -131,10 +193,19 @@ func monitorSliceStatus(ctx context.Context, tasks []*Task, group *status.Group,
 			for _, task := range sub.Tasks() {
 				lastState := taskToLastState[task]
 				state := task.State()
+				var runtime time.Duration
+				if state == TaskOk && lastState != TaskOk {
+					runtime = task.Runtime()
+				}
+				observeCounters(task, state)
 				for _, s := range task.Slices {
 					status := sliceToStatus[s.Name()]
 					status.counts[lastState]--
 					status.counts[state]++
+					if runtime > 0 {
+						status.runtimes.Observe(runtime)
+					}
+					status.counters = sliceCounters(s.Name())
 					sliceToStatus[s.Name()] = status
 					statusc <- status
 				}
@@ -8,9 +8,11 @@ import (
 	"context"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/grailbio/base/status"
 	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/stats"
 )
 
 // sample returns a slice of task sets randomly chosen from tasks, without
@@ -178,3 +180,69 @@ func TestMonitorSliceStatus(t *testing.T) {
 		}
 	}
 }
+
+// TestSliceStatusRuntimes verifies that the run times of completed tasks,
+// and user-defined counters, are reported in slice status.
+func TestSliceStatusRuntimes(t *testing.T) {
+	task := &Task{Name: TaskName{Op: "test", NumShard: 1}}
+	task.Set(TaskRunning)
+	time.Sleep(10 * time.Millisecond)
+	task.Set(TaskOk)
+	if got, min := task.Runtime(), 10*time.Millisecond; got < min {
+		t.Errorf("got %v, want at least %v", got, min)
+	}
+	var s status.Status
+	statusTask := s.Group("slice status").Start("test")
+	sliceStatus := sliceStatus{runtimes: stats.NewTimer()}
+	sliceStatus.counts[TaskOk] = 3
+	sliceStatus.printTo(statusTask)
+	if got, want := statusTask.Value().Status, "tasks idle/running/done: 0/0/3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for i := 0; i < 3; i++ {
+		sliceStatus.runtimes.Observe(2 * time.Second)
+	}
+	sliceStatus.printTo(statusTask)
+	if got, want := statusTask.Value().Status, "tasks idle/running/done: 0/0/3; task time p50/p99: 2s/2s"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	sliceStatus.counters = stats.Values{"malformed": 2}.String()
+	sliceStatus.printTo(statusTask)
+	if got, want := statusTask.Value().Status, "tasks idle/running/done: 0/0/3; task time p50/p99: 2s/2s; counters malformed:2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestSliceStatusCounters verifies that the counters of a slice
+// reflect the current counters of its completed tasks, also when a
+// task is lost and recomputed between observations.
+func TestSliceStatusCounters(t *testing.T) {
+	slice := bigslice.Const(1, []int{})
+	task := &Task{Name: TaskName{Op: "test", NumShard: 1}, Slices: []bigslice.Slice{slice}}
+	task.SetCounters(map[bigslice.Name]stats.Values{slice.Name(): {"malformed": 1}})
+	task.Set(TaskOk)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var s status.Status
+	statusc := make(chan sliceStatus)
+	go monitorSliceStatus(ctx, []*Task{task}, s.Group("slice status"), statusc)
+	if got, want := (<-statusc).counters, "malformed:1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// The task is lost and recomputed while its lock is held, so that
+	// the monitor cannot observe it in TaskLost.
+	task.Lock()
+	task.state = TaskLost
+	task.Broadcast()
+	task.counters = map[bigslice.Name]stats.Values{slice.Name(): {"malformed": 5}}
+	task.state = TaskOk
+	task.Broadcast()
+	task.Unlock()
+	if got, want := (<-statusc).counters, "malformed:5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	task.Set(TaskLost)
+	if got, want := (<-statusc).counters, "malformed:0"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -7,7 +7,6 @@ package exec
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"reflect"
@@ -15,12 +14,16 @@ import (
 	"strings"
 	"sync"
 	"text/tabwriter"
+	"time"
 
+	"github.com/grailbio/base/errors"
 	"github.com/grailbio/base/status"
 	"github.com/grailbio/base/sync/ctxsync"
 	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/frame"
 	"github.com/grailbio/bigslice/sliceio"
 	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/stats"
 )
 
 func init() {
@@ -94,6 +97,12 @@ type TaskDep struct {
 	Head      *Task
 	Partition int
 
+	// Shuffle indicates that the dependency is a shuffle dependency:
+	// the task reads its partition from each of the tasks in Head's
+	// group. Other dependencies read only Head, even if Head is also
+	// shuffled to another task.
+	Shuffle bool
+
 	// Expand indicates that the task's dependencies for a given
 	// partition should not be merged, but rather passed individually to
 	// the task implementation.
@@ -166,6 +175,13 @@ type TaskSubscriber struct {
 	// tasks holds the set of tasks that has changed since the last call to
 	// Tasks.
 	tasks map[*Task]struct{}
+
+	// queue indicates that, instead of coalescing changed tasks in
+	// tasks, the subscriber queues in events each state transition as
+	// it is notified, together with the other events of a
+	// taskObserver.
+	queue  bool
+	events []taskEvent
 }
 
 // NewTaskSubscriber returns a new TaskSubscriber. It needs to be subscribed to
@@ -176,19 +192,42 @@ func NewTaskSubscriber() *TaskSubscriber {
 	return s
 }
 
-// Notify notifies s of a task whose state has changed.
+// Notify notifies s of a task whose state has changed. It is called
+// by Task.Broadcast, with the task's lock held.
 func (s *TaskSubscriber) Notify(task *Task) {
 	s.Lock()
 	defer s.Unlock()
-	s.tasks[task] = struct{}{}
+	if s.queue {
+		s.events = append(s.events, newTransitionEvent(task))
+	} else {
+		s.tasks[task] = struct{}{}
+	}
+	s.cond.Broadcast()
+}
+
+// Enqueue queues an event on s, which must queue events.
+func (s *TaskSubscriber) enqueue(event taskEvent) {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, event)
 	s.cond.Broadcast()
 }
 
+// Dequeue returns, in order, the events that were queued since the
+// last call to dequeue.
+func (s *TaskSubscriber) dequeue() []taskEvent {
+	s.Lock()
+	defer s.Unlock()
+	events := s.events
+	s.events = nil
+	return events
+}
+
 // Ready returns a channel that is closed if a subsequent call to Tasks will
 // return a non-nil slice.
 func (s *TaskSubscriber) Ready() <-chan struct{} {
 	s.Lock()
-	if len(s.tasks) > 0 {
+	if len(s.tasks) > 0 || len(s.events) > 0 {
 		s.Unlock()
 		return closedc
 	}
@@ -232,6 +271,20 @@ type Task struct {
 	// NumPartition is the number of partitions that are output by this task.
 	// If NumPartition > 1, then the task must also define a partitioner.
 	NumPartition int
+	// Partitioner is the (optional) partitioner used to assign rows to
+	// partitions. If nil, rows are partitioned by the hash of their
+	// prefix columns. Executors partition a run's output with the
+	// partitioner returned by NewPartitioner.
+	Partitioner bigslice.Partitioner
+	// newPartitioner, if non-nil, returns a new partitioner for each
+	// run of the task; it is used instead of Partitioner for
+	// partitioners that maintain per-run state.
+	newPartitioner func() bigslice.Partitioner
+	// PartitionColumns, if not nil, holds the columns of the task's
+	// output that are stored in each of its partitions: executors store
+	// only these columns of the rows that are assigned to a partition
+	// (see ProjectPartition). If nil, partitions store all columns.
+	PartitionColumns [][]int
 
 	// Combiner specifies an (optional) combiner to use for this task's output.
 	// If a Combiner is specified, CombineKey names the combine buffer used:
@@ -270,14 +323,205 @@ type Task struct {
 	// Err is defines when state == TaskErr.
 	err error
 
+	// start is the time at which the task last entered TaskRunning,
+	// runs the number of times it has entered TaskRunning, and
+	// runtime the duration of its last successful run. They are
+	// protected by the task's lock.
+	start   time.Time
+	runs    int
+	runtime time.Duration
+
 	// consecutiveLost is the number of times this task has been run and lost
 	// consecutively. See maxConsecutiveLost.
 	consecutiveLost int
 
+	// skipped is the number of rows rejected by user functions in the
+	// task's last successful run, and deadLetters describes the
+	// partitions in which that run stored its dead letters. They are
+	// protected by the task's lock.
+	skipped     int64
+	deadLetters []DeadLetterOutput
+	// counters are the values of the user-defined counters of the
+	// task's last successful run, keyed by slice. They are protected
+	// by the task's lock.
+	counters map[bigslice.Name]stats.Values
+
 	// Status is a status object to which task status is reported.
 	Status *status.Task
 }
 
+// A DeadLetterOutput describes the dead letters of a slice that are
+// stored with a task's output (see bigslice.DeadLetterOnError). Dead
+// letters are stored in partitions that follow the task's output
+// partitions: they are numbered from Task.NumPartition, and are read
+// with Executor.Reader.
+type DeadLetterOutput struct {
+	// Slice is the name of the slice whose user function rejected the
+	// rows.
+	Slice bigslice.Name
+	// Partition is the partition of the task in which the dead letters
+	// are stored.
+	Partition int
+	// Records is the number of dead letters.
+	Records int64
+}
+
+// PartitionType returns the type of the rows that are stored in the
+// provided partition of the task's output.
+func (t *Task) PartitionType(partition int) slicetype.Type {
+	if partition >= len(t.PartitionColumns) {
+		return t
+	}
+	cols := t.PartitionColumns[partition]
+	types := make([]reflect.Type, len(cols))
+	for i, col := range cols {
+		types[i] = t.Out(col)
+	}
+	return slicetype.New(types...)
+}
+
+// ProjectPartition returns the columns of frame f, which is of the
+// task's type, that are stored in the provided partition of the
+// task's output.
+func (t *Task) ProjectPartition(f frame.Frame, partition int) frame.Frame {
+	if partition >= len(t.PartitionColumns) {
+		return f
+	}
+	cols := t.PartitionColumns[partition]
+	vals := make([]reflect.Value, len(cols))
+	for i, col := range cols {
+		vals[i] = f.Value(col)
+	}
+	return frame.Values(vals)
+}
+
+// SetRowErrors records the rows that were rejected by user functions
+// in the task's last successful run: the number of rows that were
+// skipped, and the dead letters that were stored with the task's
+// output.
+func (t *Task) SetRowErrors(skipped int64, deadLetters []DeadLetterOutput) {
+	t.Lock()
+	t.skipped = skipped
+	t.deadLetters = deadLetters
+	t.Unlock()
+}
+
+// RowErrors returns the number of rows that were skipped because of
+// errors returned by user functions in the task's last successful
+// run, together with the dead letters that were stored with its
+// output.
+func (t *Task) RowErrors() (skipped int64, deadLetters []DeadLetterOutput) {
+	t.Lock()
+	defer t.Unlock()
+	return t.skipped, t.deadLetters
+}
+
+// Runtime returns the duration of the task's last successful run,
+// as observed by the executor.
+func (t *Task) Runtime() time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	return t.runtime
+}
+
+// SetCounters records the values of the user-defined counters of the
+// task's last successful run. Counters that are not attributed to one
+// of the task's slices, for example because they were incremented by
+// a reader that is not associated with a slice, are attributed to the
+// slice computed by the task.
+func (t *Task) SetCounters(counters map[bigslice.Name]stats.Values) {
+	if len(t.Slices) > 0 {
+		slices := make(map[bigslice.Name]bool)
+		for _, slice := range t.Slices {
+			slices[slice.Name()] = true
+		}
+		name := t.Slices[0].Name()
+		for slice, vals := range counters {
+			if slices[slice] {
+				continue
+			}
+			if counters[name] == nil {
+				counters[name] = make(stats.Values)
+			}
+			for k, v := range vals {
+				counters[name][k] += v
+			}
+			delete(counters, slice)
+		}
+	}
+	t.Lock()
+	t.counters = counters
+	t.Unlock()
+}
+
+// CounterList returns the task's counters as a list indexed by the
+// position of their slice in t.Slices. Counters are communicated
+// between processes in this form, since slice names are not
+// guaranteed to be identical across processes.
+func (t *Task) counterList() []stats.Values {
+	counters := t.Counters()
+	if len(counters) == 0 {
+		return nil
+	}
+	list := make([]stats.Values, len(t.Slices))
+	for i, slice := range t.Slices {
+		list[i] = counters[slice.Name()]
+	}
+	return list
+}
+
+// RowErrorList returns the task's row errors as RowErrors does, but
+// with the position, in the task's slices, of the slice of each of
+// the dead letters, since slice names are not the same across
+// processes.
+func (t *Task) rowErrorList() (skipped int64, deadLetters []DeadLetterOutput, slices []int) {
+	skipped, deadLetters = t.RowErrors()
+	for _, out := range deadLetters {
+		i := len(t.Slices)
+		for j, slice := range t.Slices {
+			if slice.Name() == out.Slice {
+				i = j
+				break
+			}
+		}
+		slices = append(slices, i)
+	}
+	return
+}
+
+// SetRowErrorList records the task's row errors from a list returned
+// by rowErrorList.
+func (t *Task) setRowErrorList(skipped int64, deadLetters []DeadLetterOutput, slices []int) {
+	outputs := make([]DeadLetterOutput, 0, len(deadLetters))
+	for i, out := range deadLetters {
+		if i < len(slices) && slices[i] < len(t.Slices) {
+			out.Slice = t.Slices[slices[i]].Name()
+			outputs = append(outputs, out)
+		}
+	}
+	t.SetRowErrors(skipped, outputs)
+}
+
+// SetCounterList records the task's counters from a list returned by
+// counterList.
+func (t *Task) setCounterList(list []stats.Values) {
+	counters := make(map[bigslice.Name]stats.Values)
+	for i, vals := range list {
+		if vals != nil && i < len(t.Slices) {
+			counters[t.Slices[i].Name()] = vals
+		}
+	}
+	t.SetCounters(counters)
+}
+
+// Counters returns the values of the user-defined counters of the
+// task's last successful run, keyed by slice.
+func (t *Task) Counters() map[bigslice.Name]stats.Values {
+	t.Lock()
+	defer t.Unlock()
+	return t.counters
+}
+
 // Phase returns the phase to which this task belongs.
 func (t *Task) Phase() []*Task {
 	if len(t.Group) == 0 {
@@ -295,6 +539,33 @@ func (t *Task) Head() *Task {
 	return t.Group[0]
 }
 
+// NewPartitioner returns a function that returns the partition of
+// row i of frame f, which must be an output of the task. The
+// returned function assigns partitions to the rows of a single run
+// of the task, in the order in which they are output: partitioners
+// may maintain per-run state, and so executors must call
+// NewPartitioner each time that the task is run. The returned
+// function returns a fatal error if the task's partitioner assigns
+// a row to a partition outside of [0, t.NumPartition).
+func (t *Task) NewPartitioner() func(f frame.Frame, i int) (int, error) {
+	partitioner := t.Partitioner
+	if t.newPartitioner != nil {
+		partitioner = t.newPartitioner()
+	}
+	if partitioner == nil {
+		return func(f frame.Frame, i int) (int, error) {
+			return int(f.Hash(i)) % t.NumPartition, nil
+		}
+	}
+	return func(f frame.Frame, i int) (int, error) {
+		p := partitioner(f, i, t.NumPartition)
+		if p < 0 || p >= t.NumPartition {
+			return 0, errors.E(errors.Fatal, fmt.Sprintf("task %s: partitioner returned partition %d; must be in [0, %d)", t.Name, p, t.NumPartition))
+		}
+		return p, nil
+	}
+}
+
 // String returns a short, human-readable string describing the
 // task's state.
 func (t *Task) String() string {
@@ -364,9 +635,20 @@ func (t *Task) State() TaskState {
 	return state
 }
 
-// Broadcast notifies waiters of a state change. Broadcast must only
-// be called while the task's lock is held.
+// Broadcast notifies waiters and subscribers of a state change.
+// Broadcast must only be called while the task's lock is held.
+// Broadcast also maintains the task's run time and run count.
 func (t *Task) Broadcast() {
+	switch t.state {
+	case TaskRunning:
+		t.start = time.Now()
+		t.runs++
+	case TaskOk:
+		if !t.start.IsZero() {
+			t.runtime = time.Since(t.start)
+			t.start = time.Time{}
+		}
+	}
 	if t.waitc != nil {
 		close(t.waitc)
 		t.waitc = nil
@@ -411,6 +693,12 @@ func (t *Task) WaitState(ctx context.Context, state TaskState) (TaskState, error
 func (t *Task) Subscribe(s *TaskSubscriber) {
 	t.Lock()
 	defer t.Unlock()
+	t.subscribe(s)
+}
+
+// Subscribe subscribes s to t, as Task.Subscribe does. It must be
+// called with the task's lock held.
+func (t *Task) subscribe(s *TaskSubscriber) {
 	for _, sub := range t.subs {
 		if s == sub {
 			// It is already registered.
@@ -5,6 +5,7 @@
 package exec
 
 import (
+	"container/list"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/grailbio/base/log"
 	"github.com/grailbio/bigslice"
 )
 
@@ -36,10 +38,18 @@ type traceEvent struct {
 // and individual task or invocation events are tracked by the machine
 // they are run on.
 //
-// Tracer does not assign "thread IDs" to trace events; rather events are
-// coalesced into "complete events" (X) at the time of rendering.
+// Each task or invocation that is in progress on a machine (i.e.,
+// whose "B" event has not yet been matched by an "E" event) is
+// assigned its own "thread ID" on that machine, so that the events of
+// concurrent tasks are not nested. Events are coalesced into
+// "complete events" (X) at the time of rendering.
 //
-// TODO(marius): garbage collection of old events.
+// The tracer retains a bounded number of events in memory: when the
+// bound is exceeded, the events of the least recently finished tasks
+// and invocations are discarded; the events of tasks and invocations
+// that are in progress are retained. Events may also be streamed to a
+// writer as they occur (see Stream), so that a complete trace
+// survives even a crashed process.
 type tracer struct {
 	mu sync.Mutex
 
@@ -47,18 +57,194 @@ type tracer struct {
 	taskEvents    map[*Task][]traceEvent
 	compileEvents map[uint64][]traceEvent
 
+	// finished holds the subjects (*Task or invocation index) of
+	// retained events that are not in progress, in the order of their
+	// last event, so that the oldest may be discarded in constant
+	// time; finishedElems indexes its elements by subject. nevents is
+	// the number of retained task and invocation events, which is
+	// bounded by maxEvents.
+	finished      *list.List
+	finishedElems map[interface{}]*list.Element
+	nevents       int
+	maxEvents     int
+
+	// stream, if not nil, receives each event as it is logged, in
+	// Chrome's JSON array format; nstream is the number of events
+	// written.
+	stream  io.Writer
+	nstream int
+
 	machinePids map[*sliceMachine]int
 
+	// tids holds the thread IDs that are assigned to in-progress
+	// spans on each pid, and spans holds the thread ID of each
+	// in-progress span. inProgress counts the in-progress spans of
+	// each subject.
+	tids       map[int]map[int]bool
+	spans      map[traceSpan]int
+	inProgress map[interface{}]int
+
 	// firstEvent is used to store the time of the first observed
 	// event so that the offsets in the trace are meaningful.
 	firstEvent time.Time
 }
 
-func newTracer() *tracer {
+// A traceSpan identifies the events of a subject (a *Task or an
+// invocation index) on a pid.
+type traceSpan struct {
+	pid     int
+	subject interface{}
+}
+
+// defaultTraceEvents is the default number of trace events retained
+// in memory by a session.
+const defaultTraceEvents = 100000
+
+// newTracer returns a new tracer that retains at most maxEvents task
+// and invocation events. If maxEvents is zero, events are retained
+// without bound.
+func newTracer(maxEvents int) *tracer {
 	return &tracer{
 		taskEvents:    make(map[*Task][]traceEvent),
 		compileEvents: make(map[uint64][]traceEvent),
 		machinePids:   make(map[*sliceMachine]int),
+		tids:          make(map[int]map[int]bool),
+		spans:         make(map[traceSpan]int),
+		inProgress:    make(map[interface{}]int),
+		finished:      list.New(),
+		finishedElems: make(map[interface{}]*list.Element),
+		maxEvents:     maxEvents,
+	}
+}
+
+// Stream writes all subsequent events to w as they are logged. The
+// events are written as an (unterminated) JSON array of events in
+// Chrome's tracing format, which Chrome accepts as is. Close
+// terminates the array. Streamed events are not coalesced; their
+// thread IDs keep concurrent spans apart.
+func (t *tracer) Stream(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stream = w
+	t.nstream = 0
+	_, err := io.WriteString(w, "[")
+	return err
+}
+
+// Close terminates the stream of events, if any, and closes its
+// writer if it is an io.Closer.
+func (t *tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stream == nil {
+		return nil
+	}
+	_, err := io.WriteString(t.stream, "\n]\n")
+	if closer, ok := t.stream.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	t.stream = nil
+	return err
+}
+
+// write streams the provided event. It must be called with t.mu held.
+func (t *tracer) write(event traceEvent) {
+	if t.stream == nil {
+		return
+	}
+	b, err := json.Marshal(event)
+	if err == nil {
+		sep := ",\n"
+		if t.nstream == 0 {
+			sep = "\n"
+		}
+		_, err = io.WriteString(t.stream, sep+string(b))
+	}
+	if err != nil {
+		log.Error.Printf("tracer: stopped streaming events: %v", err)
+		t.stream = nil
+		return
+	}
+	t.nstream++
+}
+
+// retain records that an event was retained for the provided
+// subject, and then discards the events of the least recently
+// finished subjects, other than the provided one, until at most
+// maxEvents are retained. It must be called with t.mu held.
+func (t *tracer) retain(subject interface{}) {
+	t.nevents++
+	elem, finished := t.finishedElems[subject]
+	switch {
+	case t.inProgress[subject] > 0:
+		if finished {
+			t.finished.Remove(elem)
+			delete(t.finishedElems, subject)
+		}
+	case finished:
+		t.finished.MoveToBack(elem)
+	default:
+		t.finishedElems[subject] = t.finished.PushBack(subject)
+	}
+	for t.maxEvents > 0 && t.nevents > t.maxEvents {
+		front := t.finished.Front()
+		if front == nil || front.Value == subject {
+			break
+		}
+		old := t.finished.Remove(front)
+		delete(t.finishedElems, old)
+		switch arg := old.(type) {
+		case *Task:
+			t.nevents -= len(t.taskEvents[arg])
+			delete(t.taskEvents, arg)
+		case uint64:
+			t.nevents -= len(t.compileEvents[arg])
+			delete(t.compileEvents, arg)
+		}
+	}
+}
+
+// tid returns the thread ID of an event of type ph for the provided
+// span: "B" events are assigned the lowest thread ID that is not in
+// use by another in-progress span on the same pid, which is released
+// by the span's "E" event. It must be called with t.mu held.
+func (t *tracer) tid(span traceSpan, ph string) int {
+	switch ph {
+	case "B":
+		if tid, ok := t.spans[span]; ok {
+			return tid
+		}
+		tids := t.tids[span.pid]
+		if tids == nil {
+			tids = make(map[int]bool)
+			t.tids[span.pid] = tids
+		}
+		tid := 1
+		for tids[tid] {
+			tid++
+		}
+		tids[tid] = true
+		t.spans[span] = tid
+		t.inProgress[span.subject]++
+		return tid
+	case "E":
+		tid, ok := t.spans[span]
+		if !ok {
+			return 1
+		}
+		delete(t.tids[span.pid], tid)
+		delete(t.spans, span)
+		if t.inProgress[span.subject]--; t.inProgress[span.subject] == 0 {
+			delete(t.inProgress, span.subject)
+		}
+		return tid
+	default:
+		return 1
 	}
 }
 
@@ -96,7 +282,7 @@ func (t *tracer) Event(mach *sliceMachine, subject interface{}, ph string, args
 			pid = len(t.machinePids) + 1 // pid=0 is reserved for evaluator events
 			t.machinePids[mach] = pid
 			// Attach "process" name metadata so we can identify where a task is running.
-			t.events = append(t.events, traceEvent{
+			meta := traceEvent{
 				Pid:  pid,
 				Ts:   event.Ts,
 				Ph:   "M",
@@ -104,16 +290,19 @@ func (t *tracer) Event(mach *sliceMachine, subject interface{}, ph string, args
 				Args: map[string]interface{}{
 					"name": mach.Addr,
 				},
-			})
+			}
+			t.events = append(t.events, meta)
+			t.write(meta)
 		}
 		event.Pid = pid
 	}
-	event.Tid = 1
 	switch arg := subject.(type) {
 	case *Task:
 		event.Name = arg.Name.String()
 		event.Cat = "task"
+		event.Tid = t.tid(traceSpan{event.Pid, arg}, ph)
 		t.taskEvents[arg] = append(t.taskEvents[arg], event)
+		t.retain(arg)
 	case bigslice.Invocation:
 		var name strings.Builder
 		fmt.Fprint(&name, arg.Index)
@@ -122,10 +311,13 @@ func (t *tracer) Event(mach *sliceMachine, subject interface{}, ph string, args
 		}
 		event.Name = name.String()
 		event.Cat = "invocation"
+		event.Tid = t.tid(traceSpan{event.Pid, arg.Index}, ph)
 		t.compileEvents[arg.Index] = append(t.compileEvents[arg.Index], event)
+		t.retain(arg.Index)
 	default:
 		panic(fmt.Sprintf("unsupported subject type %T", subject))
 	}
+	t.write(event)
 }
 
 // Marshal writes the trace captured by t into the writer w in
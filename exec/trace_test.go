@@ -0,0 +1,197 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grailbio/bigmachine/testsystem"
+	"github.com/grailbio/bigslice"
+)
+
+func TestTracerRetention(t *testing.T) {
+	tracer := newTracer(4)
+	tasks := make([]*Task, 3)
+	for i := range tasks {
+		tasks[i] = &Task{Name: TaskName{Op: fmt.Sprint("op", i), NumShard: 1}}
+		tracer.Event(nil, tasks[i], "B")
+		tracer.Event(nil, tasks[i], "E")
+	}
+	if got, want := tracer.nevents, 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := tracer.taskEvents[tasks[0]]; ok {
+		t.Error("oldest task events were retained")
+	}
+	var b bytes.Buffer
+	if err := tracer.Marshal(&b); err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &trace); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(trace.TraceEvents), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTracerRetainsRunning(t *testing.T) {
+	tracer := newTracer(2)
+	running := &Task{Name: TaskName{Op: "running", NumShard: 1}}
+	tracer.Event(nil, running, "B")
+	for i := 0; i < 3; i++ {
+		task := &Task{Name: TaskName{Op: fmt.Sprint("op", i), NumShard: 1}}
+		tracer.Event(nil, task, "B")
+		tracer.Event(nil, task, "E")
+	}
+	if _, ok := tracer.taskEvents[running]; !ok {
+		t.Error("events of running task were discarded")
+	}
+	tracer.Event(nil, running, "E")
+	if got, want := len(tracer.taskEvents[running]), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTracerRetainsRecentlyFinished(t *testing.T) {
+	tracer := newTracer(4)
+	tasks := make([]*Task, 3)
+	for i := range tasks {
+		tasks[i] = &Task{Name: TaskName{Op: fmt.Sprint("op", i), NumShard: 1}}
+	}
+	// Task 0 starts first but finishes after task 1, so task 1's
+	// events are the first to be discarded.
+	tracer.Event(nil, tasks[0], "B")
+	tracer.Event(nil, tasks[1], "B")
+	tracer.Event(nil, tasks[1], "E")
+	tracer.Event(nil, tasks[0], "E")
+	tracer.Event(nil, tasks[2], "B")
+	tracer.Event(nil, tasks[2], "E")
+	if _, ok := tracer.taskEvents[tasks[1]]; ok {
+		t.Error("least recently finished task events were retained")
+	}
+	for _, task := range []*Task{tasks[0], tasks[2]} {
+		if got, want := len(tracer.taskEvents[task]), 2; got != want {
+			t.Errorf("%v: got %v, want %v", task, got, want)
+		}
+	}
+	if got, want := tracer.finished.Len(), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTracerThreads(t *testing.T) {
+	var (
+		tracer = newTracer(0)
+		tasks  = make([]*Task, 3)
+		b      bytes.Buffer
+	)
+	for i := range tasks {
+		tasks[i] = &Task{Name: TaskName{Op: fmt.Sprint("op", i), NumShard: 1}}
+	}
+	if err := tracer.Stream(&b); err != nil {
+		t.Fatal(err)
+	}
+	// Tasks 0 and 1 run concurrently; task 2 starts once task 0 is
+	// done, and so it may reuse task 0's thread.
+	tracer.Event(nil, tasks[0], "B")
+	tracer.Event(nil, tasks[1], "B")
+	tracer.Event(nil, tasks[0], "E")
+	tracer.Event(nil, tasks[2], "B")
+	tracer.Event(nil, tasks[1], "E")
+	tracer.Event(nil, tasks[2], "E")
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var events []traceEvent
+	if err := json.Unmarshal(b.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	tids := make([]string, len(events))
+	for i, event := range events {
+		tids[i] = fmt.Sprintf("%s%s:%d", event.Name[:len("op0")], event.Ph, event.Tid)
+	}
+	if got, want := fmt.Sprint(tids), "[op0B:1 op1B:2 op0E:1 op2B:1 op1E:2 op2E:1]"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(tracer.inProgress), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTracerStream(t *testing.T) {
+	var (
+		tracer = newTracer(0)
+		task   = &Task{Name: TaskName{Op: "op", NumShard: 1}}
+		b      bytes.Buffer
+	)
+	if err := tracer.Stream(&b); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Event(nil, task, "B")
+	tracer.Event(nil, task, "E", "error", "failed")
+	// A trace that is not closed (e.g., because the process crashed)
+	// is an unterminated array.
+	var events []traceEvent
+	if err := json.Unmarshal(append(b.Bytes(), ']'), &events); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := events[1].Ph, "E"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTraceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace.json")
+	sess := Start(Bigmachine(testsystem.New()), TraceFile(path))
+	f := bigslice.Func(func() bigslice.Slice {
+		return bigslice.Const(2, []int{1, 2, 3})
+	})
+	if _, err := sess.Run(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	sess.Shutdown()
+	p, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []traceEvent
+	if err := json.Unmarshal(p, &events); err != nil {
+		t.Fatal(err)
+	}
+	var ntask int
+	for _, event := range events {
+		if event.Cat == "task" && event.Ph == "B" {
+			ntask++
+		}
+	}
+	if got, want := ntask, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
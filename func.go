@@ -65,12 +65,16 @@ func (f *FuncValue) In(i int) reflect.Type { return f.args[i] }
 // applied to the provided arguments. Invocation panics with a type
 // error if the provided arguments do not match in type or arity.
 func (f *FuncValue) Invocation(location string, args ...interface{}) Invocation {
-	argTypes := make([]reflect.Type, len(args))
-	for i, arg := range args {
-		argTypes[i] = reflect.TypeOf(arg)
-	}
-	f.typecheck(argTypes...)
-	return newInvocation(location, uint64(f.index), f.exclusive, args...)
+	return f.newInvocation(atomic.AddUint64(&invocationIndex, 1), location, args...)
+}
+
+// PeekInvocation is like Invocation, but it does not consume an
+// invocation index: the returned invocation carries the index that
+// will be assigned to the next invocation. It is meant for
+// compiling plans that are never run, so that doing so does not
+// change the indices, and thus task names, of later invocations.
+func (f *FuncValue) PeekInvocation(location string, args ...interface{}) Invocation {
+	return f.newInvocation(atomic.LoadUint64(&invocationIndex)+1, location, args...)
 }
 
 // Apply invokes the function f with the provided arguments,
@@ -171,7 +175,7 @@ func FuncLocations() []string {
 // for invocations within a process namespace. It can thus be used to
 // represent a particular function invocation from a driver process.
 //
-// Invocations must be created by newInvocation.
+// Invocations must be created by FuncValue.newInvocation.
 type Invocation struct {
 	Index     uint64
 	Func      uint64
@@ -190,12 +194,20 @@ func (inv Invocation) String() string {
 
 var invocationIndex uint64
 
-func newInvocation(location string, fn uint64, exclusive bool, args ...interface{}) Invocation {
+// NewInvocation returns the invocation with the provided index of f
+// applied to the provided arguments. It panics with a type error if
+// the arguments do not match in type or arity.
+func (f *FuncValue) newInvocation(index uint64, location string, args ...interface{}) Invocation {
+	argTypes := make([]reflect.Type, len(args))
+	for i, arg := range args {
+		argTypes[i] = reflect.TypeOf(arg)
+	}
+	f.typecheck(argTypes...)
 	return Invocation{
-		Index:     atomic.AddUint64(&invocationIndex, 1),
-		Func:      fn,
+		Index:     index,
+		Func:      uint64(f.index),
 		Args:      args,
-		Exclusive: exclusive,
+		Exclusive: f.exclusive,
 		Location:  location,
 	}
 }
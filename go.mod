@@ -5,6 +5,7 @@ go 1.12
 require (
 	github.com/aws/aws-sdk-go v1.25.6
 	github.com/google/gofuzz v1.0.0
+	github.com/google/pprof v0.0.0-20190930153522-6ce02741cba3
 	github.com/grailbio/base v0.0.2-0.20191001220705-6ab88f99dd6f
 	github.com/grailbio/bigmachine v0.5.0
 	github.com/grailbio/testutil v0.0.2-0.20190910222532-7f08e0a97d25
@@ -0,0 +1,10 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package localprocstest tests bigslice computations that are run
+// by the exec.LocalProcesses executor. The tests are kept in their
+// own package because the session must be started by TestMain,
+// before any test is run, so that worker processes, which re-execute
+// the test binary, do not run the tests themselves.
+package localprocstest
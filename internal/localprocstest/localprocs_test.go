@@ -0,0 +1,138 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package localprocstest
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/exec"
+	"github.com/grailbio/bigslice/sliceio"
+)
+
+var sess *exec.Session
+
+func TestMain(m *testing.M) {
+	sess = exec.Start(exec.LocalProcesses(2))
+	os.Exit(m.Run())
+}
+
+type point struct {
+	X, Y int
+}
+
+// opaque cannot be gob-encoded, since it has no exported fields.
+type opaque struct {
+	x int
+}
+
+var (
+	sumFunc = bigslice.Func(func(n int) bigslice.Slice {
+		slice := bigslice.Const(4, rangeSlice(0, n))
+		slice = bigslice.Map(slice, func(i int) (int, point) {
+			return i % 10, point{i, 2 * i}
+		})
+		return bigslice.Reduce(slice, func(a, e point) point {
+			return point{a.X + e.X, a.Y + e.Y}
+		})
+	})
+	// The failing funcs use a single shard, since each failed task
+	// puts its machine on probation, making it unavailable to
+	// subsequent tests.
+	opaqueFunc = bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(1, rangeSlice(0, 100))
+		slice = bigslice.Map(slice, func(i int) (int, opaque) {
+			return i % 10, opaque{i}
+		})
+		return bigslice.Reduce(slice, func(a, e opaque) opaque {
+			return opaque{a.x + e.x}
+		})
+	})
+	panicFunc = bigslice.Func(func() bigslice.Slice {
+		slice := bigslice.Const(1, rangeSlice(0, 100))
+		return bigslice.Map(slice, func(i int) int {
+			if i == 50 {
+				panic("bad row")
+			}
+			return i
+		})
+	})
+)
+
+func rangeSlice(i, j int) []int {
+	s := make([]int, j-i)
+	for k := range s {
+		s[k] = i + k
+	}
+	return s
+}
+
+func TestShuffle(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short mode: skipping multi-process tests")
+	}
+	const N = 1000
+	ctx := context.Background()
+	res, err := sess.Run(ctx, sumFunc, N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		keys   []int
+		points []point
+	)
+	if err := sliceio.ReadAll(ctx, res.Scan(ctx).Reader, &keys, &points); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(keys), 10; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sums := make(map[int]point)
+	for i, key := range keys {
+		sums[key] = points[i]
+	}
+	for key := 0; key < 10; key++ {
+		var want point
+		for i := key; i < N; i += 10 {
+			want.X += i
+			want.Y += 2 * i
+		}
+		if got := sums[key]; got != want {
+			t.Errorf("key %d: got %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short mode: skipping multi-process tests")
+	}
+	// The in-process executor does not serialize the Reduce's input,
+	// and so this is a bug that is caught only by a multi-process
+	// executor.
+	_, err := sess.Run(context.Background(), opaqueFunc)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := err.Error(), "no exported fields"; !strings.Contains(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTaskError(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short mode: skipping multi-process tests")
+	}
+	_, err := sess.Run(context.Background(), panicFunc)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := err.Error(), "bad row"; !strings.Contains(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+// Package sketch implements mergeable summaries ("sketches") used by
+// bigslice's approximate aggregation combinators. Sketches are plain
+// structs with exported fields so that they may be gob-encoded and
+// shuffled like any other column value.
+//
+// Merge methods update their receiver in place. This is safe for the
+// uses in bigslice, where each sketch is created afresh for each input
+// row, and combined sketches are not read again after they are merged.
+package sketch
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+	// HLLSparseMax is the number of sparse entries beyond which the
+	// sketch switches to the dense representation. Since sparse
+	// entries occupy 4 bytes, this equates to the size of the dense
+	// register set.
+	hllSparseMax = hllRegisters / 4
+)
+
+// HLL is a HyperLogLog sketch for estimating the number of distinct
+// items in a multiset. The sketch uses 2^12 registers, for a typical
+// relative error of about 1.6%. Sketches for small sets are stored
+// sparsely.
+type HLL struct {
+	// Sparse stores the nonzero registers of a sparse sketch, sorted
+	// by register index. Each entry stores the register index in its
+	// upper bits, and its value in its lowest 8 bits.
+	Sparse []uint32
+	// Dense stores all registers of a dense sketch; it is nil while
+	// the sketch is sparse.
+	Dense []uint8
+}
+
+// Add adds an item with the provided 64-bit hash to the sketch.
+// Hashes must be uniformly distributed.
+func (h *HLL) Add(hash uint64) {
+	idx := uint32(hash >> (64 - hllPrecision))
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	h.set(idx, rank)
+}
+
+// Merge merges the sketch o into h.
+func (h *HLL) Merge(o *HLL) {
+	if o.Dense != nil {
+		h.densify()
+		for i, rank := range o.Dense {
+			if rank > h.Dense[i] {
+				h.Dense[i] = rank
+			}
+		}
+		return
+	}
+	for _, entry := range o.Sparse {
+		h.set(entry>>8, uint8(entry))
+	}
+}
+
+// Estimate returns the estimated number of distinct items that have
+// been added to the sketch.
+func (h *HLL) Estimate() uint64 {
+	var (
+		sum   float64
+		zeros int
+	)
+	if h.Dense != nil {
+		for _, rank := range h.Dense {
+			if rank == 0 {
+				zeros++
+			}
+			sum += math.Ldexp(1, -int(rank))
+		}
+	} else {
+		zeros = hllRegisters - len(h.Sparse)
+		sum = float64(zeros)
+		for _, entry := range h.Sparse {
+			sum += math.Ldexp(1, -int(uint8(entry)))
+		}
+	}
+	const m = hllRegisters
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Use linear counting for small cardinalities.
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+func (h *HLL) set(idx uint32, rank uint8) {
+	if h.Dense != nil {
+		if rank > h.Dense[idx] {
+			h.Dense[idx] = rank
+		}
+		return
+	}
+	i := sort.Search(len(h.Sparse), func(i int) bool { return h.Sparse[i]>>8 >= idx })
+	switch {
+	case i < len(h.Sparse) && h.Sparse[i]>>8 == idx:
+		if rank > uint8(h.Sparse[i]) {
+			h.Sparse[i] = idx<<8 | uint32(rank)
+		}
+	case len(h.Sparse) == hllSparseMax:
+		h.densify()
+		h.set(idx, rank)
+	default:
+		h.Sparse = append(h.Sparse, 0)
+		copy(h.Sparse[i+1:], h.Sparse[i:])
+		h.Sparse[i] = idx<<8 | uint32(rank)
+	}
+}
+
+func (h *HLL) densify() {
+	if h.Dense != nil {
+		return
+	}
+	h.Dense = make([]uint8, hllRegisters)
+	for _, entry := range h.Sparse {
+		h.Dense[entry>>8] = uint8(entry)
+	}
+	h.Sparse = nil
+}
@@ -0,0 +1,33 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sketch
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestHLL(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	for _, n := range []int{0, 1, 10, 1000, 100000} {
+		// Add each item twice, across two sketches, which are then
+		// merged.
+		var h1, h2 HLL
+		for i := 0; i < n; i++ {
+			hash := rnd.Uint64()
+			h1.Add(hash)
+			h2.Add(hash)
+			if i%2 == 0 {
+				h2.Add(rnd.Uint64())
+			}
+		}
+		h1.Merge(&h2)
+		want := float64(n + (n+1)/2)
+		if got := float64(h1.Estimate()); math.Abs(got-want) > 0.05*want {
+			t.Errorf("n=%d: got %v, want %v", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sketch
+
+import (
+	"math"
+	"sort"
+)
+
+// KLL is a KLL sketch (Karnin, Lang, Liberty: "Optimal Quantile
+// Approximation in Streams") for estimating quantiles of a stream of
+// values. The sketch maintains a hierarchy of compactors: items at
+// level h represent 2^h items of the stream. When a level exceeds its
+// capacity, it is sorted and every other item is promoted to the next
+// level.
+type KLL struct {
+	// K is the sketch's accuracy parameter: the capacity of its top
+	// level. Rank errors are roughly proportional to 1/K.
+	K int
+	// N is the number of values summarized by the sketch.
+	N uint64
+	// Levels holds the items retained at each level.
+	Levels [][]float64
+	// Coin is the state of the pseudo-random generator used to select
+	// promoted items. It is deterministic so that sketches are
+	// reproducible.
+	Coin uint32
+}
+
+// NewKLL returns a new, empty KLL sketch with accuracy parameter k.
+func NewKLL(k int) *KLL {
+	return &KLL{K: k, Levels: make([][]float64, 1)}
+}
+
+// Add adds the value x to the sketch.
+func (s *KLL) Add(x float64) {
+	if len(s.Levels) == 0 {
+		s.Levels = make([][]float64, 1)
+	}
+	s.Levels[0] = append(s.Levels[0], x)
+	s.N++
+	s.compress()
+}
+
+// Merge merges the sketch o into s. Both sketches should have the
+// same accuracy parameter.
+func (s *KLL) Merge(o *KLL) {
+	for h, level := range o.Levels {
+		if h == len(s.Levels) {
+			s.Levels = append(s.Levels, nil)
+		}
+		s.Levels[h] = append(s.Levels[h], level...)
+	}
+	s.N += o.N
+	s.compress()
+}
+
+// Quantiles returns the estimated values at each of the provided
+// quantiles, which must be in [0, 1]. NaN is returned for each
+// quantile of an empty sketch.
+func (s *KLL) Quantiles(qs []float64) []float64 {
+	type item struct {
+		value  float64
+		weight uint64
+	}
+	var (
+		items []item
+		total uint64
+	)
+	for h, level := range s.Levels {
+		for _, x := range level {
+			items = append(items, item{x, 1 << uint(h)})
+			total += 1 << uint(h)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].value < items[j].value })
+	values := make([]float64, len(qs))
+	for i, q := range qs {
+		values[i] = math.NaN()
+		target := q * float64(total)
+		var cum uint64
+		for _, item := range items {
+			cum += item.weight
+			values[i] = item.value
+			if float64(cum) >= target {
+				break
+			}
+		}
+	}
+	return values
+}
+
+// Capacity returns the capacity of level h. Capacities decrease
+// geometrically from the top level, whose capacity is K.
+func (s *KLL) capacity(h int) int {
+	depth := len(s.Levels) - 1 - h
+	c := int(math.Ceil(float64(s.K) * math.Pow(2.0/3.0, float64(depth))))
+	if c < 2 {
+		c = 2
+	}
+	return c
+}
+
+func (s *KLL) compress() {
+	for h := 0; h < len(s.Levels); h++ {
+		if len(s.Levels[h]) > s.capacity(h) {
+			s.compact(h)
+		}
+	}
+}
+
+// Compact sorts level h and promotes every other item to level h+1.
+// If the level has an odd number of items, the last one is retained.
+func (s *KLL) compact(h int) {
+	level := s.Levels[h]
+	sort.Float64s(level)
+	if h+1 == len(s.Levels) {
+		s.Levels = append(s.Levels, nil)
+	}
+	n := len(level) &^ 1
+	for i := int(s.flip()); i < n; i += 2 {
+		s.Levels[h+1] = append(s.Levels[h+1], level[i])
+	}
+	s.Levels[h] = append(level[:0], level[n:]...)
+}
+
+// Flip returns a pseudo-random bit, using Marsaglia's xorshift32
+// generator.
+func (s *KLL) flip() uint32 {
+	if s.Coin == 0 {
+		s.Coin = 2463534242
+	}
+	s.Coin ^= s.Coin << 13
+	s.Coin ^= s.Coin >> 17
+	s.Coin ^= s.Coin << 5
+	return s.Coin & 1
+}
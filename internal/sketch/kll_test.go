@@ -0,0 +1,45 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sketch
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestKLL(t *testing.T) {
+	const N = 100000
+	rnd := rand.New(rand.NewSource(0))
+	sketches := make([]*KLL, 10)
+	for i := range sketches {
+		sketches[i] = NewKLL(200)
+	}
+	for _, x := range rnd.Perm(N) {
+		sketches[rnd.Intn(len(sketches))].Add(float64(x))
+	}
+	s := sketches[0]
+	for _, o := range sketches[1:] {
+		s.Merge(o)
+	}
+	if got, want := s.N, uint64(N); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	qs := []float64{0, 0.01, 0.25, 0.5, 0.75, 0.99, 1}
+	for i, got := range s.Quantiles(qs) {
+		want := qs[i] * N
+		if math.Abs(got-want) > 0.02*N {
+			t.Errorf("quantile %v: got %v, want %v", qs[i], got, want)
+		}
+	}
+}
+
+func TestKLLEmpty(t *testing.T) {
+	for _, q := range NewKLL(200).Quantiles([]float64{0, 0.5, 1}) {
+		if !math.IsNaN(q) {
+			t.Errorf("got %v, want NaN", q)
+		}
+	}
+}
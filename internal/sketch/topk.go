@@ -0,0 +1,214 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sketch
+
+import "sort"
+
+const (
+	topKDepth = 4
+	topKWidth = 1 << 10
+)
+
+// TopK is a sketch for estimating the K most frequent items of a
+// multiset (its "heavy hitters"). While the number of distinct items
+// is small, TopK counts them exactly. Beyond this, TopK maintains a
+// Count-Min sketch of item frequencies together with the K candidate
+// items with the highest estimated frequencies. Estimated frequencies
+// never underestimate the true frequency of an item.
+//
+// Items must be comparable. Since items are stored as empty
+// interfaces, their concrete types must be registered with gob if
+// sketches are encoded.
+type TopK struct {
+	K int
+	// Counts is the Count-Min sketch, with topKDepth rows of
+	// topKWidth counters each; it is nil while counts are exact.
+	Counts []uint64
+	// Items, Hashes, and Freqs store the candidate items, their hashes,
+	// and their (exact or estimated) frequencies.
+	Items  []interface{}
+	Hashes []uint64
+	Freqs  []uint64
+
+	index map[interface{}]int
+}
+
+// NewTopK returns a new, empty sketch that tracks the k most frequent
+// items.
+func NewTopK(k int) *TopK {
+	return &TopK{K: k}
+}
+
+// Add adds count occurrences of item, whose 64-bit hash is provided,
+// to the sketch.
+func (t *TopK) Add(item interface{}, hash uint64, count uint64) {
+	if t.Counts != nil {
+		t.increment(hash, count)
+		if i, ok := t.lookup(item); ok {
+			t.Freqs[i] = t.estimate(hash)
+		} else {
+			t.insert(item, hash, t.estimate(hash))
+		}
+		t.prune()
+		return
+	}
+	if i, ok := t.lookup(item); ok {
+		t.Freqs[i] += count
+		return
+	}
+	t.insert(item, hash, count)
+	if len(t.Items) > t.exactMax() {
+		t.densify()
+	}
+}
+
+// Merge merges the sketch o into t. Both sketches should track the
+// same number of items.
+func (t *TopK) Merge(o *TopK) {
+	if t.Counts == nil && o.Counts == nil {
+		for i := range o.Items {
+			t.Add(o.Items[i], o.Hashes[i], o.Freqs[i])
+		}
+		return
+	}
+	t.densify()
+	if o.Counts != nil {
+		for i := range t.Counts {
+			t.Counts[i] += o.Counts[i]
+		}
+	} else {
+		for i := range o.Items {
+			t.increment(o.Hashes[i], o.Freqs[i])
+		}
+	}
+	for i := range o.Items {
+		if _, ok := t.lookup(o.Items[i]); !ok {
+			t.insert(o.Items[i], o.Hashes[i], 0)
+		}
+	}
+	for i := range t.Items {
+		t.Freqs[i] = t.estimate(t.Hashes[i])
+	}
+	t.prune()
+}
+
+// Top returns the (at most K) most frequent items in the sketch,
+// together with their frequencies, in order of decreasing frequency.
+func (t *TopK) Top() (items []interface{}, freqs []uint64) {
+	order := t.order()
+	if len(order) > t.K {
+		order = order[:t.K]
+	}
+	items = make([]interface{}, len(order))
+	freqs = make([]uint64, len(order))
+	for i, j := range order {
+		items[i], freqs[i] = t.Items[j], t.Freqs[j]
+	}
+	return
+}
+
+// ExactMax returns the number of distinct items beyond which the
+// sketch switches to approximate counting.
+func (t *TopK) exactMax() int {
+	if n := 4 * t.K; n > 64 {
+		return n
+	}
+	return 64
+}
+
+// Order returns the indices of the candidate items in order of
+// decreasing frequency. Ties are broken by hash, so that the order is
+// deterministic.
+func (t *TopK) order() []int {
+	order := make([]int, len(t.Items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		fi, fj := t.Freqs[order[i]], t.Freqs[order[j]]
+		if fi != fj {
+			return fi > fj
+		}
+		return t.Hashes[order[i]] < t.Hashes[order[j]]
+	})
+	return order
+}
+
+// Densify switches the sketch to approximate counting.
+func (t *TopK) densify() {
+	if t.Counts != nil {
+		return
+	}
+	t.Counts = make([]uint64, topKDepth*topKWidth)
+	for i := range t.Items {
+		t.increment(t.Hashes[i], t.Freqs[i])
+	}
+	for i := range t.Items {
+		t.Freqs[i] = t.estimate(t.Hashes[i])
+	}
+	t.prune()
+}
+
+// Prune retains only the K candidates with the highest frequencies.
+func (t *TopK) prune() {
+	if len(t.Items) <= t.K {
+		return
+	}
+	order := t.order()[:t.K]
+	var (
+		items  = make([]interface{}, len(order))
+		hashes = make([]uint64, len(order))
+		freqs  = make([]uint64, len(order))
+	)
+	for i, j := range order {
+		items[i], hashes[i], freqs[i] = t.Items[j], t.Hashes[j], t.Freqs[j]
+	}
+	t.Items, t.Hashes, t.Freqs = items, hashes, freqs
+	t.index = nil
+}
+
+func (t *TopK) lookup(item interface{}) (int, bool) {
+	if t.index == nil {
+		t.index = make(map[interface{}]int, len(t.Items))
+		for i, item := range t.Items {
+			t.index[item] = i
+		}
+	}
+	i, ok := t.index[item]
+	return i, ok
+}
+
+func (t *TopK) insert(item interface{}, hash uint64, freq uint64) {
+	if _, ok := t.lookup(item); !ok {
+		t.index[item] = len(t.Items)
+	}
+	t.Items = append(t.Items, item)
+	t.Hashes = append(t.Hashes, hash)
+	t.Freqs = append(t.Freqs, freq)
+}
+
+// Cell returns the index in Counts of the counter for the provided
+// hash in the given row. Rows' indices are derived from the hash by
+// double hashing.
+func cell(hash uint64, row int) int {
+	h1, h2 := uint32(hash), uint32(hash>>32)|1
+	return row*topKWidth + int((h1+uint32(row)*h2)%topKWidth)
+}
+
+func (t *TopK) increment(hash uint64, count uint64) {
+	for row := 0; row < topKDepth; row++ {
+		t.Counts[cell(hash, row)] += count
+	}
+}
+
+func (t *TopK) estimate(hash uint64) uint64 {
+	min := t.Counts[cell(hash, 0)]
+	for row := 1; row < topKDepth; row++ {
+		if c := t.Counts[cell(hash, row)]; c < min {
+			min = c
+		}
+	}
+	return min
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package sketch
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	rnd := rand.New(rand.NewSource(0))
+	for _, nitem := range []int{10, 10000} {
+		// Item i occurs 1000/(i+1) times, spread across several
+		// sketches, and a long tail of items occurs once.
+		sketches := make([]*TopK, 5)
+		for i := range sketches {
+			sketches[i] = NewTopK(5)
+		}
+		var occurrences []int
+		for i := 0; i < nitem; i++ {
+			n := 1
+			if i < 10 {
+				n = 1000 / (i + 1)
+			}
+			for j := 0; j < n; j++ {
+				occurrences = append(occurrences, i)
+			}
+		}
+		rnd.Shuffle(len(occurrences), func(i, j int) {
+			occurrences[i], occurrences[j] = occurrences[j], occurrences[i]
+		})
+		for _, i := range occurrences {
+			hash := uint64(i+1) * 0x9e3779b97f4a7c15
+			sketches[rnd.Intn(len(sketches))].Add(i, hash^hash>>29, 1)
+		}
+		s := sketches[0]
+		for _, o := range sketches[1:] {
+			s.Merge(o)
+		}
+		items, freqs := s.Top()
+		if got, want := items, []interface{}{0, 1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+			t.Errorf("nitem=%d: got %v, want %v", nitem, got, want)
+		}
+		for i, freq := range freqs {
+			if want := uint64(1000 / (i + 1)); freq < want {
+				t.Errorf("nitem=%d: item %d: got %v, want at least %v", nitem, i, freq, want)
+			}
+		}
+	}
+}
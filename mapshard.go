@@ -0,0 +1,246 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+var typeOfScanner = reflect.TypeOf((*sliceio.Scanner)(nil))
+
+type mapShardSlice struct {
+	name Name
+	slicePragma
+	Slice
+	fval reflect.Value
+	emit reflect.Type
+	out  slicetype.Type
+}
+
+// MapShard transforms a slice by invoking a function once for each of
+// its shards. The function is provided with the shard number, a
+// scanner from which the shard's records are read, and an emit
+// function with which output records are produced. The columns of
+// the output slice are the arguments of the emit function. MapShard
+// thus lets the user maintain state, for example an expensive
+// resource or a buffer, across the records of a shard. The function
+// may emit any number of records, and may do so at any time before
+// it returns. If it returns a non-nil error, the computation fails;
+// errors are retried only if they are temporary.
+//
+// As with Map, the returned slice matches the input slice's sharding,
+// but is always hash partitioned, and MapShard is pipelined with its
+// input.
+//
+// Schematically:
+//
+//	MapShard(Slice<t1, t2, ..., tn>, func(shard int, in *sliceio.Scanner, emit func(r1, r2, ..., rn)) error) Slice<r1, r2, ..., rn>
+//
+// The scanner produces records of type <t1, t2, ..., tn>.
+func MapShard(slice Slice, fn interface{}, prags ...Pragma) Slice {
+	m := new(mapShardSlice)
+	m.name = makeName("mapshard")
+	m.Slice = slice
+	m.fval = reflect.ValueOf(fn)
+	m.Pragma = Pragmas(prags)
+	t := m.fval.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 3 || t.In(0).Kind() != reflect.Int || t.In(1) != typeOfScanner ||
+		t.In(2).Kind() != reflect.Func || t.NumOut() != 1 || t.Out(0) != typeOfError {
+		typecheck.Panicf(1, "mapshard: invalid mapshard function %T", fn)
+	}
+	m.emit = t.In(2)
+	if m.emit.NumOut() != 0 || m.emit.IsVariadic() {
+		typecheck.Panicf(1, "mapshard: invalid emit function %s", m.emit)
+	}
+	if m.emit.NumIn() == 0 {
+		typecheck.Panic(1, "mapshard: need at least one output column")
+	}
+	out := make([]reflect.Type, m.emit.NumIn())
+	for i := range out {
+		out[i] = m.emit.In(i)
+	}
+	m.out = slicetype.New(out...)
+	return m
+}
+
+func (m *mapShardSlice) Name() Name             { return m.name }
+func (m *mapShardSlice) NumOut() int            { return m.out.NumOut() }
+func (m *mapShardSlice) Out(c int) reflect.Type { return m.out.Out(c) }
+func (*mapShardSlice) ShardType() ShardType     { return HashShard }
+func (*mapShardSlice) NumDep() int              { return 1 }
+func (m *mapShardSlice) Dep(i int) Dep          { return singleDep(i, m.Slice, false) }
+func (*mapShardSlice) Combiner() *reflect.Value { return nil }
+
+func (m *mapShardSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &mapShardReader{op: m, shard: shard, reader: deps[0]}
+}
+
+// MapShardChunk is a chunk of output produced by a mapshard function,
+// or the error with which the function returned.
+type mapShardChunk struct {
+	frame frame.Frame
+	err   error
+}
+
+// ErrMapShardCanceled is used to unwind a mapshard function whose
+// reader's context was canceled while it was emitting records.
+type errMapShardCanceled struct{ error }
+
+// MapShardReader runs the mapshard function in a separate goroutine,
+// which produces chunks of output as records are emitted. The
+// goroutine runs until the function returns, or until the reader is
+// closed. The reader is closed when it has been fully read, when its
+// context is canceled, or when its task completes (see
+// WithTaskCleanup); the latter stops the goroutines of readers that
+// are abandoned, for example by Head.
+type mapShardReader struct {
+	op     *mapShardSlice
+	shard  int
+	reader sliceio.Reader
+	err    error
+
+	chunks chan mapShardChunk
+	buf    frame.Frame
+
+	// Cancel cancels the context of the goroutine that runs the
+	// mapshard function; done is closed when the goroutine exits.
+	cancel func()
+	done   chan struct{}
+}
+
+func (m *mapShardReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	if !slicetype.Assignable(out, m.op) {
+		return 0, errTypeError
+	}
+	if m.chunks == nil {
+		m.chunks = make(chan mapShardChunk)
+		m.done = make(chan struct{})
+		var runCtx context.Context
+		runCtx, m.cancel = context.WithCancel(ctx)
+		go m.run(runCtx)
+		onTaskCleanup(ctx, func() { _ = m.Close() })
+	}
+	var n int
+	for n < out.Len() {
+		if m.buf.Len() == 0 {
+			// Return what we have, rather than block on the function.
+			if n > 0 {
+				return n, nil
+			}
+			select {
+			case <-ctx.Done():
+				m.err = ctx.Err()
+				_ = m.Close()
+				return 0, m.err
+			case chunk := <-m.chunks:
+				if chunk.err != nil {
+					m.err = chunk.err
+					_ = m.Close()
+					return 0, m.err
+				}
+				m.buf = chunk.frame
+			}
+		}
+		k := frame.Copy(out.Slice(n, out.Len()), m.buf)
+		m.buf = m.buf.Slice(k, m.buf.Len())
+		n += k
+	}
+	return n, nil
+}
+
+// Close stops the mapshard function, if it is running, and waits for
+// its goroutine to exit. The function is stopped the next time that it
+// emits a record or reads its input.
+func (m *mapShardReader) Close() error {
+	if m.cancel == nil {
+		return nil
+	}
+	m.cancel()
+	<-m.done
+	return nil
+}
+
+func (m *mapShardReader) run(ctx context.Context) {
+	defer close(m.done)
+	var (
+		buf  = frame.Make(m.op, defaultChunksize, defaultChunksize)
+		n    int
+		send = func(chunk mapShardChunk) {
+			select {
+			case <-ctx.Done():
+				panic(errMapShardCanceled{ctx.Err()})
+			case m.chunks <- chunk:
+			}
+		}
+	)
+	emit := reflect.MakeFunc(m.op.emit, func(args []reflect.Value) []reflect.Value {
+		for i := range args {
+			buf.Index(i, n).Set(args[i])
+		}
+		if n++; n == buf.Len() {
+			send(mapShardChunk{frame: buf})
+			buf = frame.Make(m.op, defaultChunksize, defaultChunksize)
+			n = 0
+		}
+		return nil
+	})
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(errMapShardCanceled); ok {
+				return
+			}
+			// Panics in the user's function are reported as errors, since
+			// they cannot be recovered by the executor in this goroutine.
+			err := errors.E(errors.Fatal, fmt.Sprintf("mapshard: panic while evaluating shard %d: %v\n%s", m.shard, e, debug.Stack()))
+			select {
+			case <-ctx.Done():
+			case m.chunks <- mapShardChunk{err: err}:
+			}
+		}
+	}()
+	scanner := &sliceio.Scanner{Type: m.op.Slice, Reader: &cancelReader{ctx, m.reader}}
+	rv := m.op.fval.Call([]reflect.Value{reflect.ValueOf(m.shard), reflect.ValueOf(scanner), emit})
+	err, _ := rv[0].Interface().(error)
+	if err == nil {
+		err = scanner.Err()
+	} else if !errors.IsTemporary(err) {
+		err = errors.E(errors.Fatal, err)
+	}
+	if err == nil && n > 0 {
+		send(mapShardChunk{frame: buf.Slice(0, n)})
+	}
+	if err == nil {
+		err = sliceio.EOF
+	}
+	send(mapShardChunk{err: err})
+}
+
+// CancelReader is a reader that fails with the error of its context
+// once it is canceled, regardless of the context passed to Read. It
+// stops the input of mapshard functions, which may scan their input
+// with contexts of their own, once their readers are closed.
+type cancelReader struct {
+	ctx context.Context
+	sliceio.Reader
+}
+
+func (c *cancelReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.Reader.Read(ctx, out)
+}
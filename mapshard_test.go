@@ -0,0 +1,108 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetest"
+)
+
+func TestMapShard(t *testing.T) {
+	const N = 1000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	slice := bigslice.Const(5, ints)
+	slice = bigslice.MapShard(slice, func(shard int, in *sliceio.Scanner, emit func(string, int)) error {
+		var (
+			ctx = context.Background()
+			sum int
+			x   int
+		)
+		for in.Scan(ctx, &x) {
+			sum += x
+			emit(fmt.Sprint("row", x), x*2)
+		}
+		emit(fmt.Sprint("shard", shard), sum)
+		return nil
+	})
+	// Make sure the output can be shuffled.
+	slice = bigslice.Map(slice, func(key string, x int) (string, int) {
+		if strings.HasPrefix(key, "shard") {
+			return "shards", x
+		}
+		return "rows", x
+	})
+	slice = bigslice.Reduce(slice, func(a, b int) int { return a + b })
+	assertEqual(t, slice, true, []string{"rows", "shards"}, []int{N * (N - 1), N * (N - 1) / 2})
+}
+
+func TestMapShardError(t *testing.T) {
+	slice := bigslice.Const(2, []int{1, 2, 3, 4})
+	slice = bigslice.MapShard(slice, func(shard int, in *sliceio.Scanner, emit func(int)) error {
+		return errors.New("mapshard error")
+	})
+	err := slicetest.RunErr(slice)
+	if err == nil || !strings.Contains(err.Error(), "mapshard error") {
+		t.Errorf("got %v, want mapshard error", err)
+	}
+}
+
+func TestMapShardHead(t *testing.T) {
+	const N = 100000
+	var running int32
+	slice := bigslice.Const(2, make([]int, N))
+	slice = bigslice.MapShard(slice, func(shard int, in *sliceio.Scanner, emit func(int)) error {
+		atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		var x int
+		for in.Scan(context.Background(), &x) {
+			emit(x)
+		}
+		return in.Err()
+	})
+	// Head abandons the mapshard readers after their first records.
+	slice = bigslice.Head(slice, 1)
+	for name, scan := range run(context.Background(), t, slice) {
+		var (
+			x int
+			n int
+		)
+		for scan.Scan(context.Background(), &x) {
+			n++
+		}
+		if err := scan.Err(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, want := n, 2; got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	if n := atomic.LoadInt32(&running); n != 0 {
+		t.Errorf("%d mapshard functions did not return", n)
+	}
+}
+
+func TestMapShardTypeError(t *testing.T) {
+	slice := bigslice.Const(1, []int{1})
+	expectTypeError(t, "mapshard: invalid mapshard function func(int, *sliceio.Scanner) error", func() {
+		bigslice.MapShard(slice, func(int, *sliceio.Scanner) error { return nil })
+	})
+	expectTypeError(t, "mapshard: invalid emit function func(int) error", func() {
+		bigslice.MapShard(slice, func(int, *sliceio.Scanner, func(int) error) error { return nil })
+	})
+	expectTypeError(t, "mapshard: need at least one output column", func() {
+		bigslice.MapShard(slice, func(int, *sliceio.Scanner, func()) error { return nil })
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+// A Partitioning assigns rows to shards with a partition function.
+// Slices that are partitioned with the same Partitioning (see
+// PartitionWith) are co-partitioned: rows whose partition columns are
+// equal reside in shards with the same index, so that they may be
+// joined by Cogroup without a shuffle. Partitionings are compared by
+// identity: slices are co-partitioned only if they share a
+// Partitioning value, even if they are partitioned by identical
+// functions. This is because bigslice cannot tell whether two
+// functions (for example, closures that capture different state)
+// partition rows in the same way.
+type Partitioning struct {
+	nshard int
+	fval   reflect.Value
+	// Arg is the type of the (leading) columns that are passed to the
+	// partition function.
+	arg slicetype.Type
+}
+
+// NewPartitioning returns a new partitioning of rows into nshard
+// shards, as determined by the partition function fn, which returns
+// the shard of each row. The partition function is provided with the
+// leading columns of each row: it may accept any number of columns,
+// and must return an int in [0, nshard). Rows for which fn returns an
+// out-of-range shard cause the computation to fail. The partition
+// function must be deterministic.
+func NewPartitioning(nshard int, fn interface{}) *Partitioning {
+	return newPartitioning("partitioning", nshard, fn)
+}
+
+func newPartitioning(op string, nshard int, fn interface{}) *Partitioning {
+	if nshard < 1 {
+		typecheck.Panicf(2, "%s: nshard must be positive; got %d", op, nshard)
+	}
+	arg, ret, ok := typecheck.Func(fn)
+	if !ok {
+		typecheck.Panicf(2, "%s: invalid partition function %T", op, fn)
+	}
+	if arg.NumOut() == 0 {
+		typecheck.Panicf(2, "%s: partition function %T must accept at least one column", op, fn)
+	}
+	if ret.NumOut() != 1 || ret.Out(0).Kind() != reflect.Int {
+		typecheck.Panicf(2, "%s: partition function %T must return a single int", op, fn)
+	}
+	return &Partitioning{nshard, reflect.ValueOf(fn), arg}
+}
+
+// NumShard returns the number of shards of the partitioning.
+func (p *Partitioning) NumShard() int { return p.nshard }
+
+type partitionBySlice struct {
+	name Name
+	Slice
+	partitioning *Partitioning
+}
+
+// PartitionBy returns a slice that shuffles the rows of the provided
+// slice into nshard shards, as determined by the partition function
+// fn, which returns the shard of each row. The partition function is
+// provided with the leading columns of each row: it may accept any
+// number of the slice's columns, in order, and must return an int in
+// [0, nshard). Rows for which fn returns an out-of-range shard cause
+// the computation to fail.
+//
+// PartitionBy partitions the slice with a new Partitioning; it is
+// equivalent to PartitionWith(slice, NewPartitioning(nshard, fn)).
+// Slices that are joined by Cogroup without a shuffle must instead
+// share a Partitioning; see PartitionWith.
+//
+// The returned slice has the same type as the input. Schematically:
+//
+//	PartitionBy(Slice<t1, t2, ..., tn>, int, func(t1, ..., tm) int) Slice<t1, t2, ..., tn>
+func PartitionBy(slice Slice, nshard int, fn interface{}) Slice {
+	p := newPartitioning("partitionby", nshard, fn)
+	checkPartitioning("partitionby", slice, p)
+	return &partitionBySlice{makeName(fmt.Sprintf("partitionby(%d)", nshard)), slice, p}
+}
+
+// PartitionWith returns a slice that shuffles the rows of the
+// provided slice into the shards of the provided partitioning, whose
+// partition function must accept the leading columns of the slice.
+//
+// If the partition function accepts only columns that are part of
+// the prefix of the slice that is passed to Reduce or Cogroup, then
+// rows with equal keys are guaranteed to reside in the same shard,
+// and Reduce uses the slice's partitioning instead of performing its
+// own shuffle. Cogroup does the same if every one of its input slices
+// is partitioned with the same Partitioning value. This
+// co-partitioning permits, for example, data sets to be joined by a
+// derived key, or hot keys to be routed to dedicated shards.
+//
+// The returned slice has the same type as the input. Schematically:
+//
+//	PartitionWith(Slice<t1, t2, ..., tn>, *Partitioning<t1, ..., tm>) Slice<t1, t2, ..., tn>
+func PartitionWith(slice Slice, p *Partitioning) Slice {
+	checkPartitioning("partitionwith", slice, p)
+	return &partitionBySlice{makeName(fmt.Sprintf("partitionwith(%d)", p.nshard)), slice, p}
+}
+
+// CheckPartitioning panics with a type error if the provided
+// partitioning's function does not accept the leading columns of the
+// provided slice.
+func checkPartitioning(op string, slice Slice, p *Partitioning) {
+	nkey := p.arg.NumOut()
+	if nkey > slice.NumOut() || !typecheck.Equal(slicetype.Slice(slice, 0, nkey), p.arg) {
+		typecheck.Panicf(2, "%s: function %s does not match leading columns of input slice type %s", op, p.fval.Type(), slicetype.String(slice))
+	}
+}
+
+func (p *partitionBySlice) Name() Name             { return p.name }
+func (p *partitionBySlice) NumShard() int          { return p.partitioning.nshard }
+func (*partitionBySlice) ShardType() ShardType     { return HashShard }
+func (*partitionBySlice) NumDep() int              { return 1 }
+func (p *partitionBySlice) Dep(i int) Dep          { return Dep{p.Slice, true, false} }
+func (*partitionBySlice) Combiner() *reflect.Value { return nil }
+
+// Partitioner implements ShufflePartitioner.
+func (p *partitionBySlice) Partitioner(dep, shard int) Partitioner {
+	var (
+		fval = p.partitioning.fval
+		args = make([]reflect.Value, p.partitioning.arg.NumOut())
+	)
+	return func(f frame.Frame, i, nshard int) int {
+		for j := range args {
+			args[j] = f.Index(j, i)
+		}
+		// Out-of-range shards fail the task; see Task.NewPartitioner.
+		return int(fval.Call(args)[0].Int())
+	}
+}
+
+func (p *partitionBySlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return deps[0]
+}
+
+// KeyPartitioning returns the partitioning of the provided slice, if
+// the slice is partitioned by (a subset of) the prefix columns of the
+// provided type.
+func keyPartitioning(slice Slice, typ slicetype.Type) (*Partitioning, bool) {
+	p, ok := Unwrap(slice).(*partitionBySlice)
+	if !ok || p.partitioning.arg.NumOut() > typ.Prefix() {
+		return nil, false
+	}
+	return p.partitioning, true
+}
+
+// Copartitioned tells whether all of the provided slices are
+// partitioned by their keys with the same Partitioning.
+func copartitioned(slices []Slice) bool {
+	var first *Partitioning
+	for _, slice := range slices {
+		p, ok := keyPartitioning(slice, slice)
+		if !ok {
+			return false
+		}
+		if first == nil {
+			first = p
+			continue
+		}
+		if p != first {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/exec"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetest"
+)
+
+// PartitionByLength partitions strings by their length.
+func partitionByLength(s string) int { return len(s) % 4 }
+
+func TestPartitionBy(t *testing.T) {
+	keys := []string{"a", "bb", "ccc", "dddd", "e", "ff", "ggg", "h"}
+	vals := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	slice := bigslice.Const(3, keys, vals)
+	slice = bigslice.PartitionBy(slice, 4, partitionByLength)
+	if got, want := slice.NumShard(), 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var (
+		mu     sync.Mutex
+		shards = make(map[string]int)
+	)
+	scan := bigslice.Scan(slice, func(shard int, scanner *sliceio.Scanner) error {
+		var (
+			key string
+			val int
+		)
+		for scanner.Scan(context.Background(), &key, &val) {
+			mu.Lock()
+			shards[key] = shard
+			mu.Unlock()
+		}
+		return scanner.Err()
+	})
+	sess := exec.Start(exec.Local)
+	defer sess.Shutdown()
+	if _, err := sess.Run(context.Background(), bigslice.Func(func() bigslice.Slice { return scan })); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(shards), len(keys); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for key, shard := range shards {
+		if want := partitionByLength(key); shard != want {
+			t.Errorf("key %s: got shard %d, want %d", key, shard, want)
+		}
+	}
+	assertEqual(t, slice, true, keys, vals)
+}
+
+func TestPartitionByReduce(t *testing.T) {
+	const N = 1000
+	keys := make([]string, N)
+	vals := make([]int, N)
+	for i := range keys {
+		keys[i] = strings.Repeat("x", i%5+1)
+		vals[i] = 1
+	}
+	slice := bigslice.Const(7, keys, vals)
+	slice = bigslice.PartitionBy(slice, 4, partitionByLength)
+	slice = bigslice.Reduce(slice, func(a, b int) int { return a + b })
+	if slice.Dep(0).Shuffle {
+		t.Error("reduce of partitioned slice should not shuffle")
+	}
+	assertEqual(t, slice, true,
+		[]string{"x", "xx", "xxx", "xxxx", "xxxxx"},
+		[]int{N / 5, N / 5, N / 5, N / 5, N / 5})
+}
+
+func TestPartitionByCogroup(t *testing.T) {
+	left := bigslice.Const(2, []string{"a", "bb", "ccc"}, []int{1, 2, 3})
+	right := bigslice.Const(5, []string{"a", "bb", "dddd"}, []string{"x", "y", "w"})
+	// Partitioning by different functions requires a shuffle.
+	slice := bigslice.Cogroup(
+		bigslice.PartitionBy(left, 4, partitionByLength),
+		bigslice.PartitionBy(right, 4, func(key string) int { return 0 }))
+	if !slice.Dep(0).Shuffle || !slice.Dep(1).Shuffle {
+		t.Error("cogroup of differently partitioned slices should shuffle")
+	}
+	// So does partitioning with distinct partitionings, even if they
+	// use the same function.
+	slice = bigslice.Cogroup(
+		bigslice.PartitionBy(left, 4, partitionByLength),
+		bigslice.PartitionBy(right, 4, partitionByLength))
+	if !slice.Dep(0).Shuffle || !slice.Dep(1).Shuffle {
+		t.Error("cogroup of slices with distinct partitionings should shuffle")
+	}
+	partitioning := bigslice.NewPartitioning(4, partitionByLength)
+	slice = bigslice.Cogroup(
+		bigslice.PartitionWith(left, partitioning),
+		bigslice.PartitionWith(right, partitioning))
+	if slice.Dep(0).Shuffle || slice.Dep(1).Shuffle {
+		t.Error("cogroup of co-partitioned slices should not shuffle")
+	}
+	if got, want := slice.NumShard(), 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	assertEqual(t, slice, true,
+		[]string{"a", "bb", "ccc", "dddd"},
+		[][]int{{1}, {2}, {3}, nil},
+		[][]string{{"x"}, {"y"}, nil, {"w"}})
+}
+
+func TestPartitionByCogroupClosures(t *testing.T) {
+	// Closures that are created from the same function literal, but
+	// that capture different state, partition differently; slices
+	// partitioned by them are not co-partitioned.
+	modulo := func(n int) func(string) int {
+		return func(key string) int { return len(key) % n }
+	}
+	left := bigslice.Const(2, []string{"a", "bb", "ccc", "dddd"}, []int{1, 2, 3, 4})
+	right := bigslice.Const(3, []string{"a", "bb", "ccc", "dddd"}, []int{5, 6, 7, 8})
+	slice := bigslice.Cogroup(
+		bigslice.PartitionBy(left, 4, modulo(4)),
+		bigslice.PartitionBy(right, 4, modulo(2)))
+	if !slice.Dep(0).Shuffle || !slice.Dep(1).Shuffle {
+		t.Error("cogroup of slices partitioned by different closures should shuffle")
+	}
+	assertEqual(t, slice, true,
+		[]string{"a", "bb", "ccc", "dddd"},
+		[][]int{{1}, {2}, {3}, {4}},
+		[][]int{{5}, {6}, {7}, {8}})
+}
+
+func TestPartitionByError(t *testing.T) {
+	slice := bigslice.Const(2, []string{"a", "bb", "ccc", "dddd"})
+	slice = bigslice.PartitionBy(slice, 4, func(s string) int { return len(s) })
+	slice = bigslice.Reduce(bigslice.Map(slice, func(s string) (string, int) { return s, 1 }), func(a, b int) int { return a + b })
+	err := slicetest.RunErr(slice)
+	if err == nil || !strings.Contains(err.Error(), "must be in [0, 4)") {
+		t.Errorf("got %v, want partition error", err)
+	}
+}
+
+func TestPartitionByTypeError(t *testing.T) {
+	slice := bigslice.Const(1, []string{"a"}, []int{1})
+	expectTypeError(t, "partitionby: nshard must be positive; got 0", func() {
+		bigslice.PartitionBy(slice, 0, partitionByLength)
+	})
+	expectTypeError(t, "partitionby: function func(int) int does not match leading columns of input slice type slice[1]string,int", func() {
+		bigslice.PartitionBy(slice, 1, func(int) int { return 0 })
+	})
+	expectTypeError(t, "partitionby: partition function func(string) string must return a single int", func() {
+		bigslice.PartitionBy(slice, 1, func(s string) string { return s })
+	})
+	expectTypeError(t, "partitionwith: function func(int) int does not match leading columns of input slice type slice[1]string,int", func() {
+		bigslice.PartitionWith(slice, bigslice.NewPartitioning(1, func(int) int { return 0 }))
+	})
+}
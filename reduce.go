@@ -5,6 +5,7 @@
 package bigslice
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strings"
@@ -30,6 +31,11 @@ import (
 // its prefix must leave just one column as the value column to be
 // aggregated.
 //
+// If the slice is partitioned by its keys (see PartitionBy and
+// PartitionWith), Reduce
+// does not shuffle its input; instead, each shard is sorted and
+// reduced locally.
+//
 // TODO(marius): Reduce currently maintains the working set of keys
 // in memory, and is thus appropriate only where the working set can
 // fit in memory. For situations where this is not the case, Cogroup
@@ -64,12 +70,20 @@ type reduceSlice struct {
 	combiner reflect.Value
 }
 
-func (r *reduceSlice) Name() Name               { return r.name }
-func (*reduceSlice) NumDep() int                { return 1 }
-func (r *reduceSlice) Dep(i int) Dep            { return Dep{r.Slice, true, true} }
+func (r *reduceSlice) Name() Name { return r.name }
+func (*reduceSlice) NumDep() int  { return 1 }
+func (r *reduceSlice) Dep(i int) Dep {
+	if _, ok := keyPartitioning(r.Slice, r.Slice); ok {
+		return Dep{r.Slice, false, false}
+	}
+	return Dep{r.Slice, true, true}
+}
 func (r *reduceSlice) Combiner() *reflect.Value { return &r.combiner }
 
 func (r *reduceSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	if _, ok := keyPartitioning(r.Slice, r.Slice); ok {
+		return &localReduceReader{op: r, reader: deps[0]}
+	}
 	if len(deps) == 1 {
 		return deps[0]
 	}
@@ -91,3 +105,81 @@ func canMakeCombiningFrame(typ slicetype.Type) error {
 	}
 	return fmt.Errorf("cannot combine values for keys of type: %s", strings.Join(failingTypes, ", "))
 }
+
+// LocalReduceReader reduces a shard whose keys are not present in
+// any other shard. The shard is sorted by key, and runs of rows with
+// equal keys are then reduced.
+type localReduceReader struct {
+	op     *reduceSlice
+	reader sliceio.Reader
+	sorted sliceio.Reader
+	err    error
+
+	in       frame.Frame
+	beg, end int
+	eof      bool
+
+	// Acc stores the row that is currently being reduced in row 0; row
+	// 1 is used to compare keys.
+	acc    frame.Frame
+	hasAcc bool
+}
+
+func (r *localReduceReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	const spillSize = 1 << 25
+	if r.err != nil {
+		return 0, r.err
+	}
+	if !slicetype.Assignable(out, r.op) {
+		return 0, errTypeError
+	}
+	if r.sorted == nil {
+		r.sorted, r.err = sortio.SortReader(ctx, spillSize, r.op, r.reader)
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.in = frame.Make(r.op, defaultChunksize, defaultChunksize)
+		r.acc = frame.Make(r.op, 2, 2)
+	}
+	var (
+		m    int
+		vcol = r.op.NumOut() - 1
+		args = make([]reflect.Value, 2)
+	)
+	for m < out.Len() {
+		if r.beg == r.end {
+			if r.eof {
+				if r.hasAcc {
+					frame.Copy(out.Slice(m, m+1), r.acc.Slice(0, 1))
+					m++
+					r.hasAcc = false
+					continue
+				}
+				r.err = sliceio.EOF
+				break
+			}
+			n, err := r.sorted.Read(ctx, r.in)
+			if err != nil && err != sliceio.EOF {
+				r.err = err
+				return m, err
+			}
+			r.beg, r.end, r.eof = 0, n, err == sliceio.EOF
+			continue
+		}
+		row := r.in.Slice(r.beg, r.beg+1)
+		r.beg++
+		if r.hasAcc {
+			frame.Copy(r.acc.Slice(1, 2), row)
+			if !r.acc.Less(0, 1) && !r.acc.Less(1, 0) {
+				args[0], args[1] = r.acc.Index(vcol, 0), r.acc.Index(vcol, 1)
+				r.acc.Index(vcol, 0).Set(r.op.combiner.Call(args)[0])
+				continue
+			}
+			frame.Copy(out.Slice(m, m+1), r.acc.Slice(0, 1))
+			m++
+		}
+		frame.Copy(r.acc.Slice(0, 1), row)
+		r.hasAcc = true
+	}
+	return m, r.err
+}
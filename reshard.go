@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"reflect"
 
+	"github.com/grailbio/bigslice/frame"
 	"github.com/grailbio/bigslice/sliceio"
 	"github.com/grailbio/bigslice/typecheck"
 )
@@ -42,3 +43,96 @@ func (r *reshardSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
 	}
 	return deps[0]
 }
+
+type repartitionSlice struct {
+	name Name
+	Slice
+	nshard int
+}
+
+// Repartition returns a slice that redistributes the rows of the
+// provided slice into nshard shards. Rows are assigned to shards in a
+// round-robin fashion, so that the shards of the returned slice are
+// of (nearly) equal size, regardless of the slice's keys. Repartition
+// is useful to spread CPU-intensive work across more shards than the
+// slice's input provides. Unlike Reshard, Repartition does not
+// require the slice's prefix columns to be partitionable; rows with
+// equal keys may thus be assigned to different shards.
+//
+// The output slice has the same type as the input.
+func Repartition(slice Slice, nshard int) Slice {
+	if nshard < 1 {
+		typecheck.Panicf(1, "repartition: nshard must be positive; got %d", nshard)
+	}
+	return &repartitionSlice{makeName(fmt.Sprintf("repartition(%d)", nshard)), slice, nshard}
+}
+
+func (r *repartitionSlice) Name() Name             { return r.name }
+func (r *repartitionSlice) NumShard() int          { return r.nshard }
+func (*repartitionSlice) ShardType() ShardType     { return HashShard }
+func (*repartitionSlice) NumDep() int              { return 1 }
+func (r *repartitionSlice) Dep(i int) Dep          { return Dep{r.Slice, true, false} }
+func (*repartitionSlice) Combiner() *reflect.Value { return nil }
+
+// Partitioner implements ShufflePartitioner. The n'th row of a run
+// of a dependency shard is assigned to partition (shard+n) mod
+// nshard: each shard of the dependency starts at a different
+// partition, so that small shards are also spread across partitions.
+func (r *repartitionSlice) Partitioner(dep, shard int) Partitioner {
+	var n int
+	return func(f frame.Frame, i, nshard int) int {
+		p := (shard + n) % nshard
+		n++
+		return p
+	}
+}
+
+func (r *repartitionSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return deps[0]
+}
+
+type coalesceSlice struct {
+	name Name
+	Slice
+	nshard int
+}
+
+// Coalesce returns a slice that merges adjacent shards of the
+// provided slice, so that it has (at most) nshard shards. Coalesce
+// does not shuffle data: each shard of the returned slice
+// concatenates a contiguous range of the slice's shards, whose sizes
+// differ by at most one. If the slice has nshard or fewer shards,
+// its shards are retained.
+//
+// The output slice has the same type as the input.
+func Coalesce(slice Slice, nshard int) Slice {
+	if nshard < 1 {
+		typecheck.Panicf(1, "coalesce: nshard must be positive; got %d", nshard)
+	}
+	if n := slice.NumShard(); nshard > n {
+		nshard = n
+	}
+	return &coalesceSlice{makeName(fmt.Sprintf("coalesce(%d)", nshard)), slice, nshard}
+}
+
+func (c *coalesceSlice) Name() Name             { return c.name }
+func (c *coalesceSlice) NumShard() int          { return c.nshard }
+func (*coalesceSlice) ShardType() ShardType     { return HashShard }
+func (*coalesceSlice) NumDep() int              { return 1 }
+func (c *coalesceSlice) Dep(i int) Dep          { return Dep{c.Slice, false, false} }
+func (*coalesceSlice) Combiner() *reflect.Value { return nil }
+
+// DepShards implements DepShardMapper.
+func (c *coalesceSlice) DepShards(shard, dep int) []int {
+	n := c.Slice.NumShard()
+	beg, end := shard*n/c.nshard, (shard+1)*n/c.nshard
+	shards := make([]int, end-beg)
+	for i := range shards {
+		shards[i] = beg + i
+	}
+	return shards
+}
+
+func (c *coalesceSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return sliceio.MultiReader(deps...)
+}
@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/grailbio/bigslice"
@@ -90,3 +91,108 @@ func init() {
 		}
 	})
 }
+
+func TestRepartition(t *testing.T) {
+	const N = 1000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	for _, nshard := range []int{1, 7, 64} {
+		t.Run(fmt.Sprint(nshard), func(t *testing.T) {
+			slice := bigslice.Const(3, ints)
+			// Use a single key, so that hash partitioning would assign all
+			// rows to the same shard.
+			slice = bigslice.Map(slice, func(x int) (string, int) { return "x", x })
+			slice = bigslice.Repartition(slice, nshard)
+			if got, want := slice.NumShard(), nshard; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			var (
+				mu     sync.Mutex
+				counts = make([]int, nshard)
+				got    []int
+			)
+			slice = bigslice.Scan(slice, func(shard int, scanner *sliceio.Scanner) error {
+				var (
+					key string
+					val int
+				)
+				for scanner.Scan(context.Background(), &key, &val) {
+					mu.Lock()
+					counts[shard]++
+					got = append(got, val)
+					mu.Unlock()
+				}
+				return scanner.Err()
+			})
+			sess := exec.Start(exec.Local)
+			defer sess.Shutdown()
+			if _, err := sess.Run(context.Background(), bigslice.Func(func() bigslice.Slice { return slice })); err != nil {
+				t.Fatalf("run error %v", err)
+			}
+			for shard, count := range counts {
+				// Each of the 3 input shards contributes at most one
+				// additional row to each output shard.
+				if min := N/nshard - 3; count < min {
+					t.Errorf("shard %d: got %d rows, want at least %d", shard, count, min)
+				}
+			}
+			sort.Ints(got)
+			if !reflect.DeepEqual(got, ints) {
+				t.Errorf("got %v, want %v", got, ints)
+			}
+		})
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	for _, c := range []struct{ nshard, coalesce, want int }{
+		{10, 3, 3},
+		{10, 1, 1},
+		{10, 10, 10},
+		{3, 8, 3},
+	} {
+		slice := bigslice.ReaderFunc(c.nshard, func(shard int, n *int, shards []int) (int, error) {
+			if *n > 0 {
+				return 0, sliceio.EOF
+			}
+			*n = 1
+			shards[0] = shard
+			return 1, nil
+		})
+		slice = bigslice.Coalesce(slice, c.coalesce)
+		if got, want := slice.NumShard(), c.want; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		// Each output shard must read a contiguous range of input shards.
+		shards := make([][]int, c.want)
+		slice = bigslice.Scan(slice, func(shard int, scanner *sliceio.Scanner) error {
+			var in int
+			for scanner.Scan(context.Background(), &in) {
+				shards[shard] = append(shards[shard], in)
+			}
+			return scanner.Err()
+		})
+		sess := exec.Start(exec.Local)
+		if _, err := sess.Run(context.Background(), bigslice.Func(func() bigslice.Slice { return slice })); err != nil {
+			t.Fatalf("run error %v", err)
+		}
+		sess.Shutdown()
+		var next int
+		for shard, ins := range shards {
+			if len(ins) < c.nshard/c.want || len(ins) > c.nshard/c.want+1 {
+				t.Errorf("%v: shard %d: unbalanced input shards %v", c, shard, ins)
+			}
+			for _, in := range ins {
+				if in != next {
+					t.Errorf("%v: shard %d: got input shard %d, want %d", c, shard, in, next)
+				}
+				next++
+			}
+		}
+		if next != c.nshard {
+			t.Errorf("%v: got %d input shards, want %d", c, next, c.nshard)
+		}
+	}
+}
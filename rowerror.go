@@ -0,0 +1,179 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/slicetype"
+)
+
+// An ErrorPolicy determines how errors returned by user functions
+// for individual rows are handled. User functions passed to Map,
+// Filter, and Flatmap may return an error as their last value; the
+// error policy applies to these errors. Errors are always fatal to a
+// computation unless an executor is configured otherwise.
+type ErrorPolicy int
+
+const (
+	// FailOnError fails the task (and thus the computation) when a user
+	// function returns an error. Errors are retried only if they are
+	// temporary.
+	FailOnError ErrorPolicy = iota
+	// SkipOnError skips rows for which a user function returns an error,
+	// and counts them.
+	SkipOnError
+	// DeadLetterOnError skips rows for which a user function returns an
+	// error, and writes them, together with their error, as dead
+	// letters. The dead letters of a slice are typed (see
+	// DeadLetterType) and are stored with the output of the tasks that
+	// rejected them, so that they can be read after the computation has
+	// completed.
+	DeadLetterOnError
+)
+
+// String returns a textual representation of the error policy.
+func (p ErrorPolicy) String() string {
+	switch p {
+	case FailOnError:
+		return "fail"
+	case SkipOnError:
+		return "skip"
+	case DeadLetterOnError:
+		return "deadletter"
+	default:
+		return fmt.Sprintf("ErrorPolicy(%d)", int(p))
+	}
+}
+
+// DeadLetterType returns the type of the dead letters of the provided
+// slice, which must be a Map, Filter, or Flatmap slice: the columns
+// of the rows that are passed to the slice's user function, followed
+// by a string column that contains the text of the error returned for
+// each row.
+func DeadLetterType(slice Slice) slicetype.Type {
+	return slicetype.Concat(slice.Dep(0).Slice, slicetype.New(typeOfString))
+}
+
+// RowErrors collects the rows rejected by user functions while
+// evaluating a task, according to its policy. Executors provide a
+// RowErrors to the readers of a task through the task's context; see
+// WithRowErrors.
+type RowErrors struct {
+	// Policy is the error policy applied to rejected rows.
+	Policy ErrorPolicy
+	// WriteDeadLetters is called with frames of the dead letters of
+	// the named slice under the DeadLetterOnError policy. Frames are of
+	// the slice's DeadLetterType, and are written in the order in which
+	// their rows were rejected; they are not reused after they are
+	// written. Calls are serialized. If WriteDeadLetters is nil,
+	// rejected rows are only counted, as with SkipOnError.
+	WriteDeadLetters func(slice Name, f frame.Frame) error
+
+	mu          sync.Mutex
+	skipped     int64
+	deadLetters map[Name]*deadLetterBuffer
+}
+
+// DeadLetterBuffer buffers the dead letters of a slice until they are
+// written.
+type deadLetterBuffer struct {
+	typ slicetype.Type
+	f   frame.Frame
+}
+
+// Skipped returns the number of rows that were skipped because of
+// errors, including those that were written as dead letters.
+func (r *RowErrors) Skipped() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.skipped
+}
+
+// Flush writes the dead letters that are buffered. Executors should
+// flush a task's RowErrors once the task's output has been exhausted.
+func (r *RowErrors) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, buf := range r.deadLetters {
+		if err := r.flush(name, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RowErrors) flush(name Name, buf *deadLetterBuffer) error {
+	if buf.f.Len() == 0 {
+		return nil
+	}
+	f := buf.f
+	buf.f = frame.Make(buf.typ, 0, defaultChunksize)
+	return r.WriteDeadLetters(name, f)
+}
+
+type rowErrorsKey struct{}
+
+// WithRowErrors returns a context that carries the provided
+// RowErrors. Executors use this to configure the error policy of
+// the tasks that they run.
+func WithRowErrors(ctx context.Context, r *RowErrors) context.Context {
+	return context.WithValue(ctx, rowErrorsKey{}, r)
+}
+
+// RowError handles an error err returned by the user function of the
+// provided slice for the row args. It returns the error with which
+// the reader should fail, or nil if the row should be skipped.
+func rowError(ctx context.Context, slice Slice, args []reflect.Value, err error) error {
+	r, _ := ctx.Value(rowErrorsKey{}).(*RowErrors)
+	if r == nil || r.Policy == FailOnError {
+		if errors.IsTemporary(err) {
+			return err
+		}
+		return errors.E(errors.Fatal, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped++
+	if r.Policy != DeadLetterOnError || r.WriteDeadLetters == nil {
+		return nil
+	}
+	name := slice.Name()
+	buf := r.deadLetters[name]
+	if buf == nil {
+		if r.deadLetters == nil {
+			r.deadLetters = make(map[Name]*deadLetterBuffer)
+		}
+		typ := DeadLetterType(slice)
+		buf = &deadLetterBuffer{typ: typ, f: frame.Make(typ, 0, defaultChunksize)}
+		r.deadLetters[name] = buf
+	}
+	n := buf.f.Len()
+	buf.f = buf.f.Grow(1)
+	for i := range args {
+		buf.f.Index(i, n).Set(args[i])
+	}
+	buf.f.Index(len(args), n).SetString(err.Error())
+	if buf.f.Len() < defaultChunksize {
+		return nil
+	}
+	return r.flush(name, buf)
+}
+
+// ErrorResult checks whether a user function's return types ret end
+// with an error. If so, it returns the return types without the
+// error.
+func errorResult(ret slicetype.Type) (slicetype.Type, bool) {
+	n := ret.NumOut()
+	if n == 0 || ret.Out(n-1) != typeOfError {
+		return ret, false
+	}
+	return slicetype.Slice(ret, 0, n-1), true
+}
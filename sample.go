@@ -0,0 +1,380 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/sortio"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+var typeOfUint64 = reflect.TypeOf(uint64(0))
+
+// ShardRand returns a pseudo-random source for the provided seed and
+// shard. Sources are derived deterministically from the seed and
+// shard number, so that retried tasks produce identical samples, while
+// different shards produce independent samples.
+func shardRand(seed int64, shard int) *rand.Rand {
+	// Mix the seed and shard with splitmix64's finalizer.
+	x := uint64(seed) + uint64(shard+1)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return rand.New(rand.NewSource(int64(x)))
+}
+
+type sampleSlice struct {
+	name Name
+	Slice
+	fraction float64
+	seed     int64
+}
+
+// Sample returns a slice that contains a random sample of the rows of
+// the provided slice: each row is retained independently with the
+// provided probability (i.e., Bernoulli sampling). Sampling is
+// deterministic: the random numbers used for each shard are derived
+// from the seed and the shard number, so that recomputing a shard
+// (e.g., because a task was retried) produces an identical sample,
+// provided that the shard's input is itself produced in a
+// deterministic order. Its type is the same as the provided slice.
+func Sample(slice Slice, fraction float64, seed int64) Slice {
+	if fraction < 0 || fraction > 1 {
+		typecheck.Panicf(1, "sample: fraction %v is not in [0, 1]", fraction)
+	}
+	return &sampleSlice{makeName(fmt.Sprintf("sample(%v)", fraction)), slice, fraction, seed}
+}
+
+func (s *sampleSlice) Name() Name             { return s.name }
+func (*sampleSlice) NumDep() int              { return 1 }
+func (s *sampleSlice) Dep(i int) Dep          { return singleDep(i, s.Slice, false) }
+func (*sampleSlice) Combiner() *reflect.Value { return nil }
+
+func (s *sampleSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &sampleReader{op: s, reader: deps[0], rand: shardRand(s.seed, shard)}
+}
+
+type sampleReader struct {
+	op     *sampleSlice
+	reader sliceio.Reader
+	rand   *rand.Rand
+	in     frame.Frame
+	err    error
+}
+
+func (s *sampleReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if !slicetype.Assignable(out, s.op) {
+		return 0, errTypeError
+	}
+	var (
+		m   int
+		max = out.Len()
+	)
+	for m < max && s.err == nil {
+		if s.in.IsZero() {
+			s.in = frame.Make(s.op, max-m, max-m)
+		} else {
+			s.in = s.in.Ensure(max - m)
+		}
+		var n int
+		n, s.err = s.reader.Read(ctx, s.in)
+		for i := 0; i < n; i++ {
+			if s.rand.Float64() < s.op.fraction {
+				frame.Copy(out.Slice(m, m+1), s.in.Slice(i, i+1))
+				m++
+			}
+		}
+	}
+	return m, s.err
+}
+
+// SampleN returns a slice that contains a uniform random sample of
+// exactly n rows of the provided slice (or all of its rows, if it has
+// fewer than n). SampleN performs reservoir sampling: each row is
+// assigned a random priority, and each shard retains the n rows with
+// the smallest priorities, so that at most n rows per shard are
+// shuffled into the single shard of the returned slice, which then
+// selects the n rows with the smallest priorities overall. As with
+// Sample, priorities are derived from the seed and the shard number.
+// Its type is the same as the provided slice.
+func SampleN(slice Slice, n int, seed int64) Slice {
+	if n < 0 {
+		typecheck.Panicf(1, "samplen: n must be nonnegative; got %d", n)
+	}
+	var top Slice = &prioritySlice{makeName(fmt.Sprintf("samplenpriority(%d)", n)), slice, seed}
+	top = &priorityTopSlice{makeName(fmt.Sprintf("samplenshard(%d)", n)), top, n, false}
+	top = &priorityTopSlice{makeName(fmt.Sprintf("samplentop(%d)", n)), top, n, true}
+	return &unprioritySlice{makeName(fmt.Sprintf("samplen(%d)", n)), slice, top}
+}
+
+// SampleByKey returns a slice that contains a uniform random sample of
+// at most n rows for each distinct value of the provided slice's
+// prefix columns; that is, it performs stratified sampling where the
+// strata are the slice's keys. The prefix columns must be
+// partitionable. Rows are shuffled by key and then sorted by key and
+// random priority, spilling to disk as needed, so that SampleByKey
+// does not require the sampled keys to fit in memory. As with Sample,
+// priorities are derived from the seed and the shard number. Its type
+// is the same as the provided slice.
+func SampleByKey(slice Slice, n int, seed int64) Slice {
+	if n < 0 {
+		typecheck.Panicf(1, "samplebykey: n must be nonnegative; got %d", n)
+	}
+	if err := canMakeCombiningFrame(slice); err != nil {
+		typecheck.Panic(1, err.Error())
+	}
+	prio := &prioritySlice{makeName(fmt.Sprintf("samplebykeypriority(%d)", n)), slice, seed}
+	top := &sampleByKeySlice{makeName(fmt.Sprintf("samplebykeytop(%d)", n)), prio, n}
+	return &unprioritySlice{makeName(fmt.Sprintf("samplebykey(%d)", n)), slice, top}
+}
+
+// PrioritySlice amends its underlying slice with a column of random
+// priorities. The priority column is inserted immediately after the
+// underlying slice's prefix columns; the prefix is retained.
+type prioritySlice struct {
+	name Name
+	Slice
+	seed int64
+}
+
+func (p *prioritySlice) Name() Name  { return p.name }
+func (p *prioritySlice) NumOut() int { return p.Slice.NumOut() + 1 }
+func (p *prioritySlice) Out(c int) reflect.Type {
+	switch pos := p.Slice.Prefix(); {
+	case c < pos:
+		return p.Slice.Out(c)
+	case c == pos:
+		return typeOfUint64
+	default:
+		return p.Slice.Out(c - 1)
+	}
+}
+func (*prioritySlice) ShardType() ShardType     { return HashShard }
+func (*prioritySlice) NumDep() int              { return 1 }
+func (p *prioritySlice) Dep(i int) Dep          { return singleDep(i, p.Slice, false) }
+func (*prioritySlice) Combiner() *reflect.Value { return nil }
+
+func (p *prioritySlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &priorityReader{op: p, reader: deps[0], rand: shardRand(p.seed, shard)}
+}
+
+type priorityReader struct {
+	op     *prioritySlice
+	reader sliceio.Reader
+	rand   *rand.Rand
+}
+
+func (p *priorityReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if !slicetype.Assignable(out, p.op) {
+		return 0, errTypeError
+	}
+	// Read directly into the non-priority columns of out.
+	pos := p.op.Slice.Prefix()
+	cols := out.Values()
+	cols = append(cols[:pos:pos], cols[pos+1:]...)
+	n, err := p.reader.Read(ctx, frame.Values(cols))
+	for i := 0; i < n; i++ {
+		out.Index(pos, i).SetUint(p.rand.Uint64())
+	}
+	return n, err
+}
+
+// PriorityTopSlice retains the rows with the n smallest priorities of
+// its underlying slice, which must be priority-typed (see
+// prioritySlice). If single is true, the slice has a single shard,
+// which reads all of the shards of its dependency; otherwise
+// priorities are selected for each shard of the dependency.
+type priorityTopSlice struct {
+	name Name
+	Slice
+	n      int
+	single bool
+}
+
+func (p *priorityTopSlice) Name() Name { return p.name }
+func (p *priorityTopSlice) NumShard() int {
+	if p.single {
+		return 1
+	}
+	return p.Slice.NumShard()
+}
+func (*priorityTopSlice) ShardType() ShardType     { return HashShard }
+func (*priorityTopSlice) NumDep() int              { return 1 }
+func (p *priorityTopSlice) Dep(i int) Dep          { return singleDep(i, p.Slice, p.single) }
+func (*priorityTopSlice) Combiner() *reflect.Value { return nil }
+
+func (p *priorityTopSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &priorityTopReader{op: p, reader: deps[0]}
+}
+
+type priorityTopReader struct {
+	op     *priorityTopSlice
+	reader sliceio.Reader
+	top    sliceio.Reader
+}
+
+func (p *priorityTopReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if !slicetype.Assignable(out, p.op) {
+		return 0, errTypeError
+	}
+	if p.top == nil {
+		pos := p.op.Prefix()
+		less := func(f frame.Frame, i int, g frame.Frame, j int) bool {
+			return f.Index(pos, i).Uint() < g.Index(pos, j).Uint()
+		}
+		top, err := topRows(ctx, p.op, p.reader, p.op.n, less)
+		if err != nil {
+			p.top = sliceio.ErrReader(err)
+		} else {
+			p.top = sliceio.FrameReader(top)
+		}
+	}
+	return p.top.Read(ctx, out)
+}
+
+// SampleByKeySlice shuffles its underlying priority-typed slice by
+// key, and retains the rows with the n smallest priorities for each
+// key.
+type sampleByKeySlice struct {
+	name Name
+	*prioritySlice
+	n int
+}
+
+func (s *sampleByKeySlice) Name() Name             { return s.name }
+func (*sampleByKeySlice) NumDep() int              { return 1 }
+func (s *sampleByKeySlice) Dep(i int) Dep          { return singleDep(i, s.prioritySlice, true) }
+func (*sampleByKeySlice) Combiner() *reflect.Value { return nil }
+
+func (s *sampleByKeySlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &sampleByKeyReader{op: s, reader: deps[0]}
+}
+
+// PrefixType amends a slicetype.Type with a different prefix.
+type prefixType struct {
+	slicetype.Type
+	prefix int
+}
+
+func (p prefixType) Prefix() int { return p.prefix }
+
+type sampleByKeyReader struct {
+	op     *sampleByKeySlice
+	reader sliceio.Reader
+	sorted sliceio.Reader
+	err    error
+
+	in       frame.Frame
+	beg, end int
+	eof      bool
+
+	// Key stores the current key in row 0, and the key of the row
+	// under consideration in row 1.
+	key   frame.Frame
+	count int
+}
+
+func (s *sampleByKeyReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	const spillSize = 1 << 25
+	if s.err != nil {
+		return 0, s.err
+	}
+	if !slicetype.Assignable(out, s.op) {
+		return 0, errTypeError
+	}
+	pos := s.op.Prefix()
+	if s.sorted == nil {
+		// Sort by key, and then by priority.
+		typ := prefixType{s.op, pos + 1}
+		s.sorted, s.err = sortio.SortReader(ctx, spillSize, typ, s.reader)
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.in = frame.Make(typ, defaultChunksize, defaultChunksize)
+		s.key = frame.Make(s.op, 2, 2)
+	}
+	var m int
+	for m < out.Len() {
+		if s.beg == s.end {
+			if s.eof {
+				s.err = sliceio.EOF
+				break
+			}
+			n, err := s.sorted.Read(ctx, s.in)
+			if err != nil && err != sliceio.EOF {
+				s.err = err
+				return m, err
+			}
+			s.beg, s.end, s.eof = 0, n, err == sliceio.EOF
+			continue
+		}
+		row := s.in.Slice(s.beg, s.beg+1)
+		s.beg++
+		frame.Copy(s.key.Slice(1, 2), row)
+		if s.count == 0 || s.key.Less(0, 1) || s.key.Less(1, 0) {
+			frame.Copy(s.key.Slice(0, 1), row)
+			s.count = 0
+		}
+		if s.count < s.op.n {
+			frame.Copy(out.Slice(m, m+1), row)
+			m++
+		}
+		s.count++
+	}
+	return m, s.err
+}
+
+// UnprioritySlice strips the priority column from its priority-typed
+// dependency. Its type is that of the slice that was sampled.
+type unprioritySlice struct {
+	name Name
+	Slice
+	dep Slice
+}
+
+func (u *unprioritySlice) Name() Name             { return u.name }
+func (u *unprioritySlice) NumShard() int          { return u.dep.NumShard() }
+func (*unprioritySlice) ShardType() ShardType     { return HashShard }
+func (*unprioritySlice) NumDep() int              { return 1 }
+func (u *unprioritySlice) Dep(i int) Dep          { return singleDep(i, u.dep, false) }
+func (*unprioritySlice) Combiner() *reflect.Value { return nil }
+
+func (u *unprioritySlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &unpriorityReader{op: u, reader: deps[0]}
+}
+
+type unpriorityReader struct {
+	op     *unprioritySlice
+	reader sliceio.Reader
+	in     frame.Frame
+}
+
+func (u *unpriorityReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if !slicetype.Assignable(out, u.op) {
+		return 0, errTypeError
+	}
+	n := out.Len()
+	if u.in.IsZero() {
+		u.in = frame.Make(u.op.dep, n, n)
+	} else {
+		u.in = u.in.Ensure(n)
+	}
+	n, err := u.reader.Read(ctx, u.in.Slice(0, n))
+	pos := u.op.Prefix()
+	cols := u.in.Slice(0, n).Values()
+	cols = append(cols[:pos:pos], cols[pos+1:]...)
+	frame.Copy(out, frame.Values(cols))
+	return n, err
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/slicetest"
+)
+
+func TestSample(t *testing.T) {
+	const N = 10000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	for m := 1; m < 20; m += 7 {
+		slice := bigslice.Const(m, ints)
+		slice = bigslice.Sample(slice, 0.1, 123)
+		var samples [][]int
+		for _, s := range run(context.Background(), t, slice) {
+			var rows []int
+			slicetest.ScanAll(t, s, &rows)
+			if n := len(rows); n < N/20 || n > N/5 {
+				t.Errorf("implausible sample size %d", n)
+			}
+			sort.Ints(rows)
+			samples = append(samples, rows)
+		}
+		// Samples must be deterministic across executors.
+		for i := 1; i < len(samples); i++ {
+			if !reflect.DeepEqual(samples[0], samples[i]) {
+				t.Errorf("samples differ across runs")
+			}
+		}
+	}
+}
+
+func TestSampleN(t *testing.T) {
+	const N = 1000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	for _, n := range []int{0, 1, 10, N, 2 * N} {
+		for m := 1; m < 20; m += 7 {
+			t.Run(fmt.Sprintf("%d/%d", n, m), func(t *testing.T) {
+				slice := bigslice.Const(m, ints)
+				slice = bigslice.SampleN(slice, n, 123)
+				if got, want := slice.NumShard(), 1; got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+				want := n
+				if want > N {
+					want = N
+				}
+				for _, s := range run(context.Background(), t, slice) {
+					var rows []int
+					slicetest.ScanAll(t, s, &rows)
+					if got := len(rows); got != want {
+						t.Errorf("got %v, want %v", got, want)
+					}
+					seen := make(map[int]bool)
+					for _, row := range rows {
+						if seen[row] {
+							t.Errorf("duplicate row %d", row)
+						}
+						seen[row] = true
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSampleByKey(t *testing.T) {
+	const N = 1000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	for m := 1; m < 20; m += 7 {
+		// Keys 1000-1002 have fewer rows than the sample size; the
+		// remaining keys have more.
+		key := func(x int) int {
+			if x < 30 {
+				return 1000 + x%3
+			}
+			return x % 20
+		}
+		counts := make(map[int]int)
+		for _, x := range ints {
+			counts[key(x)]++
+		}
+		slice := bigslice.Const(m, ints)
+		slice = bigslice.Map(slice, func(x int) (int, int) { return key(x), x })
+		const n = 15
+		slice = bigslice.SampleByKey(slice, n, 123)
+		for _, s := range run(context.Background(), t, slice) {
+			var keys, vals []int
+			slicetest.ScanAll(t, s, &keys, &vals)
+			got := make(map[int]int)
+			seen := make(map[int]bool)
+			for i := range keys {
+				got[keys[i]]++
+				if seen[vals[i]] {
+					t.Errorf("duplicate row %d", vals[i])
+				}
+				seen[vals[i]] = true
+			}
+			for key, count := range counts {
+				want := count
+				if want > n {
+					want = n
+				}
+				if got[key] != want {
+					t.Errorf("key %d: got %v, want %v", key, got[key], want)
+				}
+			}
+		}
+	}
+}
+
+func TestSampleNames(t *testing.T) {
+	// Each stage has its own name, so that their status and counters
+	// are kept apart.
+	for _, c := range []struct {
+		slice bigslice.Slice
+		ops   []string
+	}{
+		{
+			bigslice.SampleN(bigslice.Const(2, []int{1, 2}), 1, 0),
+			[]string{"samplen(1)", "samplentop(1)", "samplenshard(1)", "samplenpriority(1)"},
+		},
+		{
+			bigslice.SampleByKey(bigslice.Const(2, []int{1, 2}), 1, 0),
+			[]string{"samplebykey(1)", "samplebykeytop(1)", "samplebykeypriority(1)"},
+		},
+	} {
+		var (
+			slice = c.slice
+			names = make(map[bigslice.Name]bool)
+		)
+		for _, op := range c.ops {
+			if got, want := slice.Name().Op, op; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			if names[slice.Name()] {
+				t.Errorf("duplicate name %v", slice.Name())
+			}
+			names[slice.Name()] = true
+			slice = slice.Dep(0).Slice
+		}
+	}
+}
+
+func TestSampleTypeError(t *testing.T) {
+	slice := bigslice.Const(1, []int{1}, []string{"a"})
+	expectTypeError(t, "sample: fraction 1.5 is not in [0, 1]", func() {
+		bigslice.Sample(slice, 1.5, 0)
+	})
+	expectTypeError(t, "samplen: n must be nonnegative; got -1", func() {
+		bigslice.SampleN(slice, -1, 0)
+	})
+	expectTypeError(t, "cannot combine values for keys of type: []int", func() {
+		bigslice.SampleByKey(bigslice.Const(1, [][]int{{1}}, []string{"a"}), 1, 0)
+	})
+}
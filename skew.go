@@ -0,0 +1,562 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/internal/sketch"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/sortio"
+)
+
+const (
+	// MaxHotKeys is the maximum number of hot keys that are salted by
+	// SkewCogroup.
+	maxHotKeys = 1024
+	// HotKeyLoad is the load, relative to the average load of a
+	// shard, beyond which a key is considered hot.
+	hotKeyLoad = 2
+)
+
+var (
+	typeOfSalt    = reflect.TypeOf(0)
+	typeOfKeyHash = reflect.TypeOf(uint64(0))
+	typeOfSample  = slicetype.New(reflect.TypeOf(0), typeOfTopKSketch, reflect.TypeOf(int64(0)))
+	typeOfHotKeys = slicetype.New(typeOfKeyHash, typeOfSalt)
+)
+
+// SkewCogroup returns a slice that is equivalent to
+// Cogroup(slices...), but which mitigates key skew in the first
+// slice, which is taken to be the large side of the cogroup.
+//
+// While the first slice is computed, the key frequencies of each of
+// its shards are summarized in a heavy-hitters sketch, which is
+// stored alongside the shard's rows. The merged sketches identify hot
+// keys: keys whose rows alone would amount to more than twice the
+// average load of a shard. (At most 1024 of the hottest keys are
+// considered.) Each hot key is then salted: its rows in the first
+// slice are spread across several cogroup shards, in proportion to
+// the key's load, and the rows of the other slices are replicated to
+// each of these shards. Finally, the partial groups of hot keys are
+// shuffled to a single shard, where they are merged as they are
+// streamed, so that the output contains exactly one row per key, as
+// in Cogroup. Rows of keys that are not hot are not moved in this
+// final step.
+//
+// SkewCogroup incurs additional costs: the first slice is
+// materialized, since it cannot be salted until the hot keys are
+// known, and the cogroup output is shuffled once more. It should thus
+// be used only when the first slice is known to be skewed.
+func SkewCogroup(slices ...Slice) Slice {
+	plain := makeCogroup(makeName("skewcogroup"), slices)
+	var (
+		stats  = skewStats(makeName("skewcogroupstats"), slices[0])
+		hot    = &hotKeySlice{name: makeName("skewcogrouphotkeys"), Type: typeOfHotKeys, stats: stats, nshard: plain.NumShard()}
+		salted = make([]Slice, len(slices))
+	)
+	for i, slice := range slices {
+		salt := &saltSlice{name: makeName("skewcogroupsalt"), Slice: slice, hot: hot, replicate: i > 0}
+		if i == 0 {
+			salt.stats = stats
+		}
+		salted[i] = salt
+	}
+	return &unsaltSlice{
+		name:   makeName("skewcogroupunsalt"),
+		Type:   plain,
+		dep:    makeCogroup(makeName("skewcogroupsalted"), salted),
+		nlarge: slices[0].NumOut() - slices[0].Prefix(),
+	}
+}
+
+// Outputs of the split returned by skewStats.
+const (
+	skewRows = iota
+	skewSample
+	numSkewOutputs
+)
+
+// SkewStats returns a split of the provided slice whose first output
+// (skewRows) comprises the slice's rows, and whose second output
+// (skewSample) comprises a single row for each shard: the shard
+// number, a TopK sketch of the key hashes of all of the shard's rows,
+// and the number of rows in the shard. Key frequencies are thus
+// summarized in the same pass that computes the slice.
+func skewStats(name Name, slice Slice) *splitSlice {
+	router := func(shard int, emit func(out int, vals []reflect.Value)) (func([]reflect.Value) error, func()) {
+		var (
+			topk  = sketch.NewTopK(maxHotKeys)
+			count int64
+			// Key holds the current row, so that its key may be hashed
+			// as it is by saltReader.
+			key = frame.Make(slice, 1, 1)
+		)
+		route := func(args []reflect.Value) error {
+			for i := range args {
+				key.Index(i, 0).Set(args[i])
+			}
+			h := hashValue(key, 0)
+			topk.Add(h, h, 1)
+			count++
+			emit(skewRows, args)
+			return nil
+		}
+		flush := func() {
+			emit(skewSample, []reflect.Value{
+				reflect.ValueOf(shard),
+				reflect.ValueOf(topk),
+				reflect.ValueOf(count),
+			})
+		}
+		return route, flush
+	}
+	outs := []slicetype.Type{slice, typeOfSample}
+	return makeSplit(name, slice, outs, router)[0].(*splitOutputSlice).split
+}
+
+// HotKeySlice computes the hot keys from the key frequencies sampled
+// by a skewStats split of the large slice. It has a single shard,
+// which reads the sample of every shard of the split; its rows
+// comprise the hash of each hot key together with the number of
+// shards (its "salt factor") across which the key should be spread.
+type hotKeySlice struct {
+	name Name
+	slicetype.Type
+	stats  *splitSlice
+	nshard int
+}
+
+func (h *hotKeySlice) Name() Name             { return h.name }
+func (*hotKeySlice) NumShard() int            { return 1 }
+func (*hotKeySlice) ShardType() ShardType     { return HashShard }
+func (*hotKeySlice) NumDep() int              { return 1 }
+func (h *hotKeySlice) Dep(i int) Dep          { return Dep{h.stats, false, false} }
+func (*hotKeySlice) Combiner() *reflect.Value { return nil }
+
+// DepShards implements DepShardMapper: the single shard reads every
+// shard of the split.
+func (h *hotKeySlice) DepShards(shard, dep int) []int {
+	shards := make([]int, h.stats.NumShard())
+	for i := range shards {
+		shards[i] = i
+	}
+	return shards
+}
+
+// DepPartition implements DepPartitioner.
+func (h *hotKeySlice) DepPartition(dep int) (Partitioner, int, int) {
+	return h.stats.partition, numSkewOutputs, skewSample
+}
+
+// DepPartitionColumns implements DepPartitionProjector.
+func (h *hotKeySlice) DepPartitionColumns(dep int) [][]int {
+	return h.stats.cols
+}
+
+func (h *hotKeySlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &hotKeyReader{op: h, reader: sliceio.MultiReader(deps...)}
+}
+
+type hotKeyReader struct {
+	op     *hotKeySlice
+	reader sliceio.Reader
+	hot    sliceio.Reader
+}
+
+func (h *hotKeyReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if !slicetype.Assignable(out, h.op) {
+		return 0, errTypeError
+	}
+	if h.hot == nil {
+		hot, err := h.compute(ctx)
+		if err != nil {
+			h.hot = sliceio.ErrReader(err)
+		} else {
+			h.hot = sliceio.FrameReader(hot)
+		}
+	}
+	return h.hot.Read(ctx, out)
+}
+
+// Compute merges the sampled sketches and returns the frame of hot
+// keys and their salt factors.
+func (h *hotKeyReader) compute(ctx context.Context) (frame.Frame, error) {
+	var (
+		topk  = sketch.NewTopK(maxHotKeys)
+		total int64
+		in    = frame.Make(typeOfSample, defaultChunksize, defaultChunksize)
+	)
+	for {
+		n, err := h.reader.Read(ctx, in)
+		if err != nil && err != sliceio.EOF {
+			return frame.Frame{}, err
+		}
+		for i := 0; i < n; i++ {
+			topk.Merge(in.Index(1, i).Interface().(*sketch.TopK))
+			total += in.Index(2, i).Int()
+		}
+		if err == sliceio.EOF {
+			break
+		}
+	}
+	if total == 0 {
+		return frame.Make(h.op, 0, 0), nil
+	}
+	// The items are sorted by decreasing frequency; retain those that
+	// are hot.
+	var (
+		items, freqs = topk.Top()
+		nshard       = int64(h.op.nshard)
+		n            int
+	)
+	for n < len(items) && int64(freqs[n])*nshard >= hotKeyLoad*total {
+		n++
+	}
+	hot := frame.Make(h.op, n, n)
+	for i := 0; i < n; i++ {
+		hot.Index(0, i).SetUint(items[i].(uint64))
+		// Spread the key in proportion to its load.
+		factor := (int64(freqs[i])*nshard + total - 1) / total
+		if factor > nshard {
+			factor = nshard
+		}
+		hot.Index(1, i).SetInt(factor)
+	}
+	return hot, nil
+}
+
+// SaltSlice amends the key of its underlying slice with a salt
+// column. Rows of hot keys (as computed by hot) are assigned salts in
+// [0, factor); rows of other keys are assigned a salt of -1. If
+// replicate is true, rows of hot keys are instead replicated, once
+// for each salt. Keys are identified by their hashes: a key whose
+// hash collides with that of a hot key is salted as well, which is
+// harmless. If stats is not nil, the underlying slice's rows are read
+// from its skewRows output.
+type saltSlice struct {
+	name Name
+	Slice
+	hot       *hotKeySlice
+	stats     *splitSlice
+	replicate bool
+}
+
+func (s *saltSlice) Name() Name  { return s.name }
+func (s *saltSlice) NumOut() int { return s.Slice.NumOut() + 1 }
+func (s *saltSlice) Out(c int) reflect.Type {
+	switch prefix := s.Slice.Prefix(); {
+	case c < prefix:
+		return s.Slice.Out(c)
+	case c == prefix:
+		return typeOfSalt
+	default:
+		return s.Slice.Out(c - 1)
+	}
+}
+func (s *saltSlice) Prefix() int            { return s.Slice.Prefix() + 1 }
+func (*saltSlice) ShardType() ShardType     { return HashShard }
+func (*saltSlice) NumDep() int              { return 2 }
+func (*saltSlice) Combiner() *reflect.Value { return nil }
+func (s *saltSlice) Dep(i int) Dep {
+	switch {
+	case i == 1:
+		return Dep{s.hot, false, false}
+	case s.stats != nil:
+		return Dep{s.stats, false, false}
+	default:
+		return Dep{s.Slice, false, false}
+	}
+}
+
+// DepShards implements DepShardMapper: each shard reads its
+// corresponding shard of the underlying slice, and the (single) shard
+// of hot keys.
+func (s *saltSlice) DepShards(shard, dep int) []int {
+	if dep == 0 {
+		return []int{shard}
+	}
+	return []int{0}
+}
+
+// DepPartition implements DepPartitioner: the rows of the underlying
+// slice are read from the skewRows partition of the split, if any;
+// other dependencies are not partitioned.
+func (s *saltSlice) DepPartition(dep int) (Partitioner, int, int) {
+	if dep == 0 && s.stats != nil {
+		return s.stats.partition, numSkewOutputs, skewRows
+	}
+	return nil, 1, 0
+}
+
+// DepPartitionColumns implements DepPartitionProjector.
+func (s *saltSlice) DepPartitionColumns(dep int) [][]int {
+	if dep == 0 && s.stats != nil {
+		return s.stats.cols
+	}
+	return nil
+}
+
+func (s *saltSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &saltReader{op: s, shard: shard, reader: deps[0], hotReader: deps[1]}
+}
+
+type saltReader struct {
+	op        *saltSlice
+	shard     int
+	reader    sliceio.Reader
+	hotReader sliceio.Reader
+	err       error
+
+	// Factors stores the salt factor of each hot key hash; counts
+	// stores the number of rows of each hot key that have been salted
+	// so far.
+	factors map[uint64]int
+	counts  map[uint64]int
+
+	in       frame.Frame
+	beg, end int
+	eof      bool
+	// Rep is the next salt of the current row, when replicating.
+	rep int
+}
+
+func (s *saltReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if !slicetype.Assignable(out, s.op) {
+		return 0, errTypeError
+	}
+	if s.factors == nil {
+		if s.err = s.loadHot(ctx); s.err != nil {
+			return 0, s.err
+		}
+		s.in = frame.Make(s.op.Slice, defaultChunksize, defaultChunksize)
+	}
+	prefix := s.op.Slice.Prefix()
+	var m int
+	for m < out.Len() {
+		if s.beg == s.end {
+			if s.eof {
+				s.err = sliceio.EOF
+				break
+			}
+			n, err := s.reader.Read(ctx, s.in)
+			if err != nil && err != sliceio.EOF {
+				s.err = err
+				return m, err
+			}
+			s.beg, s.end, s.eof = 0, n, err == sliceio.EOF
+			continue
+		}
+		var (
+			row    = s.beg
+			salt   = -1
+			hash   uint64
+			factor int
+		)
+		if len(s.factors) > 0 {
+			hash = hashValue(s.in, row)
+			factor = s.factors[hash]
+		}
+		switch {
+		case factor == 0:
+			s.beg++
+		case s.op.replicate:
+			// Emit the row once for each salt before advancing.
+			salt = s.rep
+			if s.rep++; s.rep == factor {
+				s.rep = 0
+				s.beg++
+			}
+		default:
+			// Offset salts by shard, so that shards with few rows of
+			// a key do not all assign them to the same salt.
+			salt = (s.counts[hash] + s.shard) % factor
+			s.counts[hash]++
+			s.beg++
+		}
+		for c := 0; c < s.in.NumOut(); c++ {
+			oc := c
+			if c >= prefix {
+				oc++
+			}
+			out.Index(oc, m).Set(s.in.Index(c, row))
+		}
+		out.Index(prefix, m).SetInt(int64(salt))
+		m++
+	}
+	return m, s.err
+}
+
+func (s *saltReader) loadHot(ctx context.Context) error {
+	buf := frame.Make(s.op.hot, defaultChunksize, defaultChunksize)
+	s.factors = make(map[uint64]int)
+	s.counts = make(map[uint64]int)
+	for {
+		n, err := s.hotReader.Read(ctx, buf)
+		if err != nil && err != sliceio.EOF {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			s.factors[buf.Index(0, i).Uint()] = int(buf.Index(1, i).Int())
+		}
+		if err == sliceio.EOF {
+			return nil
+		}
+	}
+}
+
+// UnsaltSlice merges the output of a salted cogroup into the output
+// of the corresponding (unsalted) cogroup. Rows of keys that are not
+// hot are retained in their shard; the partial groups of hot keys are
+// shuffled to a single shard, where they are merged.
+type unsaltSlice struct {
+	name Name
+	slicetype.Type
+	dep *cogroupSlice
+	// Nlarge is the number of group columns that derive from the
+	// (salted) large slice; the remaining group columns derive from
+	// replicated slices.
+	nlarge int
+}
+
+func (u *unsaltSlice) Name() Name             { return u.name }
+func (u *unsaltSlice) NumShard() int          { return u.dep.NumShard() }
+func (*unsaltSlice) ShardType() ShardType     { return HashShard }
+func (*unsaltSlice) NumDep() int              { return 1 }
+func (u *unsaltSlice) Dep(i int) Dep          { return Dep{u.dep, true, true} }
+func (*unsaltSlice) Combiner() *reflect.Value { return nil }
+
+// Partitioner implements ShufflePartitioner.
+func (u *unsaltSlice) Partitioner(dep, shard int) Partitioner {
+	prefix := u.Prefix()
+	return func(f frame.Frame, i, nshard int) int {
+		if f.Index(prefix, i).Int() < 0 {
+			return shard
+		}
+		return int(f.Prefixed(prefix).Hash(i)) % nshard
+	}
+}
+
+func (u *unsaltSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &unsaltReader{op: u, readers: deps}
+}
+
+// UnsaltReader merges the (sorted) cogroup outputs read from each
+// shard of the salted cogroup, so that the partial groups of each hot
+// key are read consecutively. The partial groups are merged as they
+// are read; rows of keys that are not hot are passed through.
+type unsaltReader struct {
+	op      *unsaltSlice
+	readers []sliceio.Reader
+	merged  sliceio.Reader
+	err     error
+
+	in       frame.Frame
+	beg, end int
+	eof      bool
+
+	// Acc stores the hot key row that is currently being merged in row
+	// 0; row 1 is used to compare keys.
+	acc    frame.Frame
+	hasAcc bool
+}
+
+func (u *unsaltReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	if !slicetype.Assignable(out, u.op) {
+		return 0, errTypeError
+	}
+	if u.merged == nil {
+		if len(u.readers) == 1 {
+			u.merged = u.readers[0]
+		} else {
+			u.merged, u.err = sortio.NewMergeReader(ctx, u.op.dep, u.readers)
+			if u.err != nil {
+				return 0, u.err
+			}
+		}
+		u.in = frame.Make(u.op.dep, defaultChunksize, defaultChunksize)
+		u.acc = frame.Make(u.op, 2, 2)
+	}
+	prefix := u.op.Prefix()
+	var m int
+	for m < out.Len() {
+		if u.beg == u.end {
+			if u.eof {
+				if u.hasAcc {
+					frame.Copy(out.Slice(m, m+1), u.acc.Slice(0, 1))
+					m++
+					u.hasAcc = false
+					continue
+				}
+				u.err = sliceio.EOF
+				break
+			}
+			n, err := u.merged.Read(ctx, u.in)
+			if err != nil && err != sliceio.EOF {
+				u.err = err
+				return m, err
+			}
+			u.beg, u.end, u.eof = 0, n, err == sliceio.EOF
+			continue
+		}
+		i := u.beg
+		u.beg++
+		// Drop the salt column.
+		for c := 0; c < u.op.NumOut(); c++ {
+			ic := c
+			if c >= prefix {
+				ic++
+			}
+			u.acc.Index(c, 1).Set(u.in.Index(ic, i))
+		}
+		hot := u.in.Index(prefix, i).Int() >= 0
+		if u.hasAcc {
+			if hot && !u.acc.Less(0, 1) && !u.acc.Less(1, 0) {
+				u.merge()
+				continue
+			}
+			frame.Copy(out.Slice(m, m+1), u.acc.Slice(0, 1))
+			m++
+			u.hasAcc = false
+			// Process the row again, as out may now be full.
+			u.beg--
+			continue
+		}
+		if !hot {
+			frame.Copy(out.Slice(m, m+1), u.acc.Slice(1, 2))
+			m++
+			continue
+		}
+		frame.Copy(u.acc.Slice(0, 1), u.acc.Slice(1, 2))
+		// Cap the large groups so that appending to them in merge does
+		// not write to the input's backing arrays.
+		for c := prefix; c < prefix+u.op.nlarge; c++ {
+			col := u.acc.Index(c, 0)
+			col.Set(col.Slice3(0, col.Len(), col.Len()))
+		}
+		u.hasAcc = true
+	}
+	return m, u.err
+}
+
+// Merge merges the partial group in row 1 of the accumulator into
+// row 0. The groups of the large slice are concatenated; the groups
+// of replicated slices are identical in each partial group.
+func (u *unsaltReader) merge() {
+	prefix := u.op.Prefix()
+	for c := prefix; c < prefix+u.op.nlarge; c++ {
+		col := u.acc.Index(c, 0)
+		col.Set(reflect.AppendSlice(col, u.acc.Index(c, 1)))
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+)
+
+func TestSkewCogroupSalts(t *testing.T) {
+	const nshard = 8
+	var (
+		keys   []string
+		values []int
+	)
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, "hot")
+		values = append(values, i)
+	}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, fmt.Sprint("cold", i))
+		values = append(values, -i)
+	}
+	var (
+		ctx   = context.Background()
+		large = Const(1, keys, values)
+		stats = skewStats(Name{Op: "stats"}, large)
+		hot   = &hotKeySlice{Type: typeOfHotKeys, stats: stats, nshard: nshard}
+		rows  = frame.Slices(keys, values).Prefixed(1)
+	)
+
+	hashes, factors := hotKeys(t, stats, hot, rows)
+	if got, want := len(hashes), 1; got != want {
+		t.Fatalf("got %v hot keys, want %v", got, want)
+	}
+	if got, want := hashes[0], hashValue(rows, 0); got != want {
+		t.Errorf("got hot key hash %x, want %x", got, want)
+	}
+	if got, want := factors[0], nshard; got != want {
+		t.Errorf("got salt factor %v, want %v", got, want)
+	}
+
+	var (
+		hotKeys = frame.Slices(hashes, factors)
+		salt    = &saltSlice{Slice: large, hot: hot}
+		skeys   []string
+		salts   []int
+		svalues []int
+	)
+	r := salt.Reader(0, []sliceio.Reader{sliceio.FrameReader(rows), sliceio.FrameReader(hotKeys)})
+	if err := sliceio.ReadAll(ctx, r, &skeys, &salts, &svalues); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[int]int)
+	for i := range skeys {
+		switch {
+		case skeys[i] != "hot" && salts[i] != -1:
+			t.Errorf("cold key %v was salted with %v", skeys[i], salts[i])
+		case skeys[i] == "hot":
+			counts[salts[i]]++
+		}
+	}
+	for s := 0; s < nshard; s++ {
+		if got, want := counts[s], 1000/nshard; got != want {
+			t.Errorf("salt %v: got %v rows, want %v", s, got, want)
+		}
+	}
+
+	salt.replicate = true
+	skeys, salts, svalues = nil, nil, nil
+	r = salt.Reader(0, []sliceio.Reader{sliceio.FrameReader(rows.Slice(0, 1)), sliceio.FrameReader(hotKeys)})
+	if err := sliceio.ReadAll(ctx, r, &skeys, &salts, &svalues); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := salts, []int{0, 1, 2, 3, 4, 5, 6, 7}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got replicated salts %v, want %v", got, want)
+	}
+}
+
+// TestSkewCogroupClustered tests that hot keys are found when they
+// are clustered at the end of a large shard.
+func TestSkewCogroupClustered(t *testing.T) {
+	const (
+		nshard = 8
+		n      = 100000
+	)
+	var (
+		keys   = make([]string, 2*n)
+		values = make([]int, 2*n)
+	)
+	for i := 0; i < n; i++ {
+		keys[i] = fmt.Sprint("cold", i%10)
+		keys[n+i] = "hot"
+	}
+	var (
+		large = Const(1, keys, values)
+		stats = skewStats(Name{Op: "stats"}, large)
+		hot   = &hotKeySlice{Type: typeOfHotKeys, stats: stats, nshard: nshard}
+		rows  = frame.Slices(keys, values).Prefixed(1)
+	)
+	hashes, factors := hotKeys(t, stats, hot, rows)
+	if got, want := len(hashes), 1; got != want {
+		t.Fatalf("got %v hot keys, want %v", got, want)
+	}
+	if got, want := hashes[0], hashValue(rows, n); got != want {
+		t.Errorf("got hot key hash %x, want %x", got, want)
+	}
+	if got, want := factors[0], nshard/2; got != want {
+		t.Errorf("got salt factor %v, want %v", got, want)
+	}
+}
+
+// HotKeys computes the hot keys of the provided rows, comprising a
+// single shard of the large slice, through the provided stats split
+// and hot key slice. It also checks that the split's skewRows output
+// retains all of the rows.
+func hotKeys(t *testing.T, stats *splitSlice, hot *hotKeySlice, rows frame.Frame) (hashes []uint64, factors []int) {
+	t.Helper()
+	ctx := context.Background()
+	var (
+		r     = stats.Reader(0, []sliceio.Reader{sliceio.FrameReader(rows)})
+		split = frame.Make(stats, rows.Len()+1, rows.Len()+1)
+	)
+	n, err := sliceio.ReadFull(ctx, r, split)
+	if err != nil && err != sliceio.EOF {
+		t.Fatal(err)
+	}
+	var (
+		samples = frame.Make(typeOfSample, 0, 1)
+		nrows   int
+	)
+	for i := 0; i < n; i++ {
+		switch int(split.Index(0, i).Int()) {
+		case skewRows:
+			nrows++
+		case skewSample:
+			samples = samples.Grow(1)
+			for j, col := range stats.cols[skewSample] {
+				samples.Index(j, samples.Len()-1).Set(split.Index(col, i))
+			}
+		}
+	}
+	if got, want := nrows, rows.Len(); got != want {
+		t.Errorf("got %v rows, want %v", got, want)
+	}
+	if got, want := samples.Len(), 1; got != want {
+		t.Fatalf("got %v samples, want %v", got, want)
+	}
+	if got, want := samples.Index(2, 0).Int(), int64(rows.Len()); got != want {
+		t.Errorf("got sample count %v, want %v", got, want)
+	}
+	r = hot.Reader(0, []sliceio.Reader{sliceio.FrameReader(samples)})
+	if err := sliceio.ReadAll(ctx, r, &hashes, &factors); err != nil {
+		t.Fatal(err)
+	}
+	return hashes, factors
+}
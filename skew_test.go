@@ -0,0 +1,182 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/slicetest"
+)
+
+func TestSkewCogroup(t *testing.T) {
+	const N = 1000
+	var (
+		keys   []string
+		values []int
+	)
+	// Key "hot" accounts for most of the rows.
+	for i := 0; i < N; i++ {
+		keys = append(keys, "hot")
+		values = append(values, i)
+	}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, fmt.Sprint("cold", i))
+		values = append(values, -i)
+	}
+	large := bigslice.Const(4, keys, values)
+	small := bigslice.Const(2, []string{"hot", "cold0", "cold1", "none"}, []string{"h", "c0", "c1", "n"})
+	slice := bigslice.SkewCogroup(large, small)
+	slice = bigslice.Map(slice, func(key string, vals []int, tags []string) (string, []int, []string) {
+		sort.Ints(vals)
+		return key, vals, tags
+	})
+
+	var (
+		wantKeys = []string{"cold0", "cold1", "none", "hot"}
+		wantVals = [][]int{{0}, {-1}, nil, values[:N]}
+		wantTags = [][]string{{"c0"}, {"c1"}, {"n"}, {"h"}}
+	)
+	for i := 2; i < 10; i++ {
+		wantKeys = append(wantKeys, fmt.Sprint("cold", i))
+		wantVals = append(wantVals, []int{-i})
+		wantTags = append(wantTags, nil)
+	}
+	assertEqual(t, slice, true, wantKeys, wantVals, wantTags)
+}
+
+// sortGroups sorts the groups of the cogroup of a large slice of
+// (string, int) rows and a small slice of (string, string) rows.
+func sortGroups(slice bigslice.Slice) bigslice.Slice {
+	return bigslice.Map(slice, func(key string, vals []int, tags []string) (string, []int, []string) {
+		sort.Ints(vals)
+		sort.Strings(tags)
+		return key, vals, tags
+	})
+}
+
+func TestSkewCogroupMatchesCogroup(t *testing.T) {
+	var (
+		keys   []string
+		values []int
+		tags   []string
+		small  []string
+	)
+	// Key "hot" accounts for most of the rows of the large slice, and
+	// so is salted across most of its 8 shards. Every key has several
+	// rows on each side.
+	for i := 0; i < 2000; i++ {
+		keys = append(keys, "hot")
+		values = append(values, i)
+	}
+	for i := 0; i < 50; i++ {
+		for j := 0; j < 3; j++ {
+			keys = append(keys, fmt.Sprint("cold", i))
+			values = append(values, i*10+j)
+		}
+	}
+	for j := 0; j < 4; j++ {
+		small = append(small, "hot", "cold0", "cold7", "none")
+		tags = append(tags, fmt.Sprint("h", j), fmt.Sprint("c0", j), fmt.Sprint("c7", j), fmt.Sprint("n", j))
+	}
+
+	var (
+		wantKeys []string
+		wantVals [][]int
+		wantTags [][]string
+	)
+	slicetest.RunAndScan(t, sortGroups(bigslice.Cogroup(
+		bigslice.Const(8, keys, values),
+		bigslice.Const(2, small, tags),
+	)), &wantKeys, &wantVals, &wantTags)
+	if got, want := len(wantKeys), 52; got != want {
+		t.Fatalf("got %v keys, want %v", got, want)
+	}
+
+	slice := sortGroups(bigslice.SkewCogroup(
+		bigslice.Const(8, keys, values),
+		bigslice.Const(2, small, tags),
+	))
+	assertEqual(t, slice, true, wantKeys, wantVals, wantTags)
+}
+
+func TestSkewCogroupPrefixed(t *testing.T) {
+	var (
+		keys   []string
+		subs   []int
+		values []int
+	)
+	// Keys ("hot", 0) and ("hot", 1) are both hot.
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, "hot")
+		subs = append(subs, i%2)
+		values = append(values, i)
+	}
+	for i := 0; i < 20; i++ {
+		keys = append(keys, "cold", "cold")
+		subs = append(subs, i, i)
+		values = append(values, -i, -i-100)
+	}
+	small := func() bigslice.Slice {
+		return bigslice.Prefixed(bigslice.Const(3,
+			[]string{"hot", "hot", "hot", "cold", "cold"},
+			[]int{0, 1, 0, 3, 3},
+			[]string{"a", "b", "c", "d", "e"},
+		), 2)
+	}
+	count := func(slice bigslice.Slice) bigslice.Slice {
+		return bigslice.Map(slice, func(key string, sub int, vals []int, tags []string) (string, int, int, int) {
+			return key, sub, len(vals), len(tags)
+		})
+	}
+
+	var (
+		wantKeys   []string
+		wantSubs   []int
+		wantVals   []int
+		wantTags   []int
+		large      = bigslice.Prefixed(bigslice.Const(8, keys, subs, values), 2)
+		largeAgain = bigslice.Prefixed(bigslice.Const(8, keys, subs, values), 2)
+	)
+	slicetest.RunAndScan(t, count(bigslice.Cogroup(large, small())),
+		&wantKeys, &wantSubs, &wantVals, &wantTags)
+	slice := count(bigslice.SkewCogroup(largeAgain, small()))
+	assertEqual(t, slice, true, wantKeys, wantSubs, wantVals, wantTags)
+}
+
+func TestSkewCogroupUniform(t *testing.T) {
+	var (
+		keys   []string
+		values []int
+	)
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprint(i%10))
+		values = append(values, i)
+	}
+	slice := bigslice.SkewCogroup(bigslice.Const(3, keys, values))
+	slice = bigslice.Map(slice, func(key string, vals []int) (string, int) {
+		return key, len(vals)
+	})
+	var (
+		wantKeys   []string
+		wantCounts []int
+	)
+	for i := 0; i < 10; i++ {
+		wantKeys = append(wantKeys, fmt.Sprint(i))
+		wantCounts = append(wantCounts, 10)
+	}
+	assertEqual(t, slice, true, wantKeys, wantCounts)
+}
+
+func TestSkewCogroupTypeError(t *testing.T) {
+	expectTypeError(t, "skewcogroup: expected at least one slice", func() {
+		bigslice.SkewCogroup()
+	})
+	expectTypeError(t, "skewcogroup: key column type mismatch: expected string but got int", func() {
+		bigslice.SkewCogroup(bigslice.Const(1, []string{"a"}), bigslice.Const(1, []int{1}))
+	})
+}
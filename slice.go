@@ -45,6 +45,62 @@ type Dep struct {
 	Expand bool
 }
 
+// A DepShardMapper is implemented by slices whose (non-shuffle)
+// dependencies are not read shard-for-shard. By default, shard i of
+// a slice reads shard i of each of its non-shuffle dependencies;
+// DepShards instead returns the shards of dependency dep that are
+// read by the provided shard of the slice. The slice's Reader is
+// provided with a reader for each of the returned shards, for each
+// dependency, in order. Slices that implement DepShardMapper are
+// never pipelined with their dependencies.
+type DepShardMapper interface {
+	DepShards(shard, dep int) []int
+}
+
+// A Partitioner returns the partition, in [0, nshard), of row i of
+// frame f. Returning a partition outside of this range fails the
+// computation.
+type Partitioner func(f frame.Frame, i, nshard int) int
+
+// A ShufflePartitioner is implemented by slices that assign the rows
+// of their shuffle dependencies to partitions with a custom
+// partitioner, rather than by hashing the rows' prefix columns.
+// Partitioner is called each time that a shard of dependency dep is
+// computed, and the returned partitioner is called for the shard's
+// rows in order, so that partitioners may maintain per-run state.
+// Partitioners must assign partitions deterministically, given the
+// shard and the order of its rows, so that a shard that is
+// recomputed, for example because its machine was lost, partitions
+// its rows as before.
+type ShufflePartitioner interface {
+	Partitioner(dep, shard int) Partitioner
+}
+
+// A DepPartitioner is a DepShardMapper whose non-shuffle
+// dependencies are partitioned, so that each of the slice's shards
+// reads only a single partition of the dependency shards it is
+// mapped to. DepPartition returns, for dependency dep, the
+// partitioner that assigns the dependency's rows to one of
+// npartition partitions, and the partition that is read by the
+// slice. Slices that share a dependency must agree on its
+// partitioning.
+type DepPartitioner interface {
+	DepShardMapper
+	DepPartition(dep int) (partitioner Partitioner, npartition, partition int)
+}
+
+// A DepPartitionProjector is a DepPartitioner whose dependency's
+// partitions each store only some of the dependency's columns.
+// DepPartitionColumns returns, for dependency dep, the columns that
+// are stored in each of the dependency's partitions, indexed by
+// partition. The slice's Reader is provided with rows that comprise
+// only the columns of the partition that it reads. Slices that share
+// a dependency must agree on its projection.
+type DepPartitionProjector interface {
+	DepPartitioner
+	DepPartitionColumns(dep int) [][]int
+}
+
 // ShardType indicates the type of sharding used by a Slice.
 type ShardType int
 
@@ -101,6 +157,14 @@ type Slice interface {
 
 // Pragma comprises runtime directives used during bigslice
 // execution.
+//
+// A Pragma may also implement the method
+//
+//	Recompute() bool
+//
+// which indicates, if it returns true, that the slice should be
+// recomputed by each of the slices that depend on it, i.e. that it
+// should not be materialized when it has multiple dependents.
 type Pragma interface {
 	// Exclusive indicates that a slice task should be given
 	// exclusive access to the underlying machine.
@@ -133,6 +197,31 @@ func (p Pragmas) Materialize() bool {
 	return false
 }
 
+// Recompute returns true if any of the underlying Pragmas implements
+// Recompute and returns true.
+func (p Pragmas) Recompute() bool {
+	for _, q := range p {
+		if q, ok := q.(interface{ Recompute() bool }); ok && q.Recompute() {
+			return true
+		}
+	}
+	return false
+}
+
+// SlicePragma is embedded by the slices that are given pragmas. It
+// implements Pragma, together with its optional methods, by
+// delegating to the embedded Pragma, so that these are available on
+// the slice itself.
+type slicePragma struct {
+	Pragma
+}
+
+// Recompute implements the optional Recompute method of Pragma.
+func (p slicePragma) Recompute() bool {
+	q, ok := p.Pragma.(interface{ Recompute() bool })
+	return ok && q.Recompute()
+}
+
 type exclusive struct{}
 
 func (exclusive) Exclusive() bool   { return true }
@@ -156,13 +245,27 @@ func (materialize) Materialize() bool { return true }
 // It is tagged "experimental" because we are considering other ways of
 // achieving this.
 //
-// TODO(jcharumilind): Consider doing this automatically for slices on which
-// multiple slices depend.
+// Slices on which multiple slices depend are materialized
+// automatically, unless they are given the Recompute pragma.
 var ExperimentalMaterialize Pragma = materialize{}
 
+type recompute struct{}
+
+func (recompute) Exclusive() bool   { return false }
+func (recompute) Materialize() bool { return false }
+func (recompute) Recompute() bool   { return true }
+
+// Recompute is a Pragma that indicates that a slice should not be
+// materialized when multiple slices depend on it; instead, it is
+// pipelined into, and thus recomputed by, each of its dependents.
+// This is useful for slices that are cheap to compute relative to
+// the cost of materializing their output.
+var Recompute Pragma = recompute{}
+
 type constSlice struct {
 	name Name
 	slicetype.Type
+	slicePragma
 	frame  frame.Frame
 	nshard int
 }
@@ -176,6 +279,8 @@ func Const(nshard int, columns ...interface{}) Slice {
 	}
 	s := new(constSlice)
 	s.name = makeName("const")
+	// Constant slices are cheaper to recompute than to materialize.
+	s.Pragma = Recompute
 	s.nshard = nshard
 	if s.nshard < 1 {
 		typecheck.Panic(1, "const: shard must be >= 1")
@@ -243,7 +348,7 @@ func (s *constSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
 
 type readerFuncSlice struct {
 	name Name
-	Pragma
+	slicePragma
 	slicetype.Type
 	nshard    int
 	read      reflect.Value
@@ -327,7 +432,7 @@ func (r *readerFuncSliceReader) Read(ctx context.Context, out frame.Frame) (n in
 	}
 	// out is passed to a user, zero it.
 	out.Zero()
-	rvs := r.op.read.Call(append([]reflect.Value{reflect.ValueOf(r.shard), r.state}, out.Values()...))
+	rvs := callTimed(funcTimer(ctx), r.op.read, append([]reflect.Value{reflect.ValueOf(r.shard), r.state}, out.Values()...))
 	n = int(rvs[0].Int())
 	if n == 0 {
 		r.consecutiveEmptyCalls++
@@ -497,10 +602,13 @@ func (s *writerFuncSlice) Reader(shard int, reader []sliceio.Reader) sliceio.Rea
 
 type mapSlice struct {
 	name Name
-	Pragma
+	slicePragma
 	Slice
 	fval reflect.Value
 	out  slicetype.Type
+	args funcArgs
+	// Errs is true when fval returns an error as its last value.
+	errs bool
 }
 
 // Map transforms a slice by invoking a function for each record. The
@@ -512,6 +620,31 @@ type mapSlice struct {
 // Schematically:
 //
 //	Map(Slice<t1, t2, ..., tn>, func(v1 t1, v2 t2, ..., vn tn) (r1, r2, ..., rn)) Slice<r1, r2, ..., rn>
+//
+// The function fn may also accept the shard number and a per-shard
+// state as its first two arguments:
+//
+//	func(shard int, state stateType, v1 t1, v2 t2, ..., vn tn) (r1, r2, ..., rn)
+//
+// As with ReaderFunc, the state is a zero value (allocated, if it is
+// a pointer) upon the first invocation of fn for a shard, and is
+// reused for the remaining invocations for that shard. This permits
+// the function to maintain expensive resources across a shard. If the
+// state implements io.Closer, it is closed when the shard has been
+// fully read, or when reading fails; an error returned by Close fails
+// the computation. The state is also closed, ignoring errors, when it
+// is abandoned before the shard has been fully read, for example by
+// Head.
+//
+// The function fn may also accept a context.Context as its first
+// argument (preceding the shard and state, if any), in which case it
+// is passed the context of the task that evaluates the slice. The
+// context is canceled if the task is abandoned, and carries the
+// task's metadata (see TaskName, TaskShard, and TaskInvocation).
+//
+// The function fn may also return an error as its last value, in
+// which case the row is handled according to the session's error
+// policy (see ErrorPolicy).
 func Map(slice Slice, fn interface{}, prags ...Pragma) Slice {
 	m := new(mapSlice)
 	m.name = makeName("map")
@@ -521,9 +654,10 @@ func Map(slice Slice, fn interface{}, prags ...Pragma) Slice {
 	if !ok {
 		typecheck.Panicf(1, "map: invalid map function %T", fn)
 	}
-	if !typecheck.Equal(slice, arg) {
+	if m.args, ok = parseFuncArgs(arg, slice); !ok {
 		typecheck.Panicf(1, "map: function %T does not match input slice type %s", fn, slicetype.String(slice))
 	}
+	ret, m.errs = errorResult(ret)
 	if ret.NumOut() == 0 {
 		typecheck.Panicf(1, "map: need at least one output column")
 	}
@@ -544,6 +678,7 @@ type mapReader struct {
 	op     *mapSlice
 	reader sliceio.Reader // parent reader
 	in     frame.Frame    // buffer for input column vectors
+	state  shardState
 	err    error
 }
 
@@ -568,30 +703,52 @@ func (m *mapReader) Read(ctx context.Context, out frame.Frame) (int, error) {
 	// computation.
 	//
 	// TODO(marius): provide a vectorized version of map for efficiency.
-	args := make([]reflect.Value, m.in.NumOut())
+	k := m.state.nargs()
+	args := make([]reflect.Value, k+m.in.NumOut())
+	m.state.args(ctx, args)
+	var nout int
+	start := m.state.startCalls()
 	for i := 0; i < n; i++ {
 		// Gather the arguments for a single invocation.
-		for j := range args {
-			args[j] = m.in.Index(j, i)
+		for j := k; j < len(args); j++ {
+			args[j] = m.in.Index(j-k, i)
 		}
 		// TODO(marius): consider using an unsafe copy here
-		result := m.op.fval.Call(args)
+		result := m.state.call(m.op.fval, args)
+		if m.op.errs {
+			last := len(result) - 1
+			if err, _ := result[last].Interface().(error); err != nil {
+				if err = rowError(ctx, m.op, args[k:], err); err != nil {
+					m.err = err
+					break
+				}
+				continue
+			}
+			result = result[:last]
+		}
 		for j := range result {
-			out.Index(j, i).Set(result[j])
+			out.Index(j, nout).Set(result[j])
 		}
+		nout++
+	}
+	m.state.endCalls(start)
+	if m.err != nil {
+		m.err = m.state.close(m.err)
 	}
-	return n, m.err
+	return nout, m.err
 }
 
 func (m *mapSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
-	return &mapReader{op: m, reader: deps[0]}
+	return &mapReader{op: m, reader: deps[0], state: shardState{funcArgs: m.args, name: m.name, shard: shard}}
 }
 
 type filterSlice struct {
 	name Name
-	Pragma
+	slicePragma
 	Slice
 	pred reflect.Value
+	args funcArgs
+	errs bool
 }
 
 // Filter returns a slice where the provided predicate is applied to
@@ -604,6 +761,10 @@ type filterSlice struct {
 // Schematically:
 //
 //	Filter(Slice<t1, t2, ..., tn>, func(t1, t2, ..., tn) bool) Slice<t1, t2, ..., tn>
+//
+// As with Map, the predicate may also accept a context, a shard
+// number and per-shard state, or both, as its leading arguments, and
+// may return an error as its last value.
 func Filter(slice Slice, pred interface{}, prags ...Pragma) Slice {
 	f := new(filterSlice)
 	f.name = makeName("filter")
@@ -614,9 +775,10 @@ func Filter(slice Slice, pred interface{}, prags ...Pragma) Slice {
 	if !ok {
 		typecheck.Panicf(1, "filter: invalid predicate function %T", pred)
 	}
-	if !typecheck.Equal(slice, arg) {
+	if f.args, ok = parseFuncArgs(arg, slice); !ok {
 		typecheck.Panicf(1, "filter: function %T does not match input slice type %s", pred, slicetype.String(slice))
 	}
+	ret, f.errs = errorResult(ret)
 	if ret.NumOut() != 1 || ret.Out(0).Kind() != reflect.Bool {
 		typecheck.Panic(1, "filter: predicate must return a single boolean value")
 	}
@@ -632,6 +794,7 @@ type filterReader struct {
 	op     *filterSlice
 	reader sliceio.Reader
 	in     frame.Frame
+	state  shardState
 	err    error
 }
 
@@ -646,7 +809,9 @@ func (f *filterReader) Read(ctx context.Context, out frame.Frame) (n int, err er
 		m   int
 		max = out.Len()
 	)
-	args := make([]reflect.Value, out.NumOut())
+	k := f.state.nargs()
+	args := make([]reflect.Value, k+out.NumOut())
+	f.state.args(ctx, args)
 	for m < max && f.err == nil {
 		// TODO(marius): this can get pretty inefficient when the accept
 		// rate is low: as we fill the output; we could degenerate into a
@@ -658,29 +823,46 @@ func (f *filterReader) Read(ctx context.Context, out frame.Frame) (n int, err er
 			f.in = f.in.Ensure(max - m)
 		}
 		n, f.err = f.reader.Read(ctx, f.in)
+		start := f.state.startCalls()
 		for i := 0; i < n; i++ {
-			for j := range args {
-				args[j] = f.in.Value(j).Index(i)
+			for j := k; j < len(args); j++ {
+				args[j] = f.in.Value(j - k).Index(i)
 			}
-			if f.op.pred.Call(args)[0].Bool() {
+			result := f.state.call(f.op.pred, args)
+			if f.op.errs {
+				if err, _ := result[1].Interface().(error); err != nil {
+					if err = rowError(ctx, f.op, args[k:], err); err != nil {
+						f.err = err
+						break
+					}
+					continue
+				}
+			}
+			if result[0].Bool() {
 				frame.Copy(out.Slice(m, m+1), f.in.Slice(i, i+1))
 				m++
 			}
 		}
+		f.state.endCalls(start)
+	}
+	if f.err != nil {
+		f.err = f.state.close(f.err)
 	}
 	return m, f.err
 }
 
 func (f *filterSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
-	return &filterReader{op: f, reader: deps[0]}
+	return &filterReader{op: f, reader: deps[0], state: shardState{funcArgs: f.args, name: f.name, shard: shard}}
 }
 
 type flatmapSlice struct {
 	name Name
-	Pragma
+	slicePragma
 	Slice
 	fval reflect.Value
 	out  slicetype.Type
+	args funcArgs
+	errs bool
 }
 
 // Flatmap returns a Slice that applies the function fn to each
@@ -692,6 +874,10 @@ type flatmapSlice struct {
 // Schematically:
 //
 //	Flatmap(Slice<t1, t2, ..., tn>, func(v1 t1, v2 t2, ..., vn tn) ([]r1, []r2, ..., []rn)) Slice<r1, r2, ..., rn>
+//
+// As with Map, the function fn may also accept a context, a shard
+// number and per-shard state, or both, as its leading arguments, and
+// may return an error as its last value.
 func Flatmap(slice Slice, fn interface{}, prags ...Pragma) Slice {
 	f := new(flatmapSlice)
 	f.name = makeName("flatmap")
@@ -702,9 +888,10 @@ func Flatmap(slice Slice, fn interface{}, prags ...Pragma) Slice {
 	if !ok {
 		typecheck.Panicf(1, "flatmap: invalid flatmap function %T", fn)
 	}
-	if !typecheck.Equal(slice, arg) {
+	if f.args, ok = parseFuncArgs(arg, slice); !ok {
 		typecheck.Panicf(1, "flatmap: flatmap function %T does not match input slice type %s", fn, slicetype.String(slice))
 	}
+	ret, f.errs = errorResult(ret)
 	f.out, ok = typecheck.Devectorize(ret)
 	if !ok {
 		typecheck.Panicf(1, "flatmap: flatmap function %T is not vectorized", fn)
@@ -728,13 +915,20 @@ type flatmapReader struct {
 	begIn, endIn int
 	out          frame.Frame // buffer of outputs
 	eof          bool
+	state        shardState
+	err          error
 }
 
 func (f *flatmapReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
 	if !slicetype.Assignable(out, f.op) {
 		return 0, errTypeError
 	}
-	args := make([]reflect.Value, f.op.Slice.NumOut())
+	k := f.state.nargs()
+	args := make([]reflect.Value, k+f.op.Slice.NumOut())
+	f.state.args(ctx, args)
 	begOut, endOut := 0, out.Len()
 	// Add buffered output from last call, if any.
 	if f.out.Len() > 0 {
@@ -756,18 +950,32 @@ func (f *flatmapReader) Read(ctx context.Context, out frame.Frame) (int, error)
 			}
 			n, err := f.reader.Read(ctx, f.in)
 			if err != nil && err != sliceio.EOF {
-				return 0, err
+				return 0, f.state.close(err)
 			}
 			f.begIn, f.endIn = 0, n
 			f.eof = err == sliceio.EOF
 		}
 		// Consume one input at a time, as long as we have space in our
 		// output buffer.
+		start := f.state.startCalls()
 		for ; f.begIn < f.endIn && begOut < endOut; f.begIn++ {
-			for j := range args {
-				args[j] = f.in.Index(j, f.begIn)
+			for j := k; j < len(args); j++ {
+				args[j] = f.in.Index(j-k, f.begIn)
 			}
-			result := frame.Values(f.op.fval.Call(args))
+			results := f.state.call(f.op.fval, args)
+			if f.op.errs {
+				last := len(results) - 1
+				if err, _ := results[last].Interface().(error); err != nil {
+					if err = rowError(ctx, f.op, args[k:], err); err != nil {
+						f.state.endCalls(start)
+						f.err = f.state.close(err)
+						return begOut, f.err
+					}
+					continue
+				}
+				results = results[:last]
+			}
+			result := frame.Values(results)
 			n := frame.Copy(out.Slice(begOut, endOut), result)
 			begOut += n
 			// We've run out of output space. In this case, stash the rest of
@@ -776,18 +984,19 @@ func (f *flatmapReader) Read(ctx context.Context, out frame.Frame) (int, error)
 				f.out = result.Slice(n, m)
 			}
 		}
+		f.state.endCalls(start)
 	}
 	var err error
 	// We're EOF if we've encountered an EOF from the underlying
 	// reader, there's no buffered output, and no buffered input.
 	if f.eof && f.out.Len() == 0 && f.begIn == f.endIn {
-		err = sliceio.EOF
+		err = f.state.close(sliceio.EOF)
 	}
 	return begOut, err
 }
 
 func (f *flatmapSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
-	return &flatmapReader{op: f, reader: deps[0]}
+	return &flatmapReader{op: f, reader: deps[0], state: shardState{funcArgs: f.args, name: f.name, shard: shard}}
 }
 
 type foldSlice struct {
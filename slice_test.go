@@ -14,6 +14,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"text/tabwriter"
 
@@ -23,7 +24,10 @@ import (
 	"github.com/grailbio/bigmachine/testsystem"
 	"github.com/grailbio/bigslice"
 	"github.com/grailbio/bigslice/exec"
+	"github.com/grailbio/bigslice/frame"
 	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetest"
+	"github.com/grailbio/bigslice/stats"
 	"github.com/grailbio/bigslice/typecheck"
 )
 
@@ -614,12 +618,70 @@ func TestMap(t *testing.T) {
 	assertEqual(t, slice, false, output)
 }
 
+func TestMapFuncTimer(t *testing.T) {
+	const N = 1000
+	input := make([]int, N)
+	slice := bigslice.Const(1, input)
+	mapped := bigslice.Map(slice, func(i int) int { return i })
+	filtered := bigslice.Filter(mapped, func(i int) bool { return true })
+	timer := stats.NewTimer()
+	ctx := bigslice.WithFuncTimer(context.Background(), timer)
+	var r sliceio.Reader = sliceio.FrameReader(frame.Slices(input))
+	r = mapped.Reader(0, []sliceio.Reader{r})
+	r = filtered.Reader(0, []sliceio.Reader{r})
+	// Read in small batches, each of which is timed separately.
+	out := frame.Make(filtered, 7, 7)
+	var n int
+	for {
+		m, err := r.Read(ctx, out)
+		n += m
+		if err == sliceio.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := n, N; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// Each row is passed to both the map and the filter function.
+	if got, want := timer.Distribution().Count, int64(2*N); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// BenchmarkMapFuncTimer measures the overhead of timing the calls to
+// a Map's function (see WithFuncTimer).
+func BenchmarkMapFuncTimer(b *testing.B) {
+	const N = 1 << 16
+	input := make([]int, N)
+	slice := bigslice.Map(bigslice.Const(1, input), func(i int) int { return i })
+	for _, timed := range []bool{false, true} {
+		b.Run(fmt.Sprintf("timed=%v", timed), func(b *testing.B) {
+			ctx := context.Background()
+			if timed {
+				ctx = bigslice.WithFuncTimer(ctx, stats.NewTimer())
+			}
+			out := frame.Make(slice, N, N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r := slice.Reader(0, []sliceio.Reader{sliceio.FrameReader(frame.Slices(input))})
+				if _, err := sliceio.ReadFull(ctx, r, out); err != nil && err != sliceio.EOF {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestMapError(t *testing.T) {
 	input := bigslice.Const(1, []string{"x", "y"})
 	expectTypeError(t, "map: invalid map function int", func() { bigslice.Map(input, 123) })
 	expectTypeError(t, "map: function func(int) string does not match input slice type slice[1]string", func() { bigslice.Map(input, func(x int) string { return "" }) })
 	expectTypeError(t, "map: function func(int, int) string does not match input slice type slice[1]string", func() { bigslice.Map(input, func(x, y int) string { return "" }) })
 	expectTypeError(t, "map: need at least one output column", func() { bigslice.Map(input, func(x string) {}) })
+	expectTypeError(t, "map: need at least one output column", func() { bigslice.Map(input, func(x string) error { return nil }) })
 }
 
 func TestFilter(t *testing.T) {
@@ -716,6 +778,19 @@ func TestFlatmap(t *testing.T) {
 	assertEqual(t, slice, true, []string{"1024", "5000"})
 }
 
+func TestFlatmapRowError(t *testing.T) {
+	slice := bigslice.Const(2, []string{"a,b", "", "c"})
+	slice = bigslice.Flatmap(slice, func(s string) ([]string, error) {
+		if s == "" {
+			return nil, errors.New("empty row")
+		}
+		return strings.Split(s, ","), nil
+	})
+	if err := slicetest.RunErr(slice); err == nil || !strings.Contains(err.Error(), "empty row") {
+		t.Errorf("got %v, want empty row error", err)
+	}
+}
+
 func TestFlatmapBuffered(t *testing.T) {
 	zeros := make([]int, 1025)
 	slice := bigslice.Const(1, []int{0})
@@ -735,6 +810,133 @@ func TestFlatmapError(t *testing.T) {
 
 }
 
+// ShardState is used to test stateful map, filter, and flatmap
+// functions. Closed counts the number of states that have been
+// closed.
+type shardState struct{ n int }
+
+var shardStateClosed int32
+
+func (s *shardState) Close() error {
+	atomic.AddInt32(&shardStateClosed, 1)
+	return nil
+}
+
+func TestStatefulFuncs(t *testing.T) {
+	const (
+		N      = 1000
+		Nshard = 4
+	)
+	input := make([]int, N)
+	for i := range input {
+		input[i] = i
+	}
+	var (
+		// Count rows in each shard, numbering them by shard.
+		mapped = bigslice.Map(bigslice.Const(Nshard, input), func(shard int, state *shardState, i int) (int, int) {
+			state.n++
+			return shard, state.n
+		})
+		// Retain every other row in each shard.
+		filtered = bigslice.Filter(mapped, func(shard int, state *shardState, _, _ int) bool {
+			state.n++
+			return state.n%2 == 0
+		})
+		// Emit a row only for each change of shard.
+		flatmapped = bigslice.Flatmap(filtered, func(shard int, state *shardState, s, n int) []int {
+			if state.n++; state.n == 1 {
+				return []int{s}
+			}
+			return nil
+		})
+	)
+	atomic.StoreInt32(&shardStateClosed, 0)
+	var shards []int
+	slicetest.ScanAll(t, slicetest.Run(t, flatmapped), &shards)
+	sort.Ints(shards)
+	if got, want := shards, []int{0, 1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := atomic.LoadInt32(&shardStateClosed), int32(3*Nshard); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	atomic.StoreInt32(&shardStateClosed, 0)
+	var (
+		ss []int
+		ns []int
+	)
+	slicetest.ScanAll(t, slicetest.Run(t, filtered), &ss, &ns)
+	// Each shard should retain the rows numbered 2, 4, 6, ...
+	counts := make(map[int][]int)
+	for i := range ss {
+		counts[ss[i]] = append(counts[ss[i]], ns[i])
+	}
+	var total int
+	for shard, ns := range counts {
+		sort.Ints(ns)
+		for i := range ns {
+			if got, want := ns[i], 2*(i+1); got != want {
+				t.Errorf("shard %d: got %v, want %v", shard, got, want)
+			}
+		}
+		total += len(ns)
+	}
+	if total < N/2-Nshard || total > N/2 {
+		t.Errorf("unexpected number of rows %d", total)
+	}
+
+	// Head abandons the map's readers before they are fully read; their
+	// states are closed nonetheless.
+	atomic.StoreInt32(&shardStateClosed, 0)
+	slicetest.ScanAll(t, slicetest.Run(t, bigslice.Head(mapped, 1)), &ss, &ns)
+	if got, want := len(ss), Nshard; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := atomic.LoadInt32(&shardStateClosed), int32(Nshard); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestContextFuncs(t *testing.T) {
+	// TaskOK tells whether ctx carries the metadata of a task that
+	// computes one of the 4 shards of the named operation.
+	taskOK := func(ctx context.Context, op string) bool {
+		name, shard := bigslice.TaskName(ctx), bigslice.TaskShard(ctx)
+		return strings.Contains(name, op) && strings.HasSuffix(name, fmt.Sprintf("@4:%d", shard)) &&
+			bigslice.TaskInvocation(ctx) > 0
+	}
+	slice := bigslice.Const(4, []int{1, 2, 3, 4, 5, 6, 7, 8})
+	slice = bigslice.Map(slice, func(ctx context.Context, i int) (int, bool) {
+		return i, taskOK(ctx, "map")
+	})
+	slice = bigslice.Filter(slice, func(ctx context.Context, shard int, state *shardState, i int, ok bool) bool {
+		return ok && shard == bigslice.TaskShard(ctx) && i%2 == 0
+	})
+	slice = bigslice.Flatmap(slice, func(ctx context.Context, i int, ok bool) ([]string, []bool) {
+		return []string{fmt.Sprint(i), fmt.Sprint(-i)}, []bool{ok, ctx.Err() == nil}
+	})
+	assertEqual(t, slice, true,
+		[]string{"-2", "-4", "-6", "-8", "2", "4", "6", "8"},
+		[]bool{true, true, true, true, true, true, true, true})
+
+	if got, want := bigslice.TaskShard(context.Background()), -1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// ErrCloser is a state that fails to close.
+type errCloser struct{}
+
+func (errCloser) Close() error { return errors.New("close error") }
+
+func TestStatefulFuncCloseError(t *testing.T) {
+	slice := bigslice.Map(bigslice.Const(2, []int{1, 2, 3}), func(shard int, _ errCloser, i int) int { return i })
+	if err := slicetest.RunErr(slice); err == nil || !strings.Contains(err.Error(), "close error") {
+		t.Errorf("got %v, want close error", err)
+	}
+}
+
 func TestFold(t *testing.T) {
 	const N = 10000
 	fz := fuzz.New()
@@ -45,15 +45,30 @@ var Path = os.ExpandEnv("$HOME/.bigslice/config")
 // creation fails. Parse also instantiates the default http server
 // according to the configuration profile, and registers the bigslice
 // session status handlers with it.
+//
+// If the -explain flag is provided, the returned session prints the
+// physical plan of the first computation submitted to it instead of
+// running it, and then exits; see exec.ExplainOnly.
 func Parse() (sess *exec.Session, shutdown func()) {
 	log.AddFlags()
 	local := flag.Bool("local", false, "run bigslice in local mode")
+	explain := flag.String("explain", "", "print the physical plan of the first computation in the given format (text, json, or dot) instead of running it")
 	config.RegisterFlags("", Path)
 	flag.Parse()
 	must.Nil(config.ProcessFlags())
-	if *local {
+	switch {
+	case *explain != "":
+		// Nothing is run, so there is no need for a cluster. The program
+		// exits once the plan is written, since its later computations
+		// cannot be explained.
+		exit := func() {
+			sess.Shutdown()
+			os.Exit(0)
+		}
+		sess = exec.Start(exec.Local, exec.Status(new(status.Status)), exec.ExplainOnly(os.Stdout, *explain, exit))
+	case *local:
 		sess = exec.Start(exec.Local, exec.Status(new(status.Status)))
-	} else {
+	default:
 		bigmachine.Bootstrap()
 		config.Must("bigslice", &sess)
 	}
@@ -67,21 +67,35 @@ func newGobDecoder(r io.Reader) *gobDecoder {
 type Encoder struct {
 	enc *gobEncoder
 	crc hash.Hash32
+	w   *errorWriter
 }
 
 // NewEncoder returns a a new Encoder that streams slices into the
 // provided writer.
 func NewEncoder(w io.Writer) *Encoder {
 	crc := crc32.NewIEEE()
+	ew := &errorWriter{Writer: w}
 	return &Encoder{
-		enc: newGobEncoder(io.MultiWriter(w, crc)),
+		enc: newGobEncoder(io.MultiWriter(ew, crc)),
 		crc: crc,
+		w:   ew,
 	}
 }
 
 // Encode encodes a batch of rows and writes the encoded output into
-// the encoder's writer.
+// the encoder's writer. Errors that arise from encoding the rows
+// themselves, rather than from writing them, are deterministic and
+// are returned with severity errors.Fatal, so that they are not
+// retried.
 func (e *Encoder) Encode(f frame.Frame) error {
+	err := e.encode(f)
+	if err != nil && e.w.err == nil {
+		err = errors.E(errors.Fatal, "sliceio: encode", err)
+	}
+	return err
+}
+
+func (e *Encoder) encode(f frame.Frame) error {
 	e.crc.Reset()
 	if err := e.enc.Encode(f.Len()); err != nil {
 		return err
@@ -104,6 +118,21 @@ func (e *Encoder) Encode(f frame.Frame) error {
 	return e.enc.Encode(e.crc.Sum32())
 }
 
+// ErrorWriter is an io.Writer that records the first error returned
+// by its underlying writer.
+type errorWriter struct {
+	io.Writer
+	err error
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
 // DecodingReader provides a Reader on top of a gob stream
 // encoded with batches of rows stored in column-major order.
 type decodingReader struct {
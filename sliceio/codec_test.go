@@ -225,6 +225,34 @@ func TestDecodingReaderCorrupted(t *testing.T) {
 	}
 }
 
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.E(errors.Net, "write failed")
+}
+
+func TestEncodeErrors(t *testing.T) {
+	type opaque struct{ x int }
+	err := NewEncoder(new(bytes.Buffer)).Encode(frame.Slices([]opaque{{1}}))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := errors.Recover(err).Severity, errors.Fatal; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Errors from the underlying writer are returned as-is.
+	err = NewEncoder(failWriter{}).Encode(frame.Slices([]int{1, 2, 3}))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if errors.Recover(err).Severity == errors.Fatal {
+		t.Errorf("write error %v is fatal", err)
+	}
+	if !errors.Is(errors.Net, err) {
+		t.Errorf("got %v, want net error", err)
+	}
+}
+
 func TestDecodingSlices(t *testing.T) {
 	// Gob will reuse slices during decoding if we're not careful.
 	var b bytes.Buffer
@@ -71,8 +71,10 @@ func (m *multiReader) Read(ctx context.Context, out frame.Frame) (n int, err err
 		n, err := m.q[0].Read(ctx, out)
 		switch {
 		case err == EOF:
-			err = nil
 			m.q = m.q[1:]
+			if n > 0 {
+				return n, nil
+			}
 		case err != nil:
 			m.err = err
 			return n, err
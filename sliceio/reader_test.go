@@ -44,6 +44,26 @@ func TestFrameReader(t *testing.T) {
 	}
 }
 
+func TestMultiReader(t *testing.T) {
+	var (
+		fz  = fuzz.NewWithSeed(12345)
+		f   = fuzzFrame(fz, 1000, typeOfString)
+		r   = MultiReader(FrameReader(f.Slice(0, 10)), FrameReader(f.Slice(10, 1000)))
+		out = frame.Make(f, 1000, 1000)
+		ctx = context.Background()
+	)
+	n, err := ReadFull(ctx, r, out)
+	if err != nil && err != EOF {
+		t.Fatal(err)
+	}
+	if got, want := n, 1000; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(f.Interface(0).([]string), out.Interface(0).([]string)) {
+		t.Error("frames do not match")
+	}
+}
+
 // FuzzFrame creates a fuzzed frame of length n, where columns
 // have the provided types.
 func fuzzFrame(fz *fuzz.Fuzzer, n int, types ...reflect.Type) frame.Frame {
@@ -0,0 +1,24 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package slicetest
+
+import (
+	"context"
+
+	"github.com/grailbio/bigslice/exec"
+)
+
+// ReplayTask re-runs, in-process, a failed task that was captured
+// to the provided path by a session configured with
+// exec.CaptureFailures. The task is run with its captured inputs, so
+// that its failure may be reproduced, for example under a debugger,
+// without rerunning the computation that led to it. ReplayTask
+// returns the error with which the replayed task fails; panics are
+// not recovered. The task must be replayed by a binary that defines
+// the same bigslice Funcs as the one that captured it; see
+// exec.ReplayTask for details.
+func ReplayTask(path string) error {
+	return exec.ReplayTask(context.Background(), path)
+}
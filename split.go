@@ -0,0 +1,317 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+// Split returns n slices that partition the provided slice: each row
+// of the input slice is routed to the output slice whose index is
+// returned by the router function fn. The input slice is computed
+// only once, regardless of how many of the outputs are used.
+// Returning an index outside of [0, n) fails the computation.
+//
+// The rows of all of the outputs are computed together, in frames
+// that have the columns of every output, so that the memory used to
+// compute a split grows with the number of its outputs. The rows of
+// each output are however stored and shuffled with only that
+// output's columns.
+//
+// Schematically:
+//
+//	Split(Slice<t1, t2, ..., tn>, n, func(t1, t2, ..., tn) int) []Slice<t1, t2, ..., tn>
+func Split(slice Slice, n int, fn interface{}) []Slice {
+	name := makeName("split")
+	if n < 1 {
+		typecheck.Panicf(1, "split: n must be positive; got %d", n)
+	}
+	arg, ret, ok := typecheck.Func(fn)
+	if !ok {
+		typecheck.Panicf(1, "split: invalid router function %T", fn)
+	}
+	if !typecheck.Equal(slice, arg) {
+		typecheck.Panicf(1, "split: function %T does not match input slice type %s", fn, slicetype.String(slice))
+	}
+	if ret.NumOut() != 1 || ret.Out(0).Kind() != reflect.Int {
+		typecheck.Panicf(1, "split: router function %T must return a single int", fn)
+	}
+	outs := make([]slicetype.Type, n)
+	for i := range outs {
+		outs[i] = slice
+	}
+	fval := reflect.ValueOf(fn)
+	router := func(shard int, emit func(out int, vals []reflect.Value)) (func([]reflect.Value) error, func()) {
+		return func(args []reflect.Value) error {
+			out := int(fval.Call(args)[0].Int())
+			if out < 0 || out >= n {
+				return errors.E(errors.Fatal, fmt.Sprintf("split: router function returned output %d; must be in [0, %d)", out, n))
+			}
+			emit(out, args)
+			return nil
+		}, nil
+	}
+	return makeSplit(name, slice, outs, router)
+}
+
+// SplitEmit returns slices that are populated by the function fn,
+// which is invoked for each row of the provided slice. The function
+// is passed the row's columns, followed by an emitter function for
+// each output slice. The number of emitter functions determines the
+// number of returned slices, and the arguments of each emitter
+// determine the type of the corresponding slice. The function may
+// emit any number of rows to each output. The input slice is
+// computed only once, regardless of how many of the outputs are
+// used.
+//
+// As with Split, the rows of all of the outputs are computed in
+// frames that have the columns of every output, but each output's
+// rows are stored and shuffled with only that output's columns.
+//
+// Schematically:
+//
+//	SplitEmit(Slice<t1, ..., tn>, func(t1, ..., tn, func(r11, ..., r1m), ..., func(rk1, ..., rkm))) (Slice<r11, ..., r1m>, ..., Slice<rk1, ..., rkm>)
+func SplitEmit(slice Slice, fn interface{}) []Slice {
+	name := makeName("splitemit")
+	arg, ret, ok := typecheck.Func(fn)
+	if !ok {
+		typecheck.Panicf(1, "splitemit: invalid function %T", fn)
+	}
+	nout := arg.NumOut() - slice.NumOut()
+	if nout < 1 || !typecheck.Equal(slice, slicetype.Slice(arg, 0, slice.NumOut())) {
+		typecheck.Panicf(1, "splitemit: function %T does not match input slice type %s, followed by emitter functions", fn, slicetype.String(slice))
+	}
+	if ret.NumOut() != 0 {
+		typecheck.Panicf(1, "splitemit: function %T must not return values", fn)
+	}
+	var (
+		outs     = make([]slicetype.Type, nout)
+		emitters = make([]reflect.Type, nout)
+	)
+	for i := range outs {
+		t := arg.Out(slice.NumOut() + i)
+		if t.Kind() != reflect.Func || t.NumIn() == 0 || t.NumOut() != 0 || t.IsVariadic() {
+			typecheck.Panicf(1, "splitemit: invalid emitter function %s for output %d", t, i)
+		}
+		types := make([]reflect.Type, t.NumIn())
+		for j := range types {
+			types[j] = t.In(j)
+		}
+		outs[i] = slicetype.New(types...)
+		emitters[i] = t
+	}
+	fval := reflect.ValueOf(fn)
+	router := func(shard int, emit func(out int, vals []reflect.Value)) (func([]reflect.Value) error, func()) {
+		emitFuncs := make([]reflect.Value, len(emitters))
+		for i := range emitters {
+			i := i
+			emitFuncs[i] = reflect.MakeFunc(emitters[i], func(vals []reflect.Value) []reflect.Value {
+				emit(i, vals)
+				return nil
+			})
+		}
+		var callArgs []reflect.Value
+		return func(args []reflect.Value) error {
+			callArgs = append(append(callArgs[:0], args...), emitFuncs...)
+			fval.Call(callArgs)
+			return nil
+		}, nil
+	}
+	return makeSplit(name, slice, outs, router)
+}
+
+// MakeSplit returns the output slices of a split of the provided
+// slice into outputs of the provided types, as routed by router.
+func makeSplit(name Name, slice Slice, outs []slicetype.Type, router splitRouter) []Slice {
+	split := &splitSlice{name: name, Slice: slice, router: router, cols: make([][]int, len(outs))}
+	// Each row of the split slice comprises the output index, followed
+	// by the columns of every output; only the columns of the selected
+	// output are populated, and only these are stored in the output's
+	// partition.
+	types := []reflect.Type{typeOfSplitIndex}
+	for i, out := range outs {
+		for _, typ := range slicetype.Columns(out) {
+			split.cols[i] = append(split.cols[i], len(types))
+			types = append(types, typ)
+		}
+	}
+	split.typ = slicetype.New(types...)
+	slices := make([]Slice, len(outs))
+	for i := range slices {
+		outName := name
+		outName.Op = fmt.Sprintf("%s%d", name.Op, i)
+		slices[i] = &splitOutputSlice{name: outName, Type: outs[i], split: split, out: i}
+	}
+	return slices
+}
+
+var typeOfSplitIndex = reflect.TypeOf(0)
+
+// A splitRouter returns a function that is invoked for each row of a
+// split's input, and which emits the row's output rows through emit.
+// It may also return a flush function, which is invoked once the
+// input is exhausted, and which may emit further rows. A router is
+// instantiated once per shard.
+type splitRouter func(shard int, emit func(out int, vals []reflect.Value)) (route func(args []reflect.Value) error, flush func())
+
+// SplitSlice computes the rows of all of the outputs of a split. Its
+// rows are partitioned by their output index, so that each output
+// reads only its own rows.
+type splitSlice struct {
+	name Name
+	Slice
+	typ slicetype.Type
+	// Cols holds the columns of each output.
+	cols   [][]int
+	router splitRouter
+}
+
+func (s *splitSlice) Name() Name             { return s.name }
+func (s *splitSlice) NumOut() int            { return s.typ.NumOut() }
+func (s *splitSlice) Out(c int) reflect.Type { return s.typ.Out(c) }
+func (*splitSlice) Prefix() int              { return 1 }
+func (*splitSlice) ShardType() ShardType     { return HashShard }
+func (*splitSlice) NumDep() int              { return 1 }
+func (s *splitSlice) Dep(i int) Dep          { return singleDep(i, s.Slice, false) }
+func (*splitSlice) Combiner() *reflect.Value { return nil }
+func (s *splitSlice) partition(f frame.Frame, i, nshard int) int {
+	return int(f.Index(0, i).Int())
+}
+
+func (s *splitSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	r := &splitReader{op: s, reader: deps[0]}
+	r.route, r.flush = s.router(shard, r.emit)
+	return r
+}
+
+type splitReader struct {
+	op     *splitSlice
+	reader sliceio.Reader
+	route  func([]reflect.Value) error
+	flush  func()
+	err    error
+
+	in       frame.Frame
+	beg, end int
+	eof      bool
+
+	// Buf stores the rows that have been emitted but not yet read.
+	buf frame.Frame
+	n   int
+	// Flushed is true once the router has been flushed.
+	flushed bool
+}
+
+func (s *splitReader) emit(out int, vals []reflect.Value) {
+	switch {
+	case s.buf.IsZero():
+		s.buf = frame.Make(s.op, defaultChunksize, defaultChunksize)
+	case s.n == s.buf.Len():
+		s.buf = s.buf.Ensure(2 * s.buf.Len())
+	}
+	s.buf.Index(0, s.n).SetInt(int64(out))
+	for i, col := range s.op.cols[out] {
+		s.buf.Index(col, s.n).Set(vals[i])
+	}
+	s.n++
+}
+
+func (s *splitReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if !slicetype.Assignable(out, s.op) {
+		return 0, errTypeError
+	}
+	if s.in.IsZero() {
+		s.in = frame.Make(s.op.Slice, defaultChunksize, defaultChunksize)
+	}
+	args := make([]reflect.Value, s.op.Slice.NumOut())
+	for s.n < out.Len() && !(s.eof && s.beg == s.end) {
+		if s.beg == s.end {
+			n, err := s.reader.Read(ctx, s.in)
+			if err != nil && err != sliceio.EOF {
+				s.err = err
+				return 0, err
+			}
+			s.beg, s.end, s.eof = 0, n, err == sliceio.EOF
+			continue
+		}
+		for j := range args {
+			args[j] = s.in.Index(j, s.beg)
+		}
+		s.beg++
+		if err := s.route(args); err != nil {
+			s.err = err
+			return 0, err
+		}
+	}
+	if s.eof && s.beg == s.end && !s.flushed {
+		s.flushed = true
+		if s.flush != nil {
+			s.flush()
+		}
+	}
+	if s.n == 0 {
+		if s.eof && s.beg == s.end {
+			s.err = sliceio.EOF
+		}
+		return 0, s.err
+	}
+	n := frame.Copy(out, s.buf.Slice(0, s.n))
+	if n < s.n {
+		// Retain the remaining rows in a fresh buffer, so that the
+		// buffer does not retain references to rows already read.
+		s.buf = frame.AppendFrame(frame.Make(s.op, 0, s.buf.Len()), s.buf.Slice(n, s.n))
+		s.n -= n
+	} else {
+		s.buf, s.n = frame.Frame{}, 0
+	}
+	if s.eof && s.beg == s.end && s.n == 0 {
+		s.err = sliceio.EOF
+	}
+	return n, s.err
+}
+
+// SplitOutputSlice is an output of a split. It reads its partition of
+// the split slice, which stores only the output's columns.
+type splitOutputSlice struct {
+	name Name
+	slicetype.Type
+	split *splitSlice
+	out   int
+}
+
+func (s *splitOutputSlice) Name() Name             { return s.name }
+func (s *splitOutputSlice) NumShard() int          { return s.split.NumShard() }
+func (*splitOutputSlice) ShardType() ShardType     { return HashShard }
+func (*splitOutputSlice) NumDep() int              { return 1 }
+func (s *splitOutputSlice) Dep(i int) Dep          { return Dep{s.split, false, false} }
+func (*splitOutputSlice) Combiner() *reflect.Value { return nil }
+
+// DepShards implements DepShardMapper.
+func (*splitOutputSlice) DepShards(shard, dep int) []int { return []int{shard} }
+
+// DepPartition implements DepPartitioner.
+func (s *splitOutputSlice) DepPartition(dep int) (Partitioner, int, int) {
+	return s.split.partition, len(s.split.cols), s.out
+}
+
+// DepPartitionColumns implements DepPartitionProjector.
+func (s *splitOutputSlice) DepPartitionColumns(dep int) [][]int {
+	return s.split.cols
+}
+
+func (s *splitOutputSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return deps[0]
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice_test
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/slicetest"
+)
+
+func TestSplit(t *testing.T) {
+	const N = 1000
+	var (
+		keys = make([]string, N)
+		vals = make([]int, N)
+	)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+		vals[i] = i
+	}
+	slice := bigslice.Const(7, keys, vals)
+	outs := bigslice.Split(slice, 3, func(key string, val int) int { return val % 3 })
+	if got, want := len(outs), 3; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, out := range outs {
+		var (
+			keys []string
+			vals []int
+		)
+		for j := i; j < N; j += 3 {
+			keys = append(keys, fmt.Sprint(j))
+			vals = append(vals, j)
+		}
+		assertEqual(t, out, true, keys, vals)
+	}
+}
+
+func TestSplitEmit(t *testing.T) {
+	slice := bigslice.Const(3, []string{"a", "bb", "ccc", "dddd"}, []int{1, 2, 3, 4})
+	outs := bigslice.SplitEmit(slice, func(key string, val int, odd func(string, int), lengths func(string, []byte, bool)) {
+		if val%2 == 1 {
+			odd(key, val)
+		}
+		for i := 0; i < len(key); i++ {
+			lengths(fmt.Sprint(key, i), []byte(key[:i+1]), i == len(key)-1)
+		}
+	})
+	if got, want := len(outs), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	assertEqual(t, outs[0], true, []string{"a", "ccc"}, []int{1, 3})
+	var (
+		keys   []string
+		prefix [][]byte
+		last   []bool
+	)
+	for _, key := range []string{"a", "bb", "ccc", "dddd"} {
+		for i := 0; i < len(key); i++ {
+			keys = append(keys, fmt.Sprint(key, i))
+			prefix = append(prefix, []byte(key[:i+1]))
+			last = append(last, i == len(key)-1)
+		}
+	}
+	assertEqual(t, outs[1], true, keys, prefix, last)
+}
+
+func TestSplitOnce(t *testing.T) {
+	var ncall int64
+	slice := bigslice.Const(4, []int{1, 2, 3, 4, 5, 6, 7, 8})
+	slice = bigslice.Map(slice, func(i int) (string, int) {
+		atomic.AddInt64(&ncall, 1)
+		return fmt.Sprint(i % 2), i
+	})
+	outs := bigslice.Split(slice, 2, func(key string, val int) int { return val % 2 })
+	slice = bigslice.Cogroup(outs[0], outs[1])
+	var (
+		keys        []string
+		odds, evens [][]int
+	)
+	slicetest.RunAndScan(t, slice, &keys, &evens, &odds)
+	if got, want := atomic.LoadInt64(&ncall), int64(8); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(keys), 2; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, key := range keys {
+		n := len(evens[i]) + len(odds[i])
+		if got, want := n, 4; got != want {
+			t.Errorf("key %s: got %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestSplitError(t *testing.T) {
+	slice := bigslice.Const(2, []int{1, 2, 3, 4})
+	outs := bigslice.Split(slice, 2, func(i int) int { return i })
+	err := slicetest.RunErr(outs[0])
+	if err == nil || !strings.Contains(err.Error(), "must be in [0, 2)") {
+		t.Errorf("got %v, want router error", err)
+	}
+}
+
+func TestSplitTypeError(t *testing.T) {
+	input := bigslice.Const(1, []string{"x"}, []int{1})
+	expectTypeError(t, "split: n must be positive; got 0", func() { bigslice.Split(input, 0, func(string, int) int { return 0 }) })
+	expectTypeError(t, "split: invalid router function int", func() { bigslice.Split(input, 2, 123) })
+	expectTypeError(t, "split: function func(int) int does not match input slice type slice[1]string,int", func() { bigslice.Split(input, 2, func(int) int { return 0 }) })
+	expectTypeError(t, "split: router function func(string, int) string must return a single int", func() { bigslice.Split(input, 2, func(string, int) string { return "" }) })
+	expectTypeError(t, "splitemit: function func(string, int) does not match input slice type slice[1]string,int, followed by emitter functions", func() { bigslice.SplitEmit(input, func(string, int) {}) })
+	expectTypeError(t, "splitemit: function func(string, int, func(int)) int must not return values", func() { bigslice.SplitEmit(input, func(string, int, func(int)) int { return 0 }) })
+	expectTypeError(t, "splitemit: invalid emitter function func(...int) for output 1", func() { bigslice.SplitEmit(input, func(string, int, func(int), func(...int)) {}) })
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/stats"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// FuncArgs describes the optional leading arguments of a user
+// function that is invoked for each row of a slice.
+type funcArgs struct {
+	// Context is true if the function accepts the reader's context as
+	// its first argument.
+	context bool
+	// StateType is the type of the function's per-shard state, if it
+	// accepts a shard number and state; it is nil otherwise.
+	stateType reflect.Type
+}
+
+// ParseFuncArgs checks that a user function with the argument types
+// arg accepts rows of type typ, which must be Slice<t1, ..., tn>.
+// The function may be of any of the forms:
+//
+//	func(v1 t1, ..., vn tn)
+//	func(shard int, state stateType, v1 t1, ..., vn tn)
+//	func(ctx context.Context, v1 t1, ..., vn tn)
+//	func(ctx context.Context, shard int, state stateType, v1 t1, ..., vn tn)
+func parseFuncArgs(arg, typ slicetype.Type) (args funcArgs, ok bool) {
+	if arg.NumOut() > 0 && arg.Out(0) == typeOfContext {
+		args.context = true
+		arg = slicetype.Slice(arg, 1, arg.NumOut())
+	}
+	if typecheck.Equal(typ, arg) {
+		return args, true
+	}
+	if arg.NumOut() != typ.NumOut()+2 || arg.Out(0).Kind() != reflect.Int {
+		return args, false
+	}
+	if !typecheck.Equal(typ, slicetype.Slice(arg, 2, arg.NumOut())) {
+		return args, false
+	}
+	args.stateType = arg.Out(1)
+	return args, true
+}
+
+// ShardState manages the leading arguments, including the per-shard
+// state, of a user function for a shard.
+type shardState struct {
+	funcArgs
+	// Name is the name of the slice whose user function is invoked.
+	name  Name
+	shard int
+	state reflect.Value
+	// Once ensures that the state is closed at most once: it may be
+	// closed both by the reader, once it completes, and by the
+	// cleanup of the task, which may run concurrently.
+	once sync.Once
+	// Timer records the latency of calls to the user function; see
+	// WithFuncTimer. Calls counts the calls of the current batch.
+	timer *stats.Timer
+	calls int64
+}
+
+// Nargs returns the number of leading arguments that are passed to
+// the user function.
+func (s *shardState) nargs() int {
+	var n int
+	if s.context {
+		n++
+	}
+	if s.stateType != nil {
+		n += 2
+	}
+	return n
+}
+
+// Args sets the leading arguments (as given by nargs) of the user
+// function, initializing the state upon the first call. The state is
+// initialized in the same manner as ReaderFunc's, and is closed when
+// the task whose context is ctx completes, if it has not been closed
+// before; this releases the state of readers that are abandoned
+// before they are fully read, for example by Head. The context passed
+// to the function attributes user-defined counters to the slice.
+func (s *shardState) args(ctx context.Context, args []reflect.Value) {
+	s.timer = funcTimer(ctx)
+	if s.context {
+		ctx = withCounterSlice(ctx, s.name)
+		args[0] = reflect.ValueOf(&ctx).Elem()
+		args = args[1:]
+	}
+	if s.stateType == nil {
+		return
+	}
+	if !s.state.IsValid() {
+		if s.stateType.Kind() == reflect.Ptr {
+			s.state = reflect.New(s.stateType.Elem())
+		} else {
+			s.state = reflect.Zero(s.stateType)
+		}
+		onTaskCleanup(ctx, func() { _ = s.close(context.Canceled) })
+	}
+	args[0] = reflect.ValueOf(s.shard)
+	args[1] = s.state
+}
+
+// Call calls the user function fn with the provided arguments,
+// including the leading arguments set by args.
+func (s *shardState) call(fn reflect.Value, args []reflect.Value) []reflect.Value {
+	s.calls++
+	return fn.Call(args)
+}
+
+// StartCalls starts timing a batch of calls to the user function,
+// returning the batch's start time, to be passed to endCalls. Calls
+// are timed by batch, rather than one at a time, so that timing adds
+// no per-row cost.
+func (s *shardState) startCalls() time.Time {
+	s.calls = 0
+	if s.timer == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// EndCalls records the calls made since the provided start time, as
+// returned by startCalls, at their mean latency.
+func (s *shardState) endCalls(start time.Time) {
+	if s.timer == nil || s.calls == 0 {
+		return
+	}
+	s.timer.ObserveN(time.Since(start), s.calls)
+}
+
+// Close closes the state, if it was initialized and implements
+// io.Closer, once the reader has encountered the provided error. It
+// returns the error that the reader should report: errors from Close
+// are reported only if the reader otherwise completed successfully.
+// The state is closed at most once, and close may be called
+// concurrently.
+func (s *shardState) close(err error) error {
+	if !s.state.IsValid() {
+		return err
+	}
+	var cerr error
+	s.once.Do(func() {
+		if closer, ok := s.state.Interface().(io.Closer); ok {
+			cerr = closer.Close()
+		}
+	})
+	if cerr == nil || err != sliceio.EOF {
+		return err
+	}
+	if errors.IsTemporary(cerr) {
+		return cerr
+	}
+	return errors.E(errors.Fatal, cerr)
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+)
+
+// CloseCounter is a state that counts, in closeCounterClosed, the
+// number of times it has been closed.
+type closeCounter struct{}
+
+var closeCounterClosed int32
+
+func (*closeCounter) Close() error {
+	atomic.AddInt32(&closeCounterClosed, 1)
+	return nil
+}
+
+// TestShardStateCloseRace tests that a reader that is abandoned by
+// Head, and then completed, closes its state exactly once when this
+// races with the cleanup of its task.
+func TestShardStateCloseRace(t *testing.T) {
+	const N = 100
+	input := make([]int, N)
+	mapped := Map(Const(1, input), func(shard int, _ *closeCounter, i int) int { return i })
+	for iter := 0; iter < 100; iter++ {
+		atomic.StoreInt32(&closeCounterClosed, 0)
+		var (
+			ctx, cleanup = WithTaskCleanup(context.Background())
+			m            = mapped.Reader(0, []sliceio.Reader{sliceio.FrameReader(frame.Slices(input))})
+			r            = Head(mapped, 1).Reader(0, []sliceio.Reader{m})
+			out          = frame.Make(mapped, 1, 1)
+		)
+		// Head abandons the map reader after its first row.
+		if _, err := sliceio.ReadFull(ctx, r, out); err != nil && err != sliceio.EOF {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cleanup()
+		}()
+		go func() {
+			defer wg.Done()
+			// Meanwhile, the abandoned reader is read to completion.
+			_, _ = sliceio.ReadFull(ctx, m, frame.Make(mapped, N, N))
+		}()
+		wg.Wait()
+		if got, want := atomic.LoadInt32(&closeCounterClosed), int32(1); got != want {
+			t.Fatalf("got %v closes, want %v", got, want)
+		}
+	}
+}
+
+// OrderState is a state that records, in orderStateClosed, the
+// name of each state as it is closed.
+type orderState struct{ name string }
+
+var (
+	orderStateMu     sync.Mutex
+	orderStateClosed []string
+)
+
+func (s *orderState) Close() error {
+	orderStateMu.Lock()
+	orderStateClosed = append(orderStateClosed, s.name)
+	orderStateMu.Unlock()
+	return nil
+}
+
+// TestTaskCleanupOrder tests that the cleanup of a task closes the
+// state of a downstream reader before that of its upstream reader.
+func TestTaskCleanupOrder(t *testing.T) {
+	const N = 100
+	input := make([]int, N)
+	upstream := Map(Const(1, input), func(shard int, s *orderState, i int) int {
+		s.name = "upstream"
+		return i
+	})
+	downstream := Map(upstream, func(shard int, s *orderState, i int) int {
+		s.name = "downstream"
+		return i
+	})
+	orderStateClosed = nil
+	ctx, cleanup := WithTaskCleanup(context.Background())
+	r := sliceio.FrameReader(frame.Slices(input))
+	r = upstream.Reader(0, []sliceio.Reader{r})
+	r = downstream.Reader(0, []sliceio.Reader{r})
+	r = Head(downstream, 1).Reader(0, []sliceio.Reader{r})
+	// Head abandons the map readers after their first row, so that
+	// their states are closed by the cleanup.
+	if _, err := sliceio.ReadFull(ctx, r, frame.Make(downstream, 1, 1)); err != nil && err != sliceio.EOF {
+		t.Fatal(err)
+	}
+	cleanup()
+	if got, want := orderStateClosed, []string{"downstream", "upstream"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
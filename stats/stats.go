@@ -2,17 +2,20 @@
 // Use of this source code is governed by the Apache 2.0
 // license that can be found in the LICENSE file.
 
-// Package stats provides collections of counters. Each counter
-// belongs to a snapshottable collection, and these collections can be
-// aggregated.
+// Package stats provides collections of counters, gauges, and
+// histograms. Each metric belongs to a snapshottable collection, and
+// these collections can be aggregated.
 package stats
 
 import (
 	"fmt"
+	"math"
+	"math/bits"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Values is a snapshot of the values in a collection.
@@ -41,16 +44,20 @@ func (v Values) String() string {
 	return strings.Join(keys, " ")
 }
 
-// A Map is a set of counters keyed by name.
+// A Map is a set of counters, gauges, and histograms keyed by name.
 type Map struct {
-	mu     sync.Mutex
-	values map[string]*Int
+	mu         sync.Mutex
+	values     map[string]*Int
+	gauges     map[string]*Gauge
+	histograms map[string]*Histogram
 }
 
 // NewMap returns a fresh Map.
 func NewMap() *Map {
 	return &Map{
-		values: make(map[string]*Int),
+		values:     make(map[string]*Int),
+		gauges:     make(map[string]*Gauge),
+		histograms: make(map[string]*Histogram),
 	}
 }
 
@@ -67,12 +74,70 @@ func (m *Map) Int(name string) *Int {
 	return v
 }
 
-// AddAll adds all counters in the map to the provided snapshot.
+// Gauge returns the gauge with the provided name. The gauge is
+// created if it does not already exist.
+func (m *Map) Gauge(name string) *Gauge {
+	m.mu.Lock()
+	g := m.gauges[name]
+	if g == nil {
+		g = new(Gauge)
+		m.gauges[name] = g
+	}
+	m.mu.Unlock()
+	return g
+}
+
+// Histogram returns the histogram with the provided name. The
+// histogram is created if it does not already exist.
+func (m *Map) Histogram(name string) *Histogram {
+	m.mu.Lock()
+	h := m.histograms[name]
+	if h == nil {
+		h = new(Histogram)
+		m.histograms[name] = h
+	}
+	m.mu.Unlock()
+	return h
+}
+
+// Timer returns a timer that records durations in the histogram with
+// the provided name. The histogram is created if it does not already
+// exist.
+func (m *Map) Timer(name string) *Timer {
+	h := m.Histogram(name)
+	h.mu.Lock()
+	h.duration = true
+	h.mu.Unlock()
+	return &Timer{h}
+}
+
+// AddAll adds all counters and gauges in the map to the provided
+// snapshot. Gauges are aggregated by summing them, so that, for
+// example, gauges of the number of running tasks on each machine
+// aggregate to the total number of running tasks.
 func (m *Map) AddAll(vals Values) {
 	m.mu.Lock()
 	for k, v := range m.values {
 		vals[k] += v.Get()
 	}
+	for k, g := range m.gauges {
+		vals[k] += g.Get()
+	}
+	m.mu.Unlock()
+}
+
+// AddDistributions merges all histograms in the map into the
+// provided snapshot.
+func (m *Map) AddDistributions(dists Distributions) {
+	m.mu.Lock()
+	for k, h := range m.histograms {
+		d := dists[k]
+		if d == nil {
+			d = new(Distribution)
+			dists[k] = d
+		}
+		d.Merge(h.Distribution())
+	}
 	m.mu.Unlock()
 }
 
@@ -105,3 +170,332 @@ func (v *Int) Get() int64 {
 	}
 	return atomic.LoadInt64(&v.val)
 }
+
+// A Gauge is an integer value that is set to the current value of
+// some quantity, for example the number of tasks that are currently
+// running. Gauges can be atomically set and adjusted.
+type Gauge struct {
+	val int64
+}
+
+// Add adjusts the gauge's value by delta.
+func (g *Gauge) Add(delta int64) {
+	if g == nil {
+		return
+	}
+	atomic.AddInt64(&g.val, delta)
+}
+
+// Set sets the gauge's value to val.
+func (g *Gauge) Set(val int64) {
+	if g == nil {
+		return
+	}
+	atomic.StoreInt64(&g.val, val)
+}
+
+// Get returns the current value of the gauge.
+func (g *Gauge) Get() int64 {
+	if g == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&g.val)
+}
+
+// Histograms bucket values on a log-linear scale: each power of two
+// is divided into 1<<subBits buckets of equal width, so that the
+// relative error of a bucketed value is bounded by 1/(1<<subBits).
+// Values smaller than 1<<(subBits+1) are bucketed exactly, and buckets
+// are numbered contiguously, so that the bucket of a value v is its
+// index among the (fewer than 64<<subBits) buckets. Nonpositive values
+// are counted in bucket 0.
+const (
+	subBits     = 4
+	numSub      = 1 << subBits
+	maxExactLen = subBits + 1
+)
+
+// bucket returns the index of the bucket into which v falls.
+func bucket(v int64) int {
+	if v <= 0 {
+		return 0
+	}
+	var shift uint
+	if n := bits.Len64(uint64(v)); n > maxExactLen {
+		shift = uint(n - maxExactLen)
+	}
+	// The leading subBits+1 bits of v, which lie in [numSub,
+	// 2*numSub) for values that are not bucketed exactly, select the
+	// bucket among those of its power of two.
+	return int(shift)*numSub + int(v>>shift)
+}
+
+// bucketBounds returns the smallest and largest values that fall
+// into bucket b.
+func bucketBounds(b int) (lo, hi int64) {
+	if b == 0 {
+		return math.MinInt64, 0
+	}
+	if b < 2*numSub {
+		return int64(b), int64(b)
+	}
+	shift := uint(b/numSub - 1)
+	lo = int64(b-int(shift)*numSub) << shift
+	return lo, lo + 1<<shift - 1
+}
+
+// A Histogram records the distribution of a set of integer values,
+// for example task run times or partition sizes. Histograms are
+// bucketed so that they may be merged: the distributions recorded
+// by histograms on multiple machines can be aggregated into a
+// single distribution. Histograms are safe for concurrent use.
+type Histogram struct {
+	mu       sync.Mutex
+	duration bool
+	dist     Distribution
+}
+
+// Observe records the value v in the histogram.
+func (h *Histogram) Observe(v int64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.dist.observe(v, 1)
+	h.mu.Unlock()
+}
+
+// ObserveN records the value v n times.
+func (h *Histogram) ObserveN(v, n int64) {
+	if h == nil || n <= 0 {
+		return
+	}
+	h.mu.Lock()
+	h.dist.observe(v, n)
+	h.mu.Unlock()
+}
+
+// Distribution returns a snapshot of the histogram's distribution.
+func (h *Histogram) Distribution() *Distribution {
+	d := new(Distribution)
+	if h == nil {
+		return d
+	}
+	h.mu.Lock()
+	d.Merge(&h.dist)
+	d.Duration = h.duration
+	h.mu.Unlock()
+	return d
+}
+
+// A Timer records durations in a histogram, in nanoseconds.
+type Timer struct {
+	hist *Histogram
+}
+
+// NewTimer returns a fresh Timer that does not belong to a
+// collection.
+func NewTimer() *Timer {
+	return &Timer{&Histogram{duration: true}}
+}
+
+// Observe records the duration d.
+func (t *Timer) Observe(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.hist.Observe(int64(d))
+}
+
+// ObserveN records n events, whose durations total d, at their mean
+// duration. This allows events that are too short to be timed
+// individually, at negligible cost, to be timed in batches.
+func (t *Timer) ObserveN(d time.Duration, n int64) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.hist.ObserveN(int64(d)/n, n)
+}
+
+// Start starts timing an event. The returned function records the
+// time elapsed since the call to Start when it is called.
+func (t *Timer) Start() (stop func()) {
+	start := time.Now()
+	return func() { t.Observe(time.Since(start)) }
+}
+
+// Merge records the durations recorded by timer u in t. Timers that
+// are local to a task may thus be merged into a shared timer once,
+// when the task completes, instead of contending on it for each
+// duration.
+func (t *Timer) Merge(u *Timer) {
+	if t == nil || u == nil {
+		return
+	}
+	d := u.Distribution()
+	t.hist.mu.Lock()
+	t.hist.dist.Merge(d)
+	t.hist.mu.Unlock()
+}
+
+// Distribution returns a snapshot of the timer's distribution.
+func (t *Timer) Distribution() *Distribution {
+	if t == nil {
+		return &Distribution{Duration: true}
+	}
+	return t.hist.Distribution()
+}
+
+// A Distribution is a snapshot of a histogram. Distributions may be
+// merged, and are thus used to aggregate histograms across machines.
+type Distribution struct {
+	// Count is the number of observed values.
+	Count int64
+	// Sum is the sum of the observed values.
+	Sum int64
+	// Min and Max are the smallest and largest observed values. They
+	// are undefined if Count is 0.
+	Min, Max int64
+	// Buckets holds the number of values that fall into each bucket.
+	// Trailing empty buckets are omitted.
+	Buckets []int64
+	// Duration indicates that the distribution's values are
+	// durations, in nanoseconds.
+	Duration bool
+}
+
+func (d *Distribution) observe(v, count int64) {
+	if d.Count == 0 || v < d.Min {
+		d.Min = v
+	}
+	if d.Count == 0 || v > d.Max {
+		d.Max = v
+	}
+	d.Count += count
+	d.Sum += v * count
+	b := bucket(v)
+	if b >= len(d.Buckets) {
+		buckets := make([]int64, b+1)
+		copy(buckets, d.Buckets)
+		d.Buckets = buckets
+	}
+	d.Buckets[b] += count
+}
+
+// Merge merges the distribution e into d.
+func (d *Distribution) Merge(e *Distribution) {
+	if e.Count == 0 {
+		return
+	}
+	if d.Count == 0 || e.Min < d.Min {
+		d.Min = e.Min
+	}
+	if d.Count == 0 || e.Max > d.Max {
+		d.Max = e.Max
+	}
+	d.Count += e.Count
+	d.Sum += e.Sum
+	d.Duration = d.Duration || e.Duration
+	if len(e.Buckets) > len(d.Buckets) {
+		buckets := make([]int64, len(e.Buckets))
+		copy(buckets, d.Buckets)
+		d.Buckets = buckets
+	}
+	for i, n := range e.Buckets {
+		d.Buckets[i] += n
+	}
+}
+
+// Mean returns the mean of the distribution's values.
+func (d *Distribution) Mean() float64 {
+	if d.Count == 0 {
+		return 0
+	}
+	return float64(d.Sum) / float64(d.Count)
+}
+
+// Quantile returns an estimate of the q-quantile of the
+// distribution, for q in [0, 1]. The estimate is the midpoint of the
+// bucket containing the quantile, clamped to the distribution's
+// range; its relative error is thus bounded by the bucket width.
+// Quantiles 0 and 1 are the distribution's exact minimum and maximum.
+func (d *Distribution) Quantile(q float64) int64 {
+	if d.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(d.Count)))
+	switch {
+	case rank <= 1:
+		return d.Min
+	case rank >= d.Count:
+		return d.Max
+	}
+	var n int64
+	for b, count := range d.Buckets {
+		n += count
+		if n < rank {
+			continue
+		}
+		lo, hi := bucketBounds(b)
+		if lo < d.Min {
+			lo = d.Min
+		}
+		if hi > d.Max {
+			hi = d.Max
+		}
+		return lo + (hi-lo)/2
+	}
+	return d.Max
+}
+
+// Format formats the value v of the distribution: durations are
+// formatted as such, and other values as integers.
+func (d *Distribution) Format(v int64) string {
+	if d.Duration {
+		return roundDuration(time.Duration(v)).String()
+	}
+	return fmt.Sprint(v)
+}
+
+// String returns an abbreviated string describing the distribution
+// by its count and median, 99th percentile, and maximum values.
+func (d *Distribution) String() string {
+	return fmt.Sprintf("n:%d p50:%s p99:%s max:%s", d.Count,
+		d.Format(d.Quantile(0.5)), d.Format(d.Quantile(0.99)), d.Format(d.Max))
+}
+
+// roundDuration rounds d to three significant digits, for display.
+func roundDuration(d time.Duration) time.Duration {
+	m := time.Duration(1)
+	for m*1000 <= d {
+		m *= 10
+	}
+	return d.Round(m)
+}
+
+// Distributions is a snapshot of the histograms in a collection.
+type Distributions map[string]*Distribution
+
+// Merge merges the distributions e into d.
+func (d Distributions) Merge(e Distributions) {
+	for k, dist := range e {
+		if d[k] == nil {
+			d[k] = new(Distribution)
+		}
+		d[k].Merge(dist)
+	}
+}
+
+// String returns an abbreviated string with the distributions in
+// this snapshot sorted by key.
+func (d Distributions) String() string {
+	var keys []string
+	for key := range d {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		keys[i] = fmt.Sprintf("%s:{%s}", key, d[key])
+	}
+	return strings.Join(keys, " ")
+}
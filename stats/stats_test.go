@@ -4,7 +4,13 @@
 
 package stats
 
-import "testing"
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
 
 func TestStats(t *testing.T) {
 	coll := NewMap()
@@ -33,3 +39,207 @@ func TestStats(t *testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }
+
+func TestGauge(t *testing.T) {
+	coll := NewMap()
+	g := coll.Gauge("running")
+	g.Add(3)
+	g.Add(-1)
+	if got, want := g.Get(), int64(2); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	g.Set(5)
+	all := make(Values)
+	coll.AddAll(all)
+	coll.AddAll(all)
+	if got, want := all["running"], int64(10); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	for v := int64(-10); v < 1<<20; v++ {
+		lo, hi := bucketBounds(bucket(v))
+		if v < lo || v > hi {
+			t.Fatalf("value %d: bucket %d [%d, %d]", v, bucket(v), lo, hi)
+		}
+		if v > 0 && float64(hi-lo) > float64(v)/numSub {
+			t.Fatalf("value %d: bucket %d [%d, %d] too wide", v, bucket(v), lo, hi)
+		}
+	}
+	for _, v := range []int64{1<<62 + 1, math.MaxInt64} {
+		lo, hi := bucketBounds(bucket(v))
+		if v < lo || v > hi {
+			t.Errorf("value %d: bucket %d [%d, %d]", v, bucket(v), lo, hi)
+		}
+	}
+	// Buckets are numbered contiguously.
+	for b := 1; b <= bucket(math.MaxInt64); b++ {
+		_, hi := bucketBounds(b - 1)
+		lo, _ := bucketBounds(b)
+		if lo != hi+1 {
+			t.Fatalf("bucket %d: lower bound %d does not follow upper bound %d of bucket %d", b, lo, hi, b-1)
+		}
+		if got, want := bucket(lo), b; got != want {
+			t.Fatalf("value %d: got bucket %v, want %v", lo, got, want)
+		}
+	}
+}
+
+func TestQuantileAccuracy(t *testing.T) {
+	const N = 100000
+	dists := []struct {
+		name   string
+		sample func(r *rand.Rand) float64
+	}{
+		{"uniform", func(r *rand.Rand) float64 { return 1e6 * r.Float64() }},
+		{"exponential", func(r *rand.Rand) float64 { return 1e4 * r.ExpFloat64() }},
+		{"lognormal", func(r *rand.Rand) float64 { return math.Exp(10 + 2*r.NormFloat64()) }},
+		{"bimodal", func(r *rand.Rand) float64 {
+			if r.Intn(2) == 0 {
+				return 100 + 10*r.NormFloat64()
+			}
+			return 1e9 + 1e7*r.NormFloat64()
+		}},
+	}
+	for _, dist := range dists {
+		var (
+			r      = rand.New(rand.NewSource(1))
+			h      = new(Histogram)
+			values = make([]int64, N)
+		)
+		for i := range values {
+			values[i] = int64(dist.sample(r)) + 1
+			h.Observe(values[i])
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+		d := h.Distribution()
+		for _, q := range []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999} {
+			// The quantile is estimated by the midpoint of the bucket that
+			// contains it, and is thus off by at most half of its width.
+			want := float64(values[int(math.Ceil(q*N))-1])
+			got := float64(d.Quantile(q))
+			if err := math.Abs(got-want) / want; err > 1.0/(2*numSub) {
+				t.Errorf("%s: quantile %v: got %v, want %v (relative error %.3f)", dist.name, q, got, want, err)
+			}
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	coll := NewMap()
+	h := coll.Histogram("size")
+	for i := int64(1); i <= 1000; i++ {
+		h.Observe(i)
+	}
+	d := h.Distribution()
+	if got, want := d.Count, int64(1000); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := d.Mean(), 500.5; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := d.Min, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := d.Max, int64(1000); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, q := range []float64{0, 0.1, 0.5, 0.9, 0.99, 1} {
+		want := math.Max(1, q*1000)
+		got := float64(d.Quantile(q))
+		if math.Abs(got-want)/want > 1.0/numSub {
+			t.Errorf("quantile %v: got %v, want %v", q, got, want)
+		}
+	}
+	if got, want := d.Quantile(0), int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := d.Quantile(1), int64(1000); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDistributionsMerge(t *testing.T) {
+	var (
+		machine1 = NewMap()
+		machine2 = NewMap()
+	)
+	for i := int64(0); i < 100; i++ {
+		machine1.Histogram("size").Observe(10)
+		machine2.Histogram("size").Observe(1024)
+	}
+	machine2.Timer("time").Observe(time.Second)
+	all := make(Distributions)
+	machine1.AddDistributions(all)
+	machine2.AddDistributions(all)
+	size := all["size"]
+	if got, want := size.Count, int64(200); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := size.Min, int64(10); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := size.Quantile(0.25), int64(10); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := size.Quantile(0.75), int64(1024); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := all.String(), "size:{n:200 p50:10 p99:1024 max:1024} time:{n:1 p50:1s p99:1s max:1s}"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// Merging snapshots is equivalent to merging the histograms.
+	merged := make(Distributions)
+	merged.Merge(all)
+	merged.Merge(all)
+	if got, want := merged["size"].Count, int64(400); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := all["size"].Count, int64(200); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	timer := NewMap().Timer("time")
+	stop := timer.Start()
+	time.Sleep(10 * time.Millisecond)
+	stop()
+	d := timer.Distribution()
+	if !d.Duration {
+		t.Error("expected duration distribution")
+	}
+	if got, want := d.Count, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, min := time.Duration(d.Max), 10*time.Millisecond; got < min {
+		t.Errorf("got %v, want at least %v", got, min)
+	}
+	local := NewTimer()
+	local.Observe(time.Millisecond)
+	local.Observe(time.Millisecond)
+	timer.Merge(local)
+	if got, want := timer.Distribution().Count, int64(3); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	batch := NewTimer()
+	batch.ObserveN(4*time.Millisecond, 4)
+	batch.ObserveN(time.Millisecond, 0)
+	if got, want := batch.Distribution().Count, int64(4); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := time.Duration(batch.Distribution().Sum), 4*time.Millisecond; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := time.Duration(batch.Distribution().Max), time.Millisecond; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var nilTimer *Timer
+	nilTimer.Start()()
+	nilTimer.Merge(local)
+	nilTimer.ObserveN(time.Second, 1)
+	if got, want := NewTimer().Distribution().String(), "n:0 p50:0s p99:0s max:0s"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"context"
+	"sync"
+)
+
+// TaskInfo describes the task that evaluates (a part of) a slice.
+type taskInfo struct {
+	name       string
+	shard      int
+	invocation uint64
+}
+
+type taskInfoKey struct{}
+
+// WithTask returns a context that carries the metadata of a task:
+// its name, the shard it computes, and the index of the invocation
+// from which it was compiled. Executors use this to provide task
+// metadata to user functions.
+func WithTask(ctx context.Context, name string, shard int, invocation uint64) context.Context {
+	return context.WithValue(ctx, taskInfoKey{}, taskInfo{name, shard, invocation})
+}
+
+func taskInfoFromContext(ctx context.Context) (taskInfo, bool) {
+	info, ok := ctx.Value(taskInfoKey{}).(taskInfo)
+	return info, ok
+}
+
+// TaskName returns the name of the task whose context is ctx, or an
+// empty string if ctx is not a task's context.
+func TaskName(ctx context.Context) string {
+	info, _ := taskInfoFromContext(ctx)
+	return info.name
+}
+
+// TaskShard returns the shard that is computed by the task whose
+// context is ctx, or -1 if ctx is not a task's context.
+func TaskShard(ctx context.Context) int {
+	info, ok := taskInfoFromContext(ctx)
+	if !ok {
+		return -1
+	}
+	return info.shard
+}
+
+// TaskInvocation returns the index of the invocation (see
+// Invocation) from which the task whose context is ctx was compiled,
+// or 0 if ctx is not a task's context. Invocation indices start at 1.
+func TaskInvocation(ctx context.Context) uint64 {
+	info, _ := taskInfoFromContext(ctx)
+	return info.invocation
+}
+
+// TaskCleanup holds the functions that release the resources held by
+// the readers of a task.
+type taskCleanup struct {
+	mu   sync.Mutex
+	fns  []func()
+	done bool
+}
+
+type taskCleanupKey struct{}
+
+// WithTaskCleanup returns a context with which a task is evaluated,
+// together with a function that the executor must call once the
+// task's evaluation has completed, successfully or not. The function
+// cancels the returned context, and then releases the resources held
+// by the task's readers, including the per-shard state of user
+// functions and the goroutines of MapShard. This ensures that these
+// resources are released also by readers that are abandoned before
+// they are fully read, for example by Head.
+func WithTaskCleanup(ctx context.Context) (context.Context, func()) {
+	c := new(taskCleanup)
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, taskCleanupKey{}, c))
+	return ctx, func() {
+		cancel()
+		c.mu.Lock()
+		fns := c.fns
+		c.fns = nil
+		c.done = true
+		c.mu.Unlock()
+		// Readers register their cleanup upon their first read, which
+		// reads from their upstream readers before it initializes their
+		// own state: upstream readers thus register first. Clean up in
+		// reverse order so that upstream state is released only after
+		// the readers that consume it.
+		for i := len(fns) - 1; i >= 0; i-- {
+			fns[i]()
+		}
+	}
+}
+
+// OnTaskCleanup registers fn to be called when the evaluation of the
+// task whose context is ctx completes. If the task has already
+// completed, fn is called immediately. If ctx does not belong to a
+// task that is cleaned up (see WithTaskCleanup), fn is never called.
+func onTaskCleanup(ctx context.Context, fn func()) {
+	c, _ := ctx.Value(taskCleanupKey{}).(*taskCleanup)
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if c.done {
+		c.mu.Unlock()
+		fn()
+		return
+	}
+	c.fns = append(c.fns, fn)
+	c.mu.Unlock()
+}
@@ -0,0 +1,211 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/grailbio/bigslice/frame"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+type limitSlice struct {
+	name Name
+	Slice
+	dep Slice
+	n   int
+}
+
+// Limit returns a slice that contains at most n rows of the provided
+// slice overall. (Contrast with Head, which limits each shard.) Which
+// rows are retained is unspecified. Each shard of the underlying
+// slice first limits its output to n rows, so that at most n rows per
+// shard are shuffled into the single shard of the returned slice.
+// Its type is the same as the provided slice.
+func Limit(slice Slice, n int) Slice {
+	if n < 0 {
+		typecheck.Panicf(1, "limit: n must be nonnegative; got %d", n)
+	}
+	head := &headSlice{makeName(fmt.Sprintf("head(%d)", n)), slice, n}
+	return &limitSlice{makeName(fmt.Sprintf("limit(%d)", n)), slice, head, n}
+}
+
+func (l *limitSlice) Name() Name             { return l.name }
+func (*limitSlice) NumShard() int            { return 1 }
+func (*limitSlice) ShardType() ShardType     { return HashShard }
+func (*limitSlice) NumDep() int              { return 1 }
+func (l *limitSlice) Dep(i int) Dep          { return singleDep(i, l.dep, true) }
+func (*limitSlice) Combiner() *reflect.Value { return nil }
+
+func (l *limitSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &headReader{deps[0], l.n}
+}
+
+type topnSlice struct {
+	name Name
+	Slice
+	less reflect.Value
+	n    int
+	// Shard is true for the per-shard slice, which computes the top n
+	// rows of each shard of its dependency, and false for the final,
+	// single-shard slice that merges the per-shard results.
+	shard bool
+}
+
+// TopN returns a slice that contains the top n rows of the provided
+// slice, as ordered by the function less. The less function is
+// provided with the columns of two rows and must return whether the
+// first row should be ordered before the second:
+//
+//	func(x1 t1, x2 t2, ..., xn tn, y1 t1, y2 t2, ..., yn tn) bool
+//
+// The top rows are the first n rows of the slice in this ordering.
+// Each shard of the underlying slice keeps a bounded heap of its top
+// n rows, so that at most n rows per shard are shuffled into a final
+// single-shard stage, which merges them. The returned slice has a
+// single shard whose rows are sorted according to less. Its type is
+// the same as the provided slice.
+//
+// Schematically:
+//
+//	TopN(Slice<t1, t2, ..., tn>, n int, func(x1 t1, ..., xn tn, y1 t1, ..., yn tn) bool) Slice<t1, t2, ..., tn>
+func TopN(slice Slice, n int, less interface{}) Slice {
+	if n < 0 {
+		typecheck.Panicf(1, "topn: n must be nonnegative; got %d", n)
+	}
+	arg, ret, ok := typecheck.Func(less)
+	if !ok {
+		typecheck.Panicf(1, "topn: invalid less function %T", less)
+	}
+	if !typecheck.Equal(slicetype.Concat(slice, slice), arg) {
+		typecheck.Panicf(1, "topn: function %T does not match input slice type %s", less, slicetype.String(slice))
+	}
+	if ret.NumOut() != 1 || ret.Out(0).Kind() != reflect.Bool {
+		typecheck.Panic(1, "topn: less function must return a single boolean value")
+	}
+	lessv := reflect.ValueOf(less)
+	shard := &topnSlice{makeName(fmt.Sprintf("topnshard(%d)", n)), slice, lessv, n, true}
+	return &topnSlice{makeName(fmt.Sprintf("topn(%d)", n)), shard, lessv, n, false}
+}
+
+func (t *topnSlice) Name() Name { return t.name }
+func (t *topnSlice) NumShard() int {
+	if t.shard {
+		return t.Slice.NumShard()
+	}
+	return 1
+}
+func (*topnSlice) ShardType() ShardType     { return HashShard }
+func (*topnSlice) NumDep() int              { return 1 }
+func (t *topnSlice) Dep(i int) Dep          { return singleDep(i, t.Slice, !t.shard) }
+func (*topnSlice) Combiner() *reflect.Value { return nil }
+
+func (t *topnSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return &topnReader{op: t, reader: deps[0]}
+}
+
+type topnReader struct {
+	op     *topnSlice
+	reader sliceio.Reader
+	top    sliceio.Reader
+	err    error
+}
+
+func (t *topnReader) Read(ctx context.Context, out frame.Frame) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	if !slicetype.Assignable(out, t.op) {
+		return 0, errTypeError
+	}
+	if t.top == nil {
+		args := make([]reflect.Value, 2*t.op.NumOut())
+		less := func(f frame.Frame, i int, g frame.Frame, j int) bool {
+			ncol := f.NumOut()
+			for c := 0; c < ncol; c++ {
+				args[c] = f.Index(c, i)
+				args[ncol+c] = g.Index(c, j)
+			}
+			return t.op.less.Call(args)[0].Bool()
+		}
+		var top frame.Frame
+		top, t.err = topRows(ctx, t.op, t.reader, t.op.n, less)
+		if t.err != nil {
+			return 0, t.err
+		}
+		t.top = sliceio.FrameReader(top)
+	}
+	var n int
+	n, t.err = t.top.Read(ctx, out)
+	return n, t.err
+}
+
+// TopRows reads the reader r of type typ to completion, maintaining a
+// bounded heap of the top n rows as ordered by the function less,
+// which returns whether row i of frame f is ordered before row j of
+// frame g. The returned frame is sorted by less.
+func topRows(ctx context.Context, typ slicetype.Type, r sliceio.Reader, n int, less func(f frame.Frame, i int, g frame.Frame, j int) bool) (frame.Frame, error) {
+	top := &topFrame{Frame: frame.Make(typ, n, n), less: less}
+	if n == 0 {
+		return top.Frame, nil
+	}
+	in := frame.Make(typ, defaultChunksize, defaultChunksize)
+	for {
+		n, err := r.Read(ctx, in)
+		if err != nil && err != sliceio.EOF {
+			return frame.Frame{}, err
+		}
+		for i := 0; i < n; i++ {
+			switch {
+			case top.len < top.Frame.Len():
+				frame.Copy(top.Frame.Slice(top.len, top.len+1), in.Slice(i, i+1))
+				top.len++
+				if top.len == top.Frame.Len() {
+					heap.Init(top)
+				}
+			case less(in, i, top.Frame, 0):
+				// The row is ordered before the last of our current top
+				// rows; replace it.
+				frame.Copy(top.Frame.Slice(0, 1), in.Slice(i, i+1))
+				heap.Fix(top, 0)
+			}
+		}
+		if err == sliceio.EOF {
+			break
+		}
+	}
+	top.Frame = top.Frame.Slice(0, top.len)
+	sort.Sort(topSorter{top})
+	return top.Frame, nil
+}
+
+// TopFrame is a max-heap (as ordered by its less function) of rows,
+// stored in a frame. The root of the heap is thus the row that is
+// ordered last among the retained rows.
+type topFrame struct {
+	frame.Frame
+	len  int
+	less func(f frame.Frame, i int, g frame.Frame, j int) bool
+}
+
+func (t *topFrame) Len() int           { return t.len }
+func (t *topFrame) Less(i, j int) bool { return t.less(t.Frame, j, t.Frame, i) }
+func (t *topFrame) Swap(i, j int)      { t.Frame.Swap(i, j) }
+
+// Push and Pop are never called: rows are only replaced in-place.
+func (t *topFrame) Push(x interface{}) { panic("topFrame.Push") }
+func (t *topFrame) Pop() interface{}   { panic("topFrame.Pop") }
+
+// TopSorter sorts a topFrame in the order defined by its less
+// function.
+type topSorter struct{ *topFrame }
+
+func (t topSorter) Less(i, j int) bool { return t.less(t.Frame, i, t.Frame, j) }
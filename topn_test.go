@@ -0,0 +1,110 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/slicetest"
+)
+
+func TestLimit(t *testing.T) {
+	const N = 1000
+	ints := make([]int, N)
+	for i := range ints {
+		ints[i] = i
+	}
+	for _, n := range []int{0, 1, 10, N, 2 * N} {
+		for m := 1; m < 20; m += 7 {
+			t.Run(fmt.Sprintf("%d/%d", n, m), func(t *testing.T) {
+				slice := bigslice.Const(m, ints)
+				slice = bigslice.Limit(slice, n)
+				if got, want := slice.NumShard(), 1; got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+				var rows []int
+				for _, s := range run(context.Background(), t, slice) {
+					slicetest.ScanAll(t, s, &rows)
+					want := n
+					if want > N {
+						want = N
+					}
+					if got := len(rows); got != want {
+						t.Errorf("got %v, want %v", got, want)
+					}
+					seen := make(map[int]bool)
+					for _, row := range rows {
+						if seen[row] {
+							t.Errorf("duplicate row %d", row)
+						}
+						seen[row] = true
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestTopN(t *testing.T) {
+	const N = 1000
+	rnd := rand.New(rand.NewSource(0))
+	perm := rnd.Perm(N)
+	keys := make([]string, N)
+	for i := range keys {
+		keys[i] = fmt.Sprint(perm[i])
+	}
+	for _, n := range []int{1, 10, N + 10} {
+		for m := 1; m < 20; m += 7 {
+			slice := bigslice.Const(m, append([]int{}, perm...), append([]string{}, keys...))
+			slice = bigslice.TopN(slice, n, func(x1 int, x2 string, y1 int, y2 string) bool {
+				return x1 > y1
+			})
+			want := n
+			if want > N {
+				want = N
+			}
+			wantInts := make([]int, want)
+			wantKeys := make([]string, want)
+			for i := range wantInts {
+				wantInts[i] = N - 1 - i
+				wantKeys[i] = fmt.Sprint(N - 1 - i)
+			}
+			assertEqual(t, slice, false, wantInts, wantKeys)
+		}
+	}
+}
+
+func TestLimitTopNNames(t *testing.T) {
+	// The per-shard stages have their own names, so that their status
+	// and counters are kept apart from the final stages'.
+	for _, c := range []struct {
+		slice            bigslice.Slice
+		shardOp, finalOp string
+	}{
+		{bigslice.Limit(bigslice.Const(2, []int{1, 2}), 1), "head(1)", "limit(1)"},
+		{bigslice.TopN(bigslice.Const(2, []int{1, 2}), 1, func(x, y int) bool { return x < y }), "topnshard(1)", "topn(1)"},
+	} {
+		if got, want := c.slice.Dep(0).Slice.Name().Op, c.shardOp; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := c.slice.Name().Op, c.finalOp; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTopNTypeError(t *testing.T) {
+	slice := bigslice.Const(1, []int{1}, []string{"a"})
+	expectTypeError(t, "topn: function func(int, int) bool does not match input slice type slice[1]int,string", func() {
+		bigslice.TopN(slice, 1, func(x, y int) bool { return x < y })
+	})
+	expectTypeError(t, "topn: less function must return a single boolean value", func() {
+		bigslice.TopN(slice, 1, func(x1 int, x2 string, y1 int, y2 string) int { return 0 })
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice
+
+import (
+	"reflect"
+
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/bigslice/slicetype"
+	"github.com/grailbio/bigslice/typecheck"
+)
+
+type unionSlice struct {
+	name Name
+	Slice
+	slices []Slice
+	// Offsets stores the index of the first shard of each of the
+	// union's slices.
+	offsets []int
+	nshard  int
+}
+
+// Union returns a slice that contains the rows of all of the provided
+// slices, which must have identical column types. The shards of the
+// returned slice are the concatenation of the shards of the provided
+// slices: the union's first shards are those of the first slice, and
+// so on. No data are shuffled. The returned slice has the type (and
+// prefix) of the first slice. Schematically:
+//
+//	Union(Slice<t1, t2, ..., tn>, Slice<t1, t2, ..., tn>, ...) Slice<t1, t2, ..., tn>
+func Union(slices ...Slice) Slice {
+	checkUnion(slices, "union")
+	u := &unionSlice{
+		name:    makeName("union"),
+		Slice:   slices[0],
+		slices:  slices,
+		offsets: make([]int, len(slices)),
+	}
+	for i, slice := range slices {
+		u.offsets[i] = u.nshard
+		u.nshard += slice.NumShard()
+	}
+	return u
+}
+
+func (u *unionSlice) Name() Name             { return u.name }
+func (u *unionSlice) NumShard() int          { return u.nshard }
+func (*unionSlice) ShardType() ShardType     { return HashShard }
+func (u *unionSlice) NumDep() int            { return len(u.slices) }
+func (u *unionSlice) Dep(i int) Dep          { return Dep{u.slices[i], false, false} }
+func (*unionSlice) Combiner() *reflect.Value { return nil }
+
+// DepShards implements DepShardMapper: each shard of the union reads
+// exactly one shard of one of its dependencies.
+func (u *unionSlice) DepShards(shard, dep int) []int {
+	if shard < u.offsets[dep] || shard >= u.offsets[dep]+u.slices[dep].NumShard() {
+		return nil
+	}
+	return []int{shard - u.offsets[dep]}
+}
+
+func (u *unionSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return sliceio.MultiReader(deps...)
+}
+
+type unionRebalanceSlice struct {
+	name Name
+	Slice
+	slices []Slice
+	nshard int
+}
+
+// UnionRebalance returns a slice that contains the rows of all of the
+// provided slices, which must have identical column types,
+// redistributed into nshard shards. Unlike Union, UnionRebalance
+// shuffles its input: rows are assigned to shards by their prefix
+// columns, which must be partitionable. The returned slice has the
+// type (and prefix) of the first slice. Rows are not sorted within a
+// shard.
+func UnionRebalance(nshard int, slices ...Slice) Slice {
+	if nshard < 1 {
+		typecheck.Panicf(1, "unionrebalance: nshard must be positive; got %d", nshard)
+	}
+	checkUnion(slices, "unionrebalance")
+	if err := canMakeCombiningFrame(slices[0]); err != nil {
+		typecheck.Panic(1, err.Error())
+	}
+	return &unionRebalanceSlice{makeName("unionrebalance"), slices[0], slices, nshard}
+}
+
+func (u *unionRebalanceSlice) Name() Name             { return u.name }
+func (u *unionRebalanceSlice) NumShard() int          { return u.nshard }
+func (*unionRebalanceSlice) ShardType() ShardType     { return HashShard }
+func (u *unionRebalanceSlice) NumDep() int            { return len(u.slices) }
+func (u *unionRebalanceSlice) Dep(i int) Dep          { return Dep{u.slices[i], true, false} }
+func (*unionRebalanceSlice) Combiner() *reflect.Value { return nil }
+
+func (u *unionRebalanceSlice) Reader(shard int, deps []sliceio.Reader) sliceio.Reader {
+	return sliceio.MultiReader(deps...)
+}
+
+// CheckUnion panics with a type error if the provided slices cannot
+// be unioned. It is called directly by union constructors.
+func checkUnion(slices []Slice, op string) {
+	if len(slices) == 0 {
+		typecheck.Panicf(2, "%s: no slices provided", op)
+	}
+	for i, slice := range slices[1:] {
+		if !typecheck.Equal(slices[0], slice) {
+			typecheck.Panicf(2, "%s: type of slice %d (%s) does not match type of slice 0 (%s)",
+				op, i+1, slicetype.String(slice), slicetype.String(slices[0]))
+		}
+	}
+}
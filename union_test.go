@@ -0,0 +1,62 @@
+// Copyright 2019 GRAIL, Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package bigslice_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/bigslice"
+)
+
+func TestUnion(t *testing.T) {
+	slice := bigslice.Union(
+		bigslice.Const(2, []string{"a", "b", "c"}, []int{1, 2, 3}),
+		bigslice.Const(3, []string{"d", "e", "f", "g"}, []int{4, 5, 6, 7}),
+		bigslice.Const(1, []string{"h"}, []int{8}),
+	)
+	if got, want := slice.NumShard(), 6; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Make sure that the union can be pipelined into, and shuffled
+	// from.
+	slice = bigslice.Map(slice, func(s string, i int) (string, int) { return s, i * 2 })
+	assertEqual(t, slice, true,
+		[]string{"a", "b", "c", "d", "e", "f", "g", "h"},
+		[]int{2, 4, 6, 8, 10, 12, 14, 16})
+	slice = bigslice.Reduce(bigslice.Map(slice, func(s string, i int) (string, int) { return "x", i }),
+		func(a, b int) int { return a + b })
+	assertEqual(t, slice, false, []string{"x"}, []int{72})
+}
+
+func TestUnionSelf(t *testing.T) {
+	slice := bigslice.Const(2, []string{"a", "b", "c"})
+	slice = bigslice.Union(slice, slice)
+	assertEqual(t, slice, true, []string{"a", "a", "b", "b", "c", "c"})
+}
+
+func TestUnionRebalance(t *testing.T) {
+	slice := bigslice.UnionRebalance(4,
+		bigslice.Const(2, []string{"a", "b", "c"}, []int{1, 2, 3}),
+		bigslice.Const(3, []string{"d", "e", "f", "g"}, []int{4, 5, 6, 7}),
+	)
+	if got, want := slice.NumShard(), 4; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	assertEqual(t, slice, true,
+		[]string{"a", "b", "c", "d", "e", "f", "g"},
+		[]int{1, 2, 3, 4, 5, 6, 7})
+}
+
+func TestUnionTypeError(t *testing.T) {
+	expectTypeError(t, "union: no slices provided", func() {
+		bigslice.Union()
+	})
+	expectTypeError(t, "union: type of slice 1 (slice[1]string) does not match type of slice 0 (slice[1]int)", func() {
+		bigslice.Union(bigslice.Const(1, []int{1}), bigslice.Const(1, []string{"a"}))
+	})
+	expectTypeError(t, "unionrebalance: nshard must be positive; got 0", func() {
+		bigslice.UnionRebalance(0, bigslice.Const(1, []int{1}))
+	})
+}